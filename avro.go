@@ -0,0 +1,276 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// AvroBlockSize is the size of the Avro OCF data blocks to be flushed.
+var AvroBlockSize = 64 << 10
+
+// avroEncoder writes an Avro Object Container File.
+//
+// The records are the elements of the first repeated message field of the parts,
+// or the parts themselves if they have no such field.
+// The schema is derived from the protobuf descriptor of the first part.
+type avroEncoder struct {
+	w     io.Writer
+	field protoreflect.FieldDescriptor
+	desc  protoreflect.MessageDescriptor
+	sync  [16]byte
+	block bytes.Buffer
+	count int64
+	buf   [binary.MaxVarintLen64]byte
+}
+
+func (e *avroEncoder) Encode(part interface{}) error {
+	pr, ok := part.(protoReflecter)
+	if !ok {
+		return fmt.Errorf("avro: %T is not a protobuf message", part)
+	}
+	msg := pr.ProtoReflect()
+	if e.desc == nil {
+		if err := e.writeHeader(msg.Descriptor()); err != nil {
+			return err
+		}
+	} else if msg.Descriptor().FullName() != e.parentName() {
+		return fmt.Errorf("avro: got %s, wanted %s", msg.Descriptor().FullName(), e.parentName())
+	}
+	if e.field == nil {
+		e.count++
+		e.writeRecord(&e.block, msg)
+	} else {
+		list := msg.Get(e.field).List()
+		for i := 0; i < list.Len(); i++ {
+			e.count++
+			e.writeRecord(&e.block, list.Get(i).Message())
+		}
+	}
+	if e.block.Len() >= AvroBlockSize {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *avroEncoder) Close() error {
+	if e.desc == nil {
+		return nil
+	}
+	return e.flush()
+}
+
+func (e *avroEncoder) parentName() protoreflect.FullName {
+	if e.field != nil {
+		return e.field.ContainingMessage().FullName()
+	}
+	return e.desc.FullName()
+}
+
+func (e *avroEncoder) writeHeader(desc protoreflect.MessageDescriptor) error {
+	e.desc = desc
+	fields := desc.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		if f := fields.Get(i); f.IsList() && f.Kind() == protoreflect.MessageKind {
+			e.field, e.desc = f, f.Message()
+			break
+		}
+	}
+	schema, err := json.Marshal(avroSchema(e.desc, make(map[protoreflect.FullName]bool)))
+	if err != nil {
+		return err
+	}
+	if _, err = rand.Read(e.sync[:]); err != nil {
+		return err
+	}
+	var hdr bytes.Buffer
+	hdr.WriteString("Obj\x01")
+	e.writeLong(&hdr, 2)
+	e.writeString(&hdr, "avro.schema")
+	e.writeBytes(&hdr, schema)
+	e.writeString(&hdr, "avro.codec")
+	e.writeString(&hdr, "null")
+	e.writeLong(&hdr, 0)
+	hdr.Write(e.sync[:])
+	_, err = e.w.Write(hdr.Bytes())
+	return err
+}
+
+func (e *avroEncoder) flush() error {
+	if e.count == 0 {
+		return nil
+	}
+	var hdr bytes.Buffer
+	e.writeLong(&hdr, e.count)
+	e.writeLong(&hdr, int64(e.block.Len()))
+	e.block.Write(e.sync[:])
+	_, err := io.Copy(e.w, io.MultiReader(&hdr, &e.block))
+	e.block.Reset()
+	e.count = 0
+	return err
+}
+
+// avroName returns the protobuf name as an Avro name (with _ instead of .).
+func avroName(nm protoreflect.FullName) string {
+	return strings.Replace(string(nm), ".", "_", -1)
+}
+
+func avroSchema(desc protoreflect.MessageDescriptor, defined map[protoreflect.FullName]bool) interface{} {
+	name := avroName(desc.FullName())
+	if defined[desc.FullName()] {
+		return name
+	}
+	defined[desc.FullName()] = true
+	fields := desc.Fields()
+	fs := make([]map[string]interface{}, 0, fields.Len())
+	for i := 0; i < fields.Len(); i++ {
+		f := fields.Get(i)
+		fs = append(fs, map[string]interface{}{
+			"name": string(f.Name()),
+			"type": avroFieldType(f, defined),
+		})
+	}
+	return map[string]interface{}{"type": "record", "name": name, "fields": fs}
+}
+
+func avroFieldType(f protoreflect.FieldDescriptor, defined map[protoreflect.FullName]bool) interface{} {
+	if f.IsMap() {
+		return map[string]interface{}{"type": "map", "values": avroKindType(f.MapValue(), defined)}
+	}
+	t := avroKindType(f, defined)
+	if f.IsList() {
+		return map[string]interface{}{"type": "array", "items": t}
+	}
+	if f.Kind() == protoreflect.MessageKind || f.Kind() == protoreflect.GroupKind {
+		return []interface{}{"null", t}
+	}
+	return t
+}
+
+func avroKindType(f protoreflect.FieldDescriptor, defined map[protoreflect.FullName]bool) interface{} {
+	switch f.Kind() {
+	case protoreflect.BoolKind:
+		return "boolean"
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return "int"
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return "long"
+	case protoreflect.FloatKind:
+		return "float"
+	case protoreflect.DoubleKind:
+		return "double"
+	case protoreflect.BytesKind:
+		return "bytes"
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return avroSchema(f.Message(), defined)
+	default: // string, enum
+		return "string"
+	}
+}
+
+func (e *avroEncoder) writeRecord(w *bytes.Buffer, msg protoreflect.Message) {
+	fields := msg.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		f := fields.Get(i)
+		switch {
+		case f.IsMap():
+			m := msg.Get(f).Map()
+			if m.Len() != 0 {
+				e.writeLong(w, int64(m.Len()))
+				m.Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+					e.writeString(w, k.String())
+					e.writeValue(w, f.MapValue(), v)
+					return true
+				})
+			}
+			e.writeLong(w, 0)
+		case f.IsList():
+			list := msg.Get(f).List()
+			if list.Len() != 0 {
+				e.writeLong(w, int64(list.Len()))
+				for j := 0; j < list.Len(); j++ {
+					e.writeValue(w, f, list.Get(j))
+				}
+			}
+			e.writeLong(w, 0)
+		case f.Kind() == protoreflect.MessageKind || f.Kind() == protoreflect.GroupKind:
+			if !msg.Has(f) {
+				e.writeLong(w, 0)
+				continue
+			}
+			e.writeLong(w, 1)
+			e.writeRecord(w, msg.Get(f).Message())
+		default:
+			e.writeValue(w, f, msg.Get(f))
+		}
+	}
+}
+
+func (e *avroEncoder) writeValue(w *bytes.Buffer, f protoreflect.FieldDescriptor, v protoreflect.Value) {
+	switch f.Kind() {
+	case protoreflect.BoolKind:
+		if v.Bool() {
+			w.WriteByte(1)
+		} else {
+			w.WriteByte(0)
+		}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		e.writeLong(w, v.Int())
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		e.writeLong(w, int64(v.Uint()))
+	case protoreflect.FloatKind:
+		binary.Write(w, binary.LittleEndian, math.Float32bits(float32(v.Float())))
+	case protoreflect.DoubleKind:
+		binary.Write(w, binary.LittleEndian, math.Float64bits(v.Float()))
+	case protoreflect.BytesKind:
+		e.writeBytes(w, v.Bytes())
+	case protoreflect.EnumKind:
+		nm := fmt.Sprintf("%d", v.Enum())
+		if ev := f.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			nm = string(ev.Name())
+		}
+		e.writeString(w, nm)
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		e.writeRecord(w, v.Message())
+	default:
+		e.writeString(w, v.String())
+	}
+}
+
+func (e *avroEncoder) writeLong(w *bytes.Buffer, i int64) {
+	w.Write(e.buf[:binary.PutVarint(e.buf[:], i)])
+}
+func (e *avroEncoder) writeBytes(w *bytes.Buffer, b []byte) {
+	e.writeLong(w, int64(len(b)))
+	w.Write(b)
+}
+func (e *avroEncoder) writeString(w *bytes.Buffer, s string) {
+	e.writeLong(w, int64(len(s)))
+	w.WriteString(s)
+}
@@ -17,6 +17,7 @@ package grpcer
 
 import (
 	"context"
+	"strings"
 
 	"google.golang.org/grpc/credentials"
 )
@@ -63,4 +64,27 @@ func (ba basicAuthCreds) GetRequestMetadata(ctx context.Context, uri ...string)
 	return map[string]string{"authorization": up}, nil
 }
 
+var _ = credentials.PerRPCCredentials(metadataCreds{})
+
+type metadataCreds struct {
+	md         map[string]string
+	requireTLS bool
+}
+
+// NewMetadataCredentials returns a PerRPCCredentials which sends the metadata (e.g. "x-api-key") with each call.
+func NewMetadataCredentials(md map[string]string, requireTLS bool) credentials.PerRPCCredentials {
+	m := make(map[string]string, len(md))
+	for k, v := range md {
+		m[strings.ToLower(k)] = v
+	}
+	return metadataCreds{md: m, requireTLS: requireTLS}
+}
+
+func (mc metadataCreds) RequireTransportSecurity() bool { return mc.requireTLS }
+
+// GetRequestMetadata returns the metadata.
+func (mc metadataCreds) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return mc.md, nil
+}
+
 // vim: se noet fileencoding=utf-8:
@@ -0,0 +1,39 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestMetadataCredentials(t *testing.T) {
+	creds := NewMetadataCredentials(map[string]string{"X-API-Key": "secret"}, true)
+	if !creds.RequireTransportSecurity() {
+		t.Error("should require transport security")
+	}
+	md, err := creds.GetRequestMetadata(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"x-api-key": "secret"}; !reflect.DeepEqual(md, want) {
+		t.Errorf("got %v, wanted %v", md, want)
+	}
+	if NewMetadataCredentials(nil, false).RequireTransportSecurity() {
+		t.Error("should not require transport security")
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+	"google.golang.org/grpc"
+)
+
+// Request is one call of CallMany.
+type Request struct {
+	// Key is the key of the result in the merged document, Name if empty.
+	Key   string
+	Name  string
+	Input interface{}
+	Opts  []grpc.CallOption
+}
+
+// Result is the result of a Request.
+type Result struct {
+	Key   string
+	Parts []interface{}
+	Err   error
+}
+
+// CallMany calls the requests concurrently, at most limit at once (all at once if limit <= 0),
+// and returns the results in the order of the requests.
+//
+// The returned error is the first failed request's error, the results contain all the errors.
+func CallMany(ctx context.Context, c Client, reqs []Request, limit int) ([]Result, error) {
+	if limit <= 0 || limit > len(reqs) {
+		limit = len(reqs)
+	}
+	results := make([]Result, len(reqs))
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		key := req.Key
+		if key == "" {
+			key = req.Name
+		}
+		results[i].Key = key
+		select {
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(res *Result, req Request) {
+			defer func() { <-sem; wg.Done() }()
+			recv, err := c.Call(req.Name, ctx, req.Input, req.Opts...)
+			if err != nil {
+				res.Err = fmt.Errorf("%s: %w", req.Name, err)
+				return
+			}
+			for {
+				part, err := recv.Recv()
+				if err != nil {
+					if err != io.EOF {
+						res.Err = fmt.Errorf("%s: %w", req.Name, err)
+					}
+					return
+				}
+				res.Parts = append(res.Parts, part)
+			}
+		}(&results[i], req)
+	}
+	wg.Wait()
+	for _, res := range results {
+		if res.Err != nil {
+			return results, res.Err
+		}
+	}
+	return results, nil
+}
+
+// MergeResults writes the results into one JSON object keyed by the results' keys.
+//
+// A one-part result is written as is, a multi-part one as an array,
+// a failed one as {"error": "message"}.
+func MergeResults(w io.Writer, results []Result) error {
+	buf := bufPool.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		bufPool.Put(buf)
+	}()
+	buf.Reset()
+	enc := jsoniter.NewEncoder(buf)
+	buf.WriteByte('{')
+	for i, res := range results {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		if err := enc.Encode(res.Key); err != nil {
+			return err
+		}
+		buf.Truncate(buf.Len() - 1) // newline
+		buf.WriteByte(':')
+		var v interface{} = res.Parts
+		if res.Err != nil {
+			v = struct {
+				Error string `json:"error"`
+			}{Error: res.Err.Error()}
+		} else if len(res.Parts) == 1 {
+			v = res.Parts[0]
+		}
+		if err := enc.Encode(v); err != nil {
+			return fmt.Errorf("encode %s: %w", res.Key, err)
+		}
+		buf.Truncate(buf.Len() - 1)
+	}
+	buf.WriteString("}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// CallSpec is a call of MergeCalls.
+type CallSpec struct {
+	Client Client
+	Name   string
+	Input  interface{}
+	Opts   []grpc.CallOption
+}
+
+// MergeCalls runs the calls concurrently, and writes one JSON object into w,
+// with the merged stream of each call under its key (in sorted order).
+//
+// Each stream is merged as by the JSONHandler's MergeStreams, in memory up to
+// DefaultMergeSpillSize bytes, then into a temporary file.
+// A stream with unmergeable parts is written as an array, a failed call as {"error": "message"}.
+// The returned error is the first (by key) failed call's error.
+func MergeCalls(ctx context.Context, w io.Writer, calls map[string]CallSpec) error {
+	keys := make([]string, 0, len(calls))
+	for k := range calls {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	files := make([]*spillBuffer, len(keys))
+	errs := make([]error, len(keys))
+	var wg sync.WaitGroup
+	for i, k := range keys {
+		files[i] = &spillBuffer{name: "calls", limit: DefaultMergeSpillSize}
+		defer files[i].Close()
+		wg.Add(1)
+		go func(i int, spec CallSpec) {
+			defer wg.Done()
+			if errs[i] = mergeCall(ctx, files[i], spec); errs[i] != nil {
+				errs[i] = fmt.Errorf("%s: %w", keys[i], errs[i])
+			}
+		}(i, calls[k])
+	}
+	wg.Wait()
+
+	buf := bufPool.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		bufPool.Put(buf)
+	}()
+	buf.Reset()
+	enc := jsoniter.NewEncoder(buf)
+	var firstErr error
+	io.WriteString(w, "{")
+	for i, k := range keys {
+		buf.Reset()
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		if err := enc.Encode(k); err != nil {
+			return err
+		}
+		buf.Truncate(buf.Len() - 1) // newline
+		buf.WriteByte(':')
+		if err := errs[i]; err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			_ = enc.Encode(struct {
+				Error string `json:"error"`
+			}{Error: err.Error()})
+			buf.Truncate(buf.Len() - 1)
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		if errs[i] != nil {
+			continue
+		}
+		if err := copyTrimmed(w, files[i]); err != nil {
+			return fmt.Errorf("%s: %w", k, err)
+		}
+	}
+	_, err := io.WriteString(w, "}\n")
+	if firstErr != nil {
+		return firstErr
+	}
+	return err
+}
+
+// mergeCall calls the spec, and writes its merged stream into w.
+func mergeCall(ctx context.Context, w io.Writer, spec CallSpec) error {
+	recv, err := spec.Client.Call(spec.Name, ctx, spec.Input, spec.Opts...)
+	if err != nil {
+		return err
+	}
+	defer CloseReceiver(recv)
+	first, recv, err := Peek(recv)
+	if err != nil {
+		if err == io.EOF {
+			_, err = io.WriteString(w, "null")
+		}
+		return err
+	}
+	if rv := reflect.ValueOf(first); rv.Kind() == reflect.Ptr && rv.Elem().Kind() == reflect.Struct {
+		if slice, _ := sliceFields(first); len(slice) != 0 {
+			_, _ = recv.Recv() // the peeked first part
+			return mergeStreams(ctx, w, first, recv, nil)
+		}
+	}
+	var parts []interface{}
+	for part, err := range All(recv) {
+		if err != nil {
+			return err
+		}
+		parts = append(parts, part)
+	}
+	var v interface{} = parts
+	if len(parts) == 1 {
+		v = parts[0]
+	}
+	return jsoniter.NewEncoder(w).Encode(v)
+}
+
+// copyTrimmed copies the data of sb into w, without the trailing newline.
+func copyTrimmed(w io.Writer, sb *spillBuffer) error {
+	if sb.file == nil {
+		_, err := w.Write(bytes.TrimSuffix(sb.mem.Bytes(), []byte{'\n'}))
+		return err
+	}
+	if _, err := sb.reader(); err != nil { // flush
+		return err
+	}
+	fh := sb.file
+	size, err := fh.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if size != 0 {
+		var last [1]byte
+		if _, err = fh.ReadAt(last[:], size-1); err != nil {
+			return err
+		}
+		if last[0] == '\n' {
+			size--
+		}
+	}
+	if _, err = fh.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, io.LimitReader(fh, size))
+	return err
+}
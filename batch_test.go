@@ -0,0 +1,99 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tgulacsi/go/jsondiff"
+)
+
+func TestCallMany(t *testing.T) {
+	c := fakeClient{
+		"One": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return &receiver{parts: []interface{}{input}}, nil
+		},
+		"Two": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return &receiver{parts: []interface{}{1, 2}}, nil
+		},
+		"Bad": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return nil, errors.New("bad")
+		},
+	}
+	results, err := CallMany(context.Background(), c, []Request{
+		{Name: "One", Input: map[string]string{"a": "b"}},
+		{Key: "two", Name: "Two"},
+		{Name: "Bad"},
+	}, 2)
+	if err == nil {
+		t.Error("wanted error")
+	}
+	var buf bytes.Buffer
+	if err = MergeResults(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	d, err := jsondiff.DiffStrings(`{"One":{"a":"b"},"two":[1,2],"Bad":{"error":"Bad: bad"}}`, buf.String())
+	if err != nil {
+		t.Fatalf("%s: %+v", buf.String(), err)
+	}
+	if d != "" {
+		t.Error(d)
+	}
+}
+
+func TestMergeCalls(t *testing.T) {
+	type page struct {
+		Rows  []int `json:"rows"`
+		Total int
+	}
+	c := fakeClient{
+		"Pages": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return &receiver{parts: []interface{}{
+				&page{Rows: []int{1, 2}, Total: 3},
+				&page{Rows: []int{3}, Total: 3},
+			}}, nil
+		},
+		"Two": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return &receiver{parts: []interface{}{1, 2}}, nil
+		},
+		"Bad": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return nil, errors.New("bad")
+		},
+	}
+	defer func(size int64) { DefaultMergeSpillSize = size }(DefaultMergeSpillSize)
+	for _, size := range []int64{DefaultMergeSpillSize, -1} {
+		DefaultMergeSpillSize = size
+		var buf bytes.Buffer
+		err := MergeCalls(context.Background(), &buf, map[string]CallSpec{
+			"pages": {Client: c, Name: "Pages"},
+			"two":   {Client: c, Name: "Two"},
+			"bad":   {Client: c, Name: "Bad"},
+		})
+		if err == nil {
+			t.Error("wanted error")
+		}
+		d, err := jsondiff.DiffStrings(`{"bad":{"error":"bad: bad"},"pages":{"Total":3,"rows":[1,2,3]},"two":[1,2]}`, buf.String())
+		if err != nil {
+			t.Fatalf("%d: %s: %+v", size, buf.String(), err)
+		}
+		if d != "" {
+			t.Errorf("%d: %s", size, d)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// TokenSource returns the current bearer token, refreshing it when needed.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenSourceFunc is a func implementing TokenSource.
+type TokenSourceFunc func(ctx context.Context) (string, error)
+
+func (f TokenSourceFunc) Token(ctx context.Context) (string, error) { return f(ctx) }
+
+// OAuth2Tokens adapts a golang.org/x/oauth2.TokenSource (T is *oauth2.Token)
+// to TokenSource - wrap it in oauth2.ReuseTokenSource to cache the tokens till their expiry.
+func OAuth2Tokens[T interface{ SetAuthHeader(*http.Request) }](ts interface{ Token() (T, error) }) TokenSource {
+	return TokenSourceFunc(func(ctx context.Context) (string, error) {
+		tok, err := ts.Token()
+		if err != nil {
+			return "", err
+		}
+		r := http.Request{Header: make(http.Header, 1)}
+		tok.SetAuthHeader(&r)
+		auth := r.Header.Get("Authorization")
+		if i := strings.IndexByte(auth, ' '); i >= 0 {
+			auth = auth[i+1:]
+		}
+		return auth, nil
+	})
+}
+
+var _ = credentials.PerRPCCredentials(bearerAuthCreds{})
+
+type bearerAuthCreds struct {
+	ts       TokenSource
+	insecure bool
+}
+
+// NewBearerAuth returns a PerRPCCredentials with the "authorization: Bearer" token of ts.
+func NewBearerAuth(ts TokenSource) credentials.PerRPCCredentials {
+	return bearerAuthCreds{ts: ts}
+}
+
+// NewInsecureBearerAuth returns an INSECURE (not requiring secure transport) NewBearerAuth.
+func NewInsecureBearerAuth(ts TokenSource) credentials.PerRPCCredentials {
+	return bearerAuthCreds{ts: ts, insecure: true}
+}
+
+func (ba bearerAuthCreds) RequireTransportSecurity() bool { return !ba.insecure }
+
+// GetRequestMetadata returns the authorization of the context, or the bearer token.
+func (ba bearerAuthCreds) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	if up, _ := ctx.Value(BasicAuthKey).(string); up != "" {
+		return map[string]string{"authorization": up}, nil
+	}
+	tok, err := ba.ts.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("token: %w", err)
+	}
+	return map[string]string{"authorization": "Bearer " + tok}, nil
+}
+
+// JWTConfig configures NewJWTAuth.
+type JWTConfig struct {
+	// Key signs the tokens: []byte for HS256, *rsa.PrivateKey for RS256, *ecdsa.PrivateKey (P-256) for ES256.
+	Key   interface{}
+	KeyID string
+	// Issuer, Subject and Audience are the iss, sub and aud claims.
+	Issuer, Subject, Audience string
+	// Claims are added to the token.
+	Claims map[string]interface{}
+	// Lifetime is the validity of a token (1h if zero), which is renewed in its last tenth.
+	Lifetime time.Duration
+}
+
+// NewJWTAuth returns the self-signed JWT TokenSource of the config, for NewBearerAuth.
+func NewJWTAuth(conf JWTConfig) (TokenSource, error) {
+	js := &jwtSource{conf: conf}
+	switch k := conf.Key.(type) {
+	case []byte:
+		js.alg = "HS256"
+	case *rsa.PrivateKey:
+		js.alg = "RS256"
+	case *ecdsa.PrivateKey:
+		if k.Curve.Params().BitSize != 256 {
+			return nil, fmt.Errorf("ECDSA key size %d: %w", k.Curve.Params().BitSize, errUnsupportedKey)
+		}
+		js.alg = "ES256"
+	default:
+		return nil, fmt.Errorf("%T: %w", conf.Key, errUnsupportedKey)
+	}
+	if js.conf.Lifetime <= 0 {
+		js.conf.Lifetime = time.Hour
+	}
+	return js, nil
+}
+
+var errUnsupportedKey = errors.New("unsupported key")
+
+type jwtSource struct {
+	conf JWTConfig
+	alg  string
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// Token returns the cached token, or a new one if it is in the last tenth of its lifetime.
+func (js *jwtSource) Token(ctx context.Context) (string, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	now := time.Now()
+	if js.token != "" && now.Before(js.expiry.Add(-js.conf.Lifetime/10)) {
+		return js.token, nil
+	}
+	tok, err := js.sign(now)
+	if err != nil {
+		return "", err
+	}
+	js.token, js.expiry = tok, now.Add(js.conf.Lifetime)
+	return tok, nil
+}
+
+func (js *jwtSource) sign(now time.Time) (string, error) {
+	header := map[string]string{"alg": js.alg, "typ": "JWT"}
+	if js.conf.KeyID != "" {
+		header["kid"] = js.conf.KeyID
+	}
+	claims := make(map[string]interface{}, len(js.conf.Claims)+5)
+	for k, v := range js.conf.Claims {
+		claims[k] = v
+	}
+	for k, v := range map[string]string{"iss": js.conf.Issuer, "sub": js.conf.Subject, "aud": js.conf.Audience} {
+		if v != "" {
+			claims[k] = v
+		}
+	}
+	claims["iat"], claims["exp"] = now.Unix(), now.Add(js.conf.Lifetime).Unix()
+
+	var parts [2]string
+	for i, v := range []interface{}{header, claims} {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := parts[0] + "." + parts[1]
+	hash := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch k := js.conf.Key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hash[:]); err != nil {
+			return "", err
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, hash[:])
+		if err != nil {
+			return "", err
+		}
+		// JWS uses the fixed size concatenation of r and s, not ASN.1
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
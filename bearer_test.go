@@ -0,0 +1,133 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// oauth2Token mimics *oauth2.Token.
+type oauth2Token struct{ AccessToken string }
+
+func (t *oauth2Token) SetAuthHeader(r *http.Request) {
+	r.Header.Set("Authorization", "Bearer "+t.AccessToken)
+}
+
+type oauth2Source struct{ n int }
+
+func (s *oauth2Source) Token() (*oauth2Token, error) {
+	s.n++
+	return &oauth2Token{AccessToken: strings.Repeat("x", s.n)}, nil
+}
+
+func TestBearerAuth(t *testing.T) {
+	creds := NewBearerAuth(OAuth2Tokens(&oauth2Source{}))
+	if !creds.RequireTransportSecurity() {
+		t.Error("bearer auth should require transport security")
+	}
+	ctx := context.Background()
+	for _, want := range []string{"Bearer x", "Bearer xx"} {
+		md, err := creds.GetRequestMetadata(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := md["authorization"]; got != want {
+			t.Errorf("got %q, wanted %q", got, want)
+		}
+	}
+}
+
+func TestJWTAuth(t *testing.T) {
+	ctx := context.Background()
+	decode := func(t *testing.T, tok string) (signed string, claims map[string]interface{}, sig []byte) {
+		t.Helper()
+		parts := strings.Split(tok, ".")
+		if len(parts) != 3 {
+			t.Fatalf("%q: not a JWT", tok)
+		}
+		b, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = json.Unmarshal(b, &claims); err != nil {
+			t.Fatal(err)
+		}
+		if sig, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+			t.Fatal(err)
+		}
+		return parts[0] + "." + parts[1], claims, sig
+	}
+
+	t.Run("HS256", func(t *testing.T) {
+		key := []byte("secret")
+		ts, err := NewJWTAuth(JWTConfig{Key: key, Issuer: "me", Audience: "svc", Claims: map[string]interface{}{"role": "admin"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tok, err := ts.Token(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok2, _ := ts.Token(ctx); tok2 != tok {
+			t.Error("token is not cached")
+		}
+		signed, claims, sig := decode(t, tok)
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			t.Error("bad signature")
+		}
+		if claims["iss"] != "me" || claims["aud"] != "svc" || claims["role"] != "admin" || claims["exp"] == nil {
+			t.Errorf("got claims %v", claims)
+		}
+	})
+
+	t.Run("ES256", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts, err := NewJWTAuth(JWTConfig{Key: key, Subject: "me"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tok, err := ts.Token(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed, _, sig := decode(t, tok)
+		hash := sha256.Sum256([]byte(signed))
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if len(sig) != 64 || !ecdsa.Verify(&key.PublicKey, hash[:], r, s) {
+			t.Error("bad signature")
+		}
+	})
+
+	if _, err := NewJWTAuth(JWTConfig{Key: "string"}); err == nil {
+		t.Error("wanted error for unsupported key")
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxBodySize is the default limit of the (decompressed) request body size.
+var DefaultMaxBodySize int64 = 32 << 20
+
+// DefaultStreamInputSize is the default request body size above which
+// the JSON input is decoded directly from the body, see JSONHandler.StreamInputSize.
+var DefaultStreamInputSize int64 = 8 << 20
+
+var errUnsupportedEncoding = errors.New("unsupported Content-Encoding")
+
+// decodeBody replaces r.Body with its decompressed (by Content-Encoding) form,
+// limited to maxSize bytes (DefaultMaxBodySize if zero, unlimited if negative).
+func decodeBody(w http.ResponseWriter, r *http.Request, maxSize int64) error {
+	body := r.Body
+	switch enc := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); enc {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return fmt.Errorf("gzip: %w", err)
+		}
+		body = readCloser{Reader: zr, Closer: r.Body}
+	case "deflate":
+		// "deflate" should be zlib-wrapped, but some clients send raw deflate.
+		br := bufio.NewReader(body)
+		if b, err := br.Peek(2); err == nil && (uint16(b[0])<<8|uint16(b[1]))%31 == 0 && b[0]&0x0f == 8 {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return fmt.Errorf("deflate: %w", err)
+			}
+			body = readCloser{Reader: zr, Closer: r.Body}
+		} else {
+			body = readCloser{Reader: flate.NewReader(br), Closer: r.Body}
+		}
+	default:
+		return fmt.Errorf("%q: %w", enc, errUnsupportedEncoding)
+	}
+	if maxSize == 0 {
+		maxSize = DefaultMaxBodySize
+	}
+	if maxSize > 0 {
+		body = http.MaxBytesReader(w, body, maxSize)
+	}
+	r.Body = body
+	r.Header.Del("Content-Encoding")
+	r.ContentLength = -1
+	return nil
+}
+
+// bodyErrorCode returns the HTTP status code for the body reading error.
+func bodyErrorCode(err error) int {
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		return http.StatusRequestEntityTooLarge
+	}
+	if errors.Is(err, errUnsupportedEncoding) {
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusBadRequest
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// readAtMost reads body into buf, up to limit bytes (all if not positive),
+// and reports whether body has been read completely.
+func readAtMost(buf *bytes.Buffer, body io.Reader, limit int64) (bool, error) {
+	if limit <= 0 {
+		_, err := buf.ReadFrom(body)
+		return true, err
+	}
+	n, err := buf.ReadFrom(io.LimitReader(body, limit+1))
+	return n <= limit, err
+}
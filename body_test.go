@@ -0,0 +1,78 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDecodeBody(t *testing.T) {
+	const body = `{"a":"` + "0123456789abcdef" + `"}`
+	compress := func(newWriter func(io.Writer) io.WriteCloser) []byte {
+		var buf bytes.Buffer
+		w := newWriter(&buf)
+		io.WriteString(w, body)
+		w.Close()
+		return buf.Bytes()
+	}
+	gz := compress(func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })
+	zl := compress(func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) })
+	fl := compress(func(w io.Writer) io.WriteCloser { fw, _ := flate.NewWriter(w, flate.DefaultCompression); return fw })
+
+	for tN, tC := range map[string]struct {
+		Encoding string
+		Body     []byte
+		Max      int64
+		Code     int
+	}{
+		"identity":    {Body: []byte(body)},
+		"gzip":        {Encoding: "gzip", Body: gz},
+		"zlib":        {Encoding: "deflate", Body: zl},
+		"deflate":     {Encoding: "deflate", Body: fl},
+		"tooLarge":    {Encoding: "gzip", Body: gz, Max: 10, Code: http.StatusRequestEntityTooLarge},
+		"unsupported": {Encoding: "br", Body: gz, Code: http.StatusUnsupportedMediaType},
+	} {
+		r := httptest.NewRequest("POST", "/", bytes.NewReader(tC.Body))
+		if tC.Encoding != "" {
+			r.Header.Set("Content-Encoding", tC.Encoding)
+		}
+		w := httptest.NewRecorder()
+		err := decodeBody(w, r, tC.Max)
+		var b []byte
+		if err == nil {
+			b, err = io.ReadAll(r.Body)
+		}
+		if err != nil {
+			if code := bodyErrorCode(err); code != tC.Code {
+				t.Errorf("%s: got %d (%+v), wanted %d", tN, code, err, tC.Code)
+			}
+			continue
+		}
+		if tC.Code != 0 {
+			t.Errorf("%s: wanted %d, got no error", tN, tC.Code)
+		}
+		if got := string(b); got != body {
+			t.Errorf("%s: got %q, wanted %q", tN, got, body)
+		}
+	}
+}
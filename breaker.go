@@ -0,0 +1,182 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrCircuitOpen is returned by the calls of an open circuit breaker.
+var ErrCircuitOpen = status.Error(codes.Unavailable, "circuit breaker is open")
+
+// BreakerConfig configures NewCircuitBreakerClient.
+type BreakerConfig struct {
+	// Failures is the number of consecutive failures which trips the breaker (5 if zero).
+	Failures int
+	// OpenTimeout is the time the breaker stays open before letting a probe call through (30s if zero).
+	OpenTimeout time.Duration
+	// IsFailure decides whether the error counts as a failure - by default
+	// Unavailable, DeadlineExceeded, ResourceExhausted, Internal and Unknown do.
+	IsFailure func(error) bool
+	Log       func(...interface{}) error
+}
+
+type breakerState uint8
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// breaker is the circuit breaker of a method.
+type breaker struct {
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	// openedAt is the time of opening, or of letting the last probe through.
+	openedAt time.Time
+}
+
+// allow reports whether the call can go on - in half-open state only one probe is let through
+// per timeout, so a probe never recording its result (e.g. never received nor closed)
+// does not keep the breaker half-open forever.
+func (b *breaker) allow(timeout time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerClosed {
+		return true
+	}
+	if time.Since(b.openedAt) < timeout {
+		return false
+	}
+	b.state, b.openedAt = breakerHalfOpen, time.Now()
+	return true
+}
+
+// record the result of a call, returning the new state if it has changed.
+func (b *breaker) record(failed bool, maxFailures int) (breakerState, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	prev := b.state
+	if !failed {
+		b.state, b.failures = breakerClosed, 0
+	} else if b.failures++; b.state == breakerHalfOpen || b.failures >= maxFailures {
+		b.state, b.openedAt = breakerOpen, time.Now()
+	}
+	return b.state, b.state != prev
+}
+
+type breakerClient struct {
+	Client
+	conf     BreakerConfig
+	breakers sync.Map // name -> *breaker
+}
+
+// NewCircuitBreakerClient returns a Client which fails fast with ErrCircuitOpen
+// for the methods which failed conf.Failures times in a row,
+// until a probe call after conf.OpenTimeout succeeds.
+//
+// The result of a call is its error, or of its first Recv.
+func NewCircuitBreakerClient(c Client, conf BreakerConfig) Client {
+	if conf.Failures <= 0 {
+		conf.Failures = 5
+	}
+	if conf.OpenTimeout <= 0 {
+		conf.OpenTimeout = 30 * time.Second
+	}
+	if conf.IsFailure == nil {
+		conf.IsFailure = isBreakerFailure
+	}
+	if conf.Log == nil {
+		conf.Log = func(...interface{}) error { return nil }
+	}
+	return &breakerClient{Client: c, conf: conf}
+}
+
+func isBreakerFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Unknown:
+		return true
+	}
+	return false
+}
+
+func (bc *breakerClient) breaker(name string) *breaker {
+	if b, ok := bc.breakers.Load(name); ok {
+		return b.(*breaker)
+	}
+	b, _ := bc.breakers.LoadOrStore(name, new(breaker))
+	return b.(*breaker)
+}
+
+// record the result of the call of name.
+func (bc *breakerClient) record(name string, b *breaker, err error) {
+	failed := err != nil && err != io.EOF && bc.conf.IsFailure(err)
+	if state, changed := b.record(failed, bc.conf.Failures); changed {
+		bc.conf.Log("msg", "circuit breaker", "method", name, "state", state.String(), "error", err)
+	}
+}
+
+func (bc *breakerClient) Call(name string, ctx context.Context, input interface{}, opts ...grpc.CallOption) (Receiver, error) {
+	b := bc.breaker(name)
+	if !b.allow(bc.conf.OpenTimeout) {
+		return nil, ErrCircuitOpen
+	}
+	recv, err := bc.Client.Call(name, ctx, input, opts...)
+	if err != nil {
+		bc.record(name, b, err)
+		return recv, err
+	}
+	return &breakerReceiver{Receiver: recv, record: func(err error) { bc.record(name, b, err) }}, nil
+}
+
+// breakerReceiver records the result of the first Recv.
+type breakerReceiver struct {
+	Receiver
+	record func(error)
+	once   sync.Once
+}
+
+func (br *breakerReceiver) Recv() (interface{}, error) {
+	part, err := br.Receiver.Recv()
+	br.once.Do(func() { br.record(err) })
+	return part, err
+}
+
+// Close aborts the call - the result is recorded as a success, if no Recv has been called.
+func (br *breakerReceiver) Close() error {
+	br.once.Do(func() { br.record(nil) })
+	return CloseReceiver(br.Receiver)
+}
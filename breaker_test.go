@@ -0,0 +1,97 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var calls int
+	var fail bool
+	c := NewCircuitBreakerClient(fakeClient{
+		"Get": func(ctx context.Context, input interface{}) (Receiver, error) {
+			calls++
+			if fail {
+				return nil, status.Error(codes.Unavailable, "down")
+			}
+			return &receiver{parts: []interface{}{"ok"}}, nil
+		},
+		"Put": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return &failingReceiver{err: status.Error(codes.Internal, "broken")}, nil
+		},
+	}, BreakerConfig{Failures: 2, OpenTimeout: 20 * time.Millisecond})
+	ctx := context.Background()
+	call := func(name string) error {
+		recv, err := c.Call(name, ctx, nil)
+		if err != nil {
+			return err
+		}
+		_, err = recv.Recv()
+		return err
+	}
+
+	fail = true
+	for i := 0; i < 2; i++ {
+		if err := call("Get"); status.Code(err) != codes.Unavailable || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("%d. got %+v, wanted Unavailable", i, err)
+		}
+	}
+	if err := call("Get"); !errors.Is(err, ErrCircuitOpen) || calls != 2 {
+		t.Fatalf("got %+v after %d calls, wanted ErrCircuitOpen after 2", err, calls)
+	}
+
+	// the failures of the stream trip the breaker of that method only
+	for i := 0; i < 3; i++ {
+		call("Put")
+	}
+	if err := call("Put"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Put: got %+v, wanted ErrCircuitOpen", err)
+	}
+
+	// failing probe reopens
+	time.Sleep(30 * time.Millisecond)
+	if err := call("Get"); status.Code(err) != codes.Unavailable || errors.Is(err, ErrCircuitOpen) || calls != 3 {
+		t.Fatalf("got %+v after %d calls, wanted the probe to fail", err, calls)
+	}
+	if err := call("Get"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %+v, wanted ErrCircuitOpen", err)
+	}
+
+	// an abandoned probe (never received nor closed) lets the next probe through after the timeout
+	fail = false
+	time.Sleep(30 * time.Millisecond)
+	if _, err := c.Call("Get", ctx, nil); err != nil {
+		t.Fatalf("probe: %+v", err)
+	}
+	if err := call("Get"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %+v, wanted ErrCircuitOpen during the probe", err)
+	}
+
+	// succeeding probe closes
+	time.Sleep(30 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if err := call("Get"); err != nil {
+			t.Fatalf("%d. got %+v", i, err)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// CacheMaxParts is the maximum number of parts of a stream to be cached.
+var CacheMaxParts = 128
+
+// CacheMaxEntries is the maximum number of cached responses of a WithCache Client,
+// the least recently used are evicted above it.
+var CacheMaxEntries = 1024
+
+// KeyFunc returns the cache key for the call, or "" if the call should not be cached.
+type KeyFunc func(name string, input interface{}) string
+
+// sortedJSON encodes the maps with sorted keys, for stable cache keys.
+var sortedJSON = jsoniter.Config{SortMapKeys: true}.Froze()
+
+// InputKey is the default KeyFunc: the name and the SHA-256 hash of the JSON encoded input.
+func InputKey(name string, input interface{}) string {
+	h := sha256.New()
+	if err := sortedJSON.NewEncoder(h).Encode(input); err != nil {
+		return ""
+	}
+	return name + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// callerKey returns the SHA-256 hash of the caller's credentials (WithBasicAuth)
+// and outgoing metadata (e.g. the headers forwarded by JSONHandler), or "" without them.
+func callerKey(ctx context.Context) string {
+	up, _ := ctx.Value(BasicAuthKey).(string)
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if up == "" && len(md) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	fmt.Fprintf(h, "%q", up)
+	for _, k := range keys {
+		for _, v := range md[k] {
+			fmt.Fprintf(h, "\x00%s=%q", k, v)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+type cacheEntry struct {
+	key     string
+	parts   []interface{}
+	expires time.Time
+}
+
+type cacheClient struct {
+	Client
+	ttl     map[string]time.Duration
+	keyFunc KeyFunc
+	mu      sync.Mutex
+	entries map[string]*list.Element // of *cacheEntry in lru
+	lru     *list.List               // most recently used first
+}
+
+// WithCache returns a Client which caches the responses of the methods in ttlByMethod
+// for the given duration, keyed by keyFunc (InputKey if nil).
+//
+// The responses are cached by caller, too: by the credentials of WithBasicAuth and the
+// outgoing metadata of the context (the headers forwarded by JSONHandler).
+//
+// Only successful calls with at most CacheMaxParts parts are cached,
+// at most CacheMaxEntries of them.
+// The cached parts are shared between the callers, so they must not be modified!
+func WithCache(c Client, ttlByMethod map[string]time.Duration, keyFunc KeyFunc) Client {
+	if keyFunc == nil {
+		keyFunc = InputKey
+	}
+	return &cacheClient{Client: c, ttl: ttlByMethod, keyFunc: keyFunc, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+// Call the named method, returning the cached response if it is available.
+func (cc *cacheClient) Call(name string, ctx context.Context, input interface{}, opts ...grpc.CallOption) (Receiver, error) {
+	ttl := cc.ttl[name]
+	if ttl <= 0 {
+		if i := strings.LastIndexByte(name, '/'); i >= 0 {
+			ttl = cc.ttl[name[i+1:]]
+		}
+	}
+	if ttl <= 0 {
+		return cc.Client.Call(name, ctx, input, opts...)
+	}
+	key := cc.keyFunc(name, input)
+	if key == "" {
+		return cc.Client.Call(name, ctx, input, opts...)
+	}
+	if ck := callerKey(ctx); ck != "" {
+		key += "@" + ck
+	}
+	now := time.Now()
+	if parts, ok := cc.get(key, now); ok {
+		return &sliceReceiver{parts: parts}, nil
+	}
+
+	recv, err := cc.Client.Call(name, ctx, input, opts...)
+	if err != nil {
+		return recv, err
+	}
+	parts, rest, err := materialize(recv, CacheMaxParts)
+	if err != nil || rest != nil {
+		return &prefixedReceiver{parts: parts, rest: rest, err: err}, nil
+	}
+	cc.put(key, parts, now.Add(ttl), now)
+	return &sliceReceiver{parts: parts}, nil
+}
+
+// get returns the unexpired parts cached for key, evicting them if expired.
+func (cc *cacheClient) get(key string, now time.Time) ([]interface{}, bool) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	el := cc.entries[key]
+	if el == nil {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if !now.Before(e.expires) {
+		cc.lru.Remove(el)
+		delete(cc.entries, key)
+		return nil, false
+	}
+	cc.lru.MoveToFront(el)
+	return e.parts, true
+}
+
+// put the parts into the cache, evicting the expired entries when full,
+// and then the least recently used ones.
+func (cc *cacheClient) put(key string, parts []interface{}, expires, now time.Time) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if el := cc.entries[key]; el != nil {
+		e := el.Value.(*cacheEntry)
+		e.parts, e.expires = parts, expires
+		cc.lru.MoveToFront(el)
+		return
+	}
+	if len(cc.entries) >= CacheMaxEntries {
+		for k, el := range cc.entries {
+			if !now.Before(el.Value.(*cacheEntry).expires) {
+				cc.lru.Remove(el)
+				delete(cc.entries, k)
+			}
+		}
+	}
+	for len(cc.entries) >= CacheMaxEntries && cc.lru.Len() != 0 {
+		el := cc.lru.Back()
+		cc.lru.Remove(el)
+		delete(cc.entries, el.Value.(*cacheEntry).key)
+	}
+	cc.entries[key] = cc.lru.PushFront(&cacheEntry{key: key, parts: parts, expires: expires})
+}
+
+// materialize reads at most maxParts parts from recv.
+// If the stream is longer, rest is the Receiver of the remaining parts.
+func materialize(recv Receiver, maxParts int) (parts []interface{}, rest Receiver, err error) {
+	for {
+		part, err := recv.Recv()
+		if err != nil {
+			if err == io.EOF {
+				return parts, nil, nil
+			}
+			return parts, nil, err
+		}
+		parts = append(parts, part)
+		if len(parts) > maxParts {
+			return parts, recv, nil
+		}
+	}
+}
+
+// sliceReceiver returns the parts, then io.EOF.
+type sliceReceiver struct {
+	parts []interface{}
+}
+
+func (r *sliceReceiver) Recv() (interface{}, error) {
+	if len(r.parts) == 0 {
+		return nil, io.EOF
+	}
+	part := r.parts[0]
+	r.parts = r.parts[1:]
+	return part, nil
+}
+
+// prefixedReceiver returns the parts, then err or the parts of rest.
+type prefixedReceiver struct {
+	parts []interface{}
+	rest  Receiver
+	err   error
+}
+
+func (r *prefixedReceiver) Recv() (interface{}, error) {
+	if len(r.parts) != 0 {
+		part := r.parts[0]
+		r.parts = r.parts[1:]
+		return part, nil
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.rest == nil {
+		return nil, io.EOF
+	}
+	return r.rest.Recv()
+}
+
+func (r *prefixedReceiver) Close() error {
+	r.parts, r.err = nil, nil
+	if r.rest == nil {
+		return nil
+	}
+	rest := r.rest
+	r.rest = nil
+	return CloseReceiver(rest)
+}
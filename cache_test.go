@@ -0,0 +1,155 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestCache(t *testing.T) {
+	var n int
+	c := WithCache(fakeClient{
+		"Ref": func(ctx context.Context, input interface{}) (Receiver, error) {
+			n++
+			return &receiver{parts: []interface{}{n, n}}, nil
+		},
+		"Other": func(ctx context.Context, input interface{}) (Receiver, error) {
+			n++
+			return &receiver{parts: []interface{}{n}}, nil
+		},
+	}, map[string]time.Duration{"Ref": time.Minute}, nil)
+
+	ctx := context.Background()
+	call := func(name string, input interface{}) []interface{} {
+		recv, err := c.Call(name, ctx, input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var parts []interface{}
+		for {
+			part, err := recv.Recv()
+			if err == io.EOF {
+				return parts
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			parts = append(parts, part)
+		}
+	}
+	if got := call("Ref", map[string]int{"a": 1}); len(got) != 2 || got[0] != 1 {
+		t.Errorf("first call: got %v", got)
+	}
+	if got := call("Ref", map[string]int{"a": 1}); len(got) != 2 || got[0] != 1 {
+		t.Errorf("cached call: got %v", got)
+	}
+	if got := call("Ref", map[string]int{"a": 2}); got[0] != 2 {
+		t.Errorf("other input: got %v", got)
+	}
+	call("Other", nil)
+	call("Other", nil)
+	if n != 4 {
+		t.Errorf("got %d calls, wanted 4", n)
+	}
+
+	// the least recently used entry is evicted
+	defer func(max int) { CacheMaxEntries = max }(CacheMaxEntries)
+	CacheMaxEntries = 2
+	before := n
+	call("Ref", map[string]int{"a": 1}) // hit
+	call("Ref", map[string]int{"a": 3}) // miss, evicts a=2
+	call("Ref", map[string]int{"a": 1}) // hit
+	call("Ref", map[string]int{"a": 2}) // miss, evicts a=3
+	call("Ref", map[string]int{"a": 1}) // hit
+	if n-before != 2 || len(c.(*cacheClient).entries) != 2 {
+		t.Errorf("got %d calls, %d entries, wanted 2 calls and 2 entries", n-before, len(c.(*cacheClient).entries))
+	}
+}
+
+func TestCacheCallers(t *testing.T) {
+	var n int
+	c := WithCache(fakeClient{"Ref": func(ctx context.Context, input interface{}) (Receiver, error) {
+		n++
+		return &receiver{parts: []interface{}{n}}, nil
+	}}, map[string]time.Duration{"Ref": time.Minute}, nil)
+	alice := WithBasicAuth(context.Background(), "alice", "a")
+	for i, ctx := range []context.Context{
+		alice,
+		alice, // cached
+		WithBasicAuth(context.Background(), "bob", "b"),
+		metadata.AppendToOutgoingContext(alice, "x-tenant", "1"),
+		metadata.AppendToOutgoingContext(alice, "x-tenant", "1"), // cached
+		context.Background(),
+	} {
+		recv, err := c.Call("Ref", ctx, map[string]int{"a": 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		part, _ := recv.Recv()
+		if want := map[int]int{0: 1, 1: 1, 2: 2, 3: 3, 4: 3, 5: 4}[i]; part != want {
+			t.Errorf("%d. got %v, wanted %d", i, part, want)
+		}
+	}
+}
+
+func TestInputKey(t *testing.T) {
+	inp := make(map[string]int)
+	for i := 0; i < 32; i++ {
+		inp[string(rune('a'+i))] = i
+	}
+	want := InputKey("Ref", inp)
+	for i := 0; i < 10; i++ {
+		if got := InputKey("Ref", inp); got != want {
+			t.Fatalf("unstable key: got %q, wanted %q", got, want)
+		}
+	}
+}
+
+type closingReceiver struct {
+	receiver
+	closed int
+}
+
+func (cr *closingReceiver) Close() error { cr.closed++; return nil }
+
+func TestCloseReceiver(t *testing.T) {
+	if err := CloseReceiver(&receiver{}); err != nil {
+		t.Fatal(err)
+	}
+	inner := &closingReceiver{receiver: receiver{parts: []interface{}{1, 2}}}
+	var canceled bool
+	recv := &prefixedReceiver{
+		parts: []interface{}{0},
+		rest:  &cancelReceiver{Receiver: inner, cancel: func() { canceled = true }},
+	}
+	if _, err := recv.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	if err := CloseReceiver(recv); err != nil {
+		t.Fatal(err)
+	}
+	if inner.closed != 1 || !canceled {
+		t.Errorf("closed=%d canceled=%t", inner.closed, canceled)
+	}
+	if part, err := recv.Recv(); err != io.EOF {
+		t.Errorf("got %v, %v after Close", part, err)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+)
+
+// MaxMsgSizes returns the call options of the receive and send message size limits,
+// omitting the zero ones.
+func MaxMsgSizes(recv, send int) []grpc.CallOption {
+	var opts []grpc.CallOption
+	if recv != 0 {
+		opts = append(opts, grpc.MaxCallRecvMsgSize(recv))
+	}
+	if send != 0 {
+		opts = append(opts, grpc.MaxCallSendMsgSize(send))
+	}
+	return opts
+}
+
+type callOptionsClient struct {
+	Client
+	byMethod map[string][]grpc.CallOption
+}
+
+// WithMethodCallOptions returns a Client which appends the method's call options from byMethod
+// (by the short or the fully qualified name) to the calls,
+// for example MaxMsgSizes(512<<20, 0) for the methods returning huge results.
+//
+// The options given to Call come last, so they override these.
+func WithMethodCallOptions(c Client, byMethod map[string][]grpc.CallOption) Client {
+	return callOptionsClient{Client: c, byMethod: byMethod}
+}
+
+func (cc callOptionsClient) options(name string) []grpc.CallOption {
+	if opts, ok := cc.byMethod[name]; ok {
+		return opts
+	}
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		return cc.byMethod[name[i+1:]]
+	}
+	return nil
+}
+
+// Call the named method with the method's call options.
+func (cc callOptionsClient) Call(name string, ctx context.Context, input interface{}, opts ...grpc.CallOption) (Receiver, error) {
+	if mOpts := cc.options(name); len(mOpts) != 0 {
+		opts = append(append(make([]grpc.CallOption, 0, len(mOpts)+len(opts)), mOpts...), opts...)
+	}
+	return cc.Client.Call(name, ctx, input, opts...)
+}
@@ -0,0 +1,46 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+func TestWithMethodCallOptions(t *testing.T) {
+	oc := &optsClient{}
+	c := WithMethodCallOptions(oc, map[string][]grpc.CallOption{
+		"Big": MaxMsgSizes(512<<20, 0),
+	})
+	ctx := context.Background()
+	for name, want := range map[string]int{"Big": 2, "/pkg.Svc/Big": 2, "Small": 1} {
+		_, _ = c.Call(name, ctx, nil, grpc.WaitForReady(true)) // fakeClient has no methods
+		got := oc.opts
+		if len(got) != want {
+			t.Errorf("%s: got %d options, wanted %d", name, len(got), want)
+		}
+		if want == 2 {
+			if mr, ok := got[0].(grpc.MaxRecvMsgSizeCallOption); !ok || mr.MaxRecvMsgSize != 512<<20 {
+				t.Errorf("%s: got %#v", name, got[0])
+			}
+		}
+	}
+	if opts := MaxMsgSizes(0, 0); len(opts) != 0 {
+		t.Errorf("got %v for zeros", opts)
+	}
+}
@@ -18,27 +18,61 @@ package grpcer
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"strings"
+	"time"
 
 	"github.com/UNO-SOFT/otel"
 	"github.com/UNO-SOFT/otel/gtrace"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
 )
 
 // Receiver is an interface for Recv()-ing streamed responses from the server.
+//
+// A Receiver may implement io.Closer to abort the call before the end of the stream,
+// see CloseReceiver.
 type Receiver interface {
 	Recv() (interface{}, error)
 }
 
+// CloseReceiver aborts the call of r and releases its resources, if r implements io.Closer.
+//
+// Recv must not be called after CloseReceiver.
+func CloseReceiver(r Receiver) error {
+	if c, ok := r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// ReceiverWithMetadata is a Receiver which can return the header and trailer metadata of the call.
+//
+// Trailer is available only after Recv returned io.EOF (or another error).
+type ReceiverWithMetadata interface {
+	Receiver
+	Header() (metadata.MD, error)
+	Trailer() metadata.MD
+}
+
 // Client is the client interface for calling a gRPC server.
 type Client interface {
 	// List the available names
 	List() []string
 	// Input returns the input struct for the name.
 	Input(name string) interface{}
+	// Output returns the output struct for the name.
+	Output(name string) interface{}
 	// Call the named function.
 	Call(name string, ctx context.Context, input interface{}, opts ...grpc.CallOption) (Receiver, error)
 }
@@ -52,22 +86,218 @@ type DialConfig struct {
 	Log                            func(keyvals ...interface{}) error
 	AllowInsecurePasswordTransport bool
 	Tracer                         otel.Tracer
+	// DualStack races the IPv6 and IPv4 addresses of the endpoint,
+	// and uses the first connection that succeeds.
+	DualStack bool
+	// FallbackDelay is the head start of IPv6 when DualStack is set,
+	// DefaultFallbackDelay if zero.
+	FallbackDelay time.Duration
+	// LoadBalancingPolicy is the balancer name (pick_first, round_robin or a registered custom one)
+	// put into the default service config.
+	// Use a "dns:///" target for multiple resolved addresses.
+	LoadBalancingPolicy string
+	// StatsHandlers are installed with grpc.WithStatsHandler.
+	StatsHandlers []stats.Handler
+	// MaxRecvMsgSize and MaxSendMsgSize are the message size limits of the calls,
+	// the gRPC defaults (4MiB to receive, unlimited to send) are used if zero.
+	// See WithMethodCallOptions and MaxMsgSizes for the per-method limits.
+	MaxRecvMsgSize, MaxSendMsgSize int
+	// DefaultCallOptions are applied to every call (compression, wait-for-ready, max sizes).
+	DefaultCallOptions []grpc.CallOption
+	// Metadata is added to the outgoing metadata of each call.
+	Metadata map[string]string
+	// MetadataFunc is called for each call, and the returned metadata
+	// is added to the outgoing metadata.
+	MetadataFunc func(context.Context) metadata.MD
+	// PathPrefixes are tried in order (e.g. /v2, /v1), the next one used when
+	// the server returns Unimplemented. Overrides PathPrefix.
+	PathPrefixes []string
+	// MethodPathPrefixes is the fixed prefix of the (short or fully qualified) methods.
+	MethodPathPrefixes map[string]string
+	// SpanAttributes are the input fields (e.g. CustomerID or Order.ID) added
+	// to the client spans as "input.<field>" attributes.
+	SpanAttributes []string
+	// RedactedSpanAttributes are replaced by the keyed hash of their value,
+	// which is the same for the same value only within the process.
+	RedactedSpanAttributes []string
+	// Compression is the compressor of the requests: "gzip" (the default), NoCompression ("identity",
+	// or "none"), or any other registered with encoding.RegisterCompressor - zstd and snappy
+	// are not built in, import a package registering their gRPC compressor.
+	Compression string
+	// CompressionLevel is the gzip level of the requests (gzip.DefaultCompression if zero),
+	// sent as "gzip". The per-method compressors of WithCompression use the default level.
+	CompressionLevel int
+	// CompressionThreshold is the size below which the unary requests are sent uncompressed.
+	CompressionThreshold int
+	// InitialWindowSize and InitialConnWindowSize are the HTTP/2 flow-control windows
+	// of a stream and of the connection, for high-bandwidth, high-latency links.
+	// The minimum is 64KiB, the gRPC defaults are used if zero.
+	InitialWindowSize, InitialConnWindowSize int32
+	// Keepalive sends HTTP/2 pings on the idle connection every Time (at least 10s),
+	// closing it when unanswered for Timeout - to detect the connections silently dropped
+	// by firewalls. The server's keepalive enforcement policy must permit it.
+	Keepalive keepalive.ClientParameters
+	// ReadBufferSize and WriteBufferSize are the transport buffer sizes,
+	// the gRPC defaults (32KiB) are used if zero.
+	ReadBufferSize, WriteBufferSize int
+	// MessageSizes observes the message sizes of the calls, if not nil.
+	MessageSizes *MessageSizes
+	// Secrets is the source of the basic auth credentials named by UsernameSecret and PasswordSecret,
+	// read for each call (wrap it in CachedSecrets), instead of Username and Password.
+	Secrets                        SecretSource
+	UsernameSecret, PasswordSecret string
+	// CertFile and KeyFile are the PEM files of the client certificate for mutual TLS.
+	CertFile, KeyFile string
+	// ReloadKeyPair reloads the CertFile and KeyFile when they change on disk.
+	ReloadKeyPair bool
+	// Certificate is the client certificate, overriding CertFile and KeyFile.
+	Certificate *tls.Certificate
+	// TLS uses a TLS connection even without any CA set, verified with the system roots.
+	TLS bool
+	// CAFiles are more PEM files with CAs, added to the pool of CAFile.
+	CAFiles []string
+	// CAPEM is the CA certificates in PEM, added to the pool of CAFile.
+	CAPEM []byte
+	// CASecret is the name of the secret in Secrets holding CA certificates in PEM,
+	// added to the pool of CAFile.
+	CASecret string
+	// SystemRoots adds the system root CAs to the pool of the given CAs.
+	// Without any CA given, the system roots are used anyway.
+	SystemRoots bool
+	// Block makes ConnectContext wait until the connection is up.
+	Block bool
+	// DialTimeout limits the time ConnectContext waits for the connection, if Block is set.
+	DialTimeout time.Duration
+	// TokenSource sends "authorization: Bearer" tokens, instead of Username and Password,
+	// see NewBearerAuth.
+	TokenSource TokenSource
+	// CredentialMetadata is sent with each call (e.g. {"x-api-key": "..."}),
+	// with the same transport security requirement as the password, see NewMetadataCredentials.
+	CredentialMetadata map[string]string
+	// Retry installs interceptors retrying the calls failing with a retryable code
+	// (Unavailable and ResourceExhausted by default), with exponential backoff and jitter.
+	// Only the idempotent methods are retried, as with WithRetry (by their short or full name
+	// in Idempotent, or their idempotency_level option), see also RetryAttempts.
+	Retry *RetryPolicy
+	// DefaultCallTimeout is the timeout of the calls whose context has no deadline
+	// (including all the retries), unlimited if zero.
+	DefaultCallTimeout time.Duration
+	// ConnKey tells apart the configs sharing a connection in a ConnManager.
+	ConnKey string
+
+	// recorder records the dial errors for ConnectContext.
+	recorder *dialRecorder
 }
 
 // DialOpts renders the dial options for calling a gRPC server.
 //
 // * prefix is inserted before the standard request path - if your server serves on different path.
 // * caFile is the PEM file with the server's CA.
+// * certFile and keyFile are the client certificate, for mutual TLS.
 // * serverHostOverride is to override the CA's host.
+// * dualStack races IPv4 and IPv6 connections.
 func DialOpts(conf DialConfig) ([]grpc.DialOption, error) {
-	dialOpts := make([]grpc.DialOption, 0, 6)
+	dialOpts := make([]grpc.DialOption, 0, 7)
+	var callOpts []grpc.CallOption
+	switch nm := conf.Compression; nm {
+	case "", gzip.Name:
+		cp, err := gzipLevelCompressor(conf.CompressionLevel)
+		if err != nil {
+			return nil, err
+		}
+		if cp != nil { // a default UseCompressor would override it
+			dialOpts = append(dialOpts, grpc.WithCompressor(cp))
+		} else {
+			callOpts = append(callOpts, grpc.UseCompressor(gzip.Name))
+		}
+	case NoCompression, "none":
+	default:
+		if encoding.GetCompressor(nm) == nil {
+			return nil, fmt.Errorf("compressor %q is not registered", nm)
+		}
+		callOpts = append(callOpts, grpc.UseCompressor(nm))
+	}
+	if conf.InitialWindowSize != 0 {
+		dialOpts = append(dialOpts, grpc.WithInitialWindowSize(conf.InitialWindowSize))
+	}
+	if conf.InitialConnWindowSize != 0 {
+		dialOpts = append(dialOpts, grpc.WithInitialConnWindowSize(conf.InitialConnWindowSize))
+	}
+	if conf.Keepalive != (keepalive.ClientParameters{}) {
+		dialOpts = append(dialOpts, grpc.WithKeepaliveParams(conf.Keepalive))
+	}
+	if conf.ReadBufferSize != 0 {
+		dialOpts = append(dialOpts, grpc.WithReadBufferSize(conf.ReadBufferSize))
+	}
+	if conf.WriteBufferSize != 0 {
+		dialOpts = append(dialOpts, grpc.WithWriteBufferSize(conf.WriteBufferSize))
+	}
+	if conf.CompressionThreshold > 0 {
+		dialOpts = append(dialOpts, grpc.WithChainUnaryInterceptor(compressionThreshold(conf.CompressionThreshold)))
+	}
+	var dialer func(context.Context, string) (net.Conn, error)
+	if conf.DualStack {
+		dialer = dualStackDialer(conf.FallbackDelay)
+	}
+	if conf.recorder != nil {
+		dialer = conf.recorder.dialer(dialer)
+	}
+	if dialer != nil {
+		dialOpts = append(dialOpts, grpc.WithContextDialer(dialer))
+	}
+	callOpts = append(append(callOpts, MaxMsgSizes(conf.MaxRecvMsgSize, conf.MaxSendMsgSize)...), conf.DefaultCallOptions...)
+	if len(callOpts) != 0 {
+		dialOpts = append(dialOpts, grpc.WithDefaultCallOptions(callOpts...))
+	}
 	dialOpts = append(dialOpts,
-		//lint:ignore SA1019 the UseCompressor API is experimental yet.
-		grpc.WithCompressor(grpc.NewGZIPCompressor()),
-		//lint:ignore SA1019 the UseCompressor API is experimental yet.
-		grpc.WithDecompressor(grpc.NewGZIPDecompressor()))
+		grpc.WithChainStreamInterceptor(inflightStreamInterceptor),
+		grpc.WithChainUnaryInterceptor(inflightUnaryInterceptor),
+	)
+	if conf.DefaultCallTimeout > 0 {
+		ct := callTimeout(conf.DefaultCallTimeout)
+		dialOpts = append(dialOpts,
+			grpc.WithChainStreamInterceptor(ct.StreamClientInterceptor),
+			grpc.WithChainUnaryInterceptor(ct.UnaryClientInterceptor),
+		)
+	}
+	if conf.Retry != nil {
+		ri := newRetryInterceptor(*conf.Retry)
+		dialOpts = append(dialOpts,
+			grpc.WithChainStreamInterceptor(ri.StreamClientInterceptor),
+			grpc.WithChainUnaryInterceptor(ri.UnaryClientInterceptor),
+		)
+	}
+	if ms := conf.MessageSizes; ms != nil {
+		dialOpts = append(dialOpts,
+			grpc.WithChainStreamInterceptor(ms.StreamClientInterceptor),
+			grpc.WithChainUnaryInterceptor(ms.UnaryClientInterceptor),
+		)
+	}
+	if ma := newMetadataAppender(conf.Metadata, conf.MetadataFunc); ma != nil {
+		dialOpts = append(dialOpts,
+			grpc.WithChainStreamInterceptor(ma.StreamClientInterceptor),
+			grpc.WithChainUnaryInterceptor(ma.UnaryClientInterceptor),
+		)
+	}
+	switch len(conf.StatsHandlers) {
+	case 0:
+	case 1:
+		dialOpts = append(dialOpts, grpc.WithStatsHandler(conf.StatsHandlers[0]))
+	default:
+		dialOpts = append(dialOpts, grpc.WithStatsHandler(multiStatsHandler(conf.StatsHandlers)))
+	}
+	if sc, err := serviceConfig(conf); err != nil {
+		return dialOpts, err
+	} else if sc != "" {
+		dialOpts = append(dialOpts, grpc.WithDefaultServiceConfig(sc))
+	}
 
-	if prefix, Log := conf.PathPrefix, conf.Log; prefix != "" || Log != nil {
+	prefixes := conf.PathPrefixes
+	if len(prefixes) == 0 && conf.PathPrefix != "" {
+		prefixes = []string{conf.PathPrefix}
+	}
+	sa := newSpanAttributer(conf.SpanAttributes, conf.RedactedSpanAttributes)
+	if Log := conf.Log; len(prefixes) != 0 || len(conf.MethodPathPrefixes) != 0 || Log != nil || sa != nil {
 		tracer := conf.Tracer
 		if tracer == nil {
 			tracer = otel.LogTracer(Log, "github.com/UNO-SOFT/grpcer")
@@ -75,43 +305,81 @@ func DialOpts(conf DialConfig) ([]grpc.DialOption, error) {
 		if Log == nil {
 			Log = func(keyvals ...interface{}) error { return nil }
 		}
+		pr := newPrefixRouter(prefixes, conf.MethodPathPrefixes, Log)
+		streamInterceptors := []grpc.StreamClientInterceptor{
+			pr.StreamClientInterceptor,
+			gtrace.StreamClientInterceptor(tracer),
+		}
+		unaryInterceptors := []grpc.UnaryClientInterceptor{
+			pr.UnaryClientInterceptor,
+			gtrace.UnaryClientInterceptor(tracer),
+		}
+		if sa != nil {
+			// after gtrace, to have the span in the context
+			streamInterceptors = append(streamInterceptors, sa.StreamClientInterceptor)
+			unaryInterceptors = append(unaryInterceptors, sa.UnaryClientInterceptor)
+		}
 		dialOpts = append(dialOpts,
-			grpc.WithChainStreamInterceptor(
-				func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
-					Log("method", method)
-					//opts = append(opts, grpc.UseCompressor("gzip"))
-					return streamer(ctx, desc, cc, prefix+method, opts...)
-				},
-				gtrace.StreamClientInterceptor(tracer),
-			),
-			grpc.WithChainUnaryInterceptor(
-				func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-					Log("method", method)
-					//opts = append(opts, grpc.UseCompressor("gzip"))
-					return invoker(ctx, prefix+method, req, reply, cc, opts...)
-				},
-				gtrace.UnaryClientInterceptor(tracer),
-			),
+			grpc.WithChainStreamInterceptor(streamInterceptors...),
+			grpc.WithChainUnaryInterceptor(unaryInterceptors...),
 		)
 	}
-	if conf.CAFile == "" {
+	if !conf.useTLS() {
 		if conf.AllowInsecurePasswordTransport {
 			ba := NewInsecureBasicAuth(conf.Username, conf.Password)
+			if conf.TokenSource != nil {
+				ba = NewInsecureBearerAuth(conf.TokenSource)
+			} else if conf.Secrets != nil {
+				ba = NewSecretBasicAuth(conf.Secrets, conf.UsernameSecret, conf.PasswordSecret, true)
+			}
 			dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(ba))
+			if len(conf.CredentialMetadata) != 0 {
+				dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(NewMetadataCredentials(conf.CredentialMetadata, false)))
+			}
 		}
 		return append(dialOpts, grpc.WithInsecure()), nil
 	}
 	ba := NewBasicAuth(conf.Username, conf.Password)
+	if conf.TokenSource != nil {
+		ba = NewBearerAuth(conf.TokenSource)
+	} else if conf.Secrets != nil {
+		ba = NewSecretBasicAuth(conf.Secrets, conf.UsernameSecret, conf.PasswordSecret, false)
+	}
 	dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(ba))
-	creds, err := credentials.NewClientTLSFromFile(conf.CAFile, conf.ServerHostOverride)
+	if len(conf.CredentialMetadata) != 0 {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(NewMetadataCredentials(conf.CredentialMetadata, true)))
+	}
+	tc, err := tlsConfig(conf)
 	if err != nil {
 		return dialOpts, fmt.Errorf("%q,%q: %w", conf.CAFile, conf.ServerHostOverride, err)
 	}
+	creds := credentials.NewTLS(tc)
+	if conf.recorder != nil {
+		creds = recordingCreds{TransportCredentials: creds, dr: conf.recorder}
+	}
 	dialOpts = append(dialOpts, grpc.WithTransportCredentials(creds))
 
 	return dialOpts, nil
 }
 
+// serviceConfig renders the default service config JSON from the config.
+func serviceConfig(conf DialConfig) (string, error) {
+	sc := make(map[string]interface{}, 1)
+	if conf.LoadBalancingPolicy != "" {
+		sc["loadBalancingConfig"] = []map[string]interface{}{
+			{conf.LoadBalancingPolicy: struct{}{}},
+		}
+	}
+	if len(sc) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(sc)
+	if err != nil {
+		return "", fmt.Errorf("marshal service config %#v: %w", sc, err)
+	}
+	return string(b), nil
+}
+
 // Connect to the given endpoint, with the Certificate Authority and hostOverride.
 func Connect(endpoint, CAFile, serverHostOverride string) (*grpc.ClientConn, error) {
 	var prefix string
@@ -127,13 +395,31 @@ func Connect(endpoint, CAFile, serverHostOverride string) (*grpc.ClientConn, err
 			return nil
 		},
 	}
-	opts, err := DialOpts(dc)
+	return ConnectContext(context.Background(), endpoint, dc)
+}
+
+// ConnectContext connects to the endpoint with the config.
+//
+// With conf.Block set, it waits for the connection (at most conf.DialTimeout, if set),
+// and the returned *ConnectError tells DNS, TLS and connection refused failures apart
+// (see ErrDNS, ErrTLS and ErrConnectionRefused).
+func ConnectContext(ctx context.Context, endpoint string, conf DialConfig) (*grpc.ClientConn, error) {
+	conf.recorder = new(dialRecorder)
+	opts, err := DialOpts(conf)
 	if err != nil {
-		return nil, fmt.Errorf("%#v: %w", dc, err)
+		return nil, fmt.Errorf("connect %s: %w", endpoint, err)
+	}
+	if conf.Block {
+		opts = append(opts, grpc.WithBlock(), grpc.WithReturnConnectionError(), grpc.FailOnNonTempDialError(true))
+		if conf.DialTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, conf.DialTimeout)
+			defer cancel()
+		}
 	}
-	conn, err := grpc.Dial(endpoint, opts...)
+	conn, err := grpc.DialContext(ctx, endpoint, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("%s:  %w", endpoint, err)
+		return nil, conf.recorder.connectError(endpoint, err)
 	}
 	return conn, nil
 }
@@ -0,0 +1,165 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+// startHealthServer starts a health server, counting its calls.
+func startHealthServer(t *testing.T, calls *int32) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		atomic.AddInt32(calls, 1)
+		return handler(ctx, req)
+	}))
+	grpc_health_v1.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+	return ln.Addr().String()
+}
+
+// dialHealth dials the endpoint with DialOpts(conf) and the extra options.
+func dialHealth(t *testing.T, endpoint string, conf DialConfig, extra ...grpc.DialOption) grpc_health_v1.HealthClient {
+	t.Helper()
+	opts, err := DialOpts(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.Dial(endpoint, append(opts, extra...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return grpc_health_v1.NewHealthClient(conn)
+}
+
+func TestDialOptsLoadBalancingPolicy(t *testing.T) {
+	for i, tc := range []struct {
+		Policy   string
+		WantBoth bool
+	}{
+		{},
+		{Policy: "pick_first"},
+		{Policy: "round_robin", WantBoth: true},
+	} {
+		var calls [2]int32
+		r := manual.NewBuilderWithScheme("lb" + strconv.Itoa(i))
+		r.InitialState(resolver.State{Addresses: []resolver.Address{
+			{Addr: startHealthServer(t, &calls[0])}, {Addr: startHealthServer(t, &calls[1])},
+		}})
+		hc := dialHealth(t, r.Scheme()+":///test", DialConfig{LoadBalancingPolicy: tc.Policy}, grpc.WithResolvers(r))
+		both := func() bool { return atomic.LoadInt32(&calls[0]) != 0 && atomic.LoadInt32(&calls[1]) != 0 }
+		// round_robin picks the ready connections only, so call till both are used (or timeout)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		for n := 0; n < 20 || tc.WantBoth && !both() && ctx.Err() == nil; n++ {
+			if _, err := hc.Check(ctx, &grpc_health_v1.HealthCheckRequest{}, grpc.WaitForReady(true)); err != nil {
+				break
+			}
+		}
+		cancel()
+		if got := both(); got != tc.WantBoth {
+			t.Errorf("%q: got calls %d and %d, wanted both used: %t", tc.Policy, atomic.LoadInt32(&calls[0]), atomic.LoadInt32(&calls[1]), tc.WantBoth)
+		}
+	}
+}
+
+// countingStatsHandler counts the ended RPCs and the received payload bytes.
+type countingStatsHandler struct {
+	ends, inBytes int32
+}
+
+func (h *countingStatsHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+func (h *countingStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+func (h *countingStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
+func (h *countingStatsHandler) HandleRPC(_ context.Context, s stats.RPCStats) {
+	switch s := s.(type) {
+	case *stats.End:
+		atomic.AddInt32(&h.ends, 1)
+	case *stats.InPayload:
+		atomic.AddInt32(&h.inBytes, int32(s.WireLength))
+	}
+}
+
+func TestDialOptsStatsHandlers(t *testing.T) {
+	var calls int32
+	endpoint := startHealthServer(t, &calls)
+	for _, n := range []int{0, 1, 3} {
+		handlers := make([]*countingStatsHandler, n)
+		conf := DialConfig{}
+		for i := range handlers {
+			handlers[i] = new(countingStatsHandler)
+			conf.StatsHandlers = append(conf.StatsHandlers, handlers[i])
+		}
+		hc := dialHealth(t, endpoint, conf)
+		for i := 0; i < 2; i++ {
+			if _, err := hc.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i, h := range handlers {
+			if ends, inBytes := atomic.LoadInt32(&h.ends), atomic.LoadInt32(&h.inBytes); ends != 2 || inBytes == 0 {
+				t.Errorf("%d/%d. got %d ends, %d bytes received; wanted 2 ends and the payload", i, n, ends, inBytes)
+			}
+		}
+	}
+}
+
+func TestDialOptsDefaultCallOptions(t *testing.T) {
+	var calls int32
+	endpoint := startHealthServer(t, &calls)
+	for name, tc := range map[string]struct {
+		Conf    DialConfig
+		Service string
+		Want    codes.Code
+	}{
+		"none":     {},
+		"max recv": {Conf: DialConfig{DefaultCallOptions: []grpc.CallOption{grpc.MaxCallRecvMsgSize(1)}}, Want: codes.ResourceExhausted},
+		"max send": {Conf: DialConfig{DefaultCallOptions: []grpc.CallOption{grpc.MaxCallSendMsgSize(1)}}, Service: "xyz", Want: codes.ResourceExhausted},
+		"override": {Conf: DialConfig{MaxRecvMsgSize: 1, DefaultCallOptions: []grpc.CallOption{grpc.MaxCallRecvMsgSize(1 << 20)}}},
+	} {
+		hc := dialHealth(t, endpoint, tc.Conf)
+		_, err := hc.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: tc.Service})
+		if tc.Service != "" && status.Code(err) == codes.NotFound {
+			err = nil // sent
+		}
+		if got := status.Code(err); got != tc.Want {
+			t.Errorf("%s: got %v (%+v), wanted %v", name, got, err, tc.Want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// NamespaceSeparator separates the namespace from the method name in a combined Client.
+const NamespaceSeparator = "."
+
+type combinedClient map[string]Client
+
+var _ = MethodLister(combinedClient(nil))
+
+// Combine the Clients into one, which prefixes the method names with the
+// namespace (the key of the map) and NamespaceSeparator, like "billing.GetInvoice",
+// and routes the calls to the right Client (also called as "billing/GetInvoice").
+func Combine(clients map[string]Client) Client {
+	return combinedClient(clients)
+}
+
+// split the name to the Client and the method name.
+//
+// The name is "namespace/Method" (split on the last "/"), or "namespace.Method",
+// where the longest matching namespace wins, so both the namespaces and the method names
+// (e.g. "billing.v1.Invoices/Get") may contain dots.
+func (cc combinedClient) split(name string) (Client, string) {
+	name = strings.TrimPrefix(name, "/")
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		if c := cc[name[:i]]; c != nil {
+			return c, name[i+1:]
+		}
+	}
+	var ns string
+	for k := range cc {
+		if len(k) > len(ns) && strings.HasPrefix(name, k+NamespaceSeparator) {
+			ns = k
+		}
+	}
+	if ns == "" {
+		return nil, ""
+	}
+	return cc[ns], name[len(ns)+len(NamespaceSeparator):]
+}
+
+// List the namespaced names of all the Clients.
+func (cc combinedClient) List() []string {
+	var names []string
+	for ns, c := range cc {
+		for _, nm := range c.List() {
+			names = append(names, ns+NamespaceSeparator+nm)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ListMethods returns the namespaced metadata of all the Clients' methods.
+func (cc combinedClient) ListMethods() []MethodMeta {
+	var methods []MethodMeta
+	for ns, c := range cc {
+		for _, mm := range ListMethods(c) {
+			mm.Name = ns + NamespaceSeparator + mm.Name
+			methods = append(methods, mm)
+		}
+	}
+	return methods
+}
+
+// Input returns the input struct of the namespaced name.
+func (cc combinedClient) Input(name string) interface{} {
+	if c, nm := cc.split(name); c != nil {
+		return c.Input(nm)
+	}
+	return nil
+}
+
+// Output returns the output struct of the namespaced name.
+func (cc combinedClient) Output(name string) interface{} {
+	if c, nm := cc.split(name); c != nil {
+		return c.Output(nm)
+	}
+	return nil
+}
+
+// Call the namespaced name.
+func (cc combinedClient) Call(name string, ctx context.Context, input interface{}, opts ...grpc.CallOption) (Receiver, error) {
+	c, nm := cc.split(name)
+	if c == nil {
+		return nil, status.Errorf(codes.NotFound, "no client for %q", name)
+	}
+	return c.Call(nm, ctx, input, opts...)
+}
@@ -0,0 +1,64 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCombine(t *testing.T) {
+	ret := func(name string) func(ctx context.Context, input interface{}) (Receiver, error) {
+		return func(ctx context.Context, input interface{}) (Receiver, error) {
+			return &receiver{parts: []interface{}{name}}, nil
+		}
+	}
+	c := Combine(map[string]Client{
+		"billing": fakeClient{"GetInvoice": ret("billing")},
+		"crm":     fakeClient{"GetInvoice": ret("crm"), "GetCustomer": ret("crm")},
+		"crm.eu":  fakeClient{"crm.v1.Customers/Get": ret("crm.eu")},
+	})
+	if got, want := c.List(), []string{"billing.GetInvoice", "crm.GetCustomer", "crm.GetInvoice", "crm.eu.crm.v1.Customers/Get"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+	recv, err := c.Call("crm.GetInvoice", context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if part, _ := recv.Recv(); part != "crm" {
+		t.Errorf("got %v, wanted crm", part)
+	}
+	for name, want := range map[string]string{
+		"billing/GetInvoice":          "billing",
+		"crm.eu.crm.v1.Customers/Get": "crm.eu",
+		"/crm/GetCustomer":            "crm",
+	} {
+		recv, err := c.Call(name, context.Background(), nil)
+		if err != nil {
+			t.Fatalf("%s: %+v", name, err)
+		}
+		if part, _ := recv.Recv(); part != want {
+			t.Errorf("%s: got %v, wanted %s", name, part, want)
+		}
+	}
+	if c.Input("billing.GetInvoice") == nil {
+		t.Error("no input for billing.GetInvoice")
+	}
+	if _, err = c.Call("hr.GetInvoice", context.Background(), nil); err == nil {
+		t.Error("wanted error for unknown namespace")
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/protobuf/proto"
+)
+
+// NoCompression is the compressor name for sending the requests uncompressed.
+const NoCompression = encoding.Identity
+
+// gzipLevelCompressor returns the compressor of the connection with the gzip level, or nil for the default level.
+//
+// It is sent as "gzip", so any server can decompress it - unlike the registered "gzip"
+// compressor of gRPC, its level is not process-wide.
+func gzipLevelCompressor(level int) (grpc.Compressor, error) {
+	if level == 0 || level == gzip.DefaultCompression {
+		return nil, nil
+	}
+	cp, err := grpc.NewGZIPCompressorWithLevel(level)
+	if err != nil {
+		return nil, fmt.Errorf("gzip level %d: %w", level, err)
+	}
+	return cp, nil
+}
+
+type compressionClient struct {
+	Client
+	byMethod map[string]string
+}
+
+// WithCompression returns a Client which compresses the requests of the methods in byMethod
+// with the named compressor: "gzip", NoCompression, or any other registered with
+// encoding.RegisterCompressor (e.g. zstd).
+//
+// The call options of the caller take precedence.
+func WithCompression(c Client, byMethod map[string]string) Client {
+	return compressionClient{Client: c, byMethod: byMethod}
+}
+
+func (cc compressionClient) compressor(name string) (string, bool) {
+	if nm, ok := cc.byMethod[name]; ok {
+		return nm, true
+	}
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		if nm, ok := cc.byMethod[name[i+1:]]; ok {
+			return nm, true
+		}
+	}
+	return "", false
+}
+
+// Call the named method with the method's compressor.
+func (cc compressionClient) Call(name string, ctx context.Context, input interface{}, opts ...grpc.CallOption) (Receiver, error) {
+	if nm, ok := cc.compressor(name); ok {
+		opts = append([]grpc.CallOption{grpc.UseCompressor(nm)}, opts...)
+	}
+	return cc.Client.Call(name, ctx, input, opts...)
+}
+
+// compressionThreshold returns an interceptor which sends the requests smaller than threshold uncompressed.
+func compressionThreshold(threshold int) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if pr, ok := req.(protoReflecter); ok && proto.Size(pr.ProtoReflect().Interface()) < threshold {
+			opts = append([]grpc.CallOption{grpc.UseCompressor(NoCompression)}, opts...)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// acceptsGzip reports whether the client accepts gzip Content-Encoding.
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(enc, ";")
+		if strings.TrimSpace(params[0]) != "gzip" {
+			continue
+		}
+		for _, p := range params[1:] {
+			if p = strings.TrimSpace(p); strings.HasPrefix(p, "q=") {
+				if q, err := strconv.ParseFloat(p[2:], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter compresses the response if it reaches threshold bytes.
+//
+// The header is written when that is decided, so Close must be called at the end.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	level, threshold int
+	code             int
+	buf              []byte
+	zw               *gzip.Writer
+	decided          bool
+}
+
+func newGzipResponseWriter(w http.ResponseWriter, level, threshold int) *gzipResponseWriter {
+	w.Header().Add("Vary", "Accept-Encoding")
+	return &gzipResponseWriter{ResponseWriter: w, level: level, threshold: threshold}
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if w.decided {
+		if w.zw != nil {
+			return w.zw.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) < w.threshold {
+		return len(p), nil
+	}
+	if err := w.startGzip(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// startGzip writes the header and the buffered data, compressed.
+func (w *gzipResponseWriter) startGzip() error {
+	w.decided = true
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+	w.writeHeader()
+	zw, err := gzip.NewWriterLevel(w.ResponseWriter, w.level)
+	if err != nil {
+		return err
+	}
+	w.zw = zw
+	_, err = zw.Write(w.buf)
+	w.buf = nil
+	return err
+}
+
+func (w *gzipResponseWriter) writeHeader() {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+}
+
+// Flush starts the compression (if not decided yet), and flushes the compressed data.
+func (w *gzipResponseWriter) Flush() {
+	if !w.decided && w.startGzip() != nil {
+		return
+	}
+	if w.zw != nil {
+		_ = w.zw.Flush()
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Close writes the buffered (short) response uncompressed, or finishes the compressed one.
+func (w *gzipResponseWriter) Close() error {
+	if w.decided {
+		if w.zw != nil {
+			return w.zw.Close()
+		}
+		return nil
+	}
+	w.decided = true
+	w.writeHeader()
+	_, err := w.ResponseWriter.Write(w.buf)
+	w.buf = nil
+	return err
+}
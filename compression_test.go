@@ -0,0 +1,184 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/stats"
+)
+
+// optsClient records the call options.
+type optsClient struct {
+	fakeClient
+	opts []grpc.CallOption
+}
+
+func (oc *optsClient) Call(name string, ctx context.Context, input interface{}, opts ...grpc.CallOption) (Receiver, error) {
+	oc.opts = opts
+	return oc.fakeClient.Call(name, ctx, input, opts...)
+}
+
+func (oc *optsClient) compressor() string {
+	var nm string
+	for _, o := range oc.opts {
+		if co, ok := o.(grpc.CompressorCallOption); ok {
+			nm = co.CompressorType
+		}
+	}
+	return nm
+}
+
+func TestCompression(t *testing.T) {
+	ret := func(ctx context.Context, input interface{}) (Receiver, error) { return &receiver{}, nil }
+	oc := &optsClient{fakeClient: fakeClient{"GetPDF": ret, "GetRows": ret, "Other": ret}}
+	c := WithCompression(oc, map[string]string{"GetPDF": NoCompression, "GetRows": "gzip"})
+	ctx := context.Background()
+	for name, want := range map[string]string{"GetPDF": "identity", "GetRows": "gzip", "Other": ""} {
+		if _, err := c.Call(name, ctx, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := oc.compressor(); got != want {
+			t.Errorf("%s: got %q, wanted %q", name, got, want)
+		}
+	}
+	if _, err := c.Call("GetPDF", ctx, nil, grpc.UseCompressor("gzip")); err != nil {
+		t.Fatal(err)
+	}
+	if got := oc.compressor(); got != "gzip" {
+		t.Errorf("caller's option: got %q", got)
+	}
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	interceptor := compressionThreshold(10)
+	for svc, want := range map[string]string{"a": "identity", "abcdefghijklmnop": ""} {
+		oc := &optsClient{}
+		if err := interceptor(context.Background(), "/a.B/C", &grpc_health_v1.HealthCheckRequest{Service: svc}, nil, nil,
+			func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				oc.opts = opts
+				return nil
+			},
+		); err != nil {
+			t.Fatal(err)
+		}
+		if got := oc.compressor(); got != want {
+			t.Errorf("%q: got %q, wanted %q", svc, got, want)
+		}
+	}
+}
+
+func TestGzipResponseWriter(t *testing.T) {
+	for _, body := range []string{"short", strings.Repeat("long ", 100)} {
+		rec := httptest.NewRecorder()
+		w := newGzipResponseWriter(rec, gzip.BestSpeed, 100)
+		w.WriteHeader(201)
+		io.WriteString(w, body)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != 201 {
+			t.Errorf("got code %d", rec.Code)
+		}
+		got := rec.Body.String()
+		if rec.Header().Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = string(b)
+		} else if len(body) >= 100 {
+			t.Errorf("%d long body is not compressed", len(body))
+		}
+		if got != body {
+			t.Errorf("got %q, wanted %q", got, body)
+		}
+	}
+}
+
+// testCompressor is an uncompressing encoding.Compressor.
+type testCompressor struct{}
+
+func (testCompressor) Compress(w io.Writer) (io.WriteCloser, error) { return nopWriteCloser{w}, nil }
+func (testCompressor) Decompress(r io.Reader) (io.Reader, error)    { return r, nil }
+func (testCompressor) Name() string                                 { return "test-compressor" }
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestDialOptsCompression(t *testing.T) {
+	encoding.RegisterCompressor(testCompressor{})
+	for nm, wantErr := range map[string]bool{
+		"": false, "gzip": false, "none": false, NoCompression: false,
+		"test-compressor": false, "nosuch": true,
+	} {
+		if _, err := DialOpts(DialConfig{Compression: nm}); (err != nil) != wantErr {
+			t.Errorf("%q: got %+v, wanted error: %t", nm, err, wantErr)
+		}
+	}
+}
+
+// compressionStatsHandler records the compressor of the sent headers.
+type compressionStatsHandler struct {
+	countingStatsHandler
+	mu          sync.Mutex
+	compression string
+}
+
+func (h *compressionStatsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	if oh, ok := s.(*stats.OutHeader); ok {
+		h.mu.Lock()
+		h.compression = oh.Compression
+		h.mu.Unlock()
+	}
+	h.countingStatsHandler.HandleRPC(ctx, s)
+}
+
+func TestDialOptsCompressionLevel(t *testing.T) {
+	if _, err := DialOpts(DialConfig{CompressionLevel: 42}); err == nil {
+		t.Error("invalid level accepted")
+	}
+	var calls int32
+	endpoint := startHealthServer(t, &calls)
+	for _, level := range []int{0, gzip.BestSpeed, gzip.BestCompression} {
+		sh := new(compressionStatsHandler)
+		hc := dialHealth(t, endpoint, DialConfig{CompressionLevel: level, StatsHandlers: []stats.Handler{sh}})
+		// the server knows only the standard gzip
+		if _, err := hc.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}); err != nil {
+			t.Fatalf("%d: %+v", level, err)
+		}
+		sh.mu.Lock()
+		got := sh.compression
+		sh.mu.Unlock()
+		if got != "gzip" {
+			t.Errorf("%d: sent %q, wanted gzip", level, got)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+)
+
+// ConnManager shares the connections between the Clients with the same endpoint,
+// transport security and credentials (and DialConfig.ConnKey), counting the references.
+//
+// The other settings of the shared connection are those of the config dialing it:
+// set ConnKey to tell apart the configs differing in them (e.g. in PathPrefix or Metadata).
+type ConnManager struct {
+	// Dial connects to the endpoint, with DialOpts(conf) and grpc.Dial if nil.
+	Dial func(endpoint string, conf DialConfig) (*grpc.ClientConn, error)
+
+	mu    sync.Mutex
+	conns map[connKey]*sharedConn
+}
+
+type sharedConn struct {
+	// ready is closed when the dial is finished, setting conn or err.
+	ready chan struct{}
+	conn  *grpc.ClientConn
+	err   error
+	refs  int
+}
+
+// connKey is the identity of the shared connection.
+//
+// The SecretSource and the TokenSource are compared by identity,
+// and never match if they are not comparable (e.g. a TokenSourceFunc).
+type connKey struct {
+	endpoint, key string
+
+	tls, systemRoots                 bool
+	serverHostOverride               string
+	caFile, caFiles, caPEM, caSecret string
+	certFile, keyFile                string
+	certificate                      *tls.Certificate
+	username, password               string
+	usernameSecret, passwordSecret   string
+	allowInsecurePasswordTransport   bool
+	credentialMetadata               string
+	secrets, tokenSource             interface{}
+}
+
+func newConnKey(endpoint string, conf DialConfig) connKey {
+	md := make([]string, 0, len(conf.CredentialMetadata))
+	for k, v := range conf.CredentialMetadata {
+		md = append(md, k+"\x00"+v)
+	}
+	sort.Strings(md)
+	return connKey{
+		endpoint:                       endpoint,
+		key:                            conf.ConnKey,
+		tls:                            conf.TLS,
+		systemRoots:                    conf.SystemRoots,
+		serverHostOverride:             conf.ServerHostOverride,
+		caFile:                         conf.CAFile,
+		caFiles:                        strings.Join(conf.CAFiles, "\x00"),
+		caPEM:                          string(conf.CAPEM),
+		caSecret:                       conf.CASecret,
+		certFile:                       conf.CertFile,
+		keyFile:                        conf.KeyFile,
+		certificate:                    conf.Certificate,
+		username:                       conf.Username,
+		password:                       conf.Password,
+		usernameSecret:                 conf.UsernameSecret,
+		passwordSecret:                 conf.PasswordSecret,
+		allowInsecurePasswordTransport: conf.AllowInsecurePasswordTransport,
+		credentialMetadata:             strings.Join(md, "\x00"),
+		secrets:                        identity(conf.Secrets),
+		tokenSource:                    identity(conf.TokenSource),
+	}
+}
+
+// identity returns v as a map key: v itself if its type is comparable,
+// otherwise a new pointer, which matches nothing.
+func identity(v interface{}) interface{} {
+	if v == nil || reflect.TypeOf(v).Comparable() {
+		return v
+	}
+	return new(byte)
+}
+
+// Get returns the shared connection for the endpoint and config, dialing it if needed.
+// The returned release func must be called when the connection is no longer used:
+// the connection is closed after the last release.
+//
+// Only the Gets of the same connection wait for its dial (e.g. with Block set).
+func (cm *ConnManager) Get(endpoint string, conf DialConfig) (*grpc.ClientConn, func() error, error) {
+	key := newConnKey(endpoint, conf)
+	cm.mu.Lock()
+	sc := cm.conns[key]
+	dialing := sc == nil
+	if dialing {
+		sc = &sharedConn{ready: make(chan struct{})}
+		if cm.conns == nil {
+			cm.conns = make(map[connKey]*sharedConn)
+		}
+		cm.conns[key] = sc
+	}
+	sc.refs++
+	cm.mu.Unlock()
+
+	if dialing {
+		dial := cm.Dial
+		if dial == nil {
+			dial = dialConfig
+		}
+		sc.conn, sc.err = dial(endpoint, conf)
+		if sc.err != nil {
+			cm.mu.Lock()
+			if cm.conns[key] == sc {
+				delete(cm.conns, key)
+			}
+			cm.mu.Unlock()
+		}
+		close(sc.ready)
+	} else {
+		<-sc.ready
+	}
+	if sc.err != nil {
+		cm.release(key, sc)
+		return nil, nil, sc.err
+	}
+	var once sync.Once
+	release := func() error {
+		var err error
+		once.Do(func() { err = cm.release(key, sc) })
+		return err
+	}
+	return sc.conn, release, nil
+}
+
+func (cm *ConnManager) release(key connKey, sc *sharedConn) error {
+	cm.mu.Lock()
+	sc.refs--
+	last := sc.refs == 0
+	if last && cm.conns[key] == sc {
+		delete(cm.conns, key)
+	}
+	cm.mu.Unlock()
+	if last && sc.conn != nil {
+		return sc.conn.Close()
+	}
+	return nil
+}
+
+// Len returns the number of open connections.
+func (cm *ConnManager) Len() int {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return len(cm.conns)
+}
+
+func dialConfig(endpoint string, conf DialConfig) (*grpc.ClientConn, error) {
+	opts, err := DialOpts(conf)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := grpc.Dial(endpoint, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", endpoint, err)
+	}
+	return conn, nil
+}
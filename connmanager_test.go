@@ -0,0 +1,152 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+func TestConnManager(t *testing.T) {
+	var cm ConnManager
+	conf := DialConfig{AllowInsecurePasswordTransport: true, Username: "a", Password: "x"}
+	c1, release1, err := cm.Get("localhost:1", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, release2, err := cm.Get("localhost:1", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1 != c2 {
+		t.Error("same config got different connections")
+	}
+	conf.Password = "y"
+	c3, release3, err := cm.Get("localhost:1", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c3 == c1 {
+		t.Error("different credentials share the connection")
+	}
+	if n := cm.Len(); n != 2 {
+		t.Errorf("got %d connections, wanted 2", n)
+	}
+
+	release1()
+	release1() // idempotent
+	if c1.GetState() == connectivity.Shutdown {
+		t.Error("closed while referenced")
+	}
+	release2()
+	if c1.GetState() != connectivity.Shutdown {
+		t.Error("not closed after the last release")
+	}
+	release3()
+	if n := cm.Len(); n != 0 {
+		t.Errorf("got %d connections, wanted 0", n)
+	}
+}
+
+type testLogger struct{}
+
+func (testLogger) Log(...interface{}) error { return nil }
+
+func TestConnKey(t *testing.T) {
+	base := DialConfig{Metadata: map[string]string{"a": "1", "b": "2"}}
+	var logger testLogger
+	tokens := TokenSourceFunc(func(context.Context) (string, error) { return "t", nil })
+	for name, tc := range map[string]struct {
+		a, b func(*DialConfig)
+		same bool
+	}{
+		"same":        {a: func(*DialConfig) {}, b: func(*DialConfig) {}, same: true},
+		"metadata":    {a: func(*DialConfig) {}, b: func(c *DialConfig) { c.Metadata = map[string]string{"c": "3"} }, same: true},
+		"keepalive":   {a: func(*DialConfig) {}, b: func(c *DialConfig) { c.Keepalive.Time = time.Minute }, same: true},
+		"log":         {a: func(c *DialConfig) { c.Log = logger.Log }, b: func(c *DialConfig) { c.Log = logger.Log }, same: true},
+		"conn key":    {a: func(*DialConfig) {}, b: func(c *DialConfig) { c.ConnKey = "b" }},
+		"password":    {a: func(c *DialConfig) { c.Password = "x" }, b: func(c *DialConfig) { c.Password = "y" }},
+		"tls":         {a: func(*DialConfig) {}, b: func(c *DialConfig) { c.TLS = true }},
+		"ca":          {a: func(c *DialConfig) { c.CAFiles = []string{"a.pem"} }, b: func(c *DialConfig) { c.CAFiles = []string{"b.pem"} }},
+		"credentials": {a: func(c *DialConfig) { c.CredentialMetadata = map[string]string{"x-api-key": "1"} }, b: func(c *DialConfig) { c.CredentialMetadata = map[string]string{"x-api-key": "2"} }},
+		"secrets":     {a: func(c *DialConfig) { c.Secrets = EnvSecrets{Prefix: "A_"} }, b: func(c *DialConfig) { c.Secrets = EnvSecrets{Prefix: "A_"} }, same: true},
+		"token func":  {a: func(c *DialConfig) { c.TokenSource = tokens }, b: func(c *DialConfig) { c.TokenSource = tokens }},
+	} {
+		a, b := base, base
+		tc.a(&a)
+		tc.b(&b)
+		if same := newConnKey("localhost:1", a) == newConnKey("localhost:1", b); same != tc.same {
+			t.Errorf("%s: got same=%t, wanted %t", name, same, tc.same)
+		}
+	}
+}
+
+func TestConnManagerDial(t *testing.T) {
+	slow, dialed := make(chan struct{}), make(chan string, 3)
+	cm := ConnManager{Dial: func(endpoint string, conf DialConfig) (*grpc.ClientConn, error) {
+		dialed <- conf.ConnKey
+		if conf.ConnKey == "slow" {
+			<-slow
+		}
+		return dialConfig(endpoint, conf)
+	}}
+	conf := DialConfig{AllowInsecurePasswordTransport: true}
+	var mu sync.Mutex
+	var releases []func() error
+	defer func() {
+		for _, release := range releases {
+			release()
+		}
+	}()
+	get := func(key string) <-chan *grpc.ClientConn {
+		ch := make(chan *grpc.ClientConn, 1)
+		go func() {
+			conf := conf
+			conf.ConnKey = key
+			conn, release, err := cm.Get("localhost:1", conf)
+			if err != nil {
+				t.Error(err)
+			} else {
+				mu.Lock()
+				releases = append(releases, release)
+				mu.Unlock()
+			}
+			ch <- conn
+		}()
+		return ch
+	}
+	slow1 := get("slow")
+	<-dialed
+	slow2 := get("slow")
+	// another connection is not blocked by the slow dial
+	select {
+	case <-get("fast"):
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked by the slow dial")
+	}
+	close(slow)
+	if c1, c2 := <-slow1, <-slow2; c1 == nil || c1 != c2 {
+		t.Errorf("got %p and %p, wanted the same connection", c1, c2)
+	}
+	if n := len(dialed); n != 1 {
+		t.Errorf("dialed %d more times, wanted 1 (fast)", n)
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"encoding/csv"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// MergeComma sets the field delimiter of MergeStreamsCSV (',' by default, '\t' for TSV).
+func MergeComma(comma rune) MergeOption { return func(mc *mergeConfig) { mc.comma = comma } }
+
+// MergeStreamsCSV writes the elements of the first slice field of the parts as CSV rows into w,
+// with a header row of the (flattened) fields of the element type.
+//
+// The column names are taken from the csv or json struct tags, or the field names;
+// nested structs are flattened as "parent.child", times are formatted as by SetTimeFormats
+// (RFC3339 by default), other non-scalar values are written as JSON.
+// Parts without slice fields are written as rows themselves.
+func MergeStreamsCSV(w io.Writer, first interface{}, recv Receiver, opts ...MergeOption) error {
+	var mc mergeConfig
+	for _, o := range opts {
+		o(&mc)
+	}
+	Log := mc.Log
+	if Log == nil {
+		Log = func(...interface{}) error { return nil }
+	}
+	enc := newCSVEncoder(w)
+	if mc.comma != 0 {
+		enc.cw.Comma = mc.comma
+	}
+	return encodeParts(enc, first, recv, Log)
+}
+
+// csvColumn is a flattened field of a struct.
+type csvColumn struct {
+	Name  string
+	Index [][]int // the field indexes, through the pointers
+}
+
+// csvColumns returns the flattened columns of the type.
+func csvColumns(t reflect.Type) []csvColumn {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || timeOf(t) != nil {
+		return []csvColumn{{}}
+	}
+	var cols []csvColumn
+	var walk func(t reflect.Type, prefix string, index [][]int)
+	walk = func(t reflect.Type, prefix string, index [][]int) {
+		for i, n := 0, t.NumField(); i < n; i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, ok := f.Tag.Lookup("csv")
+			if !ok {
+				name = f.Tag.Get("json")
+			}
+			if name, _, _ = strings.Cut(name, ","); name == "-" {
+				continue
+			}
+			idx := append(append(make([][]int, 0, len(index)+1), index...), []int{i})
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr && ft.Elem().Kind() == reflect.Struct {
+				ft = ft.Elem()
+			}
+			isStruct := ft.Kind() == reflect.Struct && timeOf(ft) == nil
+			if f.Anonymous && name == "" && isStruct {
+				walk(ft, prefix, idx)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			if isStruct {
+				walk(ft, prefix+name+".", idx)
+				continue
+			}
+			cols = append(cols, csvColumn{Name: prefix + name, Index: idx})
+		}
+	}
+	walk(t, "", nil)
+	return cols
+}
+
+// value returns the column's value in rv, and false if a pointer on the way is nil.
+func (c csvColumn) value(rv reflect.Value) (reflect.Value, bool) {
+	for _, idx := range c.Index {
+		for rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				return rv, false
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(idx[0])
+	}
+	return rv, true
+}
+
+// csvCell returns the textual form of the value.
+func csvCell(rv reflect.Value) (string, error) {
+	if get := timeOf(rv.Type()); get != nil {
+		p := reflect.New(rv.Type())
+		p.Elem().Set(rv)
+		t := get(p.UnsafePointer())
+		if t.IsZero() {
+			return "", nil
+		}
+		if tfs := currentTimeFormats.Load(); tfs != nil && tfs.global != nil {
+			stream := jsoniter.ConfigDefault.BorrowStream(nil)
+			defer jsoniter.ConfigDefault.ReturnStream(stream)
+			tfs.global.encode(t, stream)
+			return strings.Trim(string(stream.Buffer()), `"`), nil
+		}
+		return t.Format(time.RFC3339Nano), nil
+	}
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return "", nil
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'g', -1, rv.Type().Bits()), nil
+	case reflect.Slice, reflect.Map:
+		if rv.IsNil() {
+			return "", nil
+		}
+	}
+	b, err := jsoniter.Marshal(rv.Interface())
+	return string(b), err
+}
+
+// csvEncoder writes the elements of the first slice field of the parts as CSV rows.
+type csvEncoder struct {
+	cw    *csv.Writer
+	slice string // name of the slice field
+	cols  []csvColumn
+	row   []string
+}
+
+func newCSVEncoder(w io.Writer) *csvEncoder { return &csvEncoder{cw: csv.NewWriter(w)} }
+
+func (e *csvEncoder) Encode(part interface{}) error {
+	if e.cols == nil {
+		if slice, _ := sliceFields(part); len(slice) != 0 {
+			e.slice = slice[0].Name
+			e.cols = csvColumns(reflect.TypeOf(slice[0].Value).Elem())
+			if len(e.cols) == 1 && e.cols[0].Name == "" { // not a struct
+				e.cols[0].Name = slice[0].JSONName
+			}
+		} else {
+			e.cols = csvColumns(reflect.TypeOf(part))
+		}
+		header := make([]string, len(e.cols))
+		for i, c := range e.cols {
+			header[i] = c.Name
+		}
+		if err := e.cw.Write(header); err != nil {
+			return err
+		}
+	}
+	if e.slice == "" {
+		return e.writeRow(reflect.ValueOf(part))
+	}
+	slice, _ := sliceFields(part)
+	for _, f := range slice {
+		if f.Name != e.slice {
+			continue
+		}
+		rv := reflect.ValueOf(f.Value)
+		for i, n := 0, rv.Len(); i < n; i++ {
+			if err := e.writeRow(rv.Index(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return e.cw.Error()
+}
+
+func (e *csvEncoder) writeRow(rv reflect.Value) error {
+	e.row = e.row[:0]
+	for _, c := range e.cols {
+		var s string
+		if v, ok := c.value(rv); ok {
+			var err error
+			if s, err = csvCell(v); err != nil {
+				return err
+			}
+		}
+		e.row = append(e.row, s)
+	}
+	return e.cw.Write(e.row)
+}
+
+func (e *csvEncoder) Close() error {
+	e.cw.Flush()
+	return e.cw.Error()
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type csvAddress struct {
+	City string `json:"city"`
+}
+
+type csvRow struct {
+	ID      int         `json:"id"`
+	Name    string      `csv:"full_name" json:"name"`
+	Address *csvAddress `json:"address"`
+	Tags    []string    `json:"tags"`
+	Created time.Time   `json:"created"`
+	Secret  string      `csv:"-"`
+}
+
+type csvPart struct {
+	Total int
+	Rows  []csvRow `json:"rows"`
+}
+
+func TestMergeStreamsCSV(t *testing.T) {
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	recv := &receiver{parts: []interface{}{
+		&csvPart{Rows: []csvRow{{ID: 2, Name: "b, c", Tags: []string{"x", "y"}}}},
+	}}
+	var buf bytes.Buffer
+	if err := MergeStreamsCSV(&buf, &csvPart{Total: 2, Rows: []csvRow{{ID: 1, Name: "a", Address: &csvAddress{City: "Bp"}, Created: created, Secret: "s"}}}, recv); err != nil {
+		t.Fatal(err)
+	}
+	want := `id,full_name,address.city,tags,created
+1,a,Bp,,2020-01-02T03:04:05Z
+2,"b, c",,"[""x"",""y""]",
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwanted\n%s", got, want)
+	}
+}
+
+func TestJSONHandlerTSV(t *testing.T) {
+	h := JSONHandler{Client: fakeClient{
+		"Get": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return &receiver{parts: []interface{}{
+				&struct{ Codes []string }{Codes: []string{"x", "y"}},
+				&struct{ Codes []string }{Codes: []string{"z"}},
+			}}, nil
+		},
+	}}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/Get?format=tsv", strings.NewReader("{}")))
+	if got, want := w.Body.String(), "Codes\nx\ny\nz\n"; got != want {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/tab-separated-values" {
+		t.Errorf("got Content-Type %q", ct)
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"reflect"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// MethodInfo describes a method with its input and output messages.
+type MethodInfo struct {
+	Name            string      `json:"name"`
+	Comment         string      `json:"comment,omitempty"`
+	ClientStreaming bool        `json:"clientStreaming,omitempty"`
+	ServerStreaming bool        `json:"serverStreaming,omitempty"`
+	Input           MessageInfo `json:"input"`
+	Output          MessageInfo `json:"output"`
+}
+
+// MessageInfo describes a message.
+type MessageInfo struct {
+	Name    string      `json:"name"`
+	Comment string      `json:"comment,omitempty"`
+	Fields  []FieldInfo `json:"fields,omitempty"`
+}
+
+// FieldInfo describes a field of a message.
+//
+// Type is the scalar kind ("string", "int64"...), or the full name of the message or enum.
+type FieldInfo struct {
+	Name     string       `json:"name"`
+	JSONName string       `json:"jsonName,omitempty"`
+	Type     string       `json:"type"`
+	Repeated bool         `json:"repeated,omitempty"`
+	Optional bool         `json:"optional,omitempty"`
+	Map      bool         `json:"map,omitempty"`
+	Enum     []string     `json:"enum,omitempty"`
+	Comment  string       `json:"comment,omitempty"`
+	Message  *MessageInfo `json:"message,omitempty"`
+}
+
+type protoReflecter interface {
+	ProtoReflect() protoreflect.Message
+}
+
+// Describe the named method of the Client.
+//
+// For generated protobuf messages the streaming flags and the comments are found through
+// the file descriptor, for other types only the Go struct fields are listed.
+func Describe(c Client, name string) (MethodInfo, error) {
+	if r, ok := c.(Resolver); ok {
+		nm, err := r.Resolve(name)
+		if err != nil {
+			return MethodInfo{}, err
+		}
+		name = nm
+	}
+	inp := c.Input(name)
+	if inp == nil {
+		return MethodInfo{}, status.Errorf(codes.NotFound, "method %q not found", name)
+	}
+	mi := MethodInfo{Name: name}
+	pr, ok := inp.(protoReflecter)
+	if !ok {
+		mi.Input = describeStruct(reflect.TypeOf(inp), make(map[reflect.Type]bool))
+		if out := c.Output(name); out != nil {
+			mi.Output = describeStruct(reflect.TypeOf(out), make(map[reflect.Type]bool))
+		}
+		return mi, nil
+	}
+	in := pr.ProtoReflect().Descriptor()
+	mi.Input = describeMessage(in, make(map[protoreflect.FullName]bool))
+	if md := findMethod(in, name); md != nil {
+		mi.Comment = comment(md)
+		mi.ClientStreaming, mi.ServerStreaming = md.IsStreamingClient(), md.IsStreamingServer()
+		mi.Output = describeMessage(md.Output(), make(map[protoreflect.FullName]bool))
+	}
+	return mi, nil
+}
+
+// findMethod finds the method with the given name and input in the input's file.
+func findMethod(in protoreflect.MessageDescriptor, name string) protoreflect.MethodDescriptor {
+	svcs := in.ParentFile().Services()
+	for i := 0; i < svcs.Len(); i++ {
+		if md := svcs.Get(i).Methods().ByName(protoreflect.Name(name)); md != nil && md.Input().FullName() == in.FullName() {
+			return md
+		}
+	}
+	return nil
+}
+
+func comment(desc protoreflect.Descriptor) string {
+	path := sourcePath(desc)
+	if path == nil {
+		return ""
+	}
+	locs := desc.ParentFile().SourceLocations()
+Locations:
+	for i := 0; i < locs.Len(); i++ {
+		loc := locs.Get(i)
+		if len(loc.Path) != len(path) {
+			continue
+		}
+		for j, p := range path {
+			if loc.Path[j] != p {
+				continue Locations
+			}
+		}
+		return strings.TrimSpace(loc.LeadingComments + loc.TrailingComments)
+	}
+	return ""
+}
+
+// sourcePath returns the path of desc in its file's SourceCodeInfo
+// (the field numbers of descriptor.proto and the indexes), or nil for an unknown kind.
+func sourcePath(desc protoreflect.Descriptor) protoreflect.SourcePath {
+	parent := desc.Parent()
+	if parent == nil {
+		return protoreflect.SourcePath{}
+	}
+	_, inFile := parent.(protoreflect.FileDescriptor)
+	var field int32
+	switch d := desc.(type) {
+	case protoreflect.MessageDescriptor:
+		field = 3 // DescriptorProto.nested_type
+		if inFile {
+			field = 4 // FileDescriptorProto.message_type
+		}
+	case protoreflect.EnumDescriptor:
+		field = 4 // DescriptorProto.enum_type
+		if inFile {
+			field = 5 // FileDescriptorProto.enum_type
+		}
+	case protoreflect.ServiceDescriptor:
+		field = 6 // FileDescriptorProto.service
+	case protoreflect.MethodDescriptor, protoreflect.EnumValueDescriptor:
+		field = 2 // ServiceDescriptorProto.method, EnumDescriptorProto.value
+	case protoreflect.OneofDescriptor:
+		field = 8 // DescriptorProto.oneof_decl
+	case protoreflect.FieldDescriptor:
+		field = 2 // DescriptorProto.field
+		if d.IsExtension() {
+			field = 6 // DescriptorProto.extension
+			if inFile {
+				field = 7 // FileDescriptorProto.extension
+			}
+		}
+	default:
+		return nil
+	}
+	path := sourcePath(parent)
+	if path == nil {
+		return nil
+	}
+	return append(path[:len(path):len(path)], field, int32(desc.Index()))
+}
+
+func describeMessage(md protoreflect.MessageDescriptor, seen map[protoreflect.FullName]bool) MessageInfo {
+	mi := MessageInfo{Name: string(md.FullName()), Comment: comment(md)}
+	if seen[md.FullName()] {
+		return mi
+	}
+	seen[md.FullName()] = true
+	defer delete(seen, md.FullName())
+	fields := md.Fields()
+	mi.Fields = make([]FieldInfo, 0, fields.Len())
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		fi := FieldInfo{
+			Name: string(fd.Name()), JSONName: fd.JSONName(),
+			Repeated: fd.IsList(), Map: fd.IsMap(),
+			Optional: fd.HasOptionalKeyword() || fd.Syntax() == protoreflect.Proto2 && fd.Cardinality() == protoreflect.Optional,
+			Comment:  comment(fd),
+		}
+		if fd.IsMap() {
+			fd = fd.MapValue()
+		}
+		switch fd.Kind() {
+		case protoreflect.EnumKind:
+			ed := fd.Enum()
+			fi.Type = string(ed.FullName())
+			values := ed.Values()
+			fi.Enum = make([]string, values.Len())
+			for j := range fi.Enum {
+				fi.Enum[j] = string(values.Get(j).Name())
+			}
+		case protoreflect.MessageKind, protoreflect.GroupKind:
+			fi.Type = string(fd.Message().FullName())
+			sub := describeMessage(fd.Message(), seen)
+			fi.Message = &sub
+		default:
+			fi.Type = fd.Kind().String()
+		}
+		mi.Fields = append(mi.Fields, fi)
+	}
+	return mi
+}
+
+func describeStruct(t reflect.Type, seen map[reflect.Type]bool) MessageInfo {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	mi := MessageInfo{Name: t.String()}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return mi
+	}
+	seen[t] = true
+	defer delete(seen, t)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		fi := FieldInfo{Name: f.Name, JSONName: strings.SplitN(f.Tag.Get("json"), ",", 2)[0]}
+		ft := f.Type
+		switch ft.Kind() {
+		case reflect.Slice:
+			if ft.Elem().Kind() != reflect.Uint8 {
+				fi.Repeated, ft = true, ft.Elem()
+			}
+		case reflect.Map:
+			fi.Map, ft = true, ft.Elem()
+		case reflect.Ptr:
+			fi.Optional = true
+		}
+		if fi.Type = ft.String(); ft.Kind() == reflect.Struct || ft.Kind() == reflect.Ptr && ft.Elem().Kind() == reflect.Struct {
+			sub := describeStruct(ft, seen)
+			fi.Message = &sub
+		}
+		mi.Fields = append(mi.Fields, fi)
+	}
+	return mi
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+type healthClient struct{}
+
+func (healthClient) List() []string { return []string{"Check", "Watch"} }
+func (healthClient) Input(name string) interface{} {
+	switch name {
+	case "Check", "Watch":
+		return new(grpc_health_v1.HealthCheckRequest)
+	}
+	return nil
+}
+func (healthClient) Output(name string) interface{} {
+	switch name {
+	case "Check", "Watch":
+		return new(grpc_health_v1.HealthCheckResponse)
+	}
+	return nil
+}
+func (healthClient) Call(name string, ctx context.Context, input interface{}, opts ...grpc.CallOption) (Receiver, error) {
+	return &receiver{}, nil
+}
+
+func TestDescribe(t *testing.T) {
+	mi, err := Describe(healthClient{}, "Watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%+v", mi)
+	if !mi.ServerStreaming {
+		t.Error("Watch should be server streaming")
+	}
+	if got, want := mi.Input.Name, "grpc.health.v1.HealthCheckRequest"; got != want {
+		t.Errorf("input: got %q, wanted %q", got, want)
+	}
+	if len(mi.Output.Fields) != 1 {
+		t.Fatalf("output: wanted 1 field, got %+v", mi.Output)
+	}
+	if f := mi.Output.Fields[0]; f.Name != "status" || len(f.Enum) == 0 {
+		t.Errorf("output field: got %+v", f)
+	}
+
+	if _, err = Describe(healthClient{}, "Unknown"); err == nil {
+		t.Error("wanted error for unknown method")
+	}
+}
+
+func TestListMethods(t *testing.T) {
+	methods := ListMethods(healthClient{})
+	if len(methods) != 2 {
+		t.Fatalf("wanted 2 methods, got %+v", methods)
+	}
+	if mm := methods[0]; mm.Name != "Check" || mm.ServerStreaming {
+		t.Errorf("got %+v, wanted unary Check", mm)
+	}
+	if mm := methods[1]; mm.Name != "Watch" || !mm.ServerStreaming {
+		t.Errorf("got %+v, wanted server streaming Watch", mm)
+	}
+}
+
+func TestComment(t *testing.T) {
+	fdp := testDescriptorSet().File[0]
+	fdp.SourceCodeInfo = &descriptorpb.SourceCodeInfo{Location: []*descriptorpb.SourceCodeInfo_Location{
+		{Path: []int32{4, 1}, Span: []int32{0, 0, 1}, LeadingComments: proto.String(" Resp is the response.\n")},
+		{Path: []int32{4, 1, 2, 0}, Span: []int32{0, 0, 1}, TrailingComments: proto.String(" the greeting\n")},
+		{Path: []int32{6, 0, 2, 1}, Span: []int32{0, 0, 1}, LeadingComments: proto.String(" Hellos streams.\n")},
+	}}
+	fd, err := protodesc.NewFile(fdp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := fd.Messages().ByName("Resp")
+	for _, tc := range []struct {
+		Name string
+		Got  string
+		Want string
+	}{
+		{"Req", comment(fd.Messages().ByName("Req")), ""},
+		{"Resp", comment(resp), "Resp is the response."},
+		{"Resp.greeting", comment(resp.Fields().ByName("greeting")), "the greeting"},
+		{"Greeter.Hellos", comment(fd.Services().Get(0).Methods().ByName("Hellos")), "Hellos streams."},
+	} {
+		if tc.Got != tc.Want {
+			t.Errorf("%s: got %q, wanted %q", tc.Name, tc.Got, tc.Want)
+		}
+	}
+}
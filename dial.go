@@ -0,0 +1,243 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// DefaultFallbackDelay is the head start of the preferred (IPv6) address family
+// when racing dual-stack connections.
+var DefaultFallbackDelay = 300 * time.Millisecond
+
+// dualStackDialer returns a dialer for grpc.WithContextDialer which resolves
+// the host, and races the IPv6 and IPv4 addresses (RFC 6555 "Happy Eyeballs"),
+// returning the first connection that succeeds.
+func dualStackDialer(fallbackDelay time.Duration) func(context.Context, string) (net.Conn, error) {
+	if fallbackDelay <= 0 {
+		fallbackDelay = DefaultFallbackDelay
+	}
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", addr, err)
+		}
+		if net.ParseIP(host) != nil {
+			var d net.Dialer
+			return d.DialContext(ctx, "tcp", addr)
+		}
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, fmt.Errorf("lookup %s: %w", host, err)
+		}
+		var primary, fallback []string
+		for _, a := range addrs {
+			hp := net.JoinHostPort(a.String(), port)
+			if a.IP.To4() == nil {
+				primary = append(primary, hp)
+			} else {
+				fallback = append(fallback, hp)
+			}
+		}
+		if len(primary) == 0 {
+			primary, fallback = fallback, nil
+		}
+		return raceDial(ctx, primary, fallback, fallbackDelay)
+	}
+}
+
+// raceDial dials the primary addresses serially, and starts dialing the
+// fallback addresses after delay, or when the primaries all failed.
+//
+// The first successful connection is returned, the others are closed.
+func raceDial(ctx context.Context, primary, fallback []string, delay time.Duration) (net.Conn, error) {
+	if len(primary) == 0 {
+		return nil, fmt.Errorf("no addresses to dial")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, 2)
+	dialSerial := func(addrs []string) {
+		var d net.Dialer
+		var err error
+		for _, addr := range addrs {
+			var conn net.Conn
+			if conn, err = d.DialContext(ctx, "tcp", addr); err == nil {
+				results <- result{conn: conn}
+				return
+			}
+		}
+		results <- result{err: err}
+	}
+
+	go dialSerial(primary)
+	running := 1
+	var timer *time.Timer
+	var fallbackC <-chan time.Time
+	if len(fallback) != 0 {
+		timer = time.NewTimer(delay)
+		defer timer.Stop()
+		fallbackC = timer.C
+	}
+	startFallback := func() {
+		running++
+		go dialSerial(fallback)
+		fallbackC, fallback = nil, nil
+	}
+	var firstErr error
+	for {
+		select {
+		case <-fallbackC:
+			startFallback()
+		case res := <-results:
+			running--
+			if res.err == nil {
+				if running != 0 {
+					go func() {
+						if res := <-results; res.conn != nil {
+							res.conn.Close()
+						}
+					}()
+				}
+				return res.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			if len(fallback) != 0 {
+				startFallback()
+			} else if running == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+// The kinds of the connection failures, see ConnectError.
+var (
+	ErrDNS               = errors.New("DNS resolution failed")
+	ErrTLS               = errors.New("TLS handshake failed")
+	ErrConnectionRefused = errors.New("connection refused")
+)
+
+// ConnectError is the error of ConnectContext.
+type ConnectError struct {
+	Endpoint string
+	// Kind is ErrDNS, ErrTLS or ErrConnectionRefused - nil if unknown.
+	Kind error
+	Err  error
+}
+
+func (ce *ConnectError) Error() string {
+	if ce.Kind == nil {
+		return fmt.Sprintf("%s: %v", ce.Endpoint, ce.Err)
+	}
+	return fmt.Sprintf("%s: %v: %v", ce.Endpoint, ce.Kind, ce.Err)
+}
+
+// Unwrap returns both the Kind and the Err, for errors.Is.
+func (ce *ConnectError) Unwrap() []error {
+	if ce.Kind == nil {
+		return []error{ce.Err}
+	}
+	return []error{ce.Kind, ce.Err}
+}
+
+// dialRecorder records the last dial and TLS handshake errors,
+// as gRPC does not wrap them in its errors.
+type dialRecorder struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (dr *dialRecorder) record(err error) {
+	dr.mu.Lock()
+	dr.err = err
+	dr.mu.Unlock()
+}
+
+// connectError returns the ConnectError for the dial error,
+// classified by the last recorded error.
+func (dr *dialRecorder) connectError(endpoint string, err error) *ConnectError {
+	dr.mu.Lock()
+	last := dr.err
+	dr.mu.Unlock()
+	ce := &ConnectError{Endpoint: endpoint, Err: err}
+	if last == nil {
+		return ce
+	}
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(last, ErrTLS):
+		ce.Kind = ErrTLS
+	case errors.As(last, &dnsErr):
+		ce.Kind = ErrDNS
+	case errors.Is(last, syscall.ECONNREFUSED):
+		ce.Kind = ErrConnectionRefused
+	}
+	if ce.Kind != nil {
+		ce.Err = fmt.Errorf("%w (%v)", err, last)
+	}
+	return ce
+}
+
+// dialer wraps dial (a net.Dialer if nil) to record its errors.
+func (dr *dialRecorder) dialer(dial func(context.Context, string) (net.Conn, error)) func(context.Context, string) (net.Conn, error) {
+	if dial == nil {
+		dial = func(ctx context.Context, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "tcp", addr)
+		}
+	}
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, addr)
+		if err != nil {
+			dr.record(err)
+		}
+		return conn, err
+	}
+}
+
+// recordingCreds records the errors of the client handshakes.
+type recordingCreds struct {
+	credentials.TransportCredentials
+	dr *dialRecorder
+}
+
+func (rc recordingCreds) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn2, ai, err := rc.TransportCredentials.ClientHandshake(ctx, authority, conn)
+	if err != nil {
+		rc.dr.record(fmt.Errorf("%w: %w", ErrTLS, err))
+	}
+	return conn2, ai, err
+}
+
+func (rc recordingCreds) Clone() credentials.TransportCredentials {
+	return recordingCreds{TransportCredentials: rc.TransportCredentials.Clone(), dr: rc.dr}
+}
@@ -0,0 +1,145 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRaceDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	// a closed listener's address refuses connections
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := closed.Addr().String()
+	closed.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for tN, tC := range map[string]struct {
+		Primary, Fallback []string
+		WantErr           bool
+	}{
+		"primary":  {Primary: []string{ln.Addr().String()}},
+		"fallback": {Primary: []string{refused}, Fallback: []string{ln.Addr().String()}},
+		"second":   {Primary: []string{refused, ln.Addr().String()}},
+		"none":     {Primary: []string{refused}, Fallback: []string{refused}, WantErr: true},
+	} {
+		conn, err := raceDial(ctx, tC.Primary, tC.Fallback, time.Second)
+		if tC.WantErr {
+			if err == nil {
+				conn.Close()
+				t.Errorf("%s: wanted error", tN)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %+v", tN, err)
+			continue
+		}
+		if got, want := conn.RemoteAddr().String(), ln.Addr().String(); got != want {
+			t.Errorf("%s: got %q, wanted %q", tN, got, want)
+		}
+		conn.Close()
+	}
+}
+
+func TestConnectContext(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := closed.Addr().String()
+	closed.Close()
+
+	certPEM, keyPEM := testCertificate(t, "localhost")
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsLn, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tlsLn.Close()
+	go func() {
+		for {
+			conn, err := tlsLn.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = conn.(*tls.Conn).Handshake()
+			}()
+		}
+	}()
+
+	for tN, tC := range map[string]struct {
+		Endpoint string
+		Conf     DialConfig
+		Want     error
+	}{
+		"refused": {Endpoint: refused, Conf: DialConfig{AllowInsecurePasswordTransport: true}, Want: ErrConnectionRefused},
+		"dns":     {Endpoint: "nonexistent.invalid:1", Conf: DialConfig{AllowInsecurePasswordTransport: true}, Want: ErrDNS},
+		"tls":     {Endpoint: tlsLn.Addr().String(), Conf: DialConfig{TLS: true}, Want: ErrTLS},
+	} {
+		tC.Conf.Block, tC.Conf.DialTimeout = true, time.Second
+		conn, err := ConnectContext(context.Background(), tC.Endpoint, tC.Conf)
+		if err == nil {
+			conn.Close()
+			t.Errorf("%s: wanted error", tN)
+			continue
+		}
+		var ce *ConnectError
+		if !errors.As(err, &ce) || !errors.Is(err, tC.Want) {
+			t.Errorf("%s: got %+v, wanted %v", tN, err, tC.Want)
+		}
+	}
+}
+
+func TestConnectContextConfigError(t *testing.T) {
+	conf := DialConfig{TLS: true, CAFile: "/nonexistent/ca.pem", Username: "user", Password: "s3cret"}
+	conn, err := ConnectContext(context.Background(), "localhost:1", conf)
+	if err == nil {
+		conn.Close()
+		t.Fatal("wanted error")
+	}
+	if msg := err.Error(); strings.Contains(msg, "s3cret") || !strings.Contains(msg, "localhost:1") {
+		t.Errorf("got %q", msg)
+	}
+}
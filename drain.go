@@ -0,0 +1,108 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc"
+)
+
+// inflight counts the active calls by connection, for CloseGracefully.
+var inflight = struct {
+	mu    sync.Mutex
+	conns map[*grpc.ClientConn]*connCalls
+}{conns: make(map[*grpc.ClientConn]*connCalls)}
+
+type connCalls struct {
+	n    int
+	idle chan struct{} // closed when n drops to zero
+}
+
+// startCall registers a call on cc, returning the func to call (once) when it ends.
+func startCall(cc *grpc.ClientConn) func() {
+	inflight.mu.Lock()
+	c := inflight.conns[cc]
+	if c == nil {
+		c = &connCalls{idle: make(chan struct{})}
+		inflight.conns[cc] = c
+	}
+	c.n++
+	inflight.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			inflight.mu.Lock()
+			if c.n--; c.n == 0 {
+				close(c.idle)
+				delete(inflight.conns, cc)
+			}
+			inflight.mu.Unlock()
+		})
+	}
+}
+
+// CloseGracefully waits for the calls in flight on conn (made through DialOpts' interceptors)
+// to finish, at most till the context is done, then closes conn.
+//
+// A stream is finished when its Receiver returned an error (io.EOF included),
+// the response of a client-streaming call, or its context is canceled (Close of the Receiver).
+func CloseGracefully(ctx context.Context, conn *grpc.ClientConn) error {
+	inflight.mu.Lock()
+	c := inflight.conns[conn]
+	inflight.mu.Unlock()
+	if c != nil {
+		select {
+		case <-c.idle:
+		case <-ctx.Done():
+		}
+	}
+	return conn.Close()
+}
+
+func inflightUnaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	done := startCall(cc)
+	defer done()
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func inflightStreamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	done := startCall(cc)
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		done()
+		return cs, err
+	}
+	stop := context.AfterFunc(ctx, done)
+	return &inflightStream{ClientStream: cs, done: func() { stop(); done() }, single: !desc.ServerStreams}, nil
+}
+
+// inflightStream ends the call when RecvMsg returns an error,
+// or its single response (of the client-streaming calls).
+type inflightStream struct {
+	grpc.ClientStream
+	done   func()
+	single bool
+}
+
+func (s *inflightStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil || s.single {
+		s.done()
+	}
+	return err
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+type chanStream struct {
+	grpc.ClientStream
+	recv chan error
+}
+
+func (cs chanStream) RecvMsg(m interface{}) error { return <-cs.recv }
+
+func TestCloseGracefully(t *testing.T) {
+	conn, err := dialConfig("localhost:1", DialConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv := make(chan error, 2)
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return chanStream{recv: recv}, nil
+	}
+	cs, err := inflightStreamInterceptor(context.Background(), &grpc.StreamDesc{ServerStreams: true}, conn, "/Export", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- CloseGracefully(context.Background(), conn) }()
+	recv <- nil
+	if err := cs.RecvMsg(nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-closed:
+		t.Fatal("closed with a stream in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	recv <- io.EOF
+	if err := cs.RecvMsg(nil); err != io.EOF {
+		t.Fatal(err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("not closed after the stream finished")
+	}
+	if conn.GetState() != connectivity.Shutdown {
+		t.Error("not closed")
+	}
+
+	// the response ends a client-streaming call
+	conn, err = dialConfig("localhost:1", DialConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs, err = inflightStreamInterceptor(context.Background(), &grpc.StreamDesc{ClientStreams: true}, conn, "/Import", streamer); err != nil {
+		t.Fatal(err)
+	}
+	recv <- nil
+	if err := cs.RecvMsg(nil); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = CloseGracefully(ctx, conn); err != nil || ctx.Err() != nil {
+		t.Fatalf("client stream: got %+v, %+v", err, ctx.Err())
+	}
+
+	// deadline
+	conn, err = dialConfig("localhost:1", DialConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = inflightStreamInterceptor(context.Background(), &grpc.StreamDesc{}, conn, "/Export", streamer); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err = CloseGracefully(ctx, conn); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"unsafe"
+
+	jsoniter "github.com/json-iterator/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var _ = MethodLister(descriptorClient{})
+
+type descriptorClient struct {
+	conn    grpc.ClientConnInterface
+	methods map[string]protoreflect.MethodDescriptor
+	names   []string
+}
+
+// NewDescriptorClient returns a Client of all the services of the descriptor set,
+// with dynamicpb messages, for servers without reflection.
+//
+// The methods are named by their short names, or "package.Service/Method" if ambiguous;
+// the fully qualified "/package.Service/Method" names are accepted, too.
+func NewDescriptorClient(fdset *descriptorpb.FileDescriptorSet, conn grpc.ClientConnInterface) (Client, error) {
+	files, err := protodesc.NewFiles(fdset)
+	if err != nil {
+		return nil, fmt.Errorf("descriptor set: %w", err)
+	}
+	dc := descriptorClient{conn: conn, methods: make(map[string]protoreflect.MethodDescriptor)}
+	byShort := make(map[string][]protoreflect.MethodDescriptor)
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		svcs := fd.Services()
+		for i := 0; i < svcs.Len(); i++ {
+			mds := svcs.Get(i).Methods()
+			for j := 0; j < mds.Len(); j++ {
+				md := mds.Get(j)
+				byShort[string(md.Name())] = append(byShort[string(md.Name())], md)
+				dc.methods[fullMethodName(md)] = md
+			}
+		}
+		return true
+	})
+	for short, mds := range byShort {
+		if len(mds) == 1 {
+			dc.methods[short] = mds[0]
+			dc.names = append(dc.names, short)
+			continue
+		}
+		for _, md := range mds {
+			nm := string(md.Parent().FullName()) + "/" + short
+			dc.methods[nm] = md
+			dc.names = append(dc.names, nm)
+		}
+	}
+	sort.Strings(dc.names)
+	return dc, nil
+}
+
+func fullMethodName(md protoreflect.MethodDescriptor) string {
+	return "/" + string(md.Parent().FullName()) + "/" + string(md.Name())
+}
+
+func (dc descriptorClient) List() []string { return dc.names }
+
+func (dc descriptorClient) Input(name string) interface{} {
+	if md := dc.methods[name]; md != nil {
+		return dynamicpb.NewMessage(md.Input())
+	}
+	return nil
+}
+
+func (dc descriptorClient) Output(name string) interface{} {
+	if md := dc.methods[name]; md != nil {
+		return dynamicpb.NewMessage(md.Output())
+	}
+	return nil
+}
+
+func (dc descriptorClient) ListMethods() []MethodMeta {
+	methods := make([]MethodMeta, 0, len(dc.names))
+	for _, nm := range dc.names {
+		md := dc.methods[nm]
+		mm := MethodMeta{Name: nm, ClientStreaming: md.IsStreamingClient(), ServerStreaming: md.IsStreamingServer(), Comment: comment(md)}
+		if opts, ok := md.Options().(*descriptorpb.MethodOptions); ok {
+			mm.Deprecated = opts.GetDeprecated()
+		}
+		methods = append(methods, mm)
+	}
+	return methods
+}
+
+// Call the named method, with a proto.Message input of the method's input type.
+func (dc descriptorClient) Call(name string, ctx context.Context, input interface{}, opts ...grpc.CallOption) (Receiver, error) {
+	md := dc.methods[name]
+	if md == nil {
+		return nil, fmt.Errorf("name %q not found", name)
+	}
+	in, ok := input.(proto.Message)
+	if !ok || in.ProtoReflect().Descriptor().FullName() != md.Input().FullName() {
+		return nil, fmt.Errorf("%s: wanted %s input, got %T", name, md.Input().FullName(), input)
+	}
+	method := fullMethodName(md)
+	if !md.IsStreamingClient() && !md.IsStreamingServer() {
+		r := &dynamicRecv{out: dynamicpb.NewMessage(md.Output())}
+		err := dc.conn.Invoke(ctx, method, in, r.out, append(opts, grpc.Header(&r.header), grpc.Trailer(&r.trailer))...)
+		return r, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := dc.conn.NewStream(ctx,
+		&grpc.StreamDesc{StreamName: string(md.Name()), ServerStreams: md.IsStreamingServer(), ClientStreams: md.IsStreamingClient()},
+		method, opts...)
+	if err == nil {
+		if err = stream.SendMsg(in); err == nil {
+			err = stream.CloseSend()
+		}
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return dynamicStreamRecv{ClientStream: stream, output: md.Output(), cancel: cancel}, nil
+}
+
+// dynamicRecv returns the output of a unary call.
+type dynamicRecv struct {
+	out             proto.Message
+	done            bool
+	header, trailer metadata.MD
+}
+
+func (r *dynamicRecv) Recv() (interface{}, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+	r.done = true
+	return r.out, nil
+}
+func (r *dynamicRecv) Header() (metadata.MD, error) { return r.header, nil }
+func (r *dynamicRecv) Trailer() metadata.MD         { return r.trailer }
+
+// dynamicStreamRecv receives the outputs of a stream.
+type dynamicStreamRecv struct {
+	grpc.ClientStream
+	output protoreflect.MessageDescriptor
+	cancel context.CancelFunc
+}
+
+func (r dynamicStreamRecv) Recv() (interface{}, error) {
+	out := dynamicpb.NewMessage(r.output)
+	if err := r.ClientStream.RecvMsg(out); err != nil {
+		r.cancel()
+		return nil, err
+	}
+	return out, nil
+}
+
+// Close aborts the stream.
+func (r dynamicStreamRecv) Close() error {
+	r.cancel()
+	return nil
+}
+
+// unmarshalDynamicJSON decodes the protojson b into m, rejecting the unknown fields if strict.
+//
+// The dynamic messages have no Go fields, so the key resolution of decodeJSONBytes does not apply.
+func unmarshalDynamicJSON(m *dynamicpb.Message, b []byte, strict bool) error {
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: !strict}).Unmarshal(b, m); err != nil {
+		return fmt.Errorf("decode %s: %w", m.Descriptor().FullName(), err)
+	}
+	return nil
+}
+
+// registerDynamicJSON encodes and decodes the dynamicpb messages with protojson.
+func registerDynamicJSON() {
+	jsoniter.RegisterTypeEncoderFunc("dynamicpb.Message",
+		func(ptr unsafe.Pointer, stream *jsoniter.Stream) {
+			b, err := protojson.Marshal((*dynamicpb.Message)(ptr))
+			if err != nil {
+				stream.Error = err
+				return
+			}
+			stream.WriteRaw(string(b))
+		},
+		func(ptr unsafe.Pointer) bool { return false })
+	jsoniter.RegisterTypeDecoderFunc("dynamicpb.Message", func(ptr unsafe.Pointer, iter *jsoniter.Iterator) {
+		b := iter.SkipAndReturnBytes()
+		if iter.Error != nil {
+			return
+		}
+		if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(b, (*dynamicpb.Message)(ptr)); err != nil {
+			iter.ReportError("decode dynamic message", err.Error())
+		}
+	})
+}
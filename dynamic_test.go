@@ -0,0 +1,126 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func testDescriptorSet() *descriptorpb.FileDescriptorSet {
+	str := func(name string, num int32) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name: proto.String(name), Number: proto.Int32(num), JsonName: proto.String(name),
+			Type:  descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+			Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+	}
+	return &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name: proto.String("test.proto"), Package: proto.String("test"), Syntax: proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Req"), Field: []*descriptorpb.FieldDescriptorProto{str("name", 1)}},
+			{Name: proto.String("Resp"), Field: []*descriptorpb.FieldDescriptorProto{str("greeting", 1)}},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Greeter"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{Name: proto.String("Hello"), InputType: proto.String(".test.Req"), OutputType: proto.String(".test.Resp")},
+				{Name: proto.String("Hellos"), InputType: proto.String(".test.Req"), OutputType: proto.String(".test.Resp"), ServerStreaming: proto.Bool(true)},
+			},
+		}},
+	}}}
+}
+
+func TestDescriptorClient(t *testing.T) {
+	var resp protoreflect.MessageDescriptor
+	srv := grpc.NewServer(grpc.UnknownServiceHandler(func(_ interface{}, stream grpc.ServerStream) error {
+		method, _ := grpc.MethodFromServerStream(stream)
+		in := dynamicpb.NewMessage(resp.ParentFile().Messages().ByName("Req"))
+		if err := stream.RecvMsg(in); err != nil {
+			return err
+		}
+		name := in.Get(in.Descriptor().Fields().ByName("name")).String()
+		n := 1
+		if method == "/test.Greeter/Hellos" {
+			n = 2
+		}
+		for i := 0; i < n; i++ {
+			out := dynamicpb.NewMessage(resp)
+			out.Set(resp.Fields().ByName("greeting"), protoreflect.ValueOfString("Hello, "+name))
+			if err := stream.SendMsg(out); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	go srv.Serve(ln)
+	defer srv.Stop()
+	conn, err := grpc.Dial(ln.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	c, err := NewDescriptorClient(testDescriptorSet(), conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = c.Output("Hello").(*dynamicpb.Message).Descriptor()
+	if got := strings.Join(c.List(), ","); got != "Hello,Hellos" {
+		t.Errorf("List: %s", got)
+	}
+
+	h := JSONHandler{Client: c}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/Hellos", strings.NewReader(`{"name":"world"}`)))
+	if got := strings.ReplaceAll(w.Body.String(), " ", ""); got != "{\"greeting\":\"Hello,world\"}\n{\"greeting\":\"Hello,world\"}\n" {
+		t.Errorf("got %d %q", w.Code, w.Body.String())
+	}
+
+	for _, strict := range []bool{false, true} {
+		h.StrictInput = strict
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/Hello", strings.NewReader(`{"name":"world","unknown":1}`)))
+		if want := map[bool]int{false: 200, true: 400}[strict]; w.Code != want {
+			t.Errorf("strict=%t: got %d %q, wanted %d", strict, w.Code, w.Body.String(), want)
+		}
+	}
+
+	recv, err := c.Call("/test.Greeter/Hello", context.Background(), c.Input("Hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = recv.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = recv.Recv(); err != io.EOF {
+		t.Errorf("got %+v", err)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// EncryptedFileSecrets reads the secrets from an AES-GCM encrypted JSON object
+// (secret name to value) in the file at Path, as written by EncryptSecrets.
+//
+// The file is decrypted in memory only, and read again when modified.
+type EncryptedFileSecrets struct {
+	Path string
+	// Key returns the AES key (16, 24 or 32 bytes), e.g. from a KMS.
+	// If nil, the key is read from the KeyEnv environment variable, base64 encoded.
+	Key    func(context.Context) ([]byte, error)
+	KeyEnv string
+
+	mu      sync.Mutex
+	modTime time.Time
+	secrets map[string]string
+}
+
+func (es *EncryptedFileSecrets) key(ctx context.Context) ([]byte, error) {
+	if es.Key != nil {
+		return es.Key(ctx)
+	}
+	s := os.Getenv(es.KeyEnv)
+	if s == "" {
+		return nil, fmt.Errorf("%q: %w", es.KeyEnv, ErrSecretNotFound)
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+}
+
+// Secret returns the named secret from the decrypted file.
+func (es *EncryptedFileSecrets) Secret(ctx context.Context, name string) (string, error) {
+	fi, err := os.Stat(es.Path)
+	if err != nil {
+		return "", err
+	}
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if es.secrets == nil || !fi.ModTime().Equal(es.modTime) {
+		key, err := es.key(ctx)
+		if err != nil {
+			return "", fmt.Errorf("key: %w", err)
+		}
+		b, err := os.ReadFile(es.Path)
+		if err != nil {
+			return "", err
+		}
+		secrets, err := DecryptSecrets(key, b)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", es.Path, err)
+		}
+		es.secrets, es.modTime = secrets, fi.ModTime()
+	}
+	if v, ok := es.secrets[name]; ok {
+		return v, nil
+	}
+	return "", fmt.Errorf("%s#%s: %w", es.Path, name, ErrSecretNotFound)
+}
+
+// EncryptSecrets encrypts the secrets with AES-GCM, returning the nonce and the sealed JSON.
+func EncryptSecrets(key []byte, secrets map[string]string) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := jsoniter.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+// DecryptSecrets decrypts the output of EncryptSecrets.
+func DecryptSecrets(key, b []byte) (map[string]string, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < aead.NonceSize() {
+		return nil, errors.New("encrypted secrets too short")
+	}
+	plain, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, err
+	}
+	var secrets map[string]string
+	err = jsoniter.Unmarshal(plain, &secrets)
+	for i := range plain {
+		plain[i] = 0
+	}
+	return secrets, err
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
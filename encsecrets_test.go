@@ -0,0 +1,55 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptedFileSecrets(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	b, err := EncryptSecrets(key, map[string]string{"password": "s3cret", "apikey": "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte("s3cret")) {
+		t.Fatal("not encrypted")
+	}
+	fn := filepath.Join(t.TempDir(), "secrets.enc")
+	if err = os.WriteFile(fn, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GRPCER_TEST_KEY", base64.StdEncoding.EncodeToString(key))
+	es := &EncryptedFileSecrets{Path: fn, KeyEnv: "GRPCER_TEST_KEY"}
+	ctx := context.Background()
+	if v, err := es.Secret(ctx, "password"); err != nil || v != "s3cret" {
+		t.Errorf("got %q (%+v)", v, err)
+	}
+	if _, err := es.Secret(ctx, "missing"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("got %+v", err)
+	}
+
+	wrong := &EncryptedFileSecrets{Path: fn, Key: func(context.Context) ([]byte, error) { return make([]byte, 32), nil }}
+	if _, err := wrong.Secret(ctx, "password"); err == nil {
+		t.Error("decrypted with the wrong key")
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"bytes"
+	"encoding/xml"
+	"html"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+
+	jsoniter "github.com/json-iterator/go"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+// ErrorData is the data of the ErrorRenderer's templates.
+type ErrorData struct {
+	Method  string
+	Code    string // gRPC code, e.g. NotFound
+	Status  int    // HTTP status
+	Message string // the status message, or the LocalizedMessage detail of the locale
+	Details []interface{}
+}
+
+// ErrorRenderer writes the errors as client-facing messages, rendered by the templates
+// of the request's locale (Accept-Language), in the format of the Accept header:
+// XML (a fault), HTML or JSON problem details (RFC 7807).
+type ErrorRenderer struct {
+	// Templates are the text/template message templates by locale ("hu", "en-US")
+	// and gRPC code name ("NotFound", "*" for the rest), executed with ErrorData.
+	Templates map[string]map[string]string
+	// DefaultLocale is used when the request's locale has no templates.
+	DefaultLocale string
+	// Log logs the template errors, falling back to the status message.
+	Log func(...interface{}) error
+
+	mu     sync.Mutex
+	parsed map[string]*template.Template
+}
+
+func (er *ErrorRenderer) template(locale, code string) (*template.Template, error) {
+	byCode := er.Templates[locale]
+	if byCode == nil {
+		return nil, nil
+	}
+	text, ok := byCode[code]
+	if !ok {
+		if text, ok = byCode["*"]; !ok {
+			return nil, nil
+		}
+	}
+	key := locale + "\x00" + text
+	er.mu.Lock()
+	defer er.mu.Unlock()
+	if t := er.parsed[key]; t != nil {
+		return t, nil
+	}
+	t, err := template.New(code).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if er.parsed == nil {
+		er.parsed = make(map[string]*template.Template)
+	}
+	er.parsed[key] = t
+	return t, nil
+}
+
+func (er *ErrorRenderer) log(keyvals ...interface{}) {
+	if er.Log != nil {
+		er.Log(keyvals...)
+	}
+}
+
+// locale returns the best locale of Templates for the Accept-Language of r.
+func (er *ErrorRenderer) locale(r *http.Request) string {
+	lang := preferredLanguage(r.Header.Get("Accept-Language"))
+	if _, ok := er.Templates[lang]; ok {
+		return lang
+	}
+	if i := strings.IndexByte(lang, '-'); i > 0 {
+		if _, ok := er.Templates[lang[:i]]; ok {
+			return lang[:i]
+		}
+	}
+	return er.DefaultLocale
+}
+
+// Message returns the localized message of err and its ErrorData.
+func (er *ErrorRenderer) Message(r *http.Request, method string, err error) (string, ErrorData) {
+	st := errorStatus(err)
+	locale := er.locale(r)
+	data := ErrorData{Method: method, Code: st.Code().String(), Status: statusCodeFromError(err), Message: st.Message(), Details: st.Details()}
+	for _, d := range data.Details {
+		if lm, ok := d.(*errdetails.LocalizedMessage); ok && lm.GetMessage() != "" &&
+			(lm.GetLocale() == locale || strings.HasPrefix(lm.GetLocale(), locale+"-")) {
+			data.Message = lm.GetMessage()
+		}
+	}
+	t, tErr := er.template(locale, data.Code)
+	if tErr != nil {
+		er.log("msg", "parse error template", "locale", locale, "code", data.Code, "error", tErr)
+	}
+	if t == nil || tErr != nil {
+		return data.Message, data
+	}
+	var buf strings.Builder
+	if tErr = t.Execute(&buf, data); tErr != nil {
+		er.log("msg", "execute error template", "locale", locale, "code", data.Code, "error", tErr)
+		return data.Message, data
+	}
+	return buf.String(), data
+}
+
+// Render the error of the method call into w.
+func (er *ErrorRenderer) Render(w http.ResponseWriter, r *http.Request, method string, err error) {
+	msg, data := er.Message(r, method, err)
+	accept := r.Header.Get("Accept")
+	var buf bytes.Buffer
+	switch {
+	case strings.Contains(accept, "xml"):
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		buf.WriteString(xml.Header + "<fault><code>")
+		xml.EscapeText(&buf, []byte(data.Code))
+		buf.WriteString("</code><message>")
+		xml.EscapeText(&buf, []byte(msg))
+		buf.WriteString("</message></fault>\n")
+	case strings.Contains(accept, "html"):
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		buf.WriteString("<!DOCTYPE html>\n<html><head><title>" + html.EscapeString(http.StatusText(data.Status)) +
+			"</title></head><body><p>" + html.EscapeString(msg) + "</p></body></html>\n")
+	default:
+		w.Header().Set("Content-Type", "application/problem+json")
+		jsoniter.NewEncoder(&buf).Encode(struct {
+			Type   string `json:"type"`
+			Title  string `json:"title"`
+			Status int    `json:"status"`
+			Detail string `json:"detail"`
+			Code   string `json:"code"`
+		}{Type: "about:blank", Title: http.StatusText(data.Status), Status: data.Status, Detail: msg, Code: data.Code})
+	}
+	w.WriteHeader(data.Status)
+	w.Write(buf.Bytes())
+}
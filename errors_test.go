@@ -0,0 +1,98 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestErrorRenderer(t *testing.T) {
+	er := &ErrorRenderer{
+		DefaultLocale: "en",
+		Templates: map[string]map[string]string{
+			"en": {"NotFound": "{{.Method}}: not found", "*": "Something went wrong."},
+			"hu": {"NotFound": "{{.Method}}: nem található"},
+		},
+	}
+	localized, err := status.New(codes.InvalidArgument, "bad").WithDetails(
+		&errdetails.LocalizedMessage{Locale: "hu-HU", Message: "hibás adat"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tc := range []struct {
+		Err                    error
+		Lang, Accept           string
+		Status                 int
+		ContentType, Contained string
+	}{
+		{Err: status.Error(codes.NotFound, "no rows"), Lang: "hu-HU,en;q=0.5", Status: 404,
+			ContentType: "application/problem+json", Contained: `"detail":"Get: nem található"`},
+		{Err: status.Error(codes.NotFound, "no rows"), Lang: "de", Accept: "text/xml", Status: 404,
+			ContentType: "application/xml", Contained: "<message>Get: not found</message>"},
+		{Err: status.Error(codes.Internal, "<db> down"), Accept: "text/html", Status: 500,
+			ContentType: "text/html", Contained: "<p>Something went wrong.</p>"},
+		{Err: localized.Err(), Lang: "hu", Status: 400,
+			ContentType: "application/problem+json", Contained: `"detail":"hibás adat"`},
+		{Err: fmt.Errorf("call: %w", status.Error(codes.NotFound, "no rows")), Lang: "en", Status: 404,
+			ContentType: "application/problem+json", Contained: `"detail":"Get: not found"`},
+	} {
+		h := JSONHandler{
+			Client: fakeClient{"Get": func(ctx context.Context, input interface{}) (Receiver, error) {
+				return nil, tc.Err
+			}},
+			Errors: er,
+		}
+		r := httptest.NewRequest("POST", "/Get", strings.NewReader("{}"))
+		r.Header.Set("Accept-Language", tc.Lang)
+		r.Header.Set("Accept", tc.Accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		body := w.Body.String()
+		if w.Code != tc.Status || !strings.HasPrefix(w.Header().Get("Content-Type"), tc.ContentType) ||
+			!strings.Contains(body, tc.Contained) || strings.Contains(body, "rpc error") {
+			t.Errorf("%d. got %d %q %s", i, w.Code, w.Header().Get("Content-Type"), body)
+		}
+	}
+}
+
+func TestErrorRendererTemplateError(t *testing.T) {
+	var logged []string
+	er := &ErrorRenderer{
+		DefaultLocale: "en",
+		Templates:     map[string]map[string]string{"en": {"NotFound": "{{.Method", "Internal": "{{.Nosuch}}"}},
+		Log: func(keyvals ...interface{}) error {
+			logged = append(logged, fmt.Sprint(keyvals...))
+			return nil
+		},
+	}
+	r := httptest.NewRequest("POST", "/Get", nil)
+	for _, code := range []codes.Code{codes.NotFound, codes.Internal} {
+		if msg, _ := er.Message(r, "Get", status.Error(code, "fallback")); msg != "fallback" {
+			t.Errorf("%s: got %q, wanted the status message", code, msg)
+		}
+	}
+	if len(logged) != 2 {
+		t.Errorf("got %q, wanted the parse and the execute error logged", logged)
+	}
+}
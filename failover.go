@@ -0,0 +1,227 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FailoverClient calls the methods on the active endpoint (the first at start),
+// and fails over to the next endpoint when the call returns Unavailable.
+//
+// The Client of an endpoint is created (dialed) at its first use.
+type FailoverClient struct {
+	// Endpoints are the primary and the fallback endpoints, in order.
+	Endpoints []string
+	// Connect returns the Client of the endpoint.
+	Connect func(endpoint string) (Client, error)
+	Log     func(...interface{}) error
+	// OnFailover is called when the active endpoint changes, e.g. to count it in a metric.
+	OnFailover func(from, to string, err error)
+
+	mu        sync.Mutex
+	active    int
+	clients   map[int]Client
+	failovers atomic.Int64
+}
+
+var errNoEndpoints = errors.New("no endpoints")
+
+// NewFailoverClient returns a FailoverClient which dials the endpoints with conf,
+// and creates their Client with newClient (e.g. the NewClient generated by protoc-gen-grpcer).
+func NewFailoverClient(endpoints []string, conf DialConfig, newClient func(*grpc.ClientConn) Client) *FailoverClient {
+	return &FailoverClient{
+		Endpoints: endpoints,
+		Log:       conf.Log,
+		Connect: func(endpoint string) (Client, error) {
+			conn, err := dialConfig(endpoint, conf)
+			if err != nil {
+				return nil, err
+			}
+			return closingClient{Client: newClient(conn), Closer: conn}, nil
+		},
+	}
+}
+
+// closingClient closes the connection of the Client.
+type closingClient struct {
+	Client
+	io.Closer
+}
+
+// Active returns the active endpoint.
+func (fc *FailoverClient) Active() string {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if len(fc.Endpoints) == 0 {
+		return ""
+	}
+	return fc.Endpoints[fc.active]
+}
+
+// Failovers returns the number of failovers so far.
+func (fc *FailoverClient) Failovers() int64 { return fc.failovers.Load() }
+
+// client returns the Client of the i-th endpoint, connecting to it if needed.
+func (fc *FailoverClient) client(i int) (Client, error) {
+	fc.mu.Lock()
+	c := fc.clients[i]
+	fc.mu.Unlock()
+	if c != nil {
+		return c, nil
+	}
+	c, err := fc.Connect(fc.Endpoints[i])
+	if err != nil {
+		return nil, err
+	}
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if prev := fc.clients[i]; prev != nil { // connected concurrently
+		if cl, ok := c.(io.Closer); ok {
+			cl.Close()
+		}
+		return prev, nil
+	}
+	if fc.clients == nil {
+		fc.clients = make(map[int]Client, len(fc.Endpoints))
+	}
+	fc.clients[i] = c
+	return c, nil
+}
+
+// setActive sets the i-th endpoint as the active one, err is the error of the previous.
+func (fc *FailoverClient) setActive(i int, err error) {
+	fc.mu.Lock()
+	from := fc.active
+	fc.active = i
+	fc.mu.Unlock()
+	if from == i {
+		return
+	}
+	fc.failovers.Add(1)
+	if fc.Log != nil {
+		fc.Log("msg", "failover", "from", fc.Endpoints[from], "to", fc.Endpoints[i], "error", err)
+	}
+	if fc.OnFailover != nil {
+		fc.OnFailover(fc.Endpoints[from], fc.Endpoints[i], err)
+	}
+}
+
+// anyClient returns the Client of the active endpoint, or the next one which can be connected.
+func (fc *FailoverClient) anyClient() (Client, error) {
+	fc.mu.Lock()
+	start := fc.active
+	fc.mu.Unlock()
+	err := errNoEndpoints
+	for k := range fc.Endpoints {
+		var c Client
+		if c, err = fc.client((start + k) % len(fc.Endpoints)); err == nil {
+			return c, nil
+		}
+	}
+	return nil, err
+}
+
+// List the names of the active endpoint's Client.
+func (fc *FailoverClient) List() []string {
+	if c, _ := fc.anyClient(); c != nil {
+		return c.List()
+	}
+	return nil
+}
+
+// ListMethods returns the methods of the active endpoint's Client.
+func (fc *FailoverClient) ListMethods() []MethodMeta {
+	if c, _ := fc.anyClient(); c != nil {
+		return ListMethods(c)
+	}
+	return nil
+}
+
+// Input returns the input struct for the name.
+func (fc *FailoverClient) Input(name string) interface{} {
+	if c, _ := fc.anyClient(); c != nil {
+		return c.Input(name)
+	}
+	return nil
+}
+
+// Output returns the output struct for the name.
+func (fc *FailoverClient) Output(name string) interface{} {
+	if c, _ := fc.anyClient(); c != nil {
+		return c.Output(name)
+	}
+	return nil
+}
+
+// Call the named method on the active endpoint, and on the next ones
+// while the call (or its first Recv) returns Unavailable, or the endpoint cannot be connected.
+func (fc *FailoverClient) Call(name string, ctx context.Context, input interface{}, opts ...grpc.CallOption) (Receiver, error) {
+	fc.mu.Lock()
+	start := fc.active
+	fc.mu.Unlock()
+	lastErr := errNoEndpoints
+	for k := range fc.Endpoints {
+		i := (start + k) % len(fc.Endpoints)
+		c, err := fc.client(i)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		recv, err := c.Call(name, ctx, input, opts...)
+		if err == nil {
+			var part interface{}
+			if part, err = recv.Recv(); err == nil {
+				fc.setActive(i, lastErr)
+				return &prefixedReceiver{parts: []interface{}{part}, rest: recv}, nil
+			} else if err == io.EOF {
+				fc.setActive(i, lastErr)
+				return &sliceReceiver{}, nil
+			}
+			CloseReceiver(recv)
+		}
+		if status.Code(err) != codes.Unavailable || ctx.Err() != nil {
+			return nil, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// Close the connections of the endpoints.
+func (fc *FailoverClient) Close() error {
+	fc.mu.Lock()
+	clients := fc.clients
+	fc.clients = nil
+	fc.mu.Unlock()
+	var firstErr error
+	for _, c := range clients {
+		if cl, ok := c.(io.Closer); ok {
+			if err := cl.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
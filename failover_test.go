@@ -0,0 +1,84 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFailoverClient(t *testing.T) {
+	down := map[string]bool{"primary": true}
+	var connects []string
+	endpoint := func(name string) fakeClient {
+		return fakeClient{"Get": func(ctx context.Context, input interface{}) (Receiver, error) {
+			if down[name] {
+				return &failingReceiver{err: status.Error(codes.Unavailable, name+" is down")}, nil
+			}
+			return &receiver{parts: []interface{}{name}}, nil
+		}}
+	}
+	var failovers []string
+	fc := &FailoverClient{
+		Endpoints: []string{"primary", "broken", "fallback"},
+		Connect: func(name string) (Client, error) {
+			connects = append(connects, name)
+			if name == "broken" {
+				return nil, errors.New("dial failed")
+			}
+			return endpoint(name), nil
+		},
+		OnFailover: func(from, to string, err error) { failovers = append(failovers, from+">"+to) },
+	}
+	if got := fc.Active(); got != "primary" {
+		t.Errorf("got %q, wanted primary", got)
+	}
+	get := func() string {
+		t.Helper()
+		recv, err := fc.Call("Get", context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part, err := recv.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return part.(string)
+	}
+	if got := get(); got != "fallback" {
+		t.Errorf("got %q, wanted fallback", got)
+	}
+	if got := fc.Active(); got != "fallback" || fc.Failovers() != 1 || len(failovers) != 1 || failovers[0] != "primary>fallback" {
+		t.Errorf("got %q, %d failovers (%v)", got, fc.Failovers(), failovers)
+	}
+	if got := get(); got != "fallback" || len(connects) != 3 {
+		t.Errorf("got %q, connects %v; wanted fallback without reconnecting", got, connects)
+	}
+
+	down["fallback"], down["primary"] = true, false
+	if got := get(); got != "primary" || fc.Active() != "primary" {
+		t.Errorf("got %q (active %q), wanted primary", got, fc.Active())
+	}
+
+	down["primary"] = true
+	if _, err := fc.Call("Get", context.Background(), nil); status.Code(err) != codes.Unavailable {
+		t.Errorf("got %+v, wanted Unavailable", err)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// renderFilename renders the filename template, replacing
+//
+//	{method} with the method name,
+//	{date} with the date (2006-01-02),
+//	{time} with the time (20060102T150405),
+//	{Field.Path} with the field of the input (see inputField).
+//
+// Unknown fields are rendered empty, and the path separators and other
+// characters unsafe in file names are replaced by _.
+func renderFilename(tmpl, name string, input interface{}, now time.Time) string {
+	var buf strings.Builder
+	for {
+		i := strings.IndexByte(tmpl, '{')
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(tmpl[i:], '}')
+		if j < 0 {
+			break
+		}
+		buf.WriteString(tmpl[:i])
+		var s string
+		switch key := tmpl[i+1 : i+j]; key {
+		case "method":
+			s = name
+		case "date":
+			s = now.Format("2006-01-02")
+		case "time":
+			s = now.Format("20060102T150405")
+		default:
+			if v, ok := inputField(input, key); ok {
+				s = fmt.Sprint(v)
+			}
+		}
+		buf.WriteString(s)
+		tmpl = tmpl[i+j+1:]
+	}
+	buf.WriteString(tmpl)
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, buf.String())
+}
+
+// contentDisposition returns the Content-Disposition header value for the attachment filename.
+func contentDisposition(filename string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+}
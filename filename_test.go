@@ -0,0 +1,41 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenderFilename(t *testing.T) {
+	now := time.Date(2020, 9, 8, 7, 6, 5, 0, time.UTC)
+	input := struct {
+		CustomerID string
+		Year       int
+	}{CustomerID: "C/1", Year: 2020}
+	for tmpl, want := range map[string]string{
+		"orders_{CustomerID}_{date}.csv": "orders_C_1_2020-09-08.csv",
+		"{method}-{Year}-{time}.json":    "GetOrders-2020-20200908T070605.json",
+		"{nosuch}x{":                     "x{",
+	} {
+		if got := renderFilename(tmpl, "GetOrders", &input, now); got != want {
+			t.Errorf("%q: got %q, wanted %q", tmpl, got, want)
+		}
+	}
+	if got, want := contentDisposition("árvíztűrő.csv"), `attachment; filename*=utf-8''%C3%A1rv%C3%ADzt%C5%B1r%C5%91.csv`; got != want {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+}
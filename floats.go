@@ -0,0 +1,176 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"unsafe"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/modern-go/reflect2"
+)
+
+// SpecialFloats is the JSON handling of NaN and ±Infinity, which have no JSON representation,
+// set by JSONHandler.SpecialFloats.
+type SpecialFloats uint8
+
+const (
+	// SpecialFloatsReject fails the encoding, and rejects the "NaN", "Infinity" strings in inputs.
+	SpecialFloatsReject = SpecialFloats(iota)
+	// SpecialFloatsString encodes and decodes them as the "NaN", "Infinity" and "-Infinity" strings.
+	SpecialFloatsString
+	// SpecialFloatsNull encodes them as null, and rejects them in inputs.
+	SpecialFloatsNull
+)
+
+// specialFloatsExtension encodes and decodes the float32 and float64 values by mode,
+// replacing the fuzzy float decoders in the jsoniter.API it is registered on.
+type specialFloatsExtension struct {
+	jsoniter.DummyExtension
+	mode SpecialFloats
+}
+
+func (e *specialFloatsExtension) CreateEncoder(typ reflect2.Type) jsoniter.ValEncoder {
+	if c := e.codec(typ); c != nil {
+		return c
+	}
+	return nil
+}
+
+func (e *specialFloatsExtension) CreateDecoder(typ reflect2.Type) jsoniter.ValDecoder {
+	if c := e.codec(typ); c != nil {
+		return c
+	}
+	return nil
+}
+
+func (e *specialFloatsExtension) codec(typ reflect2.Type) *floatCodec {
+	switch typ.Type1() {
+	case reflect.TypeOf(float64(0)):
+		return &floatCodec{mode: e.mode, bitSize: 64}
+	case reflect.TypeOf(float32(0)):
+		return &floatCodec{mode: e.mode, bitSize: 32}
+	}
+	return nil
+}
+
+type floatCodec struct {
+	mode    SpecialFloats
+	bitSize int
+}
+
+func (c *floatCodec) load(ptr unsafe.Pointer) float64 {
+	if c.bitSize == 32 {
+		return float64(*(*float32)(ptr))
+	}
+	return *(*float64)(ptr)
+}
+
+func (c *floatCodec) IsEmpty(ptr unsafe.Pointer) bool { return c.load(ptr) == 0 }
+
+func (c *floatCodec) Encode(ptr unsafe.Pointer, stream *jsoniter.Stream) {
+	encodeFloat(c.load(ptr), c.bitSize, c.mode, stream)
+}
+
+func (c *floatCodec) Decode(ptr unsafe.Pointer, iter *jsoniter.Iterator) {
+	f, ok := decodeFloat(iter, c.bitSize, c.mode)
+	if !ok {
+		return
+	}
+	if c.bitSize == 32 {
+		*(*float32)(ptr) = float32(f)
+	} else {
+		*(*float64)(ptr) = f
+	}
+}
+
+func encodeFloat(f float64, bitSize int, mode SpecialFloats, stream *jsoniter.Stream) {
+	if !math.IsNaN(f) && !math.IsInf(f, 0) {
+		if bitSize == 32 {
+			stream.WriteFloat32(float32(f))
+		} else {
+			stream.WriteFloat64(f)
+		}
+		return
+	}
+	switch mode {
+	case SpecialFloatsString:
+		stream.WriteString(specialFloatString(f))
+	case SpecialFloatsNull:
+		stream.WriteNil()
+	default:
+		stream.Error = fmt.Errorf("unsupported value: %s", specialFloatString(f))
+	}
+}
+
+func specialFloatString(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case f > 0:
+		return "Infinity"
+	default:
+		return "-Infinity"
+	}
+}
+
+// decodeFloat decodes numbers, numeric strings, bools and null (as 0), as the fuzzy decoders;
+// and the "NaN", "Infinity", "-Infinity" strings with SpecialFloatsString.
+func decodeFloat(iter *jsoniter.Iterator, bitSize int, mode SpecialFloats) (float64, bool) {
+	switch iter.WhatIsNext() {
+	case jsoniter.NumberValue:
+		if bitSize == 32 {
+			return float64(iter.ReadFloat32()), true
+		}
+		return iter.ReadFloat64(), true
+	case jsoniter.StringValue:
+		s := iter.ReadString()
+		switch s {
+		case "NaN", "Infinity", "-Infinity":
+			if mode != SpecialFloatsString {
+				iter.ReportError("decode float", s+" is not allowed")
+				return 0, false
+			}
+			switch s {
+			case "NaN":
+				return math.NaN(), true
+			case "Infinity":
+				return math.Inf(1), true
+			}
+			return math.Inf(-1), true
+		}
+		f, err := strconv.ParseFloat(s, bitSize)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			iter.ReportError("decode float", fmt.Sprintf("%q is not a number", s))
+			return 0, false
+		}
+		return f, true
+	case jsoniter.BoolValue:
+		if iter.ReadBool() {
+			return 1, true
+		}
+		return 0, true
+	case jsoniter.NilValue:
+		iter.Skip()
+		return 0, true
+	default:
+		iter.ReportError("decode float", "not number or string")
+		return 0, false
+	}
+}
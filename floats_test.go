@@ -0,0 +1,96 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+func TestSpecialFloats(t *testing.T) {
+	type S struct {
+		F  float64
+		G  float32
+		Fs []float64
+	}
+	v := S{F: math.NaN(), G: float32(math.Inf(1)), Fs: []float64{1.5, math.Inf(-1)}}
+	for mode, want := range map[SpecialFloats]string{
+		SpecialFloatsString: `{"F":"NaN","G":"Infinity","Fs":[1.5,"-Infinity"]}`,
+		SpecialFloatsNull:   `{"F":null,"G":null,"Fs":[1.5,null]}`,
+	} {
+		b, err := jsonConfigOf(mode).api.Marshal(v)
+		if err != nil {
+			t.Fatalf("%d: %+v", mode, err)
+		}
+		if string(b) != want {
+			t.Errorf("%d: got %s, wanted %s", mode, b, want)
+		}
+	}
+
+	api := jsonConfigOf(SpecialFloatsReject).api
+	if b, err := api.Marshal(v); err == nil {
+		t.Errorf("wanted error, got %s", b)
+	}
+	var s S
+	if err := api.UnmarshalFromString(`{"F":"NaN"}`, &s); err == nil {
+		t.Error("NaN accepted")
+	}
+	if err := api.UnmarshalFromString(`{"F":"1.5","G":true,"Fs":[2,null]}`, &s); err != nil || s.F != 1.5 || s.G != 1 || len(s.Fs) != 2 {
+		t.Errorf("fuzzy: got %+v (%+v)", s, err)
+	}
+
+	api = jsonConfigOf(SpecialFloatsString).stream
+	if err := api.UnmarshalFromString(`{"F":"NaN","G":"-Infinity"}`, &s); err != nil || !math.IsNaN(s.F) || !math.IsInf(float64(s.G), -1) {
+		t.Errorf("got %+v (%+v)", s, err)
+	}
+
+	// the other jsoniter users are not affected
+	if b, err := jsoniter.Marshal(v); err == nil {
+		t.Errorf("global: wanted error, got %s", b)
+	}
+}
+
+func TestJSONHandlerSpecialFloats(t *testing.T) {
+	type S struct {
+		F float64
+	}
+	c := fakeClient{"Get": func(ctx context.Context, input interface{}) (Receiver, error) {
+		return &receiver{parts: []interface{}{&S{F: math.Inf(1)}}}, nil
+	}}
+	for mode, want := range map[SpecialFloats]string{
+		SpecialFloatsString: `{"F":"Infinity"}`,
+		SpecialFloatsNull:   `{"F":null}`,
+	} {
+		for _, query := range []string{"?merge=0", "?merge=1", "?format=ndjson"} {
+			w := httptest.NewRecorder()
+			NewHTTPHandler(c, HandlerSpecialFloats(mode)).ServeHTTP(w, httptest.NewRequest("POST", "/Get"+query, strings.NewReader("{}")))
+			if got := strings.TrimSpace(w.Body.String()); got != want {
+				t.Errorf("%d%s: got %q, wanted %q", mode, query, got, want)
+			}
+		}
+	}
+
+	w := httptest.NewRecorder()
+	NewHTTPHandler(c).ServeHTTP(w, httptest.NewRequest("POST", "/Get?merge=0", strings.NewReader("{}")))
+	if strings.Contains(w.Body.String(), "Infinity") {
+		t.Errorf("rejected: got %q", w.Body.String())
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+)
+
+// Encoder encodes a stream of parts.
+type Encoder interface {
+	Encode(part interface{}) error
+	// Close finishes the stream - writes the trailer, if any.
+	Close() error
+}
+
+// Format is an alternative output format of JSONHandler.
+type Format struct {
+	// MediaTypes accepted, the first is used as the Content-Type.
+	MediaTypes []string
+	NewEncoder func(w io.Writer) Encoder
+	// ProtoOnly formats encode only protobuf messages, so they cannot be
+	// used with the JSONHandler's Transforms and Renames.
+	ProtoOnly bool
+
+	// newMerger returns the streamEncoder of the merged stream, if the format supports merging.
+	newMerger func() streamEncoder
+	// newJSONEncoder returns the Encoder of the JSON formats, encoding with the JSONHandler's api.
+	newJSONEncoder func(w io.Writer, api jsoniter.API) Encoder
+}
+
+// newEncoder returns the Encoder of the format, with api if it is a JSON format.
+func (f Format) newEncoder(w io.Writer, api jsoniter.API) Encoder {
+	if f.newJSONEncoder != nil {
+		return f.newJSONEncoder(w, api)
+	}
+	return f.NewEncoder(w)
+}
+
+// Formats are the output formats by name, selected by the "format" query parameter,
+// or by the Accept header. The default is JSON.
+var Formats = map[string]Format{
+	"ndjson": {
+		MediaTypes:     []string{"application/x-ndjson", "application/jsonl", "application/jsonlines"},
+		NewEncoder:     func(w io.Writer) Encoder { return ndjsonEncoder{jsoniter.NewEncoder(w)} },
+		newJSONEncoder: func(w io.Writer, api jsoniter.API) Encoder { return ndjsonEncoder{api.NewEncoder(w)} },
+	},
+	"msgpack": {
+		MediaTypes: []string{"application/msgpack", "application/x-msgpack"},
+		NewEncoder: func(w io.Writer) Encoder { return newMsgpackEncoder(w) },
+	},
+	"avro": {
+		MediaTypes: []string{"application/avro", "avro/binary"},
+		NewEncoder: func(w io.Writer) Encoder { return &avroEncoder{w: w} },
+		ProtoOnly:  true,
+	},
+	"protobuf": {
+		MediaTypes: []string{"application/x-protobuf", "application/vnd.google.protobuf"},
+		NewEncoder: func(w io.Writer) Encoder { return &delimitedEncoder{w: w} },
+		ProtoOnly:  true,
+	},
+	"xml": {
+		MediaTypes: []string{"application/xml", "text/xml"},
+		NewEncoder: func(w io.Writer) Encoder { return xmlEncoder{w: w} },
+		newMerger:  func() streamEncoder { return &xmlStreamEncoder{} },
+	},
+	"csv": {
+		MediaTypes: []string{"text/csv"},
+		NewEncoder: func(w io.Writer) Encoder { return newCSVEncoder(w) },
+	},
+	"tsv": {
+		MediaTypes: []string{"text/tab-separated-values"},
+		NewEncoder: func(w io.Writer) Encoder {
+			enc := newCSVEncoder(w)
+			enc.cw.Comma = '\t'
+			return enc
+		},
+	},
+	"sse": {
+		MediaTypes:     []string{"text/event-stream"},
+		NewEncoder:     func(w io.Writer) Encoder { return newSSEEncoder(w, jsoniter.ConfigDefault, SSEKeepAlive) },
+		newJSONEncoder: func(w io.Writer, api jsoniter.API) Encoder { return newSSEEncoder(w, api, SSEKeepAlive) },
+	},
+	"prototext": {
+		MediaTypes: []string{"text/x-prototext", "text/x-protobuf"},
+		NewEncoder: func(w io.Writer) Encoder { return &prototextEncoder{w: w} },
+		ProtoOnly:  true,
+	},
+}
+
+// negotiateFormat returns the Format requested by r, nil for JSON.
+func negotiateFormat(r *http.Request) (*Format, error) {
+	if nm := r.URL.Query().Get("format"); nm != "" && nm != "json" {
+		if f, ok := Formats[nm]; ok {
+			return &f, nil
+		}
+		return nil, fmt.Errorf("unknown format %q", nm)
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		for _, f := range Formats {
+			for _, t := range f.MediaTypes {
+				if t == mt {
+					return &f, nil
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// errorEncoder is an Encoder which can write the error of the stream (instead of Close).
+type errorEncoder interface {
+	Encoder
+	EncodeError(error) error
+}
+
+// encodeParts encodes first and the rest of the parts from recv with enc.
+func encodeParts(enc Encoder, first interface{}, recv Receiver, Log func(...interface{}) error) error {
+	part := first
+	for {
+		if err := enc.Encode(part); err != nil {
+			Log("encode", part, "error", err)
+			if ee, ok := enc.(errorEncoder); ok {
+				_ = ee.EncodeError(err)
+			}
+			return err
+		}
+		var err error
+		if part, err = recv.Recv(); err != nil {
+			if err != io.EOF {
+				Log("msg", "recv", "error", err)
+				if ee, ok := enc.(errorEncoder); ok {
+					_ = ee.EncodeError(err)
+				}
+				return err
+			}
+			break
+		}
+	}
+	return enc.Close()
+}
+
+// ndjsonEncoder writes each part as is, as one line of JSON - no merging of the parts.
+type ndjsonEncoder struct {
+	enc *jsoniter.Encoder
+}
+
+func (e ndjsonEncoder) Encode(part interface{}) error { return e.enc.Encode(part) }
+func (e ndjsonEncoder) Close() error                  { return nil }
+
+// prototextEncoder writes each (protobuf) part in text format, separated by an empty line.
+type prototextEncoder struct {
+	w io.Writer
+	n int
+}
+
+func (e *prototextEncoder) Encode(part interface{}) error {
+	pr, ok := part.(protoReflecter)
+	if !ok {
+		return fmt.Errorf("prototext: %T is not a protobuf message", part)
+	}
+	b, err := prototext.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(pr.ProtoReflect().Interface())
+	if err != nil {
+		return err
+	}
+	if e.n != 0 {
+		if _, err = io.WriteString(e.w, "\n"); err != nil {
+			return err
+		}
+	}
+	e.n++
+	_, err = e.w.Write(b)
+	return err
+}
+
+func (e *prototextEncoder) Close() error { return nil }
+
+// delimitedEncoder writes each (protobuf) part as a varint length-prefixed binary message.
+type delimitedEncoder struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (e *delimitedEncoder) Encode(part interface{}) error {
+	pr, ok := part.(protoReflecter)
+	if !ok {
+		return fmt.Errorf("protobuf: %T is not a protobuf message", part)
+	}
+	msg := pr.ProtoReflect().Interface()
+	n := proto.Size(msg)
+	if cap(e.buf) < binary.MaxVarintLen64+n {
+		e.buf = make([]byte, 0, binary.MaxVarintLen64+n)
+	}
+	b := e.buf[:binary.PutUvarint(e.buf[:binary.MaxVarintLen64], uint64(n))]
+	b, err := proto.MarshalOptions{}.MarshalAppend(b, msg)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(b)
+	return err
+}
+
+func (e *delimitedEncoder) Close() error { return nil }
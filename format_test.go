@@ -0,0 +1,222 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/runtime/protoimpl"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestMsgpack(t *testing.T) {
+	var buf bytes.Buffer
+	enc := newMsgpackEncoder(&buf)
+	for _, part := range []interface{}{
+		map[string]interface{}{"a": 1, "b": []interface{}{-1, "x", nil, true, 1.5, 300}},
+	} {
+		if err := enc.Encode(part); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x82,
+		0xa1, 'a', 0x01,
+		0xa1, 'b', 0x96, 0xff, 0xa1, 'x', 0xc0, 0xc3,
+		0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+		0xd1, 0x01, 0x2c,
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("got\n%x\nwanted\n%x", buf.Bytes(), want)
+	}
+}
+
+func TestJSONHandlerFormat(t *testing.T) {
+	h := JSONHandler{Client: fakeClient{
+		"Get": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return &receiver{parts: []interface{}{map[string]interface{}{"a": 1}}}, nil
+		},
+	}}
+	for tN, tC := range map[string]struct {
+		URL, Accept, ContentType string
+		Code                     int
+	}{
+		"json":    {URL: "/Get", ContentType: "application/json", Code: 200},
+		"accept":  {URL: "/Get", Accept: "text/html, application/x-msgpack;q=0.9", ContentType: "application/msgpack", Code: 200},
+		"query":   {URL: "/Get?format=msgpack", ContentType: "application/msgpack", Code: 200},
+		"ndjson":  {URL: "/Get", Accept: "application/x-ndjson", ContentType: "application/x-ndjson", Code: 200},
+		"unknown": {URL: "/Get?format=nosuch", ContentType: "application/json", Code: http.StatusNotAcceptable},
+	} {
+		r := httptest.NewRequest("POST", tC.URL, strings.NewReader("{}"))
+		if tC.Accept != "" {
+			r.Header.Set("Accept", tC.Accept)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tC.Code || w.Header().Get("Content-Type") != tC.ContentType {
+			t.Errorf("%s: got %d %q, wanted %d %q", tN, w.Code, w.Header().Get("Content-Type"), tC.Code, tC.ContentType)
+		}
+	}
+}
+
+func TestJSONHandlerFormatQuery(t *testing.T) {
+	var got map[string]interface{}
+	h := JSONHandler{Client: fakeClient{
+		"Get": func(ctx context.Context, input interface{}) (Receiver, error) {
+			got = *(input.(*map[string]interface{}))
+			return &receiver{parts: []interface{}{map[string]interface{}{"a": 1}}}, nil
+		},
+	}}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/Get?format=ndjson&merge=0&Id=1", nil))
+	if w.Code != 200 || len(got) != 1 || got["Id"] != "1" {
+		t.Errorf("got %d %v, wanted only Id in the input", w.Code, got)
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	type part struct {
+		A []string `json:"a"`
+		B int      `json:"b"`
+	}
+	h := JSONHandler{MergeStreams: true, Client: fakeClient{
+		"Get": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return &receiver{parts: []interface{}{&part{A: []string{"1", "2"}, B: 1}, &part{A: []string{"3"}, B: 2}}}, nil
+		},
+	}}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/Get?format=ndjson", strings.NewReader("{}")))
+	if got, want := w.Body.String(), `{"a":["1","2"],"b":1}`+"\n"+`{"a":["3"],"b":2}`+"\n"; got != want {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+}
+
+func TestPrototext(t *testing.T) {
+	var buf bytes.Buffer
+	enc := Formats["prototext"].NewEncoder(&buf)
+	for _, svc := range []string{"a", "b"} {
+		if err := enc.Encode(&grpc_health_v1.HealthCheckRequest{Service: svc}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(strings.Fields(buf.String()), " "); got != `service: "a" service: "b"` {
+		t.Errorf("got %q", buf.String())
+	}
+	if err := enc.Encode(map[string]interface{}{}); err == nil {
+		t.Error("wanted error for non-protobuf part")
+	}
+}
+
+func TestAvro(t *testing.T) {
+	var buf bytes.Buffer
+	enc := Formats["avro"].NewEncoder(&buf)
+	for _, nm := range []string{"M1", "M2"} {
+		if err := enc.Encode(&descriptorpb.FileDescriptorProto{
+			Name:        proto.String("a.proto"),
+			MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String(nm)}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	br := bytes.NewReader(buf.Bytes())
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != "Obj\x01" {
+		t.Fatalf("magic: %q, %v", magic, err)
+	}
+	readString := func() string {
+		n, err := binary.ReadVarint(br)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, n)
+		if _, err = io.ReadFull(br, b); err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	meta := make(map[string]string)
+	n, _ := binary.ReadVarint(br)
+	for i := int64(0); i < n; i++ {
+		k := readString()
+		meta[k] = readString()
+	}
+	if n, _ = binary.ReadVarint(br); n != 0 {
+		t.Fatalf("metadata end: %d", n)
+	}
+	var schema struct {
+		Type, Name string
+	}
+	if err := json.Unmarshal([]byte(meta["avro.schema"]), &schema); err != nil {
+		t.Fatal(err)
+	}
+	if schema.Type != "record" || schema.Name != "google_protobuf_DescriptorProto" {
+		t.Errorf("schema: %s", meta["avro.schema"])
+	}
+	br.Seek(16, io.SeekCurrent)
+	if n, _ = binary.ReadVarint(br); n != 2 {
+		t.Errorf("got %d records, wanted 2", n)
+	}
+}
+
+func TestDelimited(t *testing.T) {
+	var buf bytes.Buffer
+	enc := Formats["protobuf"].NewEncoder(&buf)
+	for _, svc := range []string{"a", "bb"} {
+		if err := enc.Encode(&grpc_health_v1.HealthCheckRequest{Service: svc}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	br := bytes.NewReader(buf.Bytes())
+	for _, want := range []string{"a", "bb"} {
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, n)
+		if _, err = io.ReadFull(br, b); err != nil {
+			t.Fatal(err)
+		}
+		var got grpc_health_v1.HealthCheckRequest
+		if err = proto.Unmarshal(b, protoimpl.X.ProtoMessageV2Of(&got)); err != nil {
+			t.Fatal(err)
+		}
+		if got.Service != want {
+			t.Errorf("got %q, wanted %q", got.Service, want)
+		}
+	}
+	if br.Len() != 0 {
+		t.Errorf("%d bytes remained", br.Len())
+	}
+}
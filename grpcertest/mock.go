@@ -0,0 +1,189 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package grpcertest provides helpers for testing grpcer.Client users.
+package grpcertest
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/ngurban/grpcer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = grpcer.Client((*MockClient)(nil))
+
+// MockClient is a grpcer.Client returning the parts set by expectations.
+type MockClient struct {
+	mu           sync.Mutex
+	expectations map[string][]*Expectation
+	types        map[string][2]func() interface{}
+	calls        []Call
+}
+
+// Call is a recorded call of the MockClient.
+type Call struct {
+	Name  string
+	Input interface{}
+}
+
+// Expectation is the expected call of a method, with its scripted response.
+type Expectation struct {
+	name    string
+	match   func(input interface{}) bool
+	parts   []interface{}
+	err     error
+	times   int
+	called  int
+	callErr bool
+}
+
+// NewMockClient returns a new, empty MockClient.
+func NewMockClient() *MockClient {
+	return &MockClient{
+		expectations: make(map[string][]*Expectation),
+		types:        make(map[string][2]func() interface{}),
+	}
+}
+
+// SetTypes sets the functions returning new input and output structs for the name.
+func (m *MockClient) SetTypes(name string, newInput, newOutput func() interface{}) *MockClient {
+	m.mu.Lock()
+	m.types[name] = [2]func() interface{}{newInput, newOutput}
+	m.mu.Unlock()
+	return m
+}
+
+// On adds an expectation for the named method.
+//
+// The expectations are matched in the order of their addition.
+func (m *MockClient) On(name string) *Expectation {
+	e := &Expectation{name: name}
+	m.mu.Lock()
+	m.expectations[name] = append(m.expectations[name], e)
+	m.mu.Unlock()
+	return e
+}
+
+// WithInput restricts the expectation to the inputs match returns true for.
+func (e *Expectation) WithInput(match func(input interface{}) bool) *Expectation {
+	e.match = match
+	return e
+}
+
+// Return sets the parts to be received.
+//
+// If the last argument is an error, then it is returned by Call if there are no parts,
+// else by Recv after the parts.
+func (e *Expectation) Return(partsAndErr ...interface{}) *Expectation {
+	if n := len(partsAndErr); n != 0 {
+		if err, ok := partsAndErr[n-1].(error); ok {
+			e.err, partsAndErr = err, partsAndErr[:n-1]
+			e.callErr = len(partsAndErr) == 0
+		}
+	}
+	e.parts = partsAndErr
+	return e
+}
+
+// Times limits the number of calls the expectation matches.
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+// Once is Times(1).
+func (e *Expectation) Once() *Expectation { return e.Times(1) }
+
+// List the names with expectations or types.
+func (m *MockClient) List() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.expectations)+len(m.types))
+	for nm := range m.expectations {
+		names = append(names, nm)
+	}
+	for nm := range m.types {
+		if _, ok := m.expectations[nm]; !ok {
+			names = append(names, nm)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Input returns a new input struct set by SetTypes.
+func (m *MockClient) Input(name string) interface{} { return m.newType(name, 0) }
+
+// Output returns a new output struct set by SetTypes.
+func (m *MockClient) Output(name string) interface{} { return m.newType(name, 1) }
+
+func (m *MockClient) newType(name string, i int) interface{} {
+	m.mu.Lock()
+	f := m.types[name][i]
+	m.mu.Unlock()
+	if f == nil {
+		return nil
+	}
+	return f()
+}
+
+// Call records the call, and returns the response of the first matching expectation,
+// or an Unimplemented error if there is none.
+func (m *MockClient) Call(name string, ctx context.Context, input interface{}, opts ...grpc.CallOption) (grpcer.Receiver, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, Call{Name: name, Input: input})
+	for _, e := range m.expectations[name] {
+		if e.times > 0 && e.called >= e.times || e.match != nil && !e.match(input) {
+			continue
+		}
+		e.called++
+		if e.callErr {
+			return nil, e.err
+		}
+		return NewErrorReceiver(e.err, e.parts...), nil
+	}
+	return nil, status.Errorf(codes.Unimplemented, "unexpected call of %q with %#v", name, input)
+}
+
+// Calls returns the recorded calls.
+func (m *MockClient) Calls() []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Call(nil), m.calls...)
+}
+
+// AssertExpectations reports an error for each expectation with Times set which has not been called that many times,
+// and for each expectation without Times which has not been called at all.
+func (m *MockClient) AssertExpectations(t testing.TB) {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, es := range m.expectations {
+		for _, e := range es {
+			if e.times > 0 && e.called != e.times {
+				t.Errorf("%s: called %d times, wanted %d", name, e.called, e.times)
+			} else if e.times <= 0 && e.called == 0 {
+				t.Errorf("%s: not called", name)
+			}
+		}
+	}
+}
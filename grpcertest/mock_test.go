@@ -0,0 +1,68 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcertest_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/ngurban/grpcer/grpcertest"
+)
+
+func TestMockClient(t *testing.T) {
+	errBroken := errors.New("broken")
+	m := grpcertest.NewMockClient()
+	m.On("Stream").Return(1, 2, errBroken).Once()
+	m.On("Stream").Return(3)
+	m.On("Fail").Return(errBroken)
+	ctx := context.Background()
+
+	recv, err := m.Call("Stream", ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []int{1, 2} {
+		if part, err := recv.Recv(); err != nil || part != want {
+			t.Errorf("got %v (%+v), wanted %d", part, err, want)
+		}
+	}
+	if _, err = recv.Recv(); err != errBroken {
+		t.Errorf("got %+v, wanted %v", err, errBroken)
+	}
+
+	if recv, err = m.Call("Stream", ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if part, _ := recv.Recv(); part != 3 {
+		t.Errorf("second call: got %v, wanted 3", part)
+	}
+	if _, err = recv.Recv(); err != io.EOF {
+		t.Errorf("got %+v, wanted EOF", err)
+	}
+
+	if _, err = m.Call("Fail", ctx, nil); err != errBroken {
+		t.Errorf("got %+v, wanted %v", err, errBroken)
+	}
+	if _, err = m.Call("Unknown", ctx, nil); err == nil {
+		t.Error("wanted error for unexpected call")
+	}
+	if n := len(m.Calls()); n != 4 {
+		t.Errorf("got %d calls, wanted 4", n)
+	}
+	m.AssertExpectations(t)
+}
@@ -0,0 +1,171 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcertest
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ngurban/grpcer"
+)
+
+// NewReceiver returns a Receiver which returns the parts, then io.EOF.
+func NewReceiver(parts ...interface{}) grpcer.Receiver {
+	return NewErrorReceiver(nil, parts...)
+}
+
+// NewErrorReceiver returns a Receiver which returns the parts, then err (io.EOF if nil).
+func NewErrorReceiver(err error, parts ...interface{}) grpcer.Receiver {
+	if err == nil {
+		err = io.EOF
+	}
+	return &receiver{parts: parts, err: err}
+}
+
+type receiver struct {
+	mu    sync.Mutex
+	parts []interface{}
+	err   error
+}
+
+func (r *receiver) Recv() (interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.parts) == 0 {
+		return nil, r.err
+	}
+	part := r.parts[0]
+	r.parts = r.parts[1:]
+	return part, nil
+}
+
+// Script builds scripted Receivers: parts, errors, delays and hangs, in order.
+// After the script, the Receivers return io.EOF (or the End error) forever.
+type Script struct {
+	steps []step
+	end   error
+}
+
+type step struct {
+	part  interface{}
+	err   error
+	delay time.Duration
+	hang  bool
+}
+
+// NewScript returns an empty Script.
+func NewScript() *Script { return &Script{} }
+
+// Parts appends the parts.
+func (s *Script) Parts(parts ...interface{}) *Script {
+	for _, p := range parts {
+		s.steps = append(s.steps, step{part: p})
+	}
+	return s
+}
+
+// Error appends a Recv returning err, after which the stream continues.
+func (s *Script) Error(err error) *Script {
+	s.steps = append(s.steps, step{err: err})
+	return s
+}
+
+// Delay the next Recv by d.
+func (s *Script) Delay(d time.Duration) *Script {
+	s.steps = append(s.steps, step{delay: d})
+	return s
+}
+
+// Hang blocks the next Recv till the context is done, or the Receiver is closed,
+// returning the context's error (context.Canceled on Close).
+func (s *Script) Hang() *Script {
+	s.steps = append(s.steps, step{hang: true})
+	return s
+}
+
+// End sets the error returned after the script (io.EOF if nil).
+func (s *Script) End(err error) *Script {
+	s.end = err
+	return s
+}
+
+// Receiver returns a new ScriptedReceiver playing the script.
+func (s *Script) Receiver(ctx context.Context) *ScriptedReceiver {
+	end := s.end
+	if end == nil {
+		end = io.EOF
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &ScriptedReceiver{ctx: ctx, cancel: cancel, steps: append([]step(nil), s.steps...), end: end}
+}
+
+// ScriptedReceiver is a grpcer.Receiver playing a Script.
+type ScriptedReceiver struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	steps  []step
+	end    error
+	recvs  atomic.Int32
+	closed atomic.Bool
+}
+
+var _ = grpcer.Receiver((*ScriptedReceiver)(nil))
+
+// Recv plays the next steps of the script.
+func (r *ScriptedReceiver) Recv() (interface{}, error) {
+	r.recvs.Add(1)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for len(r.steps) != 0 {
+		st := r.steps[0]
+		r.steps = r.steps[1:]
+		switch {
+		case st.delay > 0:
+			timer := time.NewTimer(st.delay)
+			select {
+			case <-r.ctx.Done():
+				timer.Stop()
+				return nil, r.ctx.Err()
+			case <-timer.C:
+			}
+		case st.hang:
+			<-r.ctx.Done()
+			return nil, r.ctx.Err()
+		case st.err != nil:
+			return nil, st.err
+		default:
+			return st.part, nil
+		}
+	}
+	return nil, r.end
+}
+
+// Close the receiver, cancelling its context.
+func (r *ScriptedReceiver) Close() error {
+	r.closed.Store(true)
+	r.cancel()
+	return nil
+}
+
+// Closed reports whether Close has been called.
+func (r *ScriptedReceiver) Closed() bool { return r.closed.Load() }
+
+// Recvs returns the number of Recv calls.
+func (r *ScriptedReceiver) Recvs() int { return int(r.recvs.Load()) }
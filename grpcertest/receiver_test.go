@@ -0,0 +1,64 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcertest_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ngurban/grpcer/grpcertest"
+)
+
+func TestScript(t *testing.T) {
+	errFlaky := errors.New("flaky")
+	script := grpcertest.NewScript().Parts(1).Error(errFlaky).Delay(10*time.Millisecond).Parts(2, 3)
+	recv := script.Receiver(context.Background())
+	start := time.Now()
+	for i, want := range []struct {
+		Part interface{}
+		Err  error
+	}{{1, nil}, {nil, errFlaky}, {2, nil}, {3, nil}, {nil, io.EOF}, {nil, io.EOF}} {
+		part, err := recv.Recv()
+		if part != want.Part || err != want.Err {
+			t.Errorf("%d. got %v, %v; wanted %v, %v", i, part, err, want.Part, want.Err)
+		}
+	}
+	if d := time.Since(start); d < 10*time.Millisecond {
+		t.Errorf("no delay: %s", d)
+	}
+	if recv.Recvs() != 6 {
+		t.Errorf("got %d Recvs", recv.Recvs())
+	}
+
+	// each Receiver plays the script from the start
+	hanging := script.Hang().Receiver(context.Background())
+	for i := 0; i < 3; i++ {
+		hanging.Recv()
+	}
+	time.AfterFunc(10*time.Millisecond, func() { hanging.Close() })
+	if part, err := hanging.Recv(); part != 3 || err != nil {
+		t.Errorf("got %v, %v", part, err)
+	}
+	if _, err := hanging.Recv(); !errors.Is(err, context.Canceled) {
+		t.Errorf("hang: got %v", err)
+	}
+	if !hanging.Closed() {
+		t.Error("not closed")
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcertest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/ngurban/grpcer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Mode of the Recorder.
+type Mode uint8
+
+const (
+	// Replay the golden files.
+	Replay = Mode(iota)
+	// Record the calls into golden files.
+	Record
+)
+
+// Recorder is a grpcer.Client which records the calls of the embedded Client
+// into golden files, or replays them.
+//
+// The embedded Client is used for the Input and Output types in Replay mode, too.
+type Recorder struct {
+	grpcer.Client
+	// Dir is the directory of the golden files.
+	Dir  string
+	Mode Mode
+	// Scrub is called on the JSON-decoded input and parts before recording,
+	// to replace the volatile values (timestamps, ids) with stable ones.
+	Scrub func(name string, v interface{}) interface{}
+}
+
+// golden is the content of a golden file.
+type golden struct {
+	Name  string            `json:"name"`
+	Input json.RawMessage   `json:"input"`
+	Parts []json.RawMessage `json:"parts"`
+	Code  codes.Code        `json:"code,omitempty"`
+	Error string            `json:"error,omitempty"`
+}
+
+// scrubbed returns the JSON encoding of v after Scrub.
+func (r *Recorder) scrubbed(name string, v interface{}) (json.RawMessage, error) {
+	b, err := jsoniter.Marshal(v)
+	if err != nil || r.Scrub == nil {
+		return b, err
+	}
+	var x interface{}
+	if err = json.Unmarshal(b, &x); err != nil {
+		return b, err
+	}
+	return json.Marshal(r.Scrub(name, x))
+}
+
+// fileName returns the golden file's name for the call: the name and the hash of the scrubbed input.
+func (r *Recorder) fileName(name string, input json.RawMessage) string {
+	hsh := sha256.Sum256(input)
+	name = strings.NewReplacer("/", "_", ".", "_").Replace(strings.TrimPrefix(name, "/"))
+	return filepath.Join(r.Dir, name+"-"+hex.EncodeToString(hsh[:6])+".json")
+}
+
+// Call the named method, recording or replaying it.
+func (r *Recorder) Call(name string, ctx context.Context, input interface{}, opts ...grpc.CallOption) (grpcer.Receiver, error) {
+	inp, err := r.scrubbed(name, input)
+	if err != nil {
+		return nil, fmt.Errorf("encode input %#v: %w", input, err)
+	}
+	fn := r.fileName(name, inp)
+	if r.Mode == Replay {
+		return r.replay(name, fn)
+	}
+
+	g := golden{Name: name, Input: inp}
+	var parts []interface{}
+	recv, callErr := r.Client.Call(name, ctx, input, opts...)
+	for callErr == nil {
+		var part interface{}
+		if part, callErr = recv.Recv(); callErr != nil {
+			if callErr == io.EOF {
+				callErr = nil
+			}
+			break
+		}
+		parts = append(parts, part)
+		b, err := r.scrubbed(name, part)
+		if err != nil {
+			return nil, fmt.Errorf("encode part %#v: %w", part, err)
+		}
+		g.Parts = append(g.Parts, b)
+	}
+	if callErr != nil {
+		st := status.Convert(callErr)
+		g.Code, g.Error = st.Code(), st.Message()
+	}
+	b, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(r.Dir, 0755); err != nil {
+		return nil, err
+	}
+	if err = ioutil.WriteFile(fn, b, 0644); err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 && callErr != nil {
+		return nil, callErr
+	}
+	return NewErrorReceiver(callErr, parts...), nil
+}
+
+func (r *Recorder) replay(name, fn string) (grpcer.Receiver, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, status.Errorf(codes.Unimplemented, "no recording of %s: %v", name, err)
+	}
+	var g golden
+	if err = json.Unmarshal(b, &g); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	parts := make([]interface{}, 0, len(g.Parts))
+	for _, b := range g.Parts {
+		part := r.Client.Output(name)
+		if part == nil {
+			var x interface{}
+			part = &x
+		}
+		if err = jsoniter.Unmarshal(b, part); err != nil {
+			return nil, fmt.Errorf("%s: decode %s: %w", fn, b, err)
+		}
+		parts = append(parts, part)
+	}
+	var callErr error
+	if g.Code != codes.OK {
+		callErr = status.Error(g.Code, g.Error)
+		if len(parts) == 0 {
+			return nil, callErr
+		}
+	}
+	return NewErrorReceiver(callErr, parts...), nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcertest_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/ngurban/grpcer/grpcertest"
+)
+
+type recInput struct {
+	ID        int
+	Timestamp string
+}
+type recOutput struct {
+	Name string
+}
+
+func TestRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grpcertest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := grpcertest.NewMockClient().SetTypes("Get",
+		func() interface{} { return new(recInput) },
+		func() interface{} { return new(recOutput) })
+	m.On("Get").Return(&recOutput{Name: "a"}, &recOutput{Name: "b"})
+	scrub := func(name string, v interface{}) interface{} {
+		if m, ok := v.(map[string]interface{}); ok {
+			if _, ok := m["Timestamp"]; ok {
+				m["Timestamp"] = "SCRUBBED"
+			}
+		}
+		return v
+	}
+	ctx := context.Background()
+	rec := &grpcertest.Recorder{Client: m, Dir: dir, Mode: grpcertest.Record, Scrub: scrub}
+	if _, err = rec.Call("Get", ctx, &recInput{ID: 1, Timestamp: "now"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rep := &grpcertest.Recorder{Client: m, Dir: dir, Mode: grpcertest.Replay, Scrub: scrub}
+	recv, err := rep.Call("Get", ctx, &recInput{ID: 1, Timestamp: "later"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"a", "b"} {
+		part, err := recv.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(part, &recOutput{Name: want}) {
+			t.Errorf("got %#v, wanted %q", part, want)
+		}
+	}
+	if _, err = rep.Call("Get", ctx, &recInput{ID: 2}); err == nil {
+		t.Error("wanted error for unrecorded call")
+	}
+	if n := len(m.Calls()); n != 1 {
+		t.Errorf("replay called the client: %d calls", n)
+	}
+}
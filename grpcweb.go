@@ -0,0 +1,322 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// The gRPC-Web content types, with an optional "+proto" or "+json" suffix.
+const (
+	GRPCWebContentType     = "application/grpc-web"
+	GRPCWebTextContentType = "application/grpc-web-text"
+)
+
+// isGRPCWeb reports whether r is a gRPC-Web request.
+func isGRPCWeb(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), GRPCWebContentType)
+}
+
+// grpcWebAllowedHeaders are the request headers of the gRPC-Web clients, allowed by the CORS preflight.
+const grpcWebAllowedHeaders = "x-grpc-web, content-type, x-user-agent, grpc-timeout"
+
+// isCORSPreflight reports whether r is a CORS preflight request.
+func isCORSPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// setGRPCWebCORS checks the Origin of r as checkOrigin, and sets the CORS headers of the response.
+func setGRPCWebCORS(w http.ResponseWriter, r *http.Request, allowed []string) error {
+	if err := checkOrigin(r, allowed); err != nil {
+		return err
+	}
+	hdr := w.Header()
+	hdr.Add("Vary", "Origin")
+	if origin := r.Header.Get("Origin"); origin != "" {
+		hdr.Set("Access-Control-Allow-Origin", origin)
+	}
+	hdr.Set("Access-Control-Expose-Headers", "grpc-status, grpc-message")
+	return nil
+}
+
+// serveGRPCWebPreflight answers the CORS preflight request of the gRPC-Web clients.
+func (h JSONHandler) serveGRPCWebPreflight(w http.ResponseWriter, r *http.Request) {
+	if err := setGRPCWebCORS(w, r, h.GRPCWebOrigins); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Methods", http.MethodPost)
+	w.Header().Set("Access-Control-Allow-Headers", grpcWebAllowedHeaders)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseGRPCTimeout parses the grpc-timeout header value ("100m" for 100ms).
+func parseGRPCTimeout(s string) (time.Duration, bool) {
+	if len(s) < 2 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	var unit time.Duration
+	switch s[len(s)-1] {
+	case 'H':
+		unit = time.Hour
+	case 'M':
+		unit = time.Minute
+	case 'S':
+		unit = time.Second
+	case 'm':
+		unit = time.Millisecond
+	case 'u':
+		unit = time.Microsecond
+	case 'n':
+		unit = time.Nanosecond
+	default:
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// grpcWebWriter writes the gRPC-Web frames, base64 encoded in text mode.
+type grpcWebWriter struct {
+	w       http.ResponseWriter
+	text    bool
+	started bool
+}
+
+func (gw *grpcWebWriter) frame(flag byte, b []byte) error {
+	if !gw.started {
+		gw.started = true
+		gw.w.WriteHeader(http.StatusOK)
+	}
+	frame := make([]byte, 5, 5+len(b))
+	frame[0] = flag
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(b)))
+	frame = append(frame, b...)
+	if gw.text {
+		frame = []byte(base64.StdEncoding.EncodeToString(frame))
+	}
+	if _, err := gw.w.Write(frame); err != nil {
+		return err
+	}
+	flush(gw.w)
+	return nil
+}
+
+// trailer writes the status of err and the trailer metadata as the trailer frame.
+func (gw *grpcWebWriter) trailer(err error, md metadata.MD) error {
+	st := status.Convert(err)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "grpc-status: %d\r\n", st.Code())
+	if msg := st.Message(); msg != "" {
+		fmt.Fprintf(&buf, "grpc-message: %s\r\n", percentEncode(msg))
+	}
+	for k, vv := range md {
+		for _, v := range vv {
+			fmt.Fprintf(&buf, "%s: %s\r\n", k, v)
+		}
+	}
+	return gw.frame(0x80, buf.Bytes())
+}
+
+// percentEncode encodes the grpc-message as the gRPC spec requires.
+func percentEncode(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; ' ' <= c && c <= '~' && c != '%' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// readGRPCWebFrame reads the (uncompressed) message of the first frame, nil if empty.
+//
+// A message longer than maxSize (DefaultMaxBodySize if zero, unlimited if negative)
+// is rejected before allocating its buffer.
+func readGRPCWebFrame(r io.Reader, maxSize int64) ([]byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if hdr[0]&1 != 0 {
+		return nil, status.Error(codes.Unimplemented, "compressed messages are not supported")
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if maxSize == 0 {
+		maxSize = DefaultMaxBodySize
+	}
+	if maxSize > 0 && int64(n) > maxSize {
+		return nil, status.Errorf(codes.ResourceExhausted, "message of %d bytes is larger than %d", n, maxSize)
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
+
+// serveGRPCWeb serves the gRPC-Web request of the named method.
+//
+// The response is always a data frame per part, and a trailer frame with the status.
+func (h JSONHandler) serveGRPCWeb(w http.ResponseWriter, r *http.Request, name string, Log func(...interface{}) error) {
+	if err := setGRPCWebCORS(w, r, h.GRPCWebOrigins); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	codec := "proto"
+	if _, sub, ok := strings.Cut(ct, "+"); ok {
+		codec = sub
+	}
+	if codec != "proto" && codec != "json" {
+		http.Error(w, fmt.Sprintf("unsupported gRPC-Web codec %q", codec), http.StatusUnsupportedMediaType)
+		return
+	}
+	gw := &grpcWebWriter{w: w, text: strings.HasPrefix(ct, GRPCWebTextContentType)}
+	w.Header().Set("Content-Type", ct)
+	fail := func(err error) {
+		Log("call", name, "error", err)
+		if err := gw.trailer(err, nil); err != nil {
+			Log("msg", "trailer", "error", err)
+		}
+	}
+
+	inp := h.Input(name)
+	if inp == nil || !allowedMethod(h.Methods, name) {
+		fail(status.Error(codes.NotFound, notFoundMessage(h.Client, name)))
+		return
+	}
+	release, err := h.Limit.Acquire(r.Context(), name)
+	if err != nil {
+		fail(status.Error(codes.ResourceExhausted, err.Error()))
+		return
+	}
+	defer release()
+	if err = decodeBody(w, r, h.MaxBodySize); err != nil {
+		fail(status.Error(codes.InvalidArgument, err.Error()))
+		return
+	}
+	var body io.Reader = r.Body
+	if gw.text {
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	b, err := readGRPCWebFrame(body, h.MaxBodySize)
+	if err == nil {
+		if codec == "json" {
+			if len(b) != 0 {
+				err = decodeJSONBytes(inp, b, h.StrictInput, h.SpecialFloats, Log)
+			}
+		} else if pr, ok := inp.(protoReflecter); !ok {
+			err = fmt.Errorf("%T is not a protobuf message", inp)
+		} else {
+			err = proto.Unmarshal(b, pr.ProtoReflect().Interface())
+		}
+	}
+	if err != nil {
+		if _, ok := status.FromError(err); !ok {
+			err = status.Error(codes.InvalidArgument, err.Error())
+		}
+		fail(err)
+		return
+	}
+	Log("inp", inp)
+
+	ctx, idleTimer, cancel, err := h.callContext(r.Context(), r, name, inp, Log)
+	if err != nil {
+		fail(status.Error(codes.PermissionDenied, err.Error()))
+		return
+	}
+	defer cancel()
+	// the client's timeout may only shorten the configured one
+	if timeout, ok := parseGRPCTimeout(r.Header.Get("grpc-timeout")); ok && timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	recv, err := h.Call(name, ctx, inp)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer CloseReceiver(recv)
+	rm, hasMD := recv.(ReceiverWithMetadata)
+	if idleTimer != nil {
+		recv = &idleReceiver{Receiver: recv, it: idleTimer}
+	}
+
+	part, err := recv.Recv()
+	if hasMD {
+		if md, _ := rm.Header(); len(md) != 0 {
+			for k, vv := range md {
+				for _, v := range vv {
+					w.Header().Add(k, v)
+				}
+			}
+		}
+	}
+	for err == nil {
+		if codec == "json" {
+			b, err = jsonConfigOf(h.SpecialFloats).api.Marshal(part)
+		} else if pr, ok := part.(protoReflecter); !ok {
+			err = fmt.Errorf("%T is not a protobuf message", part)
+		} else {
+			b, err = proto.Marshal(pr.ProtoReflect().Interface())
+		}
+		if err != nil {
+			err = status.Error(codes.Internal, err.Error())
+			break
+		}
+		if err = gw.frame(0, b); err != nil {
+			Log("msg", "write", "error", err)
+			return
+		}
+		part, err = recv.Recv()
+	}
+	if err == io.EOF {
+		err = nil
+	} else {
+		Log("msg", "recv", "error", err)
+	}
+	var trailer metadata.MD
+	if hasMD {
+		trailer = rm.Trailer()
+	}
+	if err = gw.trailer(err, trailer); err != nil {
+		Log("msg", "trailer", "error", err)
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/runtime/protoimpl"
+)
+
+type grpcWebClient struct{ healthClient }
+
+func (grpcWebClient) Call(name string, ctx context.Context, input interface{}, opts ...grpc.CallOption) (Receiver, error) {
+	if input.(*grpc_health_v1.HealthCheckRequest).Service == "" {
+		return nil, status.Error(codes.NotFound, "no such service: 100%")
+	}
+	return &receiver{parts: []interface{}{
+		&grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING},
+		&grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_NOT_SERVING},
+	}}, nil
+}
+
+func TestGRPCWeb(t *testing.T) {
+	h := NewHTTPHandler(grpcWebClient{}, HandlerGRPCWeb())
+	frame := func(b []byte) []byte {
+		hdr := make([]byte, 5, 5+len(b))
+		binary.BigEndian.PutUint32(hdr[1:], uint32(len(b)))
+		return append(hdr, b...)
+	}
+	readFrames := func(t *testing.T, r io.Reader) (msgs [][]byte, trailer string) {
+		for {
+			var hdr [5]byte
+			if _, err := io.ReadFull(r, hdr[:]); err != nil {
+				if err != io.EOF {
+					t.Fatal(err)
+				}
+				return msgs, trailer
+			}
+			b := make([]byte, binary.BigEndian.Uint32(hdr[1:]))
+			if _, err := io.ReadFull(r, b); err != nil {
+				t.Fatal(err)
+			}
+			if hdr[0]&0x80 != 0 {
+				trailer = string(b)
+			} else {
+				msgs = append(msgs, b)
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		ContentType, Service string
+		Messages             int
+		Trailer              string
+	}{
+		{GRPCWebContentType, "a", 2, "grpc-status: 0\r\n"},
+		{GRPCWebContentType + "+proto", "", 0, "grpc-status: 5\r\ngrpc-message: no such service: 100%25\r\n"},
+		{GRPCWebTextContentType, "a", 2, "grpc-status: 0\r\n"},
+		{GRPCWebContentType + "+json", "a", 2, "grpc-status: 0\r\n"},
+	} {
+		var b []byte
+		if strings.HasSuffix(tc.ContentType, "+json") {
+			b = []byte(`{"service":"` + tc.Service + `"}`)
+		} else {
+			b, _ = proto.Marshal(protoimpl.X.ProtoMessageV2Of(&grpc_health_v1.HealthCheckRequest{Service: tc.Service}))
+		}
+		body := frame(b)
+		text := strings.HasPrefix(tc.ContentType, GRPCWebTextContentType)
+		if text {
+			body = []byte(base64.StdEncoding.EncodeToString(body))
+		}
+		req := httptest.NewRequest("POST", "/grpc.health.v1.Health/Watch", bytes.NewReader(body))
+		req.Header.Set("Content-Type", tc.ContentType)
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		if rw.Code != 200 || rw.Header().Get("Content-Type") != tc.ContentType {
+			t.Errorf("%s: got %d %q", tc.ContentType, rw.Code, rw.Header().Get("Content-Type"))
+		}
+		var r io.Reader = rw.Body
+		if text {
+			// each frame is encoded separately, so decode by 4 bytes
+			var buf bytes.Buffer
+			s := rw.Body.String()
+			for i := 0; i < len(s); i += 4 {
+				dec, err := base64.StdEncoding.DecodeString(s[i : i+4])
+				if err != nil {
+					t.Fatalf("%q: %+v", s, err)
+				}
+				buf.Write(dec)
+			}
+			r = &buf
+		}
+		msgs, trailer := readFrames(t, r)
+		if len(msgs) != tc.Messages || trailer != tc.Trailer {
+			t.Errorf("%s: got %d messages and trailer %q, wanted %d and %q", tc.ContentType, len(msgs), trailer, tc.Messages, tc.Trailer)
+		}
+		if len(msgs) != 0 && strings.HasSuffix(tc.ContentType, "+json") {
+			if got, want := string(msgs[0]), `{"status":1}`; got != want {
+				t.Errorf("json: got %q, wanted %q", got, want)
+			}
+		}
+	}
+	// the length is checked before allocating the message
+	req := httptest.NewRequest("POST", "/grpc.health.v1.Health/Watch", bytes.NewReader([]byte{0, 0x7f, 0xff, 0xff, 0xff}))
+	req.Header.Set("Content-Type", GRPCWebContentType)
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	if _, trailer := readFrames(t, rw.Body); !strings.HasPrefix(trailer, "grpc-status: 8\r\n") {
+		t.Errorf("oversized: got trailer %q", trailer)
+	}
+
+	// the call context is set up as for JSON
+	req = httptest.NewRequest("POST", "/grpc.health.v1.Health/Watch", bytes.NewReader(frame(nil)))
+	req.Header.Set("Content-Type", GRPCWebContentType)
+	req.Header.Set(OnBehalfOfHeader, "customer-1")
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	if _, trailer := readFrames(t, rw.Body); !strings.HasPrefix(trailer, "grpc-status: 7\r\n") {
+		t.Errorf("on behalf of: got trailer %q", trailer)
+	}
+}
+
+func TestGRPCWebCORS(t *testing.T) {
+	h := NewHTTPHandler(grpcWebClient{}, HandlerGRPCWeb("https://app.example"))
+	for _, tc := range []struct {
+		Origin string
+		Code   int
+	}{
+		{"https://app.example", http.StatusNoContent},
+		{"https://evil.example", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest("OPTIONS", "/grpc.health.v1.Health/Watch", nil)
+		req.Header.Set("Origin", tc.Origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web,x-user-agent")
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		if rw.Code != tc.Code {
+			t.Errorf("%s: got %d, wanted %d", tc.Origin, rw.Code, tc.Code)
+		}
+		if tc.Code != http.StatusNoContent {
+			continue
+		}
+		hdr := rw.Header()
+		if hdr.Get("Access-Control-Allow-Origin") != tc.Origin || hdr.Get("Access-Control-Allow-Methods") != "POST" ||
+			!strings.Contains(hdr.Get("Access-Control-Allow-Headers"), "x-grpc-web") ||
+			!strings.Contains(hdr.Get("Access-Control-Allow-Headers"), "grpc-timeout") {
+			t.Errorf("%s: got headers %v", tc.Origin, hdr)
+		}
+	}
+
+	b, _ := proto.Marshal(protoimpl.X.ProtoMessageV2Of(&grpc_health_v1.HealthCheckRequest{Service: "a"}))
+	body := append([]byte{0, 0, 0, 0, byte(len(b))}, b...)
+	for _, tc := range []struct {
+		Origin string
+		Code   int
+	}{
+		{"https://app.example", http.StatusOK},
+		{"https://evil.example", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest("POST", "/grpc.health.v1.Health/Watch", bytes.NewReader(body))
+		req.Header.Set("Content-Type", GRPCWebContentType)
+		req.Header.Set("Origin", tc.Origin)
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		if rw.Code != tc.Code {
+			t.Errorf("%s: got %d, wanted %d", tc.Origin, rw.Code, tc.Code)
+		}
+		if tc.Code != http.StatusOK {
+			continue
+		}
+		if got := rw.Header().Get("Access-Control-Allow-Origin"); got != tc.Origin {
+			t.Errorf("%s: got Access-Control-Allow-Origin %q", tc.Origin, got)
+		}
+		if got := rw.Header().Get("Access-Control-Expose-Headers"); got != "grpc-status, grpc-message" {
+			t.Errorf("%s: got Access-Control-Expose-Headers %q", tc.Origin, got)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"net/http"
+	"path"
+	"time"
+)
+
+// HandlerOption is an option of NewHTTPHandler.
+//
+// Any JSONHandler field can be set with a custom HandlerOption.
+type HandlerOption func(*JSONHandler)
+
+// HandlerLog sets the logger.
+func HandlerLog(Log func(...interface{}) error) HandlerOption {
+	return func(h *JSONHandler) { h.Log = Log }
+}
+
+// HandlerTimeout sets the call timeout (DefaultTimeout if zero, unlimited if negative).
+func HandlerTimeout(timeout time.Duration) HandlerOption {
+	return func(h *JSONHandler) { h.Timeout = timeout }
+}
+
+// HandlerMergeStreams sets whether the streams are merged into one object (the default),
+// which can be overridden by the "merge" query parameter.
+func HandlerMergeStreams(merge bool) HandlerOption {
+	return func(h *JSONHandler) { h.MergeStreams = merge }
+}
+
+// HandlerMethods sets the allowlist of the callable methods.
+func HandlerMethods(names ...string) HandlerOption {
+	return func(h *JSONHandler) { h.Methods = names }
+}
+
+// HandlerReflection serves the method descriptions at ReflectionName (with GET, too).
+func HandlerReflection() HandlerOption { return func(h *JSONHandler) { h.Reflection = true } }
+
+// HandlerErrors sets the error renderer.
+func HandlerErrors(er *ErrorRenderer) HandlerOption {
+	return func(h *JSONHandler) { h.Errors = er }
+}
+
+// HandlerCompression compresses the responses above threshold bytes with the gzip level.
+func HandlerCompression(level, threshold int) HandlerOption {
+	return func(h *JSONHandler) { h.ResponseCompressionLevel, h.ResponseCompressionThreshold = level, threshold }
+}
+
+// HandlerStrictInput rejects the inputs with unknown fields.
+func HandlerStrictInput() HandlerOption { return func(h *JSONHandler) { h.StrictInput = true } }
+
+// HandlerSpecialFloats sets the handling of NaN and ±Infinity in the JSON inputs and outputs.
+func HandlerSpecialFloats(mode SpecialFloats) HandlerOption {
+	return func(h *JSONHandler) { h.SpecialFloats = mode }
+}
+
+// HandlerGRPCWeb serves the gRPC-Web requests (and their CORS preflights), too,
+// allowing the origins besides the same host.
+func HandlerGRPCWeb(origins ...string) HandlerOption {
+	return func(h *JSONHandler) { h.GRPCWeb, h.GRPCWebOrigins = true, origins }
+}
+
+// HandlerWebSocket serves the WebSocket upgrade requests (with GET), too,
+// allowing the origins besides the same host.
+func HandlerWebSocket(origins ...string) HandlerOption {
+	return func(h *JSONHandler) { h.WebSocket, h.WebSocketOrigins = true, origins }
+}
+
+// HandlerLimit sets the concurrency limit of the calls.
+func HandlerLimit(limit *ConcurrencyLimit) HandlerOption {
+	return func(h *JSONHandler) { h.Limit = limit }
+}
+
+// httpHandler is a JSONHandler accepting only POST (and GET for the reflection and the WebSocket).
+type httpHandler struct {
+	JSONHandler
+}
+
+// NewHTTPHandler returns the HTTP/JSON gateway of c: "POST /{method}" with the input as
+// JSON (or form) body calls the method, and responds with the (merged) output,
+// in the negotiated format (see Formats). The errors are mapped to HTTP status codes.
+func NewHTTPHandler(c Client, opts ...HandlerOption) http.Handler {
+	h := httpHandler{JSONHandler: JSONHandler{Client: c, MergeStreams: true}}
+	for _, o := range opts {
+		o(&h.JSONHandler)
+	}
+	return h
+}
+
+func (h httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	allow := "POST"
+	if h.Reflection && path.Base(r.URL.Path) == ReflectionName {
+		if r.Method == http.MethodGet {
+			h.JSONHandler.ServeHTTP(w, r)
+			return
+		}
+		allow = "GET, POST"
+	}
+	if h.WebSocket && isWebSocket(r) || h.GRPCWeb && isCORSPreflight(r) {
+		h.JSONHandler.ServeHTTP(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", allow)
+		jsonError(w, "method "+r.Method+" not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.JSONHandler.ServeHTTP(w, r)
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNewHTTPHandler(t *testing.T) {
+	type page struct {
+		Items []int `json:"items"`
+	}
+	h := NewHTTPHandler(fakeClient{
+		"Echo": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return &receiver{parts: []interface{}{
+				&page{Items: []int{1}},
+				&page{Items: []int{2}},
+			}}, nil
+		},
+		"Fail": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return nil, status.Error(codes.InvalidArgument, "bad")
+		},
+	}, HandlerReflection(), HandlerMethods("Echo", "Fail"))
+
+	for _, tc := range []struct {
+		Method, Path string
+		Code         int
+		Body         string
+	}{
+		{"POST", "/Echo", 200, `{"items":[1,2]}`},
+		{"GET", "/Echo", 405, ""},
+		{"POST", "/Fail", 400, "bad"},
+		{"POST", "/Unknown", 404, ""},
+		{"GET", "/" + ReflectionName, 200, `"name":"Echo"`},
+	} {
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest(tc.Method, tc.Path, strings.NewReader("{}")))
+		if rw.Code != tc.Code {
+			t.Errorf("%s %s: got %d, wanted %d (%s)", tc.Method, tc.Path, rw.Code, tc.Code, rw.Body.String())
+		}
+		if tc.Code == http.StatusMethodNotAllowed && rw.Header().Get("Allow") != "POST" {
+			t.Errorf("%s %s: Allow=%q", tc.Method, tc.Path, rw.Header().Get("Allow"))
+		}
+		if got := rw.Body.String(); !strings.Contains(strings.Join(strings.Fields(got), ""), tc.Body) {
+			t.Errorf("%s %s: got %q, wanted %q", tc.Method, tc.Path, got, tc.Body)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// LocaleMetadataKey is the outgoing metadata key of the locale.
+const LocaleMetadataKey = "accept-language"
+
+// preferredLanguage returns the language tag with the highest quality from the Accept-Language value.
+func preferredLanguage(accept string) string {
+	var best string
+	bestQ := -1.0
+	for _, lang := range strings.Split(accept, ",") {
+		params := strings.Split(lang, ";")
+		tag := strings.TrimSpace(params[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, p := range params[1:] {
+			if p = strings.TrimSpace(p); strings.HasPrefix(p, "q=") {
+				if f, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					q = f
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
+
+// setInputField sets the dot-separated string field path of input to value,
+// if it is empty. The field names are matched as in inputField.
+func setInputField(input interface{}, path, value string) bool {
+	rv := reflect.ValueOf(input)
+	names := strings.Split(path, ".")
+	for i, nm := range names {
+		for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+			if rv.IsNil() {
+				if rv.Kind() != reflect.Ptr || !rv.CanSet() {
+					return false
+				}
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		}
+		want := strings.ToLower(strings.Replace(nm, "_", "", -1))
+		switch rv.Kind() {
+		case reflect.Struct:
+			rv = rv.FieldByNameFunc(func(s string) bool {
+				return strings.ToLower(strings.Replace(s, "_", "", -1)) == want
+			})
+			if !rv.IsValid() || !rv.CanSet() {
+				return false
+			}
+		case reflect.Map:
+			if i != len(names)-1 || rv.Type().Key().Kind() != reflect.String || rv.IsNil() {
+				return false
+			}
+			key := reflect.ValueOf(nm)
+			for it := rv.MapRange(); it.Next(); {
+				if strings.ToLower(strings.Replace(it.Key().String(), "_", "", -1)) == want {
+					key = it.Key()
+					break
+				}
+			}
+			if old := rv.MapIndex(key); old.IsValid() && !old.IsZero() &&
+				!(old.Kind() == reflect.Interface && (old.IsNil() || old.Elem().IsZero())) {
+				return false
+			}
+			v := reflect.ValueOf(value)
+			if !v.Type().AssignableTo(rv.Type().Elem()) {
+				return false
+			}
+			rv.SetMapIndex(key, v)
+			return true
+		default:
+			return false
+		}
+	}
+	if rv.Kind() != reflect.String || rv.String() != "" {
+		return false
+	}
+	rv.SetString(value)
+	return true
+}
+
+// forwardHeaders appends the allowed HTTP headers of r to the outgoing metadata of ctx.
+//
+// allow maps the header names to the metadata keys (the lowercased header name if empty).
+// The reserved keys (see reservedKey) are never forwarded.
+func forwardHeaders(ctx context.Context, r *http.Request, allow map[string]string) context.Context {
+	if len(allow) == 0 {
+		return ctx
+	}
+	var kv []string
+	for header, key := range allow {
+		vv := r.Header.Values(header)
+		if len(vv) == 0 {
+			continue
+		}
+		if key == "" {
+			key = header
+		}
+		key = strings.ToLower(key)
+		if reservedKey(key) {
+			continue
+		}
+		for _, v := range vv {
+			kv = append(kv, key, v)
+		}
+	}
+	if len(kv) == 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+// reservedKey reports whether key is set only by the handlers, not by the forwarded headers:
+// the credentials, the OnBehalfOfKey and the client certificate identity.
+func reservedKey(key string) bool {
+	return key == "authorization" || key == OnBehalfOfKey || strings.HasPrefix(key, "x-client-cert-")
+}
+
+// Metadata keys of the client certificate identity.
+const (
+	ClientCertSubjectKey = "x-client-cert-subject"
+	ClientCertSANKey     = "x-client-cert-san"
+)
+
+// CertIdentity is the identity of the verified client certificate.
+type CertIdentity struct {
+	Subject string
+	// SANs are the DNS names, email addresses, URIs and IP addresses of the certificate.
+	SANs []string
+}
+
+// ClientCertIdentity returns the identity of the verified client certificate of the (mTLS) request.
+//
+// Unverified certificates (tls.RequestClientCert, tls.RequireAnyClientCert) are ignored.
+func ClientCertIdentity(r *http.Request) (CertIdentity, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return CertIdentity{}, false
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	id := CertIdentity{Subject: cert.Subject.String()}
+	id.SANs = append(append(id.SANs, cert.DNSNames...), cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		id.SANs = append(id.SANs, u.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		id.SANs = append(id.SANs, ip.String())
+	}
+	return id, true
+}
+
+// forwardClientCert appends the client certificate identity of r to the outgoing metadata.
+func forwardClientCert(ctx context.Context, r *http.Request) context.Context {
+	id, ok := ClientCertIdentity(r)
+	if !ok {
+		return ctx
+	}
+	kv := append(make([]string, 0, 2+2*len(id.SANs)), ClientCertSubjectKey, id.Subject)
+	for _, san := range id.SANs {
+		kv = append(kv, ClientCertSANKey, san)
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+// OnBehalfOfHeader is the HTTP header of the effective user, forwarded as OnBehalfOfKey metadata.
+const (
+	OnBehalfOfHeader = "X-On-Behalf-Of"
+	OnBehalfOfKey    = "x-on-behalf-of"
+)
+
+// ErrImpersonationDenied is returned when the caller is not allowed to act on behalf of another user.
+var ErrImpersonationDenied = errors.New("acting on behalf of another user is not allowed")
+
+// onBehalfOf checks the OnBehalfOfHeader of r with allow, and appends it to the outgoing metadata.
+//
+// Without allow, impersonation is denied.
+func onBehalfOf(ctx context.Context, r *http.Request, allow func(*http.Request, string) error, Log func(...interface{}) error) (context.Context, error) {
+	user := r.Header.Get(OnBehalfOfHeader)
+	if user == "" {
+		return ctx, nil
+	}
+	caller, _, _ := r.BasicAuth()
+	if id, ok := ClientCertIdentity(r); ok && caller == "" {
+		caller = id.Subject
+	}
+	err := ErrImpersonationDenied
+	if allow != nil {
+		if err = allow(r, user); err != nil {
+			err = fmt.Errorf("%w: %v", ErrImpersonationDenied, err)
+		}
+	}
+	Log("audit", "onBehalfOf", "caller", caller, "user", user, "path", r.URL.Path, "error", err)
+	if err != nil {
+		return ctx, err
+	}
+	return metadata.AppendToOutgoingContext(ctx, OnBehalfOfKey, user), nil
+}
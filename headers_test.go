@@ -0,0 +1,187 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestPreferredLanguage(t *testing.T) {
+	for accept, want := range map[string]string{
+		"":                            "",
+		"hu":                          "hu",
+		"en-US;q=0.8, hu-HU, *;q=0.1": "hu-HU",
+		"de;q=0.5,fr;q=0.7":           "fr",
+	} {
+		if got := preferredLanguage(accept); got != want {
+			t.Errorf("%q: got %q, wanted %q", accept, got, want)
+		}
+	}
+}
+
+func TestSetInputField(t *testing.T) {
+	type meta struct{ Lang string }
+	var input struct {
+		Locale string
+		Meta   *meta
+		Other  int
+	}
+	if !setInputField(&input, "locale", "hu") || input.Locale != "hu" {
+		t.Errorf("Locale: %+v", input)
+	}
+	if setInputField(&input, "Locale", "en") || input.Locale != "hu" {
+		t.Errorf("Locale overwritten: %+v", input)
+	}
+	if !setInputField(&input, "Meta.Lang", "hu") || input.Meta == nil || input.Meta.Lang != "hu" {
+		t.Errorf("Meta.Lang: %+v", input)
+	}
+	if setInputField(&input, "Other", "hu") || setInputField(&input, "Missing", "hu") {
+		t.Errorf("set non-string or missing field")
+	}
+	m := map[string]interface{}{"lang": ""}
+	if !setInputField(&m, "Lang", "hu") || m["lang"] != "hu" {
+		t.Errorf("map: %v", m)
+	}
+}
+
+func TestJSONHandlerLocale(t *testing.T) {
+	var gotMD metadata.MD
+	var gotInput interface{}
+	h := JSONHandler{
+		Client: fakeClient{"Get": func(ctx context.Context, input interface{}) (Receiver, error) {
+			gotMD, _ = metadata.FromOutgoingContext(ctx)
+			gotInput = input
+			return &receiver{parts: []interface{}{1}}, nil
+		}},
+		LocaleHeader: "Accept-Language",
+		LocaleField:  "lang",
+	}
+	r := httptest.NewRequest("POST", "/Get", strings.NewReader(`{"a":1}`))
+	r.Header.Set("Accept-Language", "en;q=0.5, hu")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if got := gotMD.Get(LocaleMetadataKey); len(got) != 1 || got[0] != "en;q=0.5, hu" {
+		t.Errorf("metadata: %v", gotMD)
+	}
+	if m := *(gotInput.(*map[string]interface{})); m["lang"] != "hu" {
+		t.Errorf("input: %v", m)
+	}
+}
+
+func TestForwardHeaders(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", nil)
+	r.Header.Set("X-Correlation-Id", "abc")
+	r.Header.Add("X-Feature", "a")
+	r.Header.Add("X-Feature", "b")
+	r.Header.Set("Cookie", "secret")
+	r.Header.Set("Authorization", "Bearer forged")
+	r.Header.Set(OnBehalfOfHeader, "admin")
+	r.Header.Set("X-Subject", "CN=admin")
+	ctx := forwardHeaders(context.Background(), r, map[string]string{
+		"X-Correlation-ID": "",
+		"X-Feature":        "feature-flags",
+		"X-Missing":        "",
+		// reserved
+		"Authorization":  "",
+		OnBehalfOfHeader: "",
+		"X-Subject":      ClientCertSubjectKey,
+	})
+	md, _ := metadata.FromOutgoingContext(ctx)
+	want := metadata.Pairs("x-correlation-id", "abc", "feature-flags", "a", "feature-flags", "b")
+	if len(md) != len(want) {
+		t.Errorf("got %v, wanted %v", md, want)
+	}
+	for k, vv := range want {
+		if got := strings.Join(md[k], ","); got != strings.Join(vv, ",") {
+			t.Errorf("%s: got %q, wanted %q", k, got, vv)
+		}
+	}
+}
+
+func TestForwardClientCert(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", nil)
+	if ctx := forwardClientCert(context.Background(), r); ctx != context.Background() {
+		t.Error("forwarded without TLS")
+	}
+	u, _ := url.Parse("spiffe://partner/a")
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "partner-a", Organization: []string{"Partner"}},
+		DNSNames: []string{"a.partner.example"},
+		URIs:     []*url.URL{u},
+	}
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	if ctx := forwardClientCert(context.Background(), r); ctx != context.Background() {
+		t.Error("forwarded an unverified certificate")
+	}
+	r.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	md, _ := metadata.FromOutgoingContext(forwardClientCert(context.Background(), r))
+	if got := md.Get(ClientCertSubjectKey); len(got) != 1 || got[0] != "CN=partner-a,O=Partner" {
+		t.Errorf("subject: %v", got)
+	}
+	if got := strings.Join(md.Get(ClientCertSANKey), " "); got != "a.partner.example spiffe://partner/a" {
+		t.Errorf("SANs: %q", got)
+	}
+}
+
+func TestOnBehalfOf(t *testing.T) {
+	var audit []interface{}
+	Log := func(keyvals ...interface{}) error { audit = keyvals; return nil }
+	allow := func(r *http.Request, user string) error {
+		if u, _, _ := r.BasicAuth(); u != "backoffice" {
+			return errors.New("not privileged")
+		}
+		return nil
+	}
+	for tN, tC := range map[string]struct {
+		Caller string
+		Allow  func(*http.Request, string) error
+		Err    bool
+	}{
+		"noAllow":    {Caller: "backoffice", Err: true},
+		"privileged": {Caller: "backoffice", Allow: allow},
+		"denied":     {Caller: "partner", Allow: allow, Err: true},
+	} {
+		audit = nil
+		r := httptest.NewRequest("POST", "/Get", nil)
+		r.SetBasicAuth(tC.Caller, "pw")
+		r.Header.Set(OnBehalfOfHeader, "customer-1")
+		ctx, err := onBehalfOf(context.Background(), r, tC.Allow, Log)
+		if (err != nil) != tC.Err {
+			t.Errorf("%s: got %v", tN, err)
+			continue
+		}
+		if err != nil && !errors.Is(err, ErrImpersonationDenied) {
+			t.Errorf("%s: got %v, wanted %v", tN, err, ErrImpersonationDenied)
+		}
+		if len(audit) == 0 {
+			t.Errorf("%s: not audited", tN)
+		}
+		md, _ := metadata.FromOutgoingContext(ctx)
+		if got := md.Get(OnBehalfOfKey); !tC.Err && (len(got) != 1 || got[0] != "customer-1") {
+			t.Errorf("%s: got %v", tN, got)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// UnknownKeysError lists the keys of the input which do not match any field.
+type UnknownKeysError struct {
+	Keys []string
+}
+
+func (e *UnknownKeysError) Error() string {
+	return "unknown keys: " + strings.Join(e.Keys, ", ")
+}
+
+// FillInput fills dst (a pointer to an input struct) from src,
+// converting snake_case keys to CamelCase and the values weakly to the field's type,
+// recursing into nested messages.
+//
+// Empty strings are skipped.
+// Unknown keys are returned in an *UnknownKeysError, after filling all the known ones.
+func FillInput(dst interface{}, src map[string]interface{}) error {
+	var md mapstructure.Metadata
+	dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToTimeHookFunc(time.RFC3339),
+		),
+		WeaklyTypedInput: true,
+		Metadata:         &md,
+		Result:           dst,
+	})
+	if err != nil {
+		return fmt.Errorf("%T: %w", dst, err)
+	}
+	if err = dec.Decode(camelCaseKeys(src)); err != nil {
+		return fmt.Errorf("decode %#v: %w", src, err)
+	}
+	if len(md.Unused) == 0 {
+		return nil
+	}
+	sort.Strings(md.Unused)
+	return &UnknownKeysError{Keys: md.Unused}
+}
+
+// fillInputLenient fills the input as FillInput, but logs the unknown keys instead of failing.
+func fillInputLenient(dst interface{}, src map[string]interface{}, Log func(...interface{}) error) error {
+	err := FillInput(dst, src)
+	var uke *UnknownKeysError
+	if errors.As(err, &uke) {
+		Log("msg", "unknown keys", "keys", uke.Keys)
+		return nil
+	}
+	return err
+}
+
+// fillInputValuesLenient fills the input as FillInputValues, but logs the unknown keys instead of failing.
+func fillInputValuesLenient(dst interface{}, values url.Values, Log func(...interface{}) error) error {
+	m, err := unflatten(values)
+	if err != nil {
+		return err
+	}
+	return fillInputLenient(dst, m, Log)
+}
+
+// camelCaseKeys returns a copy of m with the snake_case keys converted to CamelCase,
+// and without the empty string values, recursively.
+func camelCaseKeys(m map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if s, ok := v.(string); ok && s == "" {
+			continue
+		}
+		if f, _ := utf8.DecodeRuneInString(k); unicode.IsLower(f) {
+			k = CamelCase(k)
+		}
+		res[k] = camelCaseValue(v)
+	}
+	return res
+}
+
+func camelCaseValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		return camelCaseKeys(x)
+	case []interface{}:
+		y := make([]interface{}, len(x))
+		for i, v := range x {
+			y[i] = camelCaseValue(v)
+		}
+		return y
+	default:
+		return v
+	}
+}
+
+// unknownPaths returns the dotted paths of the keys of v (a decoded JSON value),
+// which match no field of typ (as by jsoniter: by the json tag or the field name, case insensitively).
+func unknownPaths(typ reflect.Type, v interface{}, prefix string) []string {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	var paths []string
+	switch x := v.(type) {
+	case map[string]interface{}:
+		switch typ.Kind() {
+		case reflect.Struct:
+			fields := make(map[string]reflect.Type)
+			jsonFields(typ, fields)
+			keys := make([]string, 0, len(x))
+			for k := range x {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				ft, ok := fields[strings.ToLower(k)]
+				if !ok {
+					paths = append(paths, prefix+k)
+					continue
+				}
+				paths = append(paths, unknownPaths(ft, x[k], prefix+k+".")...)
+			}
+		case reflect.Map:
+			for k, e := range x {
+				paths = append(paths, unknownPaths(typ.Elem(), e, prefix+k+".")...)
+			}
+			sort.Strings(paths)
+		}
+	case []interface{}:
+		if typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array {
+			p := strings.TrimSuffix(prefix, ".")
+			for i, e := range x {
+				paths = append(paths, unknownPaths(typ.Elem(), e, fmt.Sprintf("%s[%d].", p, i))...)
+			}
+		}
+	}
+	return paths
+}
+
+// jsonFields collects the lowercased JSON names of the exported fields of the struct typ,
+// including the embedded structs' fields.
+func jsonFields(typ reflect.Type, fields map[string]reflect.Type) {
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				jsonFields(ft, fields)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[strings.ToLower(name)] = f.Type
+	}
+}
+
+// AmbiguousKeyError is returned when an input key matches more than one field,
+// or more keys match the same field.
+type AmbiguousKeyError struct {
+	Path string
+	Keys []string
+}
+
+func (e *AmbiguousKeyError) Error() string {
+	return "ambiguous keys at " + e.Path + ": " + strings.Join(e.Keys, ", ")
+}
+
+type keyField struct {
+	Key  string
+	Type reflect.Type
+}
+
+// normalizeKey lowercases k and removes the underscores.
+func normalizeKey(k string) string {
+	return strings.ToLower(strings.ReplaceAll(k, "_", ""))
+}
+
+// keyFields returns the JSON key and type of the exported fields of the struct typ,
+// by the normalized proto name, json_name and Go field name.
+func keyFields(typ reflect.Type, fields map[string][]keyField) {
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		key, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if key == "-" {
+			continue
+		}
+		if f.Anonymous && key == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				keyFields(ft, fields)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if key == "" {
+			key = f.Name
+		}
+		names := []string{key, f.Name}
+		for _, p := range strings.Split(f.Tag.Get("protobuf"), ",") {
+			if strings.HasPrefix(p, "name=") || strings.HasPrefix(p, "json=") {
+				names = append(names, p[5:])
+			}
+		}
+		kf := keyField{Key: key, Type: f.Type}
+	Names:
+		for _, nm := range names {
+			nm = normalizeKey(nm)
+			for _, x := range fields[nm] {
+				if x.Key == key {
+					continue Names
+				}
+			}
+			fields[nm] = append(fields[nm], kf)
+		}
+	}
+}
+
+// resolveKeys renames the keys of v (a decoded JSON value) to the JSON keys of typ's fields,
+// matching the proto names, json_names and Go field names regardless of case and underscores.
+//
+// Returns an *AmbiguousKeyError if a key matches more fields, or more keys match the same field.
+func resolveKeys(typ reflect.Type, v interface{}, prefix string) error {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch x := v.(type) {
+	case map[string]interface{}:
+		switch typ.Kind() {
+		case reflect.Struct:
+			fields := make(map[string][]keyField)
+			keyFields(typ, fields)
+			keys := make([]string, 0, len(x))
+			for k := range x {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			seen := make(map[string]string, len(keys))
+			resolved := make(map[string]interface{}, len(x))
+			for _, k := range keys {
+				cands := fields[normalizeKey(k)]
+				if len(cands) == 0 {
+					resolved[k] = x[k]
+					continue
+				}
+				kf := cands[0]
+				if len(cands) > 1 {
+					var found bool
+					for _, c := range cands {
+						if found = c.Key == k; found {
+							kf = c
+							break
+						}
+					}
+					if !found {
+						names := make([]string, len(cands))
+						for i, c := range cands {
+							names[i] = c.Key
+						}
+						return &AmbiguousKeyError{Path: prefix + k, Keys: names}
+					}
+				}
+				if prev, ok := seen[kf.Key]; ok {
+					return &AmbiguousKeyError{Path: prefix + kf.Key, Keys: []string{prev, k}}
+				}
+				seen[kf.Key] = k
+				if err := resolveKeys(kf.Type, x[k], prefix+kf.Key+"."); err != nil {
+					return err
+				}
+				resolved[kf.Key] = x[k]
+			}
+			for k := range x {
+				delete(x, k)
+			}
+			for k, e := range resolved {
+				x[k] = e
+			}
+		case reflect.Map:
+			for k, e := range x {
+				if err := resolveKeys(typ.Elem(), e, prefix+k+"."); err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		if typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array {
+			p := strings.TrimSuffix(prefix, ".")
+			for i, e := range x {
+				if err := resolveKeys(typ.Elem(), e, fmt.Sprintf("%s[%d].", p, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
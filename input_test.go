@@ -0,0 +1,228 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testItem struct {
+	Id   int64
+	Name string
+}
+type testInput struct {
+	CustomerId int32
+	Active     bool
+	Items      []*testItem
+	Main       *testItem
+}
+
+func TestFillInput(t *testing.T) {
+	var inp testInput
+	err := FillInput(&inp, map[string]interface{}{
+		"customer_id": "42",
+		"Active":      "true",
+		"items":       []interface{}{map[string]interface{}{"id": 1, "name": "a"}},
+		"main":        map[string]interface{}{"id": "2", "nmae": "b", "name": ""},
+		"unknown":     1,
+	})
+	var uke *UnknownKeysError
+	if !errors.As(err, &uke) {
+		t.Fatalf("wanted UnknownKeysError, got %+v", err)
+	}
+	if want := []string{"Main.Nmae", "Unknown"}; !reflect.DeepEqual(uke.Keys, want) {
+		t.Errorf("got unknown keys %q, wanted %q", uke.Keys, want)
+	}
+	want := testInput{CustomerId: 42, Active: true,
+		Items: []*testItem{{Id: 1, Name: "a"}},
+		Main:  &testItem{Id: 2},
+	}
+	if !reflect.DeepEqual(inp, want) {
+		t.Errorf("got %+v, wanted %+v", inp, want)
+	}
+}
+
+func TestFillInputValues(t *testing.T) {
+	var inp testInput
+	values, err := url.ParseQuery("customer_id=7&items.1.id=2&items[0][name]=a&main.name=m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := FillInputValues(&inp, values); err != nil {
+		t.Fatal(err)
+	}
+	want := testInput{CustomerId: 7,
+		Items: []*testItem{{Name: "a"}, {Id: 2}},
+		Main:  &testItem{Name: "m"},
+	}
+	if !reflect.DeepEqual(inp, want) {
+		t.Errorf("got %+v, wanted %+v", inp, want)
+	}
+
+	if err := FillInputValues(&inp, url.Values{"main": {"x"}, "main.id": {"1"}}); err == nil {
+		t.Error("wanted error for value and parent")
+	}
+}
+
+func TestDecodeJSONBytesStrict(t *testing.T) {
+	const body = `{"customerId":1,"Unknown":2,"items":[{"id":1},{"nmae":"b"}],"main":{"name":"m","x":{}}}`
+	noLog := func(...interface{}) error { return nil }
+	var inp testInput
+	err := decodeJSONBytes(&inp, []byte(body), true, SpecialFloatsReject, noLog)
+	var uke *UnknownKeysError
+	if !errors.As(err, &uke) {
+		t.Fatalf("wanted UnknownKeysError, got %+v", err)
+	}
+	if want := []string{"Items[1].nmae", "Main.x", "Unknown"}; !reflect.DeepEqual(uke.Keys, want) {
+		t.Errorf("got unknown keys %q, wanted %q", uke.Keys, want)
+	}
+
+	inp = testInput{}
+	if err = decodeJSONBytes(&inp, []byte(body), false, SpecialFloatsReject, noLog); err != nil {
+		t.Fatal(err)
+	}
+	if inp.CustomerId != 1 || len(inp.Items) != 2 || inp.Main.Name != "m" {
+		t.Errorf("got %+v", inp)
+	}
+}
+
+type testProtoInput struct {
+	CustomerId int64       `protobuf:"varint,1,opt,name=customer_id,json=customerId,proto3" json:"customer_id,omitempty"`
+	MainItem   *testItem   `protobuf:"bytes,2,opt,name=main_item,json=mainItem,proto3" json:"main_item,omitempty"`
+	Items      []*testItem `protobuf:"bytes,3,rep,name=items,proto3" json:"items,omitempty"`
+}
+
+func TestDecodeJSONBytesKeys(t *testing.T) {
+	noLog := func(...interface{}) error { return nil }
+	for _, body := range []string{
+		`{"customer_id":9007199254740993,"main_item":{"Id":1},"items":[{"name":"a"}]}`,
+		`{"customerId":9007199254740993,"mainItem":{"id":1},"Items":[{"Name":"a"}]}`,
+		`{"CustomerId":9007199254740993,"MainItem":{"ID":1},"items":[{"NAME":"a"}]}`,
+	} {
+		var inp testProtoInput
+		if err := decodeJSONBytes(&inp, []byte(body), true, SpecialFloatsReject, noLog); err != nil {
+			t.Errorf("%s: %+v", body, err)
+			continue
+		}
+		want := testProtoInput{CustomerId: 9007199254740993, MainItem: &testItem{Id: 1}, Items: []*testItem{{Name: "a"}}}
+		if !reflect.DeepEqual(inp, want) {
+			t.Errorf("%s: got %+v", body, inp)
+		}
+	}
+
+	var inp testProtoInput
+	err := decodeJSONBytes(&inp, []byte(`{"customer_id":1,"customerId":2}`), false, SpecialFloatsReject, noLog)
+	var ake *AmbiguousKeyError
+	if !errors.As(err, &ake) {
+		t.Errorf("wanted AmbiguousKeyError, got %+v", err)
+	}
+	// the direct decoding stops at the first unknown key, the fallback starts afresh
+	var ti testInput
+	if err := decodeJSONBytes(&ti, []byte(`{"items":[{"id":1}],"customer_id":"42"}`), true, SpecialFloatsReject, noLog); err != nil {
+		t.Fatal(err)
+	}
+	if ti.CustomerId != 42 || len(ti.Items) != 1 {
+		t.Errorf("got %+v", ti)
+	}
+}
+
+func TestDecodeJSONInputStream(t *testing.T) {
+	var inp testInput
+	if err := decodeJSONInputStream(&inp, strings.NewReader(`{"customerId":1,"items":[{"id":2}]}`), true, SpecialFloatsReject); err != nil || inp.CustomerId != 1 || len(inp.Items) != 1 {
+		t.Errorf("got %+v (%+v)", inp, err)
+	}
+	if err := decodeJSONInputStream(&inp, strings.NewReader(`{"unknown":1}`), true, SpecialFloatsReject); err == nil {
+		t.Error("strict accepted unknown field")
+	}
+	// the strict and lax modes and the key names as in TestDecodeJSONBytesStrict and TestDecodeJSONBytesKeys
+	const strictBody = `{"customerId":1,"Unknown":2,"items":[{"id":1},{"nmae":"b"}],"main":{"name":"m","x":{}}}`
+	inp = testInput{}
+	if err := decodeJSONInputStream(&inp, strings.NewReader(strictBody), true, SpecialFloatsReject); err == nil {
+		t.Error("strict accepted unknown nested fields")
+	}
+	inp = testInput{}
+	if err := decodeJSONInputStream(&inp, strings.NewReader(strictBody), false, SpecialFloatsReject); err != nil {
+		t.Fatal(err)
+	}
+	if inp.CustomerId != 1 || len(inp.Items) != 2 || inp.Main.Name != "m" {
+		t.Errorf("got %+v", inp)
+	}
+	for _, body := range []string{
+		`{"customer_id":9007199254740993,"main_item":{"Id":1},"items":[{"name":"a"}]}`,
+		`{"customerId":9007199254740993,"mainItem":{"id":1},"Items":[{"Name":"a"}]}`,
+		`{"CustomerId":9007199254740993,"MainItem":{"ID":1},"items":[{"NAME":"a"}]}`,
+	} {
+		var pinp testProtoInput
+		if err := decodeJSONInputStream(&pinp, strings.NewReader(body), true, SpecialFloatsReject); err != nil {
+			t.Errorf("%s: %+v", body, err)
+			continue
+		}
+		want := testProtoInput{CustomerId: 9007199254740993, MainItem: &testItem{Id: 1}, Items: []*testItem{{Name: "a"}}}
+		if !reflect.DeepEqual(pinp, want) {
+			t.Errorf("%s: got %+v", body, pinp)
+		}
+	}
+	// the keys are matched as by resolveKeys
+	var pinp testProtoInput
+	if err := decodeJSONInputStream(&pinp, strings.NewReader(`{"customerId":1,"MainItem":{"id":2},"ITEMS":[{}]}`), true, SpecialFloatsReject); err != nil || pinp.CustomerId != 1 || pinp.MainItem == nil || pinp.MainItem.Id != 2 || len(pinp.Items) != 1 {
+		t.Errorf("got %+v (%+v)", pinp, err)
+	}
+
+	var got interface{}
+	h := JSONHandler{
+		Client: fakeClient{"Get": func(ctx context.Context, input interface{}) (Receiver, error) {
+			got = *(input.(*map[string]interface{}))
+			return &receiver{parts: []interface{}{1}}, nil
+		}},
+		StreamInputSize: 10,
+	}
+	body := `{"items":["` + strings.Repeat("x", 100) + `"]}`
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/Get", strings.NewReader(body)))
+	if m, _ := got.(map[string]interface{}); w.Code != 200 || len(m["items"].([]interface{})) != 1 {
+		t.Errorf("got %d %v", w.Code, got)
+	}
+
+	// decided by the decompressed size
+	var streamed bool
+	h.Log = func(keyvals ...interface{}) error {
+		streamed = streamed || len(keyvals) == 2 && keyvals[1] == "streaming input decode"
+		return nil
+	}
+	h.StreamInputSize = 64
+	var zbuf bytes.Buffer
+	zw := gzip.NewWriter(&zbuf)
+	zw.Write([]byte(body))
+	zw.Close()
+	if zbuf.Len() > 64 {
+		t.Fatalf("compressed body is %d bytes", zbuf.Len())
+	}
+	r := httptest.NewRequest("POST", "/Get", &zbuf)
+	r.Header.Set("Content-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if !streamed || w.Code != 200 {
+		t.Errorf("got %d, streamed=%t", w.Code, streamed)
+	}
+}
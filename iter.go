@@ -0,0 +1,137 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"iter"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// All returns an iterator over the parts of r:
+//
+//	for part, err := range grpcer.All(recv) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// io.EOF ends the iteration, any other error is yielded (once) as the last element.
+func All(r Receiver) iter.Seq2[interface{}, error] {
+	return func(yield func(interface{}, error) bool) {
+		for {
+			part, err := r.Recv()
+			if err != nil {
+				if err != io.EOF {
+					yield(part, err)
+				}
+				return
+			}
+			if !yield(part, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Chan pumps the parts of r into the returned channel, which has the given buffer size.
+//
+// The parts channel is closed at the end of the stream, or when ctx is canceled
+// (which closes r, to unblock a pending Recv);
+// the error channel then receives the error (if any, other than io.EOF) and is closed.
+// A slow consumer blocks the Recv-ing of the next part.
+func Chan(ctx context.Context, r Receiver, buffer int) (<-chan interface{}, <-chan error) {
+	partCh := make(chan interface{}, buffer)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(errCh)
+		defer close(partCh)
+		stop := context.AfterFunc(ctx, func() { CloseReceiver(r) })
+		defer stop()
+		for {
+			part, err := r.Recv()
+			if err != nil {
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					errCh <- ctxErr
+				} else if err != io.EOF {
+					errCh <- err
+				}
+				return
+			}
+			select {
+			case partCh <- part:
+			case <-ctx.Done():
+				errCh <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return partCh, errCh
+}
+
+// Peek reads the first part of r, and returns it with a Receiver
+// which returns that part again, then the rest of r.
+//
+// This allows deciding the response status before committing to it.
+// An error (io.EOF for an empty stream) is returned as is.
+func Peek(r Receiver) (first interface{}, rest Receiver, err error) {
+	if first, err = r.Recv(); err != nil {
+		return first, r, err
+	}
+	pr := &prefixedReceiver{parts: []interface{}{first}, rest: r}
+	if md, ok := r.(ReceiverWithMetadata); ok {
+		return first, peekedMetadataReceiver{prefixedReceiver: pr, md: md}, nil
+	}
+	return first, pr, nil
+}
+
+// peekedMetadataReceiver keeps the metadata of the peeked Receiver.
+type peekedMetadataReceiver struct {
+	*prefixedReceiver
+	md ReceiverWithMetadata
+}
+
+func (r peekedMetadataReceiver) Header() (metadata.MD, error) { return r.md.Header() }
+func (r peekedMetadataReceiver) Trailer() metadata.MD         { return r.md.Trailer() }
+
+// TypedReceiver is a Receiver of T parts.
+type TypedReceiver[T any] struct {
+	r Receiver
+}
+
+// Typed returns a TypedReceiver of r, which checks the type of each part.
+func Typed[T any](r Receiver) TypedReceiver[T] { return TypedReceiver[T]{r: r} }
+
+// Recv the next part, returning an error if it is not a T.
+func (tr TypedReceiver[T]) Recv() (T, error) {
+	var zero T
+	part, err := tr.r.Recv()
+	if err != nil || part == nil {
+		return zero, err
+	}
+	t, ok := part.(T)
+	if !ok {
+		return zero, fmt.Errorf("got part of type %T, wanted %T", part, zero)
+	}
+	return t, nil
+}
+
+// Close the underlying Receiver.
+func (tr TypedReceiver[T]) Close() error { return CloseReceiver(tr.r) }
@@ -0,0 +1,127 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestAll(t *testing.T) {
+	var got []interface{}
+	for part, err := range All(&receiver{parts: []interface{}{1, 2, 3}}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, part)
+		if len(got) == 2 {
+			break
+		}
+	}
+	if len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("got %v", got)
+	}
+
+	errBoom := errors.New("boom")
+	var n int
+	var gotErr error
+	for _, err := range All(&sliceReceiver{parts: []interface{}{1}}) {
+		n++
+		gotErr = err
+	}
+	if n != 1 || gotErr != nil {
+		t.Errorf("got %d parts, error %v", n, gotErr)
+	}
+	for _, err := range All(&prefixedReceiver{parts: []interface{}{1}, err: errBoom}) {
+		gotErr = err
+	}
+	if gotErr != errBoom {
+		t.Errorf("got %v, wanted %v", gotErr, errBoom)
+	}
+}
+
+func TestChan(t *testing.T) {
+	partCh, errCh := Chan(context.Background(), &receiver{parts: []interface{}{1, 2, 3}}, 1)
+	var n int
+	for range partCh {
+		n++
+	}
+	if err := <-errCh; err != nil || n != 3 {
+		t.Errorf("got %d parts, error %v", n, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	partCh, errCh = Chan(ctx, &receiver{parts: []interface{}{1, 2, 3}}, 0)
+	<-partCh
+	cancel()
+	for range partCh {
+	}
+	if err := <-errCh; err != nil && err != context.Canceled {
+		t.Errorf("got %v, wanted %v", err, context.Canceled)
+	}
+
+	// the cancelation unblocks a pending Recv
+	ctx, cancel = context.WithCancel(context.Background())
+	_, errCh = Chan(ctx, &hangingReceiver{closed: make(chan struct{})}, 0)
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != context.Canceled {
+			t.Errorf("got %v, wanted %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Recv not unblocked by the cancelation")
+	}
+}
+
+func TestPeek(t *testing.T) {
+	first, rest, err := Peek(&receiver{parts: []interface{}{1, 2}})
+	if err != nil || first != 1 {
+		t.Fatalf("got %v, %v", first, err)
+	}
+	var got []interface{}
+	for part, err := range All(rest) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, part)
+	}
+	if len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("got %v", got)
+	}
+
+	if _, _, err = Peek(&receiver{}); err != io.EOF {
+		t.Errorf("got %v, wanted io.EOF", err)
+	}
+}
+
+func TestTyped(t *testing.T) {
+	tr := Typed[int](&receiver{parts: []interface{}{1, "two"}})
+	if i, err := tr.Recv(); err != nil || i != 1 {
+		t.Errorf("got %d, %v", i, err)
+	}
+	if _, err := tr.Recv(); err == nil {
+		t.Error("wanted type mismatch error")
+	} else {
+		t.Log(err)
+	}
+	if _, err := tr.Recv(); err != io.EOF {
+		t.Errorf("got %v, wanted io.EOF", err)
+	}
+}
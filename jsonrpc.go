@@ -22,20 +22,22 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"path"
 	"reflect"
 	"strings"
 	"sync"
 	"time"
 	"unicode"
-	"unicode/utf8"
 	"unsafe"
 
 	jsoniter "github.com/json-iterator/go"
 	"github.com/json-iterator/go/extra"
-	"github.com/mitchellh/mapstructure"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
 )
 
 var DefaultTimeout = 5 * time.Minute
@@ -46,6 +48,89 @@ type JSONHandler struct {
 	MergeStreams bool
 	Log          func(...interface{}) error
 	Timeout      time.Duration
+	// ResponseCompressionLevel is the gzip level of the responses, if the client accepts gzip.
+	// Zero means no compression.
+	ResponseCompressionLevel int
+	// ResponseCompressionThreshold is the size below which the responses are not compressed.
+	ResponseCompressionThreshold int
+	// TimeoutMargin is subtracted from the incoming deadline (the RequestTimeoutHeader,
+	// or the server's WriteTimeout), DefaultTimeoutMargin if zero.
+	// The incoming deadline may only shorten the Timeout and the Timeouts.
+	TimeoutMargin time.Duration
+	// Timeouts are the per-method overall timeouts, overriding Timeout.
+	Timeouts map[string]time.Duration
+	// IdleTimeout is the maximum wait for the first and between the parts of a stream, unlimited if zero.
+	// On timeout the call is aborted with 504, or with a truncation marker
+	// ({"error": "...", "truncated": true}) if the response is already being sent.
+	IdleTimeout time.Duration
+	// IdleTimeouts are the per-method idle timeouts, overriding IdleTimeout.
+	IdleTimeouts map[string]time.Duration
+	// ForwardHeaders maps the HTTP headers to be forwarded to the outgoing
+	// metadata keys (the lowercased header name if empty).
+	// The authorization, OnBehalfOfKey and x-client-cert-* keys are never forwarded.
+	ForwardHeaders map[string]string
+	// ForwardClientCert forwards the identity of the client certificate
+	// as ClientCertSubjectKey and ClientCertSANKey metadata.
+	ForwardClientCert bool
+	// AllowOnBehalfOf decides whether the caller of the request may act on behalf of user,
+	// given in the OnBehalfOfHeader. If nil, such requests are denied with 403.
+	// The decision is logged with "audit".
+	AllowOnBehalfOf func(r *http.Request, user string) error
+	// LocaleHeader is the HTTP header of the locale (e.g. Accept-Language), which is
+	// forwarded as the LocaleMetadataKey metadata. Nothing is forwarded if empty.
+	LocaleHeader string
+	// LocaleField is the input field set to the preferred language of the LocaleHeader, if empty.
+	LocaleField string
+	// Methods is the allowlist of the callable methods, all are allowed if empty.
+	Methods []string
+	// Reflection serves the descriptions of the allowed methods, with their proto file descriptors,
+	// at the ReflectionName path ("?method=Name" for only one).
+	Reflection bool
+	// StreamInputSize is the (decompressed) body size above which the JSON input is decoded while
+	// reading the body, without buffering and logging it; with the keys matched as by resolveKeys,
+	// but without detecting the ambiguous keys, and strict mode rejecting the first unknown field.
+	// DefaultStreamInputSize if zero, never if negative.
+	StreamInputSize int64
+	// StrictInput rejects the inputs with unknown fields with 400, listing their paths.
+	// By default the unknown fields are discarded.
+	StrictInput bool
+	// GRPCWeb serves the gRPC-Web requests (GRPCWebContentType and GRPCWebTextContentType,
+	// with "+proto" or "+json" messages), too, calling the method named by the last path element,
+	// and answers the CORS preflight (OPTIONS) requests.
+	GRPCWeb bool
+	// GRPCWebOrigins are the allowed Origins of the gRPC-Web requests (besides the same host),
+	// "*" allows all.
+	GRPCWebOrigins []string
+	// WebSocket serves the WebSocket upgrade requests, too: the input is the first message,
+	// each part of the output is sent as a separate text message, and any further message
+	// of the client cancels the call.
+	WebSocket bool
+	// WebSocketOrigins are the allowed Origins of the WebSocket requests (besides the same host),
+	// "*" allows all.
+	WebSocketOrigins []string
+	// Errors renders the call errors, if set.
+	Errors *ErrorRenderer
+	// Quota enforces the API key quotas, and accounts the requests and the streamed bytes.
+	Quota *Quota
+	// Limit is the concurrency limit of the calls (shared by copies of the handler),
+	// a slot is held from reading the request body.
+	Limit *ConcurrencyLimit
+	// MaxBodySize is the limit of the decompressed request body, DefaultMaxBodySize if zero.
+	MaxBodySize int64
+	// Filenames are the download file name templates by method name ("*" for the default),
+	// sent as Content-Disposition. For example "orders_{CustomerID}_{date}.csv",
+	// see renderFilename for the placeholders.
+	Filenames map[string]string
+	// Transforms are applied to the parts of the named methods, which are not merged then,
+	// and the ProtoOnly Formats are rejected for them with 406.
+	Transforms map[string]*Transform
+	// Renames are the output key renames (old to new, at any depth) by method name,
+	// applied after the Transforms, see CompileRenames.
+	// The renamed methods are merged only as JSON.
+	Renames map[string]*Renames
+	// SpecialFloats is the handling of NaN and ±Infinity in the JSON inputs and outputs,
+	// SpecialFloatsReject by default.
+	SpecialFloats SpecialFloats
 }
 
 func jsonError(w http.ResponseWriter, errMsg string, code int) {
@@ -65,106 +150,203 @@ func (h JSONHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if Log == nil {
 		Log = func(...interface{}) error { return nil }
 	}
+	if h.GRPCWeb && isCORSPreflight(r) {
+		h.serveGRPCWebPreflight(w, r)
+		return
+	}
+	grpcWeb := h.GRPCWeb && isGRPCWeb(r)
+	upgrade := h.WebSocket && isWebSocket(r)
+	if h.ResponseCompressionLevel != 0 && !grpcWeb && !upgrade && acceptsGzip(r) {
+		gw := newGzipResponseWriter(w, h.ResponseCompressionLevel, h.ResponseCompressionThreshold)
+		defer gw.Close()
+		w = gw
+	}
+	if q := h.Quota; q != nil {
+		key := q.key(r)
+		if key == "" {
+			jsonError(w, ErrMissingAPIKey.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err := q.Allow(key); err != nil {
+			code := http.StatusTooManyRequests
+			if errors.Is(err, ErrUnknownAPIKey) {
+				code = http.StatusUnauthorized
+			}
+			jsonError(w, err.Error(), code)
+			return
+		}
+		if !upgrade { // the hijacked connection is not counted
+			cw := &countingWriter{ResponseWriter: w}
+			defer func() { q.AddBytes(key, cw.n) }()
+			w = cw
+		}
+	}
 	name := path.Base(r.URL.Path)
 	Log("name", name)
+	if name == ReflectionName && h.Reflection {
+		h.serveReflection(w, r)
+		return
+	}
+	if grpcWeb {
+		h.serveGRPCWeb(w, r, name, Log)
+		return
+	}
+	if upgrade {
+		h.serveWebSocket(w, r, name, Log)
+		return
+	}
+	format, err := negotiateFormat(r)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusNotAcceptable)
+		return
+	}
 	inp := h.Input(name)
-	if inp == nil {
-		jsonError(w, fmt.Sprintf("No unmarshaler for %q.", name), http.StatusNotFound)
+	if inp == nil || !allowedMethod(h.Methods, name) {
+		jsonError(w, notFoundMessage(h.Client, name), http.StatusNotFound)
 		return
 	}
+	if format != nil && format.ProtoOnly && (h.Transforms[name] != nil || h.Renames[name] != nil) {
+		jsonError(w, fmt.Sprintf("the output of %s is transformed, cannot be encoded as %s", name, format.MediaTypes[0]), http.StatusNotAcceptable)
+		return
+	}
+	// the slot is held while reading the body, too
+	release, err := h.Limit.Acquire(r.Context(), name)
+	if err != nil {
+		Log("call", name, "error", err)
+		w.Header().Set("Retry-After", "1")
+		jsonError(w, err.Error(), limitErrorCode(err))
+		return
+	}
+	defer release()
 	buf := bufPool.Get().(*bytes.Buffer)
 	defer func() {
 		buf.Reset()
 		bufPool.Put(buf)
 	}()
 
-	buf.Reset()
-	err := jsoniter.NewDecoder(io.TeeReader(r.Body, buf)).Decode(inp)
-	Log("body", buf.String())
-	if err != nil {
-		err = fmt.Errorf("%s: %w", buf.String(), err)
-		Log("got", buf.String(), "inp", inp, "error", err)
-		m := mapPool.Get().(map[string]interface{})
-		defer func() {
-			for k := range m {
-				delete(m, k)
+	streamSize := h.StreamInputSize
+	if streamSize == 0 {
+		streamSize = DefaultStreamInputSize
+	}
+	if err = decodeBody(w, r, h.MaxBodySize); err != nil {
+		jsonError(w, err.Error(), bodyErrorCode(err))
+		return
+	}
+	if isFormRequest(r) {
+		var values url.Values
+		if values, err = formValues(r); err == nil {
+			Log("form", values)
+			if h.StrictInput {
+				err = FillInputValues(inp, values)
+			} else {
+				err = fillInputValuesLenient(inp, values, Log)
 			}
-			mapPool.Put(m)
-		}()
-		err := jsoniter.NewDecoder(
-			io.MultiReader(bytes.NewReader(buf.Bytes()), r.Body),
-		).Decode(&m)
-		if err != nil {
-			jsonError(w, fmt.Sprintf("decode %s: %s", buf.String(), err), http.StatusBadRequest)
-			return
 		}
+	} else {
+		// decide by the decompressed size: the ContentLength is known only without Content-Encoding
 		buf.Reset()
-
-		// mapstruct
-		for k, v := range m {
-			if s, ok := v.(string); ok && s == "" {
-				delete(m, k)
-				continue
-			}
-			f, _ := utf8.DecodeRune([]byte(k))
-			if unicode.IsLower(f) {
-				m[CamelCase(k)] = v
+		var complete bool
+		if complete, err = readAtMost(buf, r.Body, streamSize); err == nil {
+			if complete {
+				err = decodeJSONBytes(inp, buf.Bytes(), h.StrictInput, h.SpecialFloats, Log)
+			} else {
+				Log("msg", "streaming input decode")
+				err = decodeJSONInputStream(inp, io.MultiReader(bytes.NewReader(buf.Bytes()), r.Body), h.StrictInput, h.SpecialFloats)
 			}
 		}
-		if err := mapstructure.WeakDecode(m, inp); err != nil {
-			jsonError(w, fmt.Sprintf("WeakDecode(%#v): %s", m, err), http.StatusBadRequest)
-			return
-		}
 	}
+	if err != nil {
+		jsonError(w, err.Error(), bodyErrorCode(err))
+		return
+	}
+	ctx, idleTimer, cancel, err := h.callContext(r.Context(), r, name, inp, Log)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	defer cancel()
 	buf.Reset()
-	jenc := jsoniter.NewEncoder(buf)
+	api := jsonConfigOf(h.SpecialFloats).api
+	jenc := api.NewEncoder(buf)
 	_ = jenc.Encode(inp)
-	ctx := r.Context()
 	{
-		u, p, ok := r.BasicAuth()
+		u, _, _ := r.BasicAuth()
 		Log("inp", buf.String(), "username", u)
-		if ok {
-			ctx = WithBasicAuth(ctx, u, p)
-		}
-	}
-	if _, ok := ctx.Deadline(); !ok {
-		timeout := h.Timeout
-		if timeout == 0 {
-			timeout = DefaultTimeout
-		}
-		if timeout > 0 {
-			var cancel context.CancelFunc
-			ctx, cancel = context.WithTimeout(ctx, timeout)
-			defer cancel()
-		}
 	}
 	recv, err := h.Call(name, ctx, inp)
 	if err != nil {
 		Log("call", name, "error", fmt.Sprintf("%#v", err))
+		setRetryAfter(w, err)
+		if h.Errors != nil {
+			h.Errors.Render(w, r, name, err)
+			return
+		}
 		jsonError(w, fmt.Sprintf("Call %s: %s", name, err), statusCodeFromError(err))
 		return
 	}
+	defer CloseReceiver(recv)
+	if idleTimer != nil {
+		recv = &idleReceiver{Receiver: recv, it: idleTimer}
+	}
+	transform := h.Transforms[name]
+	if transform != nil {
+		recv = transformReceiver{Receiver: recv, t: transform}
+	}
+	m := r.URL.Query().Get("merge")
+	merge := transform == nil && (h.MergeStreams && m != "0" || !h.MergeStreams && m == "1")
+	// the JSON merge encoder renames the keys itself
+	renames := h.Renames[name]
+	if renames != nil && (format != nil || !merge) {
+		merge = merge && format == nil
+		recv = renameReceiver{Receiver: recv, renames: renames}
+	}
 
 	part, err := recv.Recv()
 	if err != nil {
 		Log("msg", "recv", "error", err)
+		setRetryAfter(w, err)
+		if h.Errors != nil {
+			h.Errors.Render(w, r, name, err)
+			return
+		}
 		jsonError(w, fmt.Sprintf("recv: %s", err), statusCodeFromError(err))
 		return
 	}
+	tmpl, ok := h.Filenames[name]
+	if !ok {
+		tmpl = h.Filenames["*"]
+	}
+	if tmpl != "" {
+		w.Header().Set("Content-Disposition", contentDisposition(renderFilename(tmpl, name, inp, time.Now())))
+	}
+	if format != nil {
+		w.Header().Set("Content-Type", format.MediaTypes[0])
+		w.WriteHeader(200)
+		if merge && format.newMerger != nil {
+			if err := mergeStreamsConfig(ctx, w, part, recv, mergeConfig{enc: format.newMerger(), Log: Log}); err != nil {
+				Log("mergeStreams", "error", err)
+			}
+			return
+		}
+		if err := encodeParts(format.newEncoder(w, api), part, recv, Log); err != nil {
+			Log("encodeParts", "error", err)
+		}
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
 
-	if m := r.URL.Query().Get("merge"); h.MergeStreams && m != "0" || !h.MergeStreams && m == "1" {
+	if merge {
 		buf.Reset()
 		_ = jenc.Encode(part)
 		Log("part", limitWidth(buf.Bytes(), MaxLogWidth))
-		if err := mergeStreams(w, part, recv, Log); err != nil {
+		if err := mergeStreamsConfig(ctx, w, part, recv, mergeConfig{renames: renames, api: api, Log: Log}); err != nil {
 			Log("mergeStreams", "error", err)
 		}
 		return
 	}
 
-	enc := jsoniter.NewEncoder(w)
+	enc := api.NewEncoder(w)
 	for {
 		buf.Reset()
 		_ = jenc.Encode(part)
@@ -178,17 +360,264 @@ func (h JSONHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			if err != io.EOF {
 				Log("msg", "recv", "error", err)
+				if errors.Is(err, errIdleTimeout) {
+					_ = enc.Encode(struct {
+						Error     string `json:"error"`
+						Truncated bool   `json:"truncated"`
+					}{Error: err.Error(), Truncated: true})
+				}
 			}
 			break
 		}
 	}
 }
 
+// callContext returns the context of calling the named method for r, derived from parent:
+// with the forwarded headers, client certificate identity, on-behalf-of user, locale
+// (set in inp, too) and basic auth, and the configured timeout, shortened by the incoming deadline.
+//
+// The idleTimer is nil without idle timeout. cancel stops it, and must be called.
+// The error is ErrImpersonationDenied.
+func (h JSONHandler) callContext(parent context.Context, r *http.Request, name string, inp interface{}, Log func(...interface{}) error) (
+	ctx context.Context, idle *idleTimer, cancel context.CancelFunc, err error,
+) {
+	ctx = forwardHeaders(parent, r, h.ForwardHeaders)
+	if h.ForwardClientCert {
+		ctx = forwardClientCert(ctx, r)
+	}
+	if ctx, err = onBehalfOf(ctx, r, h.AllowOnBehalfOf, Log); err != nil {
+		return parent, nil, func() {}, err
+	}
+	if h.LocaleHeader != "" {
+		if locale := r.Header.Get(h.LocaleHeader); locale != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, LocaleMetadataKey, locale)
+			if h.LocaleField != "" {
+				setInputField(inp, h.LocaleField, preferredLanguage(locale))
+			}
+		}
+	}
+	if u, p, ok := r.BasicAuth(); ok {
+		ctx = WithBasicAuth(ctx, u, p)
+	}
+
+	timeout, ok := h.Timeouts[name]
+	if !ok {
+		timeout = h.Timeout
+	}
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	margin := h.TimeoutMargin
+	if margin == 0 {
+		margin = DefaultTimeoutMargin
+	}
+	// the incoming deadline may only shorten the configured timeout
+	if d, ok := incomingDeadline(r, margin); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+	if deadline.IsZero() {
+		ctx, cancel = context.WithCancel(ctx)
+	} else {
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+	}
+	d, ok := h.IdleTimeouts[name]
+	if !ok {
+		d = h.IdleTimeout
+	}
+	if d > 0 {
+		idle = newIdleTimer(ctx, d)
+		ctx = idle.ctx
+		stop := cancel
+		cancel = func() { idle.Stop(); stop() }
+	}
+	return ctx, idle, cancel, nil
+}
+
+// jsonConfig is the set of jsoniter APIs of the JSONHandler with a SpecialFloats mode.
+type jsonConfig struct {
+	// api encodes as jsoniter.ConfigDefault.
+	api    jsoniter.API
+	strict jsoniter.API
+	// stream and strictStream match the keys by the names of resolveKeys.
+	stream, strictStream jsoniter.API
+}
+
+// jsonConfigs are the jsonConfigs by SpecialFloats mode.
+var jsonConfigs [SpecialFloatsNull + 1]jsonConfig
+
+func init() {
+	for i := range jsonConfigs {
+		c := &jsonConfigs[i]
+		c.api = jsoniter.Config{EscapeHTML: true}.Froze()
+		c.strict = jsoniter.Config{DisallowUnknownFields: true}.Froze()
+		c.stream = jsoniter.Config{}.Froze()
+		c.strictStream = jsoniter.Config{DisallowUnknownFields: true}.Froze()
+		floats := &specialFloatsExtension{mode: SpecialFloats(i)}
+		for _, api := range []jsoniter.API{c.api, c.strict, c.stream, c.strictStream} {
+			api.RegisterExtension(floats)
+		}
+		c.stream.RegisterExtension(&keyNamesExtension{})
+		c.strictStream.RegisterExtension(&keyNamesExtension{})
+	}
+}
+
+// jsonConfigOf returns the jsonConfig of mode (of SpecialFloatsReject if unknown).
+func jsonConfigOf(mode SpecialFloats) *jsonConfig {
+	if int(mode) >= len(jsonConfigs) {
+		mode = SpecialFloatsReject
+	}
+	return &jsonConfigs[mode]
+}
+
+// keyNamesExtension adds the proto names, json_names and Go field names to the
+// names of the fields, as matched by resolveKeys (case insensitively, by jsoniter).
+//
+// The names matching more fields are not added, and more keys of the same field are not detected.
+type keyNamesExtension struct{ jsoniter.DummyExtension }
+
+func (*keyNamesExtension) UpdateStructDescriptor(sd *jsoniter.StructDescriptor) {
+	fields := make(map[string][]keyField)
+	keyFields(sd.Type.Type1(), fields)
+	for _, b := range sd.Fields {
+		if len(b.FromNames) == 0 {
+			continue
+		}
+		key := b.FromNames[0]
+		names := []string{b.Field.Name()}
+		for _, p := range strings.Split(b.Field.Tag().Get("protobuf"), ",") {
+			if strings.HasPrefix(p, "name=") || strings.HasPrefix(p, "json=") {
+				names = append(names, p[5:])
+			}
+		}
+	Names:
+		for _, nm := range names {
+			if cands := fields[normalizeKey(nm)]; len(cands) != 1 || cands[0].Key != key {
+				continue
+			}
+			for _, x := range b.FromNames {
+				if strings.EqualFold(x, nm) {
+					continue Names
+				}
+			}
+			b.FromNames = append(b.FromNames, nm)
+		}
+	}
+}
+
+// decodeJSONInputStream decodes the JSON body into inp while reading it.
+func decodeJSONInputStream(inp interface{}, body io.Reader, strict bool, floats SpecialFloats) error {
+	if dm, ok := inp.(*dynamicpb.Message); ok {
+		b, err := io.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		return unmarshalDynamicJSON(dm, b, strict)
+	}
+	api := jsonConfigOf(floats).stream
+	if strict {
+		api = jsonConfigOf(floats).strictStream
+	}
+	if err := api.NewDecoder(body).Decode(inp); err != nil {
+		return fmt.Errorf("decode %T: %w", inp, err)
+	}
+	return nil
+}
+
+// numberJSON keeps the numbers as json.Number, to be re-encoded without loss.
+var numberJSON = jsoniter.Config{UseNumber: true}.Froze()
+
+// decodeJSONBytes decodes the JSON b into inp, falling back to FillInput.
+//
+// If all the keys are known by jsoniter, b is decoded directly, otherwise the keys
+// are matched to the fields by resolveKeys.
+// If strict, the unknown fields are returned in an *UnknownKeysError.
+// The float fields are decoded as the floats mode allows.
+func decodeJSONBytes(inp interface{}, b []byte, strict bool, floats SpecialFloats, Log func(...interface{}) error) error {
+	Log("body", string(b))
+	if dm, ok := inp.(*dynamicpb.Message); ok {
+		return unmarshalDynamicJSON(dm, b, strict)
+	}
+	jc := jsonConfigOf(floats)
+	if err := jc.strict.Unmarshal(b, inp); err == nil {
+		return nil
+	}
+	// unknown (or differently named) keys, or weakly typed values
+	resetInput(inp)
+	var v interface{}
+	if err := numberJSON.Unmarshal(b, &v); err == nil {
+		if err = resolveKeys(reflect.TypeOf(inp), v, ""); err != nil {
+			return err
+		}
+		if strict {
+			if paths := unknownPaths(reflect.TypeOf(inp), v, ""); len(paths) != 0 {
+				return &UnknownKeysError{Keys: paths}
+			}
+		}
+		if b, err = numberJSON.Marshal(v); err != nil {
+			return err
+		}
+		if err = jc.api.Unmarshal(b, inp); err == nil {
+			return nil
+		}
+		Log("got", string(b), "inp", inp, "error", err)
+		resetInput(inp)
+	}
+	m := mapPool.Get().(map[string]interface{})
+	defer func() {
+		for k := range m {
+			delete(m, k)
+		}
+		mapPool.Put(m)
+	}()
+	if err := jsoniter.Unmarshal(b, &m); err != nil {
+		return fmt.Errorf("decode %s: %w", b, err)
+	}
+
+	if strict {
+		return FillInput(inp, m)
+	}
+	return fillInputLenient(inp, m, Log)
+}
+
+// resetInput resets inp (a pointer) to its zero value, after a failed decoding.
+func resetInput(inp interface{}) {
+	if pr, ok := inp.(protoReflecter); ok {
+		proto.Reset(pr.ProtoReflect().Interface())
+		return
+	}
+	if rv := reflect.ValueOf(inp); rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+	}
+}
+
+// errorStatus returns the status of err, or of the error it wraps.
+func errorStatus(err error) *status.Status {
+	st, ok := status.FromError(err)
+	if !ok {
+		st = status.Convert(errors.Unwrap(err))
+	}
+	return st
+}
+
 func statusCodeFromError(err error) int {
-	st := status.Convert(errors.Unwrap(err))
+	st := errorStatus(err)
 	switch st.Code() {
 	case codes.PermissionDenied, codes.Unauthenticated:
 		return http.StatusUnauthorized
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
 	case codes.Unknown:
 		if desc := st.Message(); desc == "bad username or password" {
 			return http.StatusUnauthorized
@@ -274,6 +703,8 @@ func SnakeCase(text string) string {
 
 func init() {
 	extra.RegisterFuzzyDecoders()
+	jsoniter.RegisterExtension(&timeFormatExtension{})
+	registerDynamicJSON()
 	SetNoOmit(func(nm string) bool { return strings.HasSuffix(nm, "_Output") })
 }
 func SetNoOmit(filter func(string) bool) {
@@ -0,0 +1,145 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrOverloaded is returned when no call slot is available.
+var ErrOverloaded = errors.New("too many calls in flight")
+
+// ConcurrencyLimit limits the number of in-flight calls of the handlers.
+//
+// It must not be copied after first use.
+type ConcurrencyLimit struct {
+	// Max is the global limit, unlimited if zero.
+	Max int
+	// PerMethod are the limits by method name.
+	PerMethod map[string]int
+	// QueueTimeout is the maximum wait for a free slot.
+	// If zero, the call is rejected immediately.
+	QueueTimeout time.Duration
+
+	mu      sync.Mutex
+	global  chan struct{}
+	methods map[string]chan struct{}
+}
+
+func (cl *ConcurrencyLimit) semaphores(name string) (method, global chan struct{}) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.Max > 0 && cl.global == nil {
+		cl.global = make(chan struct{}, cl.Max)
+	}
+	if n := cl.PerMethod[name]; n > 0 {
+		if method = cl.methods[name]; method == nil {
+			if cl.methods == nil {
+				cl.methods = make(map[string]chan struct{}, len(cl.PerMethod))
+			}
+			method = make(chan struct{}, n)
+			cl.methods[name] = method
+		}
+	}
+	return method, cl.global
+}
+
+// Acquire a slot for the named method, returning the func releasing it.
+//
+// Returns ErrOverloaded if no slot is freed in QueueTimeout.
+func (cl *ConcurrencyLimit) Acquire(ctx context.Context, name string) (release func(), err error) {
+	if cl == nil {
+		return func() {}, nil
+	}
+	method, global := cl.semaphores(name)
+	return cl.acquire(ctx, method, global)
+}
+
+// acquireGlobal acquires a slot of the global limit only, for reading the requests
+// naming the method in their body - see acquireMethod.
+func (cl *ConcurrencyLimit) acquireGlobal(ctx context.Context) (release func(), err error) {
+	if cl == nil {
+		return func() {}, nil
+	}
+	_, global := cl.semaphores("")
+	return cl.acquire(ctx, global)
+}
+
+// acquireMethod acquires a slot of the limit of the named method only, after acquireGlobal.
+func (cl *ConcurrencyLimit) acquireMethod(ctx context.Context, name string) (release func(), err error) {
+	if cl == nil {
+		return func() {}, nil
+	}
+	method, _ := cl.semaphores(name)
+	return cl.acquire(ctx, method)
+}
+
+// acquire the semaphores in order (skipping the nil ones), waiting at most QueueTimeout for all.
+func (cl *ConcurrencyLimit) acquire(ctx context.Context, sems ...chan struct{}) (release func(), err error) {
+	var timeout <-chan time.Time
+	if cl.QueueTimeout > 0 {
+		timer := time.NewTimer(cl.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	acquire := func(sem chan struct{}) error {
+		select {
+		case sem <- struct{}{}:
+			return nil
+		default:
+		}
+		if timeout == nil {
+			return ErrOverloaded
+		}
+		select {
+		case sem <- struct{}{}:
+			return nil
+		case <-timeout:
+			return ErrOverloaded
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	acquired := make([]chan struct{}, 0, len(sems))
+	release = func() {
+		for i := len(acquired) - 1; i >= 0; i-- {
+			<-acquired[i]
+		}
+	}
+	for _, sem := range sems {
+		if sem == nil {
+			continue
+		}
+		if err := acquire(sem); err != nil {
+			release()
+			return nil, err
+		}
+		acquired = append(acquired, sem)
+	}
+	return release, nil
+}
+
+// limitErrorCode returns the HTTP status code for the Acquire error.
+func limitErrorCode(err error) int {
+	if errors.Is(err, ErrOverloaded) {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusServiceUnavailable
+}
@@ -0,0 +1,125 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	ctx := context.Background()
+	cl := &ConcurrencyLimit{Max: 2, PerMethod: map[string]int{"Heavy": 1}}
+	release1, err := cl.Acquire(ctx, "Heavy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cl.Acquire(ctx, "Heavy"); err != ErrOverloaded {
+		t.Errorf("second Heavy: got %v, wanted %v", err, ErrOverloaded)
+	}
+	release2, err := cl.Acquire(ctx, "Light")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cl.Acquire(ctx, "Light"); err != ErrOverloaded {
+		t.Errorf("over global: got %v, wanted %v", err, ErrOverloaded)
+	}
+
+	cl.QueueTimeout = time.Second
+	go func() { time.Sleep(10 * time.Millisecond); release1() }()
+	release3, err := cl.Acquire(ctx, "Heavy")
+	if err != nil {
+		t.Fatalf("queued: %+v", err)
+	}
+	release2()
+	release3()
+
+	var nilLimit *ConcurrencyLimit
+	if release, err := nilLimit.Acquire(ctx, "Any"); err != nil {
+		t.Fatal(err)
+	} else {
+		release()
+	}
+}
+
+func TestJSONHandlerLimit(t *testing.T) {
+	started, done := make(chan struct{}), make(chan struct{})
+	h := JSONHandler{
+		Client: fakeClient{"Slow": func(ctx context.Context, input interface{}) (Receiver, error) {
+			close(started)
+			<-done
+			return &receiver{parts: []interface{}{1}}, nil
+		}},
+		Limit: &ConcurrencyLimit{Max: 1},
+	}
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/Slow", strings.NewReader("{}")))
+	<-started
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/Slow", strings.NewReader("{}")))
+	close(done)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("got %d %v", w.Code, w.Header())
+	}
+
+	// the slot is released after a bad body, too
+	cl := &ConcurrencyLimit{Max: 1}
+	h = JSONHandler{Client: fakeClient{"Get": func(ctx context.Context, input interface{}) (Receiver, error) {
+		return &receiver{parts: []interface{}{1}}, nil
+	}}, Limit: cl}
+	for _, body := range []string{"{", "{}"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/Get", strings.NewReader(body)))
+		if w.Code == http.StatusTooManyRequests {
+			t.Errorf("%q: got %d", body, w.Code)
+		}
+	}
+}
+
+// readFlag records whether the body was read.
+type readFlag struct {
+	io.Reader
+	read bool
+}
+
+func (rf *readFlag) Read(p []byte) (int, error) { rf.read = true; return rf.Reader.Read(p) }
+
+func TestBodyHandlersLimit(t *testing.T) {
+	cl := &ConcurrencyLimit{Max: 1}
+	release, err := cl.Acquire(context.Background(), "Other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	c := fakeClient{"Get": func(ctx context.Context, input interface{}) (Receiver, error) {
+		return &receiver{parts: []interface{}{1}}, nil
+	}}
+	for name, h := range map[string]http.Handler{
+		"xmlrpc": XMLRPCHandler{Client: c, Limit: cl},
+		"soap":   SOAPHandler{Client: c, Limit: cl},
+	} {
+		body := &readFlag{Reader: strings.NewReader("<x/>")}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/", body))
+		if w.Code != http.StatusTooManyRequests || body.read {
+			t.Errorf("%s: got %d, body read: %t", name, w.Code, body.read)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package loadgen drives a grpcer.Client with a configurable load,
+// and collects the latencies.
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ngurban/grpcer"
+	"google.golang.org/grpc/status"
+)
+
+// Config of a load test run.
+type Config struct {
+	// Method is the called method's name.
+	Method string
+	// Input returns the input of the i-th call, the Client's empty Input if nil.
+	Input func(i int) interface{}
+	// RPS is the rate of the calls per second, unlimited if zero.
+	RPS float64
+	// Concurrency is the number of concurrent callers, 1 if zero.
+	Concurrency int
+	// Duration limits the run, unlimited if zero.
+	Duration time.Duration
+	// Requests limits the number of calls, unlimited if zero.
+	Requests int
+}
+
+// Result of a run.
+type Result struct {
+	Requests, Errors, Parts int64
+	// Codes counts the calls by gRPC status code.
+	Codes map[string]int64
+	// Latency is the histogram of the call latencies, till the end of the stream.
+	Latency *Histogram
+	Elapsed time.Duration
+}
+
+// RPS returns the achieved calls per second.
+func (res *Result) RPS() float64 {
+	if res.Elapsed <= 0 {
+		return 0
+	}
+	return float64(res.Requests) / res.Elapsed.Seconds()
+}
+
+func (res *Result) String() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "requests=%d errors=%d parts=%d elapsed=%s rps=%.1f %s",
+		res.Requests, res.Errors, res.Parts, res.Elapsed, res.RPS(), res.Latency)
+	return buf.String()
+}
+
+// Run the load test, till the Duration or the Requests are reached, or the context is done.
+func Run(ctx context.Context, c grpcer.Client, conf Config) (*Result, error) {
+	if conf.Duration <= 0 && conf.Requests <= 0 {
+		if _, ok := ctx.Deadline(); !ok {
+			return nil, errors.New("no Duration, Requests or context deadline")
+		}
+	}
+	if conf.Method == "" {
+		return nil, errors.New("no Method")
+	}
+	input := conf.Input
+	if input == nil {
+		input = func(int) interface{} { return c.Input(conf.Method) }
+	}
+	concurrency := conf.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	if conf.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, conf.Duration)
+		defer cancel()
+	}
+
+	// the tickets of the calls
+	tickets := make(chan int)
+	go func() {
+		defer close(tickets)
+		var tick <-chan time.Time
+		if conf.RPS > 0 {
+			ticker := time.NewTicker(time.Duration(float64(time.Second) / conf.RPS))
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for i := 0; conf.Requests <= 0 || i < conf.Requests; i++ {
+			if tick != nil {
+				select {
+				case <-ctx.Done():
+					return
+				case <-tick:
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case tickets <- i:
+			}
+		}
+	}()
+
+	res := Result{Codes: make(map[string]int64), Latency: NewHistogram()}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range tickets {
+				callStart := time.Now()
+				parts, err := call(ctx, c, conf.Method, input(i))
+				d := time.Since(callStart)
+				if err != nil && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+					// canceled by the end of the run
+					return
+				}
+				mu.Lock()
+				res.Requests++
+				res.Parts += parts
+				res.Codes[status.Code(err).String()]++
+				if err != nil {
+					res.Errors++
+				}
+				res.Latency.Add(d)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	res.Elapsed = time.Since(start)
+	return &res, nil
+}
+
+func call(ctx context.Context, c grpcer.Client, method string, input interface{}) (int64, error) {
+	recv, err := c.Call(method, ctx, input)
+	if err != nil {
+		return 0, err
+	}
+	defer grpcer.CloseReceiver(recv)
+	var n int64
+	for {
+		if _, err = recv.Recv(); err != nil {
+			if err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+		n++
+	}
+}
+
+// Histogram of durations, in logarithmic buckets with 1% precision.
+type Histogram struct {
+	counts   map[int]int64
+	n        int64
+	sum      time.Duration
+	min, max time.Duration
+}
+
+// NewHistogram returns an empty Histogram.
+func NewHistogram() *Histogram { return &Histogram{counts: make(map[int]int64)} }
+
+const histBase = 1.01
+
+func bucketOf(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Log(float64(d)) / math.Log(histBase))
+}
+
+// Add a duration.
+func (h *Histogram) Add(d time.Duration) {
+	h.counts[bucketOf(d)]++
+	if h.n == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.n++
+	h.sum += d
+}
+
+// Count returns the number of durations.
+func (h *Histogram) Count() int64 { return h.n }
+
+// Mean returns the average duration.
+func (h *Histogram) Mean() time.Duration {
+	if h.n == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.n)
+}
+
+// Max returns the maximum duration.
+func (h *Histogram) Max() time.Duration { return h.max }
+
+// Percentile returns the p-th (0-100) percentile.
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.n == 0 {
+		return 0
+	}
+	buckets := make([]int, 0, len(h.counts))
+	for b := range h.counts {
+		buckets = append(buckets, b)
+	}
+	sort.Ints(buckets)
+	rank := int64(math.Ceil(p / 100 * float64(h.n)))
+	var seen int64
+	for _, b := range buckets {
+		if seen += h.counts[b]; seen >= rank {
+			d := time.Duration(math.Pow(histBase, float64(b+1)))
+			if d > h.max {
+				d = h.max
+			}
+			if d < h.min {
+				d = h.min
+			}
+			return d
+		}
+	}
+	return h.max
+}
+
+func (h *Histogram) String() string {
+	return fmt.Sprintf("min=%s mean=%s p50=%s p90=%s p99=%s max=%s",
+		h.min, h.Mean(), h.Percentile(50), h.Percentile(90), h.Percentile(99), h.max)
+}
@@ -0,0 +1,65 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package loadgen_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ngurban/grpcer/grpcertest"
+	"github.com/ngurban/grpcer/loadgen"
+)
+
+func TestRun(t *testing.T) {
+	m := grpcertest.NewMockClient()
+	m.On("Get").Return(1, 2)
+	res, err := loadgen.Run(context.Background(), m, loadgen.Config{
+		Method: "Get", Requests: 50, Concurrency: 4, RPS: 1000,
+		Input: func(i int) interface{} { return i },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(res)
+	if res.Requests != 50 || res.Parts != 100 || res.Errors != 0 || res.Latency.Count() != 50 {
+		t.Errorf("got %+v", res)
+	}
+	if res.Elapsed < 40*time.Millisecond {
+		t.Errorf("RPS not limited: %s", res.Elapsed)
+	}
+
+	m = grpcertest.NewMockClient()
+	m.On("Fail").Return(errors.New("broken"))
+	if res, err = loadgen.Run(context.Background(), m, loadgen.Config{Method: "Fail", Duration: 20 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if res.Requests == 0 || res.Errors != res.Requests {
+		t.Errorf("got %+v", res)
+	}
+
+	h := loadgen.NewHistogram()
+	for i := 1; i <= 100; i++ {
+		h.Add(time.Duration(i) * time.Millisecond)
+	}
+	if p := h.Percentile(50); p < 49*time.Millisecond || p > 51*time.Millisecond {
+		t.Errorf("p50: %s", p)
+	}
+	if p := h.Percentile(100); p != 100*time.Millisecond {
+		t.Errorf("p100: %s", p)
+	}
+}
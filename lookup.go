@@ -0,0 +1,179 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Resolver is implemented by Clients which can resolve a (possibly inexact) method name.
+type Resolver interface {
+	// Resolve the name to a name returned by List, or return a NotFound error.
+	Resolve(name string) (string, error)
+}
+
+var _ = Resolver(lookupClient{})
+
+type lookupClient struct {
+	Client
+	names map[string]string // lower-cased name or alias -> name
+}
+
+// WithLookup returns a Client which resolves the method names case-insensitively,
+// and through the aliases (alias -> name) map.
+//
+// For unknown names a NotFound error listing the near-matches is returned.
+func WithLookup(c Client, aliases map[string]string) Client {
+	list := c.List()
+	lc := lookupClient{Client: c, names: make(map[string]string, len(list)+len(aliases))}
+	for _, nm := range list {
+		lc.names[strings.ToLower(nm)] = nm
+	}
+	for alias, nm := range aliases {
+		lc.names[strings.ToLower(alias)] = nm
+	}
+	return lc
+}
+
+// Resolve the name to a real method name.
+func (lc lookupClient) Resolve(name string) (string, error) {
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		name = name[i+1:]
+	}
+	if nm, ok := lc.names[strings.ToLower(name)]; ok {
+		return nm, nil
+	}
+	if near := nearMatches(name, lc.List(), 3); len(near) != 0 {
+		return "", status.Errorf(codes.NotFound, "method %q not found, did you mean %s?", name, strings.Join(near, ", "))
+	}
+	return "", status.Errorf(codes.NotFound, "method %q not found", name)
+}
+
+// Input returns the input struct for the resolved name.
+func (lc lookupClient) Input(name string) interface{} {
+	nm, err := lc.Resolve(name)
+	if err != nil {
+		return nil
+	}
+	return lc.Client.Input(nm)
+}
+
+// Output returns the output struct for the resolved name.
+func (lc lookupClient) Output(name string) interface{} {
+	nm, err := lc.Resolve(name)
+	if err != nil {
+		return nil
+	}
+	return lc.Client.Output(nm)
+}
+
+// ListMethods returns the metadata of the underlying Client's methods.
+func (lc lookupClient) ListMethods() []MethodMeta { return ListMethods(lc.Client) }
+
+// Call the resolved name.
+func (lc lookupClient) Call(name string, ctx context.Context, input interface{}, opts ...grpc.CallOption) (Receiver, error) {
+	nm, err := lc.Resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return lc.Client.Call(nm, ctx, input, opts...)
+}
+
+// notFoundMessage returns the message for the unknown name,
+// with the near matches if the Client is a Resolver.
+func notFoundMessage(c Client, name string) string {
+	if r, ok := c.(Resolver); ok {
+		if _, err := r.Resolve(name); err != nil {
+			return status.Convert(err).Message()
+		}
+	}
+	return fmt.Sprintf("No unmarshaler for %q.", name)
+}
+
+// nearMatches returns at most n names which are near to name, nearest first.
+func nearMatches(name string, names []string, n int) []string {
+	type match struct {
+		Name     string
+		Distance int
+	}
+	lname := strings.ToLower(name)
+	maxDist := len(lname) / 3
+	if maxDist < 2 {
+		maxDist = 2
+	}
+	matches := make([]match, 0, n)
+	for _, nm := range names {
+		lnm := strings.ToLower(nm)
+		d := levenshtein(lname, lnm)
+		if d > maxDist && !strings.Contains(lnm, lname) {
+			continue
+		}
+		matches = append(matches, match{Name: nm, Distance: d})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance == matches[j].Distance {
+			return matches[i].Name < matches[j].Name
+		}
+		return matches[i].Distance < matches[j].Distance
+	})
+	if len(matches) > n {
+		matches = matches[:n]
+	}
+	near := make([]string, len(matches))
+	for i, m := range matches {
+		near[i] = m.Name
+	}
+	return near
+}
+
+// levenshtein returns the edit distance of a and b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
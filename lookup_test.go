@@ -0,0 +1,109 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeClient calls the named functions.
+type fakeClient map[string]func(ctx context.Context, input interface{}) (Receiver, error)
+
+func (fc fakeClient) List() []string {
+	names := make([]string, 0, len(fc))
+	for nm := range fc {
+		names = append(names, nm)
+	}
+	sort.Strings(names)
+	return names
+}
+func (fc fakeClient) Input(name string) interface{} {
+	if _, ok := fc[name]; !ok {
+		return nil
+	}
+	return new(map[string]interface{})
+}
+func (fc fakeClient) Output(name string) interface{} {
+	if _, ok := fc[name]; !ok {
+		return nil
+	}
+	return new(map[string]interface{})
+}
+func (fc fakeClient) Call(name string, ctx context.Context, input interface{}, opts ...grpc.CallOption) (Receiver, error) {
+	f, ok := fc[name]
+	if !ok {
+		return nil, fmt.Errorf("name %q not found", name)
+	}
+	return f(ctx, input)
+}
+
+func TestLookup(t *testing.T) {
+	ret := func(ctx context.Context, input interface{}) (Receiver, error) {
+		return &receiver{}, nil
+	}
+	c := WithLookup(fakeClient{
+		"GetOrderList": ret, "GetOrder": ret, "PutOrder": ret, "DeleteCustomer": ret,
+	}, map[string]string{"orders": "GetOrderList"})
+	r := c.(Resolver)
+	for tN, tC := range map[string]struct {
+		Name, Want string
+		Near       []string
+	}{
+		"exact":     {Name: "GetOrder", Want: "GetOrder"},
+		"lower":     {Name: "getorderlist", Want: "GetOrderList"},
+		"alias":     {Name: "Orders", Want: "GetOrderList"},
+		"qualified": {Name: "/pkg.Svc/getOrder", Want: "GetOrder"},
+		"typo":      {Name: "GetOrdr", Near: []string{"GetOrder"}},
+		"far":       {Name: "Xyzzy"},
+	} {
+		got, err := r.Resolve(tC.Name)
+		if tC.Want != "" {
+			if err != nil {
+				t.Errorf("%s: %+v", tN, err)
+			} else if got != tC.Want {
+				t.Errorf("%s: got %q, wanted %q", tN, got, tC.Want)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: wanted error, got %q", tN, got)
+			continue
+		}
+		if code := status.Code(err); code != codes.NotFound {
+			t.Errorf("%s: got code %v, wanted NotFound", tN, code)
+		}
+		msg := status.Convert(err).Message()
+		for _, nm := range tC.Near {
+			if !strings.Contains(msg, nm) {
+				t.Errorf("%s: %q does not list %q", tN, msg, nm)
+			}
+		}
+		if len(tC.Near) == 0 && strings.Contains(msg, "did you mean") {
+			t.Errorf("%s: unwanted near matches in %q", tN, msg)
+		}
+	}
+	if _, err := c.Call("getorder", context.Background(), nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -16,42 +16,312 @@
 package grpcer
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	//json "encoding/json"
 	"errors"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"reflect"
 	"strings"
+	"time"
 
 	json "github.com/json-iterator/go"
 )
 
 var errNewField = errors.New("new field")
 
-func mergeStreams(w io.Writer, first interface{}, recv interface {
-	Recv() (interface{}, error)
-},
-	Log func(...interface{}) error,
-) error {
+// MergeOption is an option of MergeStreams.
+type MergeOption func(*mergeConfig)
+
+type mergeConfig struct {
+	tempDir   string
+	bufSize   int
+	spillSize int64
+	enc       streamEncoder
+	comma     rune
+	// flushInterval and flushSize are the MergeFlush settings.
+	flushInterval time.Duration
+	flushSize     int
+	// renames are the key renames of the JSON output.
+	renames *Renames
+	// api encodes the JSON output, json.ConfigDefault if nil.
+	api json.API
+	Log func(...interface{}) error
+}
+
+// MergeTempDir sets the directory of the temporary files (os.TempDir() by default).
+func MergeTempDir(dir string) MergeOption { return func(mc *mergeConfig) { mc.tempDir = dir } }
+
+// MergeBufferSize sets the write buffer size of the temporary files (unbuffered by default).
+func MergeBufferSize(size int) MergeOption { return func(mc *mergeConfig) { mc.bufSize = size } }
+
+// DefaultMergeSpillSize is the size above which the collected slice fields
+// are spilled into temporary files, see MergeSpillSize.
+var DefaultMergeSpillSize int64 = 1 << 20
+
+// MergeSpillSize sets the size above which a collected slice field is spilled into a temporary file
+// (DefaultMergeSpillSize if zero, immediately if negative).
+func MergeSpillSize(size int64) MergeOption { return func(mc *mergeConfig) { mc.spillSize = size } }
+
+// MergeXML writes the merged stream as XML, honoring the xml struct tags.
+func MergeXML() MergeOption { return func(mc *mergeConfig) { mc.enc = &xmlStreamEncoder{} } }
+
+// MergeFlush sets the flushing of the writer, if it is an http.Flusher (or a wrapping http.ResponseWriter):
+// after a merged chunk it is flushed if interval has elapsed since the last flush,
+// or at least size bytes have been written since.
+//
+// By default (both zero) it is flushed after every chunk; never if interval is negative.
+func MergeFlush(interval time.Duration, size int) MergeOption {
+	return func(mc *mergeConfig) { mc.flushInterval, mc.flushSize = interval, size }
+}
+
+// MergeRenames renames the keys of the (JSON) output, see CompileRenames.
+func MergeRenames(renames *Renames) MergeOption {
+	return func(mc *mergeConfig) { mc.renames = renames }
+}
+
+// MergeSpecialFloats sets the handling of NaN and ±Infinity in the JSON output,
+// SpecialFloatsReject by default.
+func MergeSpecialFloats(mode SpecialFloats) MergeOption {
+	return func(mc *mergeConfig) { mc.api = jsonConfigOf(mode).api }
+}
+
+// MergeLog sets the logger.
+func MergeLog(Log func(...interface{}) error) MergeOption {
+	return func(mc *mergeConfig) { mc.Log = Log }
+}
+
+// MergeStreams writes the first and the rest of the parts from recv into w as one JSON object,
+// merging the slice fields of the parts: the first slice field is written as it arrives,
+// the others are collected in memory, spilled into temporary files above MergeSpillSize.
+//
+// Parts without slice fields are written one by one.
+func MergeStreams(w io.Writer, first interface{}, recv Receiver, opts ...MergeOption) error {
+	return MergeStreamsContext(context.Background(), w, first, recv, opts...)
+}
+
+// MergeStreamsContext is like MergeStreams, but stops when ctx is done:
+// recv is closed (if it is an io.Closer) to unblock a pending Recv,
+// the temporary files are removed, a {"error":...,"truncated":true} marker is written
+// (as a field of the merged object, or as a separate object if there's nothing to merge),
+// and ctx.Err() is returned.
+func MergeStreamsContext(ctx context.Context, w io.Writer, first interface{}, recv Receiver, opts ...MergeOption) error {
+	var mc mergeConfig
+	for _, o := range opts {
+		o(&mc)
+	}
+	return mergeStreamsConfig(ctx, w, first, recv, mc)
+}
+
+func mergeStreams(ctx context.Context, w io.Writer, first interface{}, recv Receiver, Log func(...interface{}) error) error {
+	return mergeStreamsConfig(ctx, w, first, recv, mergeConfig{Log: Log})
+}
+
+// recvContext calls recv.Recv, returning ctx.Err() if ctx is done.
+//
+// The error of the receiver (such as the idle timeout) is kept,
+// except io.EOF, which may be the result of closing the receiver.
+func recvContext(ctx context.Context, recv Receiver) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	part, err := recv.Recv()
+	if err == io.EOF {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+	}
+	return part, err
+}
+
+// truncatedMarker is written at the end of the output when the merge has been stopped.
+type truncatedMarker struct {
+	Error     string `json:"error"`
+	Truncated bool   `json:"truncated"`
+}
+
+// spillBuffer collects the data in memory up to limit bytes,
+// then in a temporary file (removed right after creation), with an optional write buffer.
+type spillBuffer struct {
+	name    string // of the temporary file
+	dir     string
+	limit   int64
+	bufSize int
+	mem     bytes.Buffer
+	file    *os.File
+	w       io.Writer // of the file
+}
+
+func (sb *spillBuffer) Write(p []byte) (int, error) {
+	if sb.file == nil {
+		if int64(sb.mem.Len()+len(p)) <= sb.limit {
+			return sb.mem.Write(p)
+		}
+		if err := sb.spill(); err != nil {
+			return 0, err
+		}
+	}
+	return sb.w.Write(p)
+}
+
+func (sb *spillBuffer) WriteString(s string) (int, error) {
+	if sb.file == nil && int64(sb.mem.Len()+len(s)) <= sb.limit {
+		return sb.mem.WriteString(s)
+	}
+	return sb.Write([]byte(s))
+}
+
+// spill the collected data into a new temporary file.
+func (sb *spillBuffer) spill() error {
+	tf, err := ioutil.TempFile(sb.dir, "merge-"+sb.name+"-")
+	if err != nil {
+		return err
+	}
+	os.Remove(tf.Name())
+	sb.file, sb.w = tf, tf
+	if sb.bufSize > 0 {
+		sb.w = bufio.NewWriterSize(tf, sb.bufSize)
+	}
+	_, err = sb.mem.WriteTo(sb.w)
+	sb.mem = bytes.Buffer{}
+	return err
+}
+
+// Name returns the name of the temporary file, or "memory".
+func (sb *spillBuffer) Name() string {
+	if sb.file == nil {
+		return "memory"
+	}
+	return sb.file.Name()
+}
+
+// reader flushes the buffer and returns the reader of the collected data.
+func (sb *spillBuffer) reader() (io.Reader, error) {
+	if sb.file == nil {
+		return &sb.mem, nil
+	}
+	if bw, ok := sb.w.(*bufio.Writer); ok {
+		if err := bw.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	_, err := sb.file.Seek(0, io.SeekStart)
+	return sb.file, err
+}
+
+// Close closes the temporary file.
+func (sb *spillBuffer) Close() error {
+	if sb.file == nil {
+		return nil
+	}
+	return sb.file.Close()
+}
+
+// chunkFlusher flushes the underlying writer after the chunks, see MergeFlush.
+type chunkFlusher struct {
+	w        io.Writer
+	interval time.Duration
+	size     int
+	n        int // written since the last flush
+	last     time.Time
+}
+
+// newChunkFlusher returns nil if w cannot be flushed, or interval is negative.
+func newChunkFlusher(w io.Writer, interval time.Duration, size int) *chunkFlusher {
+	if interval < 0 {
+		return nil
+	}
+	if _, ok := w.(http.ResponseWriter); !ok {
+		if _, ok = w.(http.Flusher); !ok {
+			return nil
+		}
+	}
+	return &chunkFlusher{w: w, interval: interval, size: size, last: time.Now()}
+}
+
+func (f *chunkFlusher) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	f.n += n
+	return n, err
+}
+
+// chunk ends a chunk, flushing if needed.
+func (f *chunkFlusher) chunk() {
+	if f == nil || f.n == 0 {
+		return
+	}
+	if f.interval == 0 && f.size == 0 ||
+		f.size > 0 && f.n >= f.size ||
+		f.interval > 0 && time.Since(f.last) >= f.interval {
+		flush(f.w)
+		f.n, f.last = 0, time.Now()
+	}
+}
+
+// streamEncoder encodes the merged stream: the non-slice fields of the first part,
+// then the elements of the slice fields as they arrive.
+//
+// The first slice field is written directly, the others are collected in temporary files,
+// and copied after the first one has been ended.
+type streamEncoder interface {
+	// Part encodes a part of a stream without slice fields.
+	Part(w io.Writer, part interface{}) error
+	// EndParts ends the stream of parts, stopped is the error which stopped it early.
+	EndParts(w io.Writer, stopped error) error
+
+	// Begin starts the merged document with the non-slice fields of the first part.
+	Begin(w io.Writer, first interface{}, notSlice []field) error
+	// BeginSlice starts the i-th slice field.
+	BeginSlice(w io.Writer, f field, i int) error
+	// Elements writes the elements of the slice field - more is true if some has already been written.
+	Elements(w io.Writer, f field, more bool) error
+	// EndSlice ends the slice field.
+	EndSlice(w io.Writer, f field) error
+	// End ends the merged document, stopped is the error which stopped it early.
+	End(w io.Writer, stopped error) error
+}
+
+func mergeStreamsConfig(ctx context.Context, w io.Writer, first interface{}, recv Receiver, mc mergeConfig) error {
+	Log := mc.Log
 	if Log == nil {
 		Log = func(...interface{}) error { return nil }
 	}
+	if ctx.Done() != nil {
+		stop := context.AfterFunc(ctx, func() { CloseReceiver(recv) })
+		defer stop()
+	}
+	api := mc.api
+	if api == nil {
+		api = json.ConfigDefault
+	}
+	enc := mc.enc
+	if enc == nil {
+		jse := newJSONStreamEncoder(api)
+		jse.renames = mc.renames
+		enc = jse
+	}
+	fw := newChunkFlusher(w, mc.flushInterval, mc.flushSize)
+	if fw != nil {
+		w = fw
+	}
 
 	slice, notSlice := sliceFields(first)
 	if len(slice) == 0 {
 		var err error
 		part := first
-		enc := json.NewEncoder(w)
 		for {
-			if err := enc.Encode(part); err != nil {
+			if err := enc.Part(w, part); err != nil {
 				Log("encode", part, "error", err)
 				return fmt.Errorf("encode part: %w", err)
 			}
+			fw.chunk()
 
-			part, err = recv.Recv()
+			part, err = recvContext(ctx, recv)
 			if err != nil {
 				if err != io.EOF {
 					Log("msg", "recv", "error", err)
@@ -60,7 +330,11 @@ func mergeStreams(w io.Writer, first interface{}, recv interface {
 			}
 		}
 		Log("slice", len(slice))
-		return nil
+		if err != io.EOF && ctx.Err() != nil {
+			_ = enc.EndParts(w, err)
+			return err
+		}
+		return enc.EndParts(w, nil)
 	}
 
 	names := make(map[string]bool, len(slice)+len(notSlice))
@@ -70,65 +344,64 @@ func mergeStreams(w io.Writer, first interface{}, recv interface {
 		buf.Reset()
 		bufPool.Put(buf)
 	}()
-	jenc := json.NewEncoder(buf)
+	jenc := api.NewEncoder(buf)
 
 	//Log("slices", slice)
-	w.Write([]byte("{"))
+	if err := enc.Begin(w, first, notSlice); err != nil {
+		return err
+	}
 	for _, f := range notSlice {
-		buf.Reset()
-		jenc.Encode(f.JSONName)
-		w.Write(bytes.TrimSpace(buf.Bytes()))
-
-		w.Write([]byte{':'})
-		buf.Reset()
-		jenc.Encode(f.Value)
-		w.Write(bytes.TrimSpace(buf.Bytes()))
-		w.Write([]byte{','})
-
 		names[f.Name] = false
 	}
-	buf.Reset()
-	jenc.Encode(slice[0].JSONName)
-	w.Write(bytes.TrimSpace(buf.Bytes()))
-	w.Write([]byte(":"))
-
-	buf.Reset()
-	jenc.Encode(slice[0].Value)
-	w.Write(bytes.TrimSuffix(bytes.TrimSpace(buf.Bytes()), []byte{']'}))
-
+	// more reports whether elements has already been written, by field name.
+	more := make(map[string]bool, len(slice))
+	writeElements := func(w io.Writer, f field) error {
+		if reflect.ValueOf(f.Value).Len() == 0 {
+			return nil
+		}
+		err := enc.Elements(w, f, more[f.Name])
+		more[f.Name] = true
+		return err
+	}
+	if err := enc.BeginSlice(w, slice[0], 0); err != nil {
+		return err
+	}
+	if err := writeElements(w, slice[0]); err != nil {
+		return err
+	}
 	names[slice[0].Name] = true
+	fw.chunk()
 
-	files := make(map[string]*os.File, len(slice)-1)
-	for _, f := range slice[1:] {
-		fh, err := ioutil.TempFile("", "merge-"+f.Name+"-")
-		if err != nil {
-			Log("tempFile", f.Name, "error", err)
-			return fmt.Errorf("%s: %w", f.Name, err)
-		}
-		os.Remove(fh.Name())
-		Log("fn", fh.Name())
+	spillSize := mc.spillSize
+	if spillSize == 0 {
+		spillSize = DefaultMergeSpillSize
+	}
+	files := make(map[string]*spillBuffer, len(slice)-1)
+	for i, f := range slice[1:] {
+		fh := &spillBuffer{name: f.Name, dir: mc.tempDir, limit: spillSize, bufSize: mc.bufSize}
 		defer fh.Close()
 		files[f.Name] = fh
-		buf.Reset()
-		jenc.Encode(f.JSONName)
-		fh.Write(bytes.TrimSpace(buf.Bytes()))
-		io.WriteString(fh, ":[")
-
-		buf.Reset()
-		jenc.Encode(f.Value)
-		fh.Write(trimSqBrs(buf.Bytes()))
-
+		if err := enc.BeginSlice(fh, f, i+1); err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		if err := writeElements(fh, f); err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
 		names[f.Name] = true
 	}
 
 	var part interface{}
 	var err error
+	var stopped error
 	for {
-		part, err = recv.Recv()
+		part, err = recvContext(ctx, recv)
 		if err != nil {
 			if err != io.EOF {
 				Log("msg", "recv", "error", err)
 			}
+			if err != io.EOF && ctx.Err() != nil {
+				stopped = err
+			}
 			break
 		}
 		buf.Reset()
@@ -152,44 +425,156 @@ func mergeStreams(w io.Writer, first interface{}, recv interface {
 			return err
 		}
 
-		if S[0].Name == slice[0].Name {
-			w.Write([]byte{','})
-			buf.Reset()
-			jenc.Encode(S[0].Value)
-			w.Write(trimSqBrs(buf.Bytes()))
-			S = S[1:]
-		}
 		for _, f := range S {
+			if f.Name == slice[0].Name {
+				if err := writeElements(w, f); err != nil {
+					return err
+				}
+				fw.chunk()
+				continue
+			}
 			fh := files[f.Name]
-			if _, err := fh.Write([]byte{','}); err != nil {
+			if err := writeElements(fh, f); err != nil {
 				Log("write", fh.Name(), "error", err)
+				return fmt.Errorf("%s: %w", f.Name, err)
 			}
-			buf.Reset()
-			jenc.Encode(f.Value)
-			fh.Write(trimSqBrs(buf.Bytes()))
 		}
 	}
-	w.Write([]byte("]"))
+	if err := enc.EndSlice(w, slice[0]); err != nil {
+		return err
+	}
 
-	for _, fh := range files {
-		if err := fh.Sync(); err != nil {
-			Log("Sync", fh.Name(), "error", err)
-		}
-		if _, err := fh.Seek(0, 0); err != nil {
-			Log("Seek", fh.Name(), "error", err)
+	for _, f := range slice[1:] {
+		fh := files[f.Name]
+		r, err := fh.reader()
+		if err != nil {
+			Log("rewind", fh.Name(), "error", err)
 			continue
 		}
-		w.Write([]byte{','})
-		io.Copy(w, fh)
-		w.Write([]byte{']'})
+		io.Copy(w, r)
+		if err := enc.EndSlice(w, f); err != nil {
+			return err
+		}
+		fw.chunk()
+	}
+	if err := enc.End(w, stopped); err != nil {
+		return err
+	}
+	return stopped
+}
+
+// jsonStreamEncoder is the default, JSON streamEncoder.
+type jsonStreamEncoder struct {
+	api     json.API
+	buf     *bytes.Buffer
+	jenc    *json.Encoder
+	penc    *json.Encoder // of the parts
+	renames *Renames
+}
+
+func newJSONStreamEncoder(api json.API) *jsonStreamEncoder {
+	var buf bytes.Buffer
+	return &jsonStreamEncoder{api: api, buf: &buf, jenc: api.NewEncoder(&buf)}
+}
+
+func (e *jsonStreamEncoder) encode(v interface{}) []byte {
+	e.buf.Reset()
+	e.jenc.Encode(v)
+	return bytes.TrimSpace(e.buf.Bytes())
+}
+
+// key returns the JSON key of the field.
+func (e *jsonStreamEncoder) key(f field) []byte {
+	if e.renames != nil {
+		return e.encode(e.renames.key(f.JSONName))
+	}
+	return e.encode(f.JSONName)
+}
+
+// value returns v with the keys renamed.
+func (e *jsonStreamEncoder) value(v interface{}) (interface{}, error) {
+	if e.renames == nil {
+		return v, nil
+	}
+	return e.renames.Apply(v)
+}
+
+func (e *jsonStreamEncoder) Part(w io.Writer, part interface{}) error {
+	if e.penc == nil {
+		e.penc = e.api.NewEncoder(w)
+	}
+	part, err := e.value(part)
+	if err != nil {
+		return err
+	}
+	return e.penc.Encode(part)
+}
+
+func (e *jsonStreamEncoder) EndParts(w io.Writer, stopped error) error {
+	if stopped == nil {
+		return nil
+	}
+	return e.api.NewEncoder(w).Encode(truncatedMarker{Error: stopped.Error(), Truncated: true})
+}
+
+func (e *jsonStreamEncoder) Begin(w io.Writer, first interface{}, notSlice []field) error {
+	w.Write([]byte("{"))
+	for _, f := range notSlice {
+		v, err := e.value(f.Value)
+		if err != nil {
+			return err
+		}
+		w.Write(e.key(f))
+		w.Write([]byte{':'})
+		w.Write(e.encode(v))
+		// a slice field always follows
+		if _, err := w.Write([]byte{','}); err != nil {
+			return err
+		}
 	}
-	w.Write([]byte{'}', '\n'})
 	return nil
 }
 
+func (e *jsonStreamEncoder) BeginSlice(w io.Writer, f field, i int) error {
+	if i != 0 {
+		w.Write([]byte{','})
+	}
+	w.Write(e.key(f))
+	_, err := w.Write([]byte(":["))
+	return err
+}
+
+func (e *jsonStreamEncoder) Elements(w io.Writer, f field, more bool) error {
+	if more {
+		w.Write([]byte{','})
+	}
+	v, err := e.value(f.Value)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(trimSqBrs(e.encode(v)))
+	return err
+}
+
+func (e *jsonStreamEncoder) EndSlice(w io.Writer, f field) error {
+	_, err := w.Write([]byte{']'})
+	return err
+}
+
+func (e *jsonStreamEncoder) End(w io.Writer, stopped error) error {
+	if stopped != nil {
+		// replace the marker object's braces, to append its fields to the merged object
+		w.Write([]byte{','})
+		w.Write(bytes.TrimSuffix(bytes.TrimPrefix(e.encode(truncatedMarker{Error: stopped.Error(), Truncated: true}), []byte{'{'}), []byte{'}'}))
+	}
+	_, err := w.Write([]byte{'}', '\n'})
+	return err
+}
+
 type field struct {
 	Name     string
 	JSONName string
+	Tag      reflect.StructTag
 	Value    interface{}
 }
 
@@ -200,11 +585,14 @@ func sliceFields(part interface{}) (slice, notSlice []field) {
 		rv = rv.Elem()
 		t = rv.Type()
 	}
+	if t.Kind() != reflect.Struct {
+		return nil, nil
+	}
 	n := t.NumField()
 	for i := 0; i < n; i++ {
 		f := rv.Field(i)
 		tf := t.Field(i)
-		fld := field{Name: tf.Name, Value: f.Interface()}
+		fld := field{Name: tf.Name, Tag: tf.Tag, Value: f.Interface()}
 		fld.JSONName = tf.Tag.Get("json")
 		if i := strings.IndexByte(fld.JSONName, ','); i >= 0 {
 			fld.JSONName = fld.JSONName[:i]
@@ -213,7 +601,7 @@ func sliceFields(part interface{}) (slice, notSlice []field) {
 			fld.JSONName = fld.Name
 		}
 
-		if f.Type().Kind() != reflect.Slice {
+		if f.Type().Kind() != reflect.Slice || f.Type().Elem().Kind() == reflect.Uint8 { // []byte is a scalar
 			notSlice = append(notSlice, fld)
 			continue
 		}
@@ -17,11 +17,17 @@ package grpcer
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/kylelemons/godebug/diff"
 	"github.com/tgulacsi/go/jsondiff"
@@ -76,7 +82,7 @@ func TestMerge(t *testing.T) {
 		buf.Reset()
 		recv := &receiver{parts: tC.Input}
 		first, _ := recv.Recv()
-		mergeStreams(buf, first, recv, Log)
+		mergeStreams(context.Background(), buf, first, recv, Log)
 		_ = repComma
 		d, err := jsondiff.DiffStrings(
 			//repComma.Replace(tC.Want),
@@ -654,3 +660,165 @@ const jsBig1Out = `
 {"row_num":245,"contract_number":10883864,"member_code":692188,"modkod":"22101","modrnev":"OTTHON","bid_id":60288132,"contract_status":"26","contract_status_name":"DÍJ SZEMPONTJÁBÓL ÁTDOLGOZOTT SZERZŐDÉS","contract_status_short":"ÉLŐ","contract_recording_date":"2012-02-20 00:00:00 +0200","contract_btkezd":"2012-01-28 00:00:00 +0200","contract_begin_date":"2012-01-27 00:00:00 +0200","contract_balance_date":"2017-12-31 00:00:00 +0200","contract_future_balance_date":"2017-12-31 00:00:00 +0200","contract_yearly_price":12775,"contract_anniversary":"12-31","client_name":"Tt Sped Kft.","client_code":2335604,"dealer_code":"0001001103","dealer_name":"Topa Mária Ilona","kockhely_irszam":"41770","kockhely_telepules":"FÖLDES","kockhely_cim":"Kállai utca 43. ","client_ppid":"41760","client_city":"SÁP"},
 {"row_num":246,"contract_number":10733025,"member_code":692188,"modkod":"22101","modrnev":"OTTHON","bid_id":60503164,"contract_status":"63","contract_status_name":"DÍJNEMFIZETÉS MIATT TÖRÖLT SZERZŐDÉS","contract_status_short":"TÖRÖLT","contract_recording_date":"2011-09-23 00:00:00 +0200","contract_btkezd":"2010-12-14 00:00:00 +0200","contract_begin_date":"2010-12-13 00:00:00 +0200","contract_deletion_valid_from":"2011-12-06 00:00:00 +0200","contract_balance_date":"2011-09-30 00:00:00 +0200","contract_future_balance_date":"2011-09-30 00:00:00 +0200","contract_yearly_price":20805,"contract_anniversary":"12-31","elvi_dijhatralek":3819,"client_name":"Tt Sped Kft.","client_code":1277407,"dealer_code":"0001001103","dealer_name":"Topa Mária Ilona","kockhely_irszam":"41760","kockhely_telepules":"SÁP","kockhely_cim":"Hrsz  _ ","client_ppid":"41760","client_city":"SÁP"},
 {"row_num":247,"contract_number":10610558,"member_code":692188,"modkod":"22101","modrnev":"OTTHON","bid_id":60469892,"contract_status":"26","contract_status_name":"DÍJ SZEMPONTJÁBÓL ÁTDOLGOZOTT SZERZŐDÉS","contract_status_short":"ÉLŐ","contract_recording_date":"2010-12-28 00:00:00 +0200","contract_btkezd":"2010-12-14 00:00:00 +0200","contract_begin_date":"2010-12-13 00:00:00 +0200","contract_balance_date":"2017-12-31 00:00:00 +0200","contract_future_balance_date":"2017-12-31 00:00:00 +0200","contract_yearly_price":28470,"contract_anniversary":"12-31","client_name":"Tt Sped Kft.","client_code":1277407,"dealer_code":"0001001103","dealer_name":"Topa Mária Ilona","kockhely_irszam":"41760","kockhely_telepules":"SÁP","kockhely_cim":"Hrsz  _ ","client_ppid":"41760","client_city":"SÁP"}]}`
+
+func TestMergeStreamsOptions(t *testing.T) {
+	type part struct {
+		A []string
+		B []int
+	}
+	dir := t.TempDir()
+	recv := &receiver{parts: []interface{}{&part{A: []string{"2"}, B: []int{3}}, &part{A: []string{"4"}, B: []int{5}}}}
+	var buf bytes.Buffer
+	if err := MergeStreams(&buf, &part{A: []string{"1"}, B: []int{1}}, recv,
+		MergeTempDir(dir), MergeBufferSize(4096), MergeLog(func(...interface{}) error { return nil }),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), `{"A":["1","2","4"],"B":[1,3,5]}`+"\n"; got != want {
+		t.Errorf("got %s, wanted %s", got, want)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("temp files left: %v (%+v)", entries, err)
+	}
+}
+
+// hangingReceiver returns its parts, then blocks until closed.
+type hangingReceiver struct {
+	receiver
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (r *hangingReceiver) Recv() (interface{}, error) {
+	if len(r.parts) != 0 {
+		return r.receiver.Recv()
+	}
+	<-r.closed
+	return nil, io.EOF
+}
+
+func (r *hangingReceiver) Close() error {
+	r.closeOnce.Do(func() { close(r.closed) })
+	return nil
+}
+
+func TestMergeStreamsContext(t *testing.T) {
+	type part struct {
+		A []string
+		B []int
+	}
+	type noSlice struct{ A string }
+	for tN, tC := range map[string]struct {
+		First interface{}
+		Parts []interface{}
+		Want  string
+	}{
+		"merged": {
+			First: &part{A: []string{"1"}, B: []int{1}},
+			Parts: []interface{}{&part{A: []string{"2"}, B: []int{3}}},
+			Want:  `{"A":["1","2"],"B":[1,3],"error":"context deadline exceeded","truncated":true}` + "\n",
+		},
+		"noSlice": {
+			First: &noSlice{A: "1"},
+			Parts: []interface{}{&noSlice{A: "2"}},
+			Want:  `{"A":"1"}` + "\n" + `{"A":"2"}` + "\n" + `{"error":"context deadline exceeded","truncated":true}` + "\n",
+		},
+	} {
+		t.Run(tN, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			recv := &hangingReceiver{receiver: receiver{parts: tC.Parts}, closed: make(chan struct{})}
+			var buf bytes.Buffer
+			err := MergeStreamsContext(ctx, &buf, tC.First, recv, MergeTempDir(dir))
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("got %+v, wanted DeadlineExceeded", err)
+			}
+			if got := buf.String(); got != tC.Want {
+				t.Errorf("got %s, wanted %s", got, tC.Want)
+			}
+			if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+				t.Errorf("temp files left: %v (%+v)", entries, err)
+			}
+		})
+	}
+}
+
+func TestMergeStreamsSpill(t *testing.T) {
+	type part struct {
+		A []string
+		B []int
+	}
+	noDir := filepath.Join(t.TempDir(), "nonexistent")
+	for tN, tC := range map[string]struct {
+		Size    int64
+		WantErr bool
+	}{
+		"memory": {Size: 0},
+		"spill":  {Size: -1, WantErr: true},
+		"later":  {Size: 8, WantErr: true},
+	} {
+		recv := &receiver{parts: []interface{}{&part{A: []string{"2"}, B: []int{3}}, &part{A: []string{"4"}, B: []int{5}}}}
+		var buf bytes.Buffer
+		err := MergeStreams(&buf, &part{A: []string{"1"}, B: []int{1}}, recv, MergeTempDir(noDir), MergeSpillSize(tC.Size))
+		if tC.WantErr {
+			if err == nil {
+				t.Errorf("%s: wanted error for the missing temp dir", tN)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %+v", tN, err)
+		}
+		if got, want := buf.String(), `{"A":["1","2","4"],"B":[1,3,5]}`+"\n"; got != want {
+			t.Errorf("%s: got %s, wanted %s", tN, got, want)
+		}
+	}
+
+	// spilled
+	dir := t.TempDir()
+	recv := &receiver{parts: []interface{}{&part{A: []string{"2"}, B: []int{3}}, &part{A: []string{"4"}, B: []int{5}}}}
+	var buf bytes.Buffer
+	if err := MergeStreams(&buf, &part{A: []string{"1"}, B: []int{1}}, recv, MergeTempDir(dir), MergeSpillSize(4), MergeBufferSize(2)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), `{"A":["1","2","4"],"B":[1,3,5]}`+"\n"; got != want {
+		t.Errorf("got %s, wanted %s", got, want)
+	}
+}
+
+// flushRecorder records the written data at each Flush.
+type flushRecorder struct {
+	bytes.Buffer
+	flushes []string
+}
+
+func (fr *flushRecorder) Flush() { fr.flushes = append(fr.flushes, fr.String()) }
+
+func TestMergeStreamsFlush(t *testing.T) {
+	type part struct {
+		A []string
+		B []int
+	}
+	for tN, tC := range map[string]struct {
+		Opts    []MergeOption
+		Flushes int
+	}{
+		"default":  {Flushes: 4},
+		"never":    {Opts: []MergeOption{MergeFlush(-1, 0)}, Flushes: 0},
+		"interval": {Opts: []MergeOption{MergeFlush(time.Hour, 0)}, Flushes: 0},
+		"size":     {Opts: []MergeOption{MergeFlush(time.Hour, 8)}, Flushes: 3},
+	} {
+		recv := &receiver{parts: []interface{}{&part{A: []string{"2"}, B: []int{3}}, &part{A: []string{"4"}, B: []int{5}}}}
+		var fr flushRecorder
+		if err := MergeStreams(&fr, &part{A: []string{"1"}, B: []int{1}}, recv, tC.Opts...); err != nil {
+			t.Fatalf("%s: %+v", tN, err)
+		}
+		if len(fr.flushes) != tC.Flushes {
+			t.Errorf("%s: got %d flushes (%q), wanted %d", tN, len(fr.flushes), fr.flushes, tC.Flushes)
+		}
+		if tN == "default" && fr.flushes[0] != `{"A":["1"` {
+			t.Errorf("%s: first flush: got %q", tN, fr.flushes[0])
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// metadataAppender appends the static and the dynamic metadata to the outgoing context.
+type metadataAppender struct {
+	static metadata.MD
+	fn     func(context.Context) metadata.MD
+}
+
+func newMetadataAppender(static map[string]string, fn func(context.Context) metadata.MD) *metadataAppender {
+	if len(static) == 0 && fn == nil {
+		return nil
+	}
+	return &metadataAppender{static: metadata.New(static), fn: fn}
+}
+
+func (ma *metadataAppender) outgoing(ctx context.Context) context.Context {
+	md := ma.static
+	if ma.fn != nil {
+		if dyn := ma.fn(ctx); len(dyn) != 0 {
+			md = metadata.Join(md, dyn)
+		}
+	}
+	if len(md) == 0 {
+		return ctx
+	}
+	kv := make([]string, 0, 2*len(md))
+	for k, vv := range md {
+		for _, v := range vv {
+			kv = append(kv, k, v)
+		}
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+func (ma *metadataAppender) UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(ma.outgoing(ctx), method, req, reply, cc, opts...)
+}
+
+func (ma *metadataAppender) StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(ma.outgoing(ctx), desc, cc, method, opts...)
+}
@@ -0,0 +1,66 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"sort"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// MethodMeta is the metadata of a method.
+type MethodMeta struct {
+	Name            string `json:"name"`
+	ClientStreaming bool   `json:"clientStreaming,omitempty"`
+	ServerStreaming bool   `json:"serverStreaming,omitempty"`
+	Deprecated      bool   `json:"deprecated,omitempty"`
+	Comment         string `json:"comment,omitempty"`
+}
+
+// MethodLister is implemented by the Clients which know their methods' metadata,
+// such as the ones generated by protoc-gen-grpcer.
+type MethodLister interface {
+	ListMethods() []MethodMeta
+}
+
+// ListMethods returns the metadata of the Client's methods, sorted by name.
+//
+// If the Client is not a MethodLister, the metadata is looked up
+// from the protobuf descriptors of the inputs.
+func ListMethods(c Client) []MethodMeta {
+	var methods []MethodMeta
+	if ml, ok := c.(MethodLister); ok {
+		methods = ml.ListMethods()
+	} else {
+		names := c.List()
+		methods = make([]MethodMeta, 0, len(names))
+		for _, name := range names {
+			mm := MethodMeta{Name: name}
+			if pr, ok := c.Input(name).(protoReflecter); ok {
+				if md := findMethod(pr.ProtoReflect().Descriptor(), name); md != nil {
+					mm.ClientStreaming, mm.ServerStreaming = md.IsStreamingClient(), md.IsStreamingServer()
+					mm.Comment = comment(md)
+					if opts, ok := md.Options().(*descriptorpb.MethodOptions); ok {
+						mm.Deprecated = opts.GetDeprecated()
+					}
+				}
+			}
+			methods = append(methods, mm)
+		}
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i].Name < methods[j].Name })
+	return methods
+}
@@ -0,0 +1,151 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// msgpackEncoder encodes each part as a MessagePack value, using the part's JSON form.
+type msgpackEncoder struct {
+	w   *bufio.Writer
+	api jsoniter.API
+}
+
+func newMsgpackEncoder(w io.Writer) *msgpackEncoder {
+	return &msgpackEncoder{
+		w:   bufio.NewWriter(w),
+		api: jsoniter.Config{UseNumber: true, SortMapKeys: true}.Froze(),
+	}
+}
+
+func (e *msgpackEncoder) Encode(part interface{}) error {
+	b, err := e.api.Marshal(part)
+	if err != nil {
+		return fmt.Errorf("marshal %T: %w", part, err)
+	}
+	var v interface{}
+	if err = e.api.Unmarshal(b, &v); err != nil {
+		return fmt.Errorf("unmarshal %s: %w", b, err)
+	}
+	if err = e.encode(v); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+func (e *msgpackEncoder) Close() error { return e.w.Flush() }
+
+func (e *msgpackEncoder) encode(v interface{}) error {
+	w := e.w
+	switch x := v.(type) {
+	case nil:
+		return w.WriteByte(0xc0)
+	case bool:
+		if x {
+			return w.WriteByte(0xc3)
+		}
+		return w.WriteByte(0xc2)
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return e.encodeInt(i)
+		}
+		f, err := x.Float64()
+		if err != nil {
+			return err
+		}
+		w.WriteByte(0xcb)
+		return binary.Write(w, binary.BigEndian, math.Float64bits(f))
+	case string:
+		e.writeLen(len(x), 0xa0, 32, 0xd9, 0xda, 0xdb)
+		_, err := w.WriteString(x)
+		return err
+	case []interface{}:
+		e.writeLen(len(x), 0x90, 16, 0, 0xdc, 0xdd)
+		for _, y := range x {
+			if err := e.encode(y); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		e.writeLen(len(x), 0x80, 16, 0, 0xde, 0xdf)
+		for _, k := range keys {
+			if err := e.encode(k); err != nil {
+				return err
+			}
+			if err := e.encode(x[k]); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("msgpack: unsupported type %T", v)
+	}
+}
+
+func (e *msgpackEncoder) encodeInt(i int64) error {
+	w := e.w
+	switch {
+	case 0 <= i && i < 128:
+		return w.WriteByte(byte(i))
+	case -32 <= i && i < 0:
+		return w.WriteByte(byte(i))
+	case math.MinInt8 <= i && i <= math.MaxInt8:
+		w.WriteByte(0xd0)
+		return w.WriteByte(byte(i))
+	case math.MinInt16 <= i && i <= math.MaxInt16:
+		w.WriteByte(0xd1)
+		return binary.Write(w, binary.BigEndian, int16(i))
+	case math.MinInt32 <= i && i <= math.MaxInt32:
+		w.WriteByte(0xd2)
+		return binary.Write(w, binary.BigEndian, int32(i))
+	}
+	w.WriteByte(0xd3)
+	return binary.Write(w, binary.BigEndian, i)
+}
+
+// writeLen writes the header of a length n string/array/map:
+// fix|n if n < fixMax, else the 8-bit (if non-zero), 16-bit or 32-bit marker and length.
+func (e *msgpackEncoder) writeLen(n int, fix byte, fixMax int, m8, m16, m32 byte) {
+	w := e.w
+	switch {
+	case n < fixMax:
+		w.WriteByte(fix | byte(n))
+	case m8 != 0 && n <= math.MaxUint8:
+		w.WriteByte(m8)
+		w.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		w.WriteByte(m16)
+		binary.Write(w, binary.BigEndian, uint16(n))
+	default:
+		w.WriteByte(m32)
+		binary.Write(w, binary.BigEndian, uint32(n))
+	}
+}
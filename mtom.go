@@ -0,0 +1,158 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// XOPNamespace is the namespace of the xop:Include elements referencing the MTOM attachments.
+const XOPNamespace = "http://www.w3.org/2004/08/xop/include"
+
+// readMTOM returns the root part (the envelope) and the attachments by Content-ID
+// of the MTOM (multipart/related XOP) request, or ok=false (without reading body) if the request is not MTOM.
+// The SOAP namespace is given by the start-info parameter.
+func readMTOM(body io.Reader, contentType string) (root []byte, parts map[string][]byte, ns string, ok bool, err error) {
+	mt, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mt != "multipart/related" || params["type"] != "application/xop+xml" {
+		return nil, nil, "", false, nil
+	}
+	ns = SOAP11Namespace
+	if strings.HasPrefix(params["start-info"], "application/soap+xml") {
+		ns = SOAP12Namespace
+	}
+	start := strings.Trim(params["start"], "<>")
+	mr := multipart.NewReader(body, params["boundary"])
+	parts = make(map[string][]byte)
+	var rootFound bool
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, ns, true, fmt.Errorf("MTOM: %w", err)
+		}
+		b, err := io.ReadAll(p)
+		if err != nil {
+			return nil, nil, ns, true, fmt.Errorf("MTOM: %w", err)
+		}
+		cid := strings.Trim(p.Header.Get("Content-ID"), "<>")
+		if !rootFound && (start == "" || cid == start) {
+			root, rootFound = b, true
+			continue
+		}
+		parts[cid] = b
+	}
+	if !rootFound {
+		return nil, nil, ns, true, fmt.Errorf("MTOM: no root part %q", start)
+	}
+	return root, parts, ns, true, nil
+}
+
+// xopReader replaces the xop:Include elements with the content of the referenced attachments,
+// which are unmarshaled into the []byte fields as is.
+type xopReader struct {
+	dec   *xml.Decoder
+	parts map[string][]byte
+}
+
+func (x *xopReader) Token() (xml.Token, error) {
+	tok, err := x.dec.Token()
+	if err != nil {
+		return tok, err
+	}
+	se, ok := tok.(xml.StartElement)
+	if !ok || se.Name.Space != XOPNamespace || se.Name.Local != "Include" {
+		return tok, nil
+	}
+	var href string
+	for _, a := range se.Attr {
+		if a.Name.Local == "href" {
+			href = a.Value
+		}
+	}
+	cid, err := url.PathUnescape(strings.TrimPrefix(href, "cid:"))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "xop:Include href %q: %v", href, err)
+	}
+	b, ok := x.parts[cid]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "no MTOM attachment %q", href)
+	}
+	if err := x.dec.Skip(); err != nil {
+		return nil, err
+	}
+	return xml.CharData(b), nil
+}
+
+// mtomWriter writes the response as MTOM: the envelope in the root part,
+// and the attachments collected while writing it in the following parts.
+type mtomWriter struct {
+	mw          *multipart.Writer
+	attachments [][]byte
+}
+
+// newMTOMWriter sets the multipart Content-Type in header.
+func newMTOMWriter(w io.Writer, header http.Header, ns string) *mtomWriter {
+	mw := multipart.NewWriter(w)
+	header.Set("Content-Type", fmt.Sprintf(`multipart/related; type="application/xop+xml"; start="<root>"; start-info=%q; boundary=%q`,
+		soapMediaType(ns), mw.Boundary()))
+	return &mtomWriter{mw: mw}
+}
+
+// root creates the root part, after the headers have been written.
+func (m *mtomWriter) root(ns string) (io.Writer, error) {
+	return m.mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {fmt.Sprintf(`application/xop+xml; charset=utf-8; type=%q`, soapMediaType(ns))},
+		"Content-Transfer-Encoding": {"8bit"},
+		"Content-ID":                {"<root>"},
+	})
+}
+
+// attach the content, returning its Content-ID.
+func (m *mtomWriter) attach(b []byte) string {
+	m.attachments = append(m.attachments, b)
+	return fmt.Sprintf("att%d@grpcer", len(m.attachments))
+}
+
+// Close writes the attachments and the closing boundary.
+func (m *mtomWriter) Close() error {
+	for i, b := range m.attachments {
+		w, err := m.mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"application/octet-stream"},
+			"Content-Transfer-Encoding": {"binary"},
+			"Content-ID":                {fmt.Sprintf("<att%d@grpcer>", i+1)},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(b); err != nil {
+			return err
+		}
+	}
+	return m.mw.Close()
+}
@@ -0,0 +1,245 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"encoding/json"
+	"strings"
+
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// OpenAPIInfo is the info object of the generated OpenAPI document.
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+	// Servers are the base URLs of the JSONHandler.
+	Servers []string `json:"-"`
+	// Methods is the allowlist of the documented methods, all are documented if empty.
+	Methods []string `json:"-"`
+}
+
+type openAPIDoc struct {
+	OpenAPI    string                 `json:"openapi"`
+	Info       OpenAPIInfo            `json:"info"`
+	Servers    []openAPIServer        `json:"servers,omitempty"`
+	Paths      map[string]openAPIPath `json:"paths"`
+	Components struct {
+		Schemas map[string]*openAPISchema `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+type openAPIPath struct {
+	Post openAPIOperation `json:"post"`
+}
+
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary,omitempty"`
+	Description string                     `json:"description,omitempty"`
+	Deprecated  bool                       `json:"deprecated,omitempty"`
+	RequestBody openAPIBody                `json:"requestBody"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIBody struct {
+	Required bool                        `json:"required,omitempty"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Description          string                    `json:"description,omitempty"`
+	Enum                 []string                  `json:"enum,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+}
+
+// OpenAPI returns the OpenAPI 3 document (as JSON) of the JSONHandler serving c.
+//
+// Each method is a POST operation at "/" + name, with the JSON input as the request body.
+// The method comments and deprecations are taken from ListMethods, the schemas from Describe.
+// The responses of the server streaming methods are documented as one JSON message per line.
+func OpenAPI(c Client, info OpenAPIInfo) ([]byte, error) {
+	doc := openAPIDoc{OpenAPI: "3.0.3", Info: info, Paths: make(map[string]openAPIPath)}
+	doc.Components.Schemas = map[string]*openAPISchema{
+		"Error": {Type: "object", Properties: map[string]*openAPISchema{"Error": {Type: "string"}}},
+	}
+	for _, s := range info.Servers {
+		doc.Servers = append(doc.Servers, openAPIServer{URL: s})
+	}
+	errResp := openAPIResponse{
+		Description: "Error",
+		Content:     map[string]openAPIMediaType{"application/json": {Schema: &openAPISchema{Ref: openAPIRef("Error")}}},
+	}
+	for _, mm := range ListMethods(c) {
+		if !allowedMethod(info.Methods, mm.Name) {
+			continue
+		}
+		mi, err := Describe(c, mm.Name)
+		if err != nil {
+			return nil, err
+		}
+		inp := c.Input(mm.Name)
+		_, proto := inp.(protoReflecter)
+		_, protoJSON := inp.(*dynamicpb.Message)
+		sg := schemaGen{schemas: doc.Components.Schemas, proto: proto, protoJSON: protoJSON}
+		desc := mm.Comment
+		if desc == "" {
+			desc = mi.Comment
+		}
+		op := openAPIOperation{
+			OperationID: mm.Name, Description: desc, Deprecated: mm.Deprecated,
+			RequestBody: openAPIBody{Required: true, Content: map[string]openAPIMediaType{
+				"application/json": {Schema: sg.message(mi.Input)},
+			}},
+			Responses: map[string]openAPIResponse{"default": errResp},
+		}
+		op.Summary, _, _ = strings.Cut(desc, "\n")
+		ok := openAPIResponse{Description: "OK", Content: map[string]openAPIMediaType{
+			"application/json": {Schema: sg.message(mi.Output)},
+		}}
+		if mm.ServerStreaming || mi.ServerStreaming {
+			ok.Description = "OK, a stream of JSON messages, one per line (merged into one with ?merge=1)"
+		}
+		op.Responses["200"] = ok
+		doc.Paths["/"+strings.TrimPrefix(mm.Name, "/")] = openAPIPath{Post: op}
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+func openAPIRef(name string) string { return "#/components/schemas/" + name }
+
+// schemaGen collects the message schemas as components.
+type schemaGen struct {
+	schemas map[string]*openAPISchema
+	// proto is set for the protobuf messages, whose fields are named as in the proto file.
+	proto bool
+	// protoJSON is set for the dynamic messages, which are encoded with protojson.
+	protoJSON bool
+}
+
+// message returns the reference to the message's schema, registering it.
+func (sg schemaGen) message(mi MessageInfo) *openAPISchema {
+	if s := sg.wellKnown(mi.Name); s != nil {
+		return s
+	}
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, mi.Name)
+	if mi.Fields == nil {
+		// The recursive references are described without fields.
+		if _, ok := sg.schemas[name]; ok {
+			return &openAPISchema{Ref: openAPIRef(name)}
+		}
+		// Not a struct (such as a map).
+		return &openAPISchema{Type: "object"}
+	}
+	s := &openAPISchema{Type: "object", Description: mi.Comment, Properties: make(map[string]*openAPISchema, len(mi.Fields))}
+	sg.schemas[name] = s
+	for _, fi := range mi.Fields {
+		// The generated messages are encoded by their json tags, which are the proto names.
+		key := fi.Name
+		if sg.protoJSON || !sg.proto && fi.JSONName != "" {
+			key = fi.JSONName
+		}
+		if key == "-" {
+			continue
+		}
+		fs := sg.field(fi)
+		if fi.Comment != "" {
+			fs.Description = fi.Comment
+		}
+		switch {
+		case fi.Map:
+			fs = &openAPISchema{Type: "object", Description: fs.Description, AdditionalProperties: fs}
+		case fi.Repeated:
+			fs = &openAPISchema{Type: "array", Description: fs.Description, Items: fs}
+		}
+		s.Properties[key] = fs
+	}
+	return &openAPISchema{Ref: openAPIRef(name)}
+}
+
+// field returns the schema of one value of the field.
+func (sg schemaGen) field(fi FieldInfo) *openAPISchema {
+	if fi.Message != nil {
+		return sg.message(*fi.Message)
+	}
+	if fi.Enum != nil {
+		if sg.protoJSON {
+			return &openAPISchema{Type: "string", Enum: fi.Enum}
+		}
+		return &openAPISchema{Type: "integer", Format: "int32", Description: strings.Join(fi.Enum, ", ")}
+	}
+	switch t := strings.TrimLeft(fi.Type, "*"); t {
+	case "bool":
+		return &openAPISchema{Type: "boolean"}
+	case "string":
+		return &openAPISchema{Type: "string"}
+	case "bytes", "[]uint8", "[]byte":
+		return &openAPISchema{Type: "string", Format: "byte"}
+	case "float", "float32":
+		return &openAPISchema{Type: "number", Format: "float"}
+	case "double", "float64":
+		return &openAPISchema{Type: "number", Format: "double"}
+	case "int32", "sint32", "sfixed32", "int8", "int16", "uint8", "uint16":
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case "uint32", "fixed32", "int", "int64", "uint", "uint64", "sint64", "sfixed64", "fixed64":
+		if sg.protoJSON && t != "uint32" && t != "fixed32" {
+			return &openAPISchema{Type: "string", Format: "int64"}
+		}
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	default:
+		if s := sg.wellKnown(t); s != nil {
+			return s
+		}
+		return &openAPISchema{}
+	}
+}
+
+// wellKnown returns the schema of the specially encoded types, nil for the others.
+func (sg schemaGen) wellKnown(name string) *openAPISchema {
+	switch name {
+	case "time.Time", "google.protobuf.Timestamp":
+		return &openAPISchema{Type: "string", Format: "date-time"}
+	case "google.protobuf.Duration":
+		if sg.protoJSON {
+			return &openAPISchema{Type: "string"}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+type openAPIInput struct {
+	Name  string   `json:"name"`
+	Tags  []string `json:"tags,omitempty"`
+	Child *openAPIInput
+	Skip  int `json:"-"`
+}
+
+type openAPIClient struct{}
+
+func (openAPIClient) List() []string                 { return []string{"Get"} }
+func (openAPIClient) Input(name string) interface{}  { return new(openAPIInput) }
+func (openAPIClient) Output(name string) interface{} { return new(map[string]interface{}) }
+func (openAPIClient) Call(name string, ctx context.Context, input interface{}, opts ...grpc.CallOption) (Receiver, error) {
+	return &receiver{}, nil
+}
+
+func TestOpenAPI(t *testing.T) {
+	type doc struct {
+		Info  OpenAPIInfo
+		Paths map[string]struct {
+			Post struct {
+				OperationID string
+				Description string
+				RequestBody struct {
+					Content map[string]struct{ Schema openAPISchema }
+				}
+				Responses map[string]struct {
+					Description string
+					Content     map[string]struct{ Schema openAPISchema }
+				}
+			}
+		}
+		Components struct {
+			Schemas map[string]openAPISchema
+		}
+	}
+	b, err := OpenAPI(healthClient{}, OpenAPIInfo{Title: "health", Version: "1.0", Methods: []string{"Watch"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(string(b))
+	var d doc
+	if err := json.Unmarshal(b, &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Info.Title != "health" || len(d.Paths) != 1 {
+		t.Fatalf("got %+v", d)
+	}
+	op := d.Paths["/Watch"].Post
+	if got, want := op.RequestBody.Content["application/json"].Schema.Ref, "#/components/schemas/grpc.health.v1.HealthCheckRequest"; got != want {
+		t.Errorf("request schema: got %q, wanted %q", got, want)
+	}
+	if op.Responses["200"].Content["application/json"].Schema.Ref == "" || op.Responses["default"].Content == nil {
+		t.Errorf("responses: %+v", op.Responses)
+	}
+	if s := d.Components.Schemas["grpc.health.v1.HealthCheckRequest"]; s.Properties["service"] == nil || s.Properties["service"].Type != "string" {
+		t.Errorf("request: %+v", s)
+	}
+	if s := d.Components.Schemas["grpc.health.v1.HealthCheckResponse"]; s.Properties["status"] == nil || s.Properties["status"].Type != "integer" {
+		t.Errorf("response: %+v", s)
+	}
+
+	if b, err = OpenAPI(openAPIClient{}, OpenAPIInfo{Title: "structs"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Log(string(b))
+	d = doc{}
+	if err := json.Unmarshal(b, &d); err != nil {
+		t.Fatal(err)
+	}
+	s, ok := d.Components.Schemas["grpcer.openAPIInput"]
+	if !ok {
+		t.Fatalf("no input schema in %+v", d.Components.Schemas)
+	}
+	if len(s.Properties) != 3 || s.Properties["tags"].Items == nil || s.Properties["Child"].Ref != "#/components/schemas/grpcer.openAPIInput" {
+		t.Errorf("input: %+v", s.Properties)
+	}
+	if got := d.Paths["/Get"].Post.Responses["200"].Content["application/json"].Schema.Type; got != "object" {
+		t.Errorf("output: got %q, wanted object", got)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// prefixRouter inserts the path prefix before the method,
+// trying the next prefix when the server returns Unimplemented.
+type prefixRouter struct {
+	prefixes  []string
+	overrides map[string]string
+	working   sync.Map // method -> prefix
+	Log       func(...interface{}) error
+}
+
+func newPrefixRouter(prefixes []string, overrides map[string]string, Log func(...interface{}) error) *prefixRouter {
+	if len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+	return &prefixRouter{prefixes: prefixes, overrides: overrides, Log: Log}
+}
+
+// candidates returns the prefixes to try for the method, in order.
+func (pr *prefixRouter) candidates(method string) []string {
+	if p, ok := pr.overrides[method]; ok {
+		return []string{p}
+	}
+	if i := strings.LastIndexByte(method, '/'); i >= 0 {
+		if p, ok := pr.overrides[method[i+1:]]; ok {
+			return []string{p}
+		}
+	}
+	if p, ok := pr.working.Load(method); ok {
+		return []string{p.(string)}
+	}
+	return pr.prefixes
+}
+
+// found records that the method is served under the prefix.
+func (pr *prefixRouter) found(method, prefix string) {
+	if len(pr.prefixes) > 1 {
+		pr.working.Store(method, prefix)
+	}
+}
+
+func (pr *prefixRouter) UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	cands := pr.candidates(method)
+	var err error
+	for i, prefix := range cands {
+		pr.Log("method", method, "prefix", prefix)
+		if err = invoker(ctx, prefix+method, req, reply, cc, opts...); i == len(cands)-1 || status.Code(err) != codes.Unimplemented {
+			if err == nil {
+				pr.found(method, prefix)
+			}
+			return err
+		}
+		pr.Log("msg", "unimplemented, falling back", "method", method, "prefix", prefix)
+	}
+	return err
+}
+
+func (pr *prefixRouter) StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	cands := pr.candidates(method)
+	pr.Log("method", method, "prefix", cands[0])
+	cs, err := streamer(ctx, desc, cc, cands[0]+method, opts...)
+	if err != nil || len(cands) == 1 || desc.ClientStreams {
+		if err == nil && len(cands) == 1 {
+			pr.found(method, cands[0])
+		}
+		return cs, err
+	}
+	return &fallbackStream{
+		ClientStream: cs, pr: pr, cands: cands,
+		ctx: ctx, desc: desc, cc: cc, method: method, streamer: streamer, opts: opts,
+	}, nil
+}
+
+// fallbackStream reopens the (server-streaming) stream with the next prefix
+// when the first RecvMsg returns Unimplemented, replaying the sent request.
+type fallbackStream struct {
+	grpc.ClientStream
+	pr       *prefixRouter
+	cands    []string
+	ctx      context.Context
+	desc     *grpc.StreamDesc
+	cc       *grpc.ClientConn
+	method   string
+	streamer grpc.Streamer
+	opts     []grpc.CallOption
+	sent     []interface{}
+	closed   bool
+	received bool
+}
+
+func (fs *fallbackStream) SendMsg(m interface{}) error {
+	if !fs.received {
+		fs.sent = append(fs.sent, m)
+	}
+	return fs.ClientStream.SendMsg(m)
+}
+
+func (fs *fallbackStream) CloseSend() error {
+	fs.closed = true
+	return fs.ClientStream.CloseSend()
+}
+
+func (fs *fallbackStream) RecvMsg(m interface{}) error {
+	err := fs.ClientStream.RecvMsg(m)
+	for !fs.received && status.Code(err) == codes.Unimplemented && len(fs.cands) > 1 {
+		fs.pr.Log("msg", "unimplemented, falling back", "method", fs.method, "prefix", fs.cands[0])
+		fs.cands = fs.cands[1:]
+		var cs grpc.ClientStream
+		if cs, err = fs.streamer(fs.ctx, fs.desc, fs.cc, fs.cands[0]+fs.method, fs.opts...); err != nil {
+			return err
+		}
+		fs.ClientStream = cs
+		for _, s := range fs.sent {
+			if err = cs.SendMsg(s); err != nil {
+				return err
+			}
+		}
+		if fs.closed {
+			if err = cs.CloseSend(); err != nil {
+				return err
+			}
+		}
+		err = cs.RecvMsg(m)
+	}
+	if !fs.received {
+		fs.received, fs.sent = true, nil
+		if err == nil {
+			fs.pr.found(fs.method, fs.cands[0])
+		}
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeStream is a server-streaming ClientStream which fails with Unimplemented for /v2.
+type fakeStream struct {
+	grpc.ClientStream
+	method string
+	sent   []interface{}
+	n      int
+}
+
+func (fs *fakeStream) SendMsg(m interface{}) error { fs.sent = append(fs.sent, m); return nil }
+func (fs *fakeStream) CloseSend() error            { return nil }
+func (fs *fakeStream) RecvMsg(m interface{}) error {
+	if fs.method[:3] == "/v2" {
+		return status.Error(codes.Unimplemented, fs.method)
+	}
+	if fs.n++; fs.n > 1 {
+		return io.EOF
+	}
+	*(m.(*string)) = fs.method + ":" + fs.sent[0].(string)
+	return nil
+}
+
+func TestPrefixRouter(t *testing.T) {
+	var called []string
+	pr := newPrefixRouter([]string{"/v2", "/v1"}, map[string]string{"Fixed": "/v3"},
+		func(...interface{}) error { return nil })
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		called = append(called, method)
+		if method[:3] == "/v2" {
+			return status.Error(codes.Unimplemented, method)
+		}
+		return nil
+	}
+	ctx := context.Background()
+	for _, method := range []string{"/pkg.Svc/A", "/pkg.Svc/A", "/pkg.Svc/Fixed"} {
+		if err := pr.UnaryClientInterceptor(ctx, method, nil, nil, nil, invoker); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"/v2/pkg.Svc/A", "/v1/pkg.Svc/A", "/v1/pkg.Svc/A", "/v3/pkg.Svc/Fixed"}; !reflect.DeepEqual(called, want) {
+		t.Errorf("got %q, wanted %q", called, want)
+	}
+
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return &fakeStream{method: method}, nil
+	}
+	cs, err := pr.StreamClientInterceptor(ctx, &grpc.StreamDesc{ServerStreams: true}, nil, "/pkg.Svc/S", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cs.SendMsg("req"); err != nil {
+		t.Fatal(err)
+	}
+	if err = cs.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err = cs.RecvMsg(&s); err != nil {
+		t.Fatal(err)
+	}
+	if want := "/v1/pkg.Svc/S:req"; s != want {
+		t.Errorf("got %q, wanted %q", s, want)
+	}
+	if err = cs.RecvMsg(&s); err != io.EOF {
+		t.Errorf("wanted EOF, got %+v", err)
+	}
+	if got := pr.candidates("/pkg.Svc/S"); !reflect.DeepEqual(got, []string{"/v1"}) {
+		t.Errorf("remembered %q", got)
+	}
+}
@@ -109,10 +109,11 @@ func Generate(resp *protoc.CodeGeneratorResponse, req protoc.CodeGeneratorReques
 	for _, root := range roots {
 		root := root
 		pkg := root.GetName()
-		for _, svc := range root.GetService() {
+		for svcIdx, svc := range root.GetService() {
+			svc, comments := svc, methodComments(root, svcIdx)
 			grp.Go(func() error {
 				destFn := strings.TrimSuffix(filepath.Base(pkg), ".proto") + ".grpcer.go"
-				content, err := genGo(destPkg, pkg, svc, root.GetDependency())
+				content, err := genGo(destPkg, pkg, root.GetPackage(), svc, comments, root.GetDependency())
 				mu.Lock()
 				resp.File = append(resp.File, &protoc.CodeGeneratorResponse_File{
 					Name:    &destFn,
@@ -131,6 +132,21 @@ func Generate(resp *protoc.CodeGeneratorResponse, req protoc.CodeGeneratorReques
 	return nil
 }
 
+// methodComments returns the comments of the service's methods, by method name.
+func methodComments(root *descriptor.FileDescriptorProto, svcIdx int) map[string]string {
+	const serviceField, methodField = 6, 2 // FileDescriptorProto.service, ServiceDescriptorProto.method
+	methods := root.GetService()[svcIdx].GetMethod()
+	comments := make(map[string]string, len(methods))
+	for _, loc := range root.GetSourceCodeInfo().GetLocation() {
+		path := loc.GetPath()
+		if len(path) != 4 || path[0] != serviceField || path[1] != int32(svcIdx) || path[2] != methodField || int(path[3]) >= len(methods) {
+			continue
+		}
+		comments[methods[path[3]].GetName()] = strings.TrimSpace(loc.GetLeadingComments() + loc.GetTrailingComments())
+	}
+	return comments
+}
+
 var goTmpl = template.Must(template.
 	New("go").
 	Funcs(template.FuncMap{
@@ -178,8 +194,10 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"strings"
 
 	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 	grpcer "github.com/ngurban/grpcer"
 
     "integration_grpc/proto"
@@ -203,16 +221,39 @@ func (c client) List() []string {
 	return names
 }
 
+// methodPrefix is the prefix of the fully-qualified gRPC method names.
+const methodPrefix = "/{{.FullName}}/"
+
 func (c client) Input(name string) interface{} {
-	iac := c.m[name]
+	iac := c.m[strings.TrimPrefix(name, methodPrefix)]
 	if iac.Input == nil {
 		return nil
 	}
 	return iac.Input()
 }
 
+func (c client) Output(name string) interface{} {
+	iac := c.m[strings.TrimPrefix(name, methodPrefix)]
+	if iac.Output == nil {
+		return nil
+	}
+	return iac.Output()
+}
+
+func (c client) ListMethods() []grpcer.MethodMeta {
+	return []grpcer.MethodMeta{
+	{{range .GetMethod}}{Name: "{{.GetName}}", ClientStreaming: {{.GetClientStreaming}}, ServerStreaming: {{.GetServerStreaming}}, Deprecated: {{.GetOptions.GetDeprecated}}, Comment: {{index $.Comments .GetName | printf "%q"}}},
+	{{end}}
+	}
+}
+
+// OpenAPI returns the OpenAPI 3 document of the HTTP/JSON façade (grpcer.JSONHandler) of the client.
+func (c client) OpenAPI(info grpcer.OpenAPIInfo) ([]byte, error) {
+	return grpcer.OpenAPI(c, info)
+}
+
 func (c client) Call(name string, ctx context.Context, in interface{}, opts ...grpc.CallOption) (grpcer.Receiver, error) {
-	iac := c.m[name]
+	iac := c.m[strings.TrimPrefix(name, methodPrefix)]
 	if iac.Call == nil {
 		return nil, fmt.Errorf("name %q not found", name)
 	}
@@ -225,16 +266,22 @@ func NewClient(cc *grpc.ClientConn) grpcer.Client {
 		m: map[string]inputAndCall{
 		{{range .GetMethod}}"{{.GetName}}": inputAndCall{
 			Input: func() interface{} { return new({{ trimLeftDot .GetInputType | changePkgTo $import "pb" }}) },
+			Output: func() interface{} { return new({{ trimLeftDot .GetOutputType | changePkgTo $import "pb" }}) },
 			Call: func(ctx context.Context, in interface{}, opts ...grpc.CallOption) (grpcer.Receiver, error) {
 				input := in.(*{{ trimLeftDot .GetInputType | changePkgTo $import "pb" }})
+				{{if .GetServerStreaming -}}
+				ctx, cancel := context.WithCancel(ctx)
 				res, err := c.{{.Name}}(ctx, input, opts...)
 				if err != nil {
+					cancel()
 					return &onceRecv{Out:res}, err
 				}
-				{{if .GetServerStreaming -}}
-				return multiRecv(func() (interface{}, error) { return res.Recv() }), nil
+				return streamRecv{ClientStream: res, recv: func() (interface{}, error) { return res.Recv() }, cancel: cancel}, nil
 				{{else -}}
-				return &onceRecv{Out:res}, err
+				o := new(onceRecv)
+				res, err := c.{{.Name}}(ctx, input, o.callOptions(opts)...)
+				o.Out = res
+				return o, err
 				{{end}}
 			},
 		},
@@ -245,12 +292,14 @@ func NewClient(cc *grpc.ClientConn) grpcer.Client {
 
 type inputAndCall struct {
 	Input func() interface{}
+	Output func() interface{}
 	Call func(ctx context.Context, in interface{}, opts ...grpc.CallOption) (grpcer.Receiver, error)
 }
 
 type onceRecv struct {
 	Out interface{}
 	done bool
+	header, trailer metadata.MD
 }
 func (o *onceRecv) Recv() (interface{}, error) {
 	if o.done {
@@ -260,29 +309,50 @@ func (o *onceRecv) Recv() (interface{}, error) {
 	o.done, o.Out = true, nil
 	return out, nil
 }
+func (o *onceRecv) Header() (metadata.MD, error) { return o.header, nil }
+func (o *onceRecv) Trailer() metadata.MD { return o.trailer }
+func (o *onceRecv) callOptions(opts []grpc.CallOption) []grpc.CallOption {
+	return append(append(make([]grpc.CallOption, 0, len(opts)+2), opts...),
+		grpc.Header(&o.header), grpc.Trailer(&o.trailer))
+}
 
-type multiRecv func() (interface{}, error)
-func (m multiRecv) Recv() (interface{}, error) {
-	return m()
+type streamRecv struct {
+	grpc.ClientStream
+	recv func() (interface{}, error)
+	cancel context.CancelFunc
+}
+func (s streamRecv) Recv() (interface{}, error) {
+	part, err := s.recv()
+	if err != nil {
+		s.cancel()
+	}
+	return part, err
+}
+// Close aborts the stream.
+func (s streamRecv) Close() error {
+	s.cancel()
+	return nil
 }
 
-var _ = multiRecv(nil) // against "unused"
+var _ = grpcer.ReceiverWithMetadata((*onceRecv)(nil))
+var _ = grpcer.ReceiverWithMetadata(streamRecv{})
+var _ = io.Closer(streamRecv{})
 
 `))
 
-func genGo(destPkg, protoFn string, svc *descriptor.ServiceDescriptorProto, dependencies []string) (string, error) {
+func genGo(destPkg, protoFn, protoPkg string, svc *descriptor.ServiceDescriptorProto, comments map[string]string, dependencies []string) (string, error) {
 	if destPkg == "" {
 		destPkg = "main"
 	}
 	needed := make(map[string]struct{}, len(dependencies))
 	for _, m := range svc.GetMethod() {
-		//for _, t := range []string{m.GetInputType(), m.GetOutputType()} {
-		t := m.GetInputType()
-		if !strings.HasPrefix(t, ".") {
-			continue
+		for _, t := range []string{m.GetInputType(), m.GetOutputType()} {
+			if !strings.HasPrefix(t, ".") {
+				continue
+			}
+			t = t[1:]
+			needed[strings.SplitN(t, ".", 2)[0]] = struct{}{}
 		}
-		t = t[1:]
-		needed[strings.SplitN(t, ".", 2)[0]] = struct{}{}
 	}
 	deps := make([]string, 0, len(dependencies))
 	for _, dep := range dependencies {
@@ -294,11 +364,14 @@ func genGo(destPkg, protoFn string, svc *descriptor.ServiceDescriptorProto, depe
 	}
 	var buf bytes.Buffer
 	err := goTmpl.Execute(&buf, struct {
-		ProtoFile, Package, Import string
-		Dependencies               []string
+		ProtoFile, Package, Import, FullName string
+		Dependencies                         []string
+		Comments                             map[string]string
 		*descriptor.ServiceDescriptorProto
 	}{
+		Comments:               comments,
 		ProtoFile:              protoFn,
+		FullName:               strings.TrimPrefix(protoPkg+"."+svc.GetName(), "."),
 		Package:                destPkg,
 		Import:                 filepath.Dir(protoFn),
 		Dependencies:           deps,
@@ -0,0 +1,205 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// APIKeyHeader is the default HTTP header of the API key.
+const APIKeyHeader = "X-API-Key"
+
+var (
+	// ErrQuotaExceeded is returned when the daily or monthly quota of the API key is used up.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrMissingAPIKey is returned when the request has no API key.
+	ErrMissingAPIKey = errors.New("missing API key")
+	// ErrUnknownAPIKey is returned when the API key is not accepted by Quota.Valid.
+	ErrUnknownAPIKey = errors.New("unknown API key")
+)
+
+// Usage is the number of requests and the streamed response bytes.
+type Usage struct {
+	Requests int64 `json:"requests"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// exceeds reports whether u reached any non-zero limit.
+func (u Usage) exceeds(limit Usage) bool {
+	return limit.Requests > 0 && u.Requests >= limit.Requests ||
+		limit.Bytes > 0 && u.Bytes >= limit.Bytes
+}
+
+// Quota accounts the usage by API key, and enforces the daily and monthly limits.
+//
+// The usage is kept in memory only, for the current month. Quota must not be copied after first use.
+type Quota struct {
+	// KeyFunc returns the API key of the request, the APIKeyHeader if nil.
+	KeyFunc func(*http.Request) string
+	// Valid reports whether the API key is known (see KnownKeys), only those are accounted.
+	// Every key is rejected if nil.
+	Valid func(key string) bool
+	// Daily and Monthly are the limits, zero fields are unlimited.
+	Daily, Monthly Usage
+
+	mu    sync.Mutex
+	usage map[string]*keyUsage
+	month string // of the last eviction
+	now   func() time.Time
+}
+
+// KnownKeys returns a Quota.Valid func accepting the given keys.
+func KnownKeys(keys ...string) func(string) bool {
+	m := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		m[k] = struct{}{}
+	}
+	return func(key string) bool {
+		_, ok := m[key]
+		return ok
+	}
+}
+
+type keyUsage struct {
+	day, month     string
+	daily, monthly Usage
+}
+
+func (q *Quota) key(r *http.Request) string {
+	if q.KeyFunc != nil {
+		return q.KeyFunc(r)
+	}
+	return r.Header.Get(APIKeyHeader)
+}
+
+// valid reports whether the key is accepted.
+func (q *Quota) valid(key string) bool { return key != "" && q.Valid != nil && q.Valid(key) }
+
+// get returns the usage of key, in the current period, evicting the past months' usages.
+//
+// Must be called with q.mu held.
+func (q *Quota) get(key string) *keyUsage {
+	now := time.Now
+	if q.now != nil {
+		now = q.now
+	}
+	t := now().UTC()
+	day, month := t.Format("2006-01-02"), t.Format("2006-01")
+	if q.usage == nil {
+		q.usage = make(map[string]*keyUsage)
+	}
+	if q.month != month {
+		for k, ku := range q.usage {
+			if ku.month != month {
+				delete(q.usage, k)
+			}
+		}
+		q.month = month
+	}
+	ku := q.usage[key]
+	if ku == nil {
+		ku = &keyUsage{day: day, month: month}
+		q.usage[key] = ku
+	}
+	if ku.day != day {
+		ku.day, ku.daily = day, Usage{}
+	}
+	if ku.month != month {
+		ku.month, ku.monthly = month, Usage{}
+	}
+	return ku
+}
+
+// Allow a request of key, counting it.
+//
+// Returns ErrUnknownAPIKey if the key is not Valid, ErrQuotaExceeded if its quota is used up.
+func (q *Quota) Allow(key string) error {
+	if !q.valid(key) {
+		return ErrUnknownAPIKey
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ku := q.get(key)
+	if ku.daily.exceeds(q.Daily) || ku.monthly.exceeds(q.Monthly) {
+		return ErrQuotaExceeded
+	}
+	ku.daily.Requests++
+	ku.monthly.Requests++
+	return nil
+}
+
+// AddBytes adds n streamed bytes to the usage of key.
+func (q *Quota) AddBytes(key string, n int64) {
+	if !q.valid(key) {
+		return
+	}
+	q.mu.Lock()
+	ku := q.get(key)
+	ku.daily.Bytes += n
+	ku.monthly.Bytes += n
+	q.mu.Unlock()
+}
+
+// Usage returns the daily and monthly usage of key.
+func (q *Quota) Usage(key string) (daily, monthly Usage) {
+	if !q.valid(key) {
+		return daily, monthly
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ku := q.get(key)
+	return ku.daily, ku.monthly
+}
+
+// ServeHTTP returns the usage and the limits of the request's API key as JSON.
+func (q *Quota) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := q.key(r)
+	if key == "" {
+		jsonError(w, ErrMissingAPIKey.Error(), http.StatusUnauthorized)
+		return
+	}
+	if !q.valid(key) {
+		jsonError(w, ErrUnknownAPIKey.Error(), http.StatusUnauthorized)
+		return
+	}
+	daily, monthly := q.Usage(key)
+	w.Header().Set("Content-Type", "application/json")
+	jsoniter.NewEncoder(w).Encode(struct {
+		Daily        Usage `json:"daily"`
+		Monthly      Usage `json:"monthly"`
+		DailyLimit   Usage `json:"dailyLimit"`
+		MonthlyLimit Usage `json:"monthlyLimit"`
+	}{daily, monthly, q.Daily, q.Monthly})
+}
+
+// countingWriter counts the bytes written.
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.ResponseWriter.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+func (cw *countingWriter) Unwrap() http.ResponseWriter { return cw.ResponseWriter }
@@ -0,0 +1,81 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuota(t *testing.T) {
+	now := time.Date(2020, 9, 30, 23, 0, 0, 0, time.UTC)
+	q := &Quota{Valid: KnownKeys("a", "b"), Daily: Usage{Requests: 2}, Monthly: Usage{Requests: 3}, now: func() time.Time { return now }}
+	h := JSONHandler{
+		Client: fakeClient{"Get": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return &receiver{parts: []interface{}{"0123456789"}}, nil
+		}},
+		Quota: q,
+	}
+	call := func(key string) int {
+		r := httptest.NewRequest("POST", "/Get", strings.NewReader("{}"))
+		if key != "" {
+			r.Header.Set(APIKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := call(""); code != http.StatusUnauthorized {
+		t.Errorf("no key: got %d", code)
+	}
+	if code := call("x"); code != http.StatusUnauthorized {
+		t.Errorf("unknown key: got %d", code)
+	}
+	for i, want := range []int{200, 200, http.StatusTooManyRequests} {
+		if code := call("a"); code != want {
+			t.Errorf("%d. got %d, wanted %d", i, code, want)
+		}
+	}
+	if code := call("b"); code != 200 {
+		t.Errorf("other key: got %d", code)
+	}
+	daily, monthly := q.Usage("a")
+	if daily.Requests != 2 || daily.Bytes != 2*int64(len(`"0123456789"`+"\n")) || monthly != daily {
+		t.Errorf("usage: %+v %+v", daily, monthly)
+	}
+
+	now = now.Add(2 * time.Hour) // next day, next month
+	if code := call("a"); code != 200 {
+		t.Errorf("next day: got %d", code)
+	}
+	q.mu.Lock()
+	if len(q.usage) != 1 || q.usage["x"] != nil {
+		t.Errorf("the unknown and the past month's keys are kept: %v", q.usage)
+	}
+	q.mu.Unlock()
+
+	r := httptest.NewRequest("GET", "/usage", nil)
+	r.Header.Set(APIKeyHeader, "a")
+	w := httptest.NewRecorder()
+	q.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), `"daily":{"requests":1,`) {
+		t.Errorf("usage endpoint: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// ReflectionName is the path element of the JSONHandler's reflection endpoint.
+const ReflectionName = "$reflection"
+
+// Reflection is the response of the reflection endpoint.
+type Reflection struct {
+	Methods []MethodInfo `json:"methods"`
+	// Files are the FileDescriptorProtos (as protojson) of the proto methods.
+	Files []jsoniter.RawMessage `json:"files,omitempty"`
+}
+
+// allowedMethod reports whether name is in the allowlist (everything is allowed if empty).
+func allowedMethod(allow []string, name string) bool {
+	if len(allow) == 0 {
+		return true
+	}
+	for _, a := range allow {
+		if a == name {
+			return true
+		}
+	}
+	return false
+}
+
+// reflectMethods describes the allowed methods of c (only the named one, if not empty).
+func reflectMethods(c Client, allow []string, name string) (Reflection, error) {
+	var refl Reflection
+	names := c.List()
+	if name != "" {
+		names = []string{name}
+	}
+	sort.Strings(names)
+	files := make(map[string]protoreflect.FileDescriptor)
+	methods := make(map[protoreflect.FullName]bool)
+	for _, nm := range names {
+		if !allowedMethod(allow, nm) {
+			continue
+		}
+		mi, err := Describe(c, nm)
+		if err != nil {
+			return refl, err
+		}
+		refl.Methods = append(refl.Methods, mi)
+		if pr, ok := c.Input(nm).(protoReflecter); ok {
+			in := pr.ProtoReflect().Descriptor()
+			fd := in.ParentFile()
+			if md := findMethod(in, nm[strings.LastIndexByte(nm, '/')+1:]); md != nil {
+				methods[md.FullName()] = true
+				fd = md.ParentFile()
+			}
+			files[fd.Path()] = fd
+		}
+	}
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		b, err := protojson.Marshal(stripServices(protodesc.ToFileDescriptorProto(files[p]), methods))
+		if err != nil {
+			return refl, err
+		}
+		refl.Files = append(refl.Files, b)
+	}
+	return refl, nil
+}
+
+// stripServices removes the methods not in allowed from fdp, and the services left empty.
+//
+// The source code info is removed, too, if anything is removed, as its paths are index based.
+func stripServices(fdp *descriptorpb.FileDescriptorProto, allowed map[protoreflect.FullName]bool) *descriptorpb.FileDescriptorProto {
+	svcs := fdp.Service[:0]
+	var stripped bool
+	for _, svc := range fdp.Service {
+		prefix := svc.GetName() + "."
+		if pkg := fdp.GetPackage(); pkg != "" {
+			prefix = pkg + "." + prefix
+		}
+		mds := svc.Method[:0]
+		for _, md := range svc.Method {
+			if allowed[protoreflect.FullName(prefix+md.GetName())] {
+				mds = append(mds, md)
+			}
+		}
+		stripped = stripped || len(mds) != len(svc.Method)
+		if svc.Method = mds; len(mds) != 0 {
+			svcs = append(svcs, svc)
+		}
+	}
+	if fdp.Service = svcs; stripped {
+		fdp.SourceCodeInfo = nil
+	}
+	return fdp
+}
+
+// serveReflection writes the Reflection of the handler's Client.
+func (h JSONHandler) serveReflection(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("method")
+	if name != "" && (h.Input(name) == nil || !allowedMethod(h.Methods, name)) {
+		jsonError(w, notFoundMessage(h.Client, name), http.StatusNotFound)
+		return
+	}
+	refl, err := reflectMethods(h.Client, h.Methods, name)
+	if err != nil {
+		jsonError(w, err.Error(), statusCodeFromError(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	jsoniter.NewEncoder(w).Encode(refl)
+}
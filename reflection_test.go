@@ -0,0 +1,60 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+func TestReflection(t *testing.T) {
+	c, err := NewDescriptorClient(testDescriptorSet(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := JSONHandler{Client: c, Reflection: true, Methods: []string{"Hello"}}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/"+ReflectionName, nil))
+	var refl struct {
+		Methods []MethodInfo
+		Files   []map[string]interface{}
+	}
+	if err := jsoniter.Unmarshal(w.Body.Bytes(), &refl); err != nil {
+		t.Fatalf("%s: %+v", w.Body.String(), err)
+	}
+	if len(refl.Methods) != 1 || refl.Methods[0].Name != "Hello" || refl.Methods[0].Output.Name != "test.Resp" {
+		t.Errorf("methods: %+v", refl.Methods)
+	}
+	if len(refl.Files) != 1 || refl.Files[0]["name"] != "test.proto" {
+		t.Fatalf("files: %+v", refl.Files)
+	}
+	// Hellos is in the same service, but not allowed
+	if svcs, _ := refl.Files[0]["service"].([]interface{}); len(svcs) != 1 ||
+		len(svcs[0].(map[string]interface{})["method"].([]interface{})) != 1 {
+		t.Errorf("services: %+v", refl.Files[0]["service"])
+	}
+
+	for _, path := range []string{"/api/" + ReflectionName + "?method=Hellos", "/api/Hellos"} {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader("{}")))
+		if w.Code != 404 {
+			t.Errorf("%s: not allowed method got %d", path, w.Code)
+		}
+	}
+}
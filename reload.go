@@ -0,0 +1,100 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReloadableHandler serves with the handler returned by the last successful Load.
+//
+// The in-flight requests finish with the handler they started with.
+type ReloadableHandler struct {
+	// Load reads the configuration, validates it and returns the handler built from it.
+	Load func() (http.Handler, error)
+	Log  func(...interface{}) error
+
+	mu      sync.Mutex
+	handler atomic.Pointer[http.Handler]
+}
+
+// Reload calls Load, and switches to its handler on success.
+// On error the previous handler stays in use.
+func (rh *ReloadableHandler) Reload() error {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	h, err := rh.Load()
+	if err == nil && h == nil {
+		err = errors.New("nil handler")
+	}
+	if err != nil {
+		if rh.Log != nil {
+			rh.Log("msg", "reload", "error", err)
+		}
+		return err
+	}
+	rh.handler.Store(&h)
+	return nil
+}
+
+// ServeHTTP with the current handler, loading it on first use.
+func (rh *ReloadableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hp := rh.handler.Load()
+	if hp == nil {
+		if err := rh.Reload(); err != nil {
+			jsonError(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		hp = rh.handler.Load()
+	}
+	(*hp).ServeHTTP(w, r)
+}
+
+// Watch the modification time of the file at path in every interval, and Reload on change,
+// till the context is canceled.
+func (rh *ReloadableHandler) Watch(ctx context.Context, path string, interval time.Duration) error {
+	var last time.Time
+	if fi, err := os.Stat(path); err == nil {
+		last = fi.ModTime()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			if rh.Log != nil {
+				rh.Log("msg", "watch", "path", path, "error", err)
+			}
+			continue
+		}
+		if fi.ModTime().Equal(last) {
+			continue
+		}
+		last = fi.ModTime()
+		_ = rh.Reload()
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReloadableHandler(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(fn, []byte("one"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rh := &ReloadableHandler{Load: func() (http.Handler, error) {
+		b, err := os.ReadFile(fn)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(string(b), "bad") {
+			return nil, errors.New("invalid config")
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write(b) }), nil
+	}}
+	get := func() string {
+		w := httptest.NewRecorder()
+		rh.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		b, _ := io.ReadAll(w.Body)
+		return string(b)
+	}
+	if got := get(); got != "one" {
+		t.Errorf("got %q", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go rh.Watch(ctx, fn, time.Millisecond)
+	time.Sleep(20 * time.Millisecond) // let Watch stat the file
+	waitFor := func(want string) {
+		t.Helper()
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			if got := get(); got == want {
+				return
+			}
+		}
+		t.Errorf("wanted %q, got %q", want, get())
+	}
+	later := time.Now().Add(time.Second)
+	os.WriteFile(fn, []byte("two"), 0600)
+	os.Chtimes(fn, later, later)
+	waitFor("two")
+
+	later = later.Add(time.Second)
+	os.WriteFile(fn, []byte("bad"), 0600)
+	os.Chtimes(fn, later, later)
+	time.Sleep(20 * time.Millisecond)
+	if got := get(); got != "two" {
+		t.Errorf("invalid config applied: %q", got)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Renames are the checked output key renames, see CompileRenames.
+type Renames struct {
+	m map[string]string
+}
+
+// CompileRenames checks the renames (old to new), rejecting the new names shared by more old ones.
+func CompileRenames(renames map[string]string) (*Renames, error) {
+	olds := make(map[string][]string, len(renames))
+	for k, nk := range renames {
+		olds[nk] = append(olds[nk], k)
+	}
+	for nk, ks := range olds {
+		if len(ks) > 1 {
+			sort.Strings(ks)
+			return nil, fmt.Errorf("%s are all renamed to %q", strings.Join(ks, ", "), nk)
+		}
+	}
+	return &Renames{m: renames}, nil
+}
+
+// key returns the new name of k.
+func (r *Renames) key(k string) string {
+	if nk, ok := r.m[k]; ok {
+		return nk
+	}
+	return k
+}
+
+// Apply returns part as a generic JSON value, with its object keys renamed, at any depth.
+func (r *Renames) Apply(part interface{}) (interface{}, error) {
+	b, err := numberJSON.Marshal(part)
+	if err != nil {
+		return nil, fmt.Errorf("marshal %T: %w", part, err)
+	}
+	var v interface{}
+	if err = numberJSON.Unmarshal(b, &v); err != nil {
+		return nil, fmt.Errorf("unmarshal %s: %w", b, err)
+	}
+	return renameKeys(v, r.m), nil
+}
+
+// RenameKeys returns part as a generic JSON value, with its object keys renamed
+// by renames (old to new), at any depth.
+func RenameKeys(part interface{}, renames map[string]string) (interface{}, error) {
+	r, err := CompileRenames(renames)
+	if err != nil {
+		return nil, err
+	}
+	return r.Apply(part)
+}
+
+func renameKeys(v interface{}, renames map[string]string) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			if nk, ok := renames[k]; ok {
+				k = nk
+			}
+			res[k] = renameKeys(e, renames)
+		}
+		return res
+	case []interface{}:
+		for i, e := range x {
+			x[i] = renameKeys(e, renames)
+		}
+	}
+	return v
+}
+
+// renameReceiver renames the keys of each part.
+type renameReceiver struct {
+	Receiver
+	renames *Renames
+}
+
+func (rr renameReceiver) Recv() (interface{}, error) {
+	part, err := rr.Receiver.Recv()
+	if err != nil {
+		return part, err
+	}
+	return rr.renames.Apply(part)
+}
+
+func (rr renameReceiver) Close() error { return CloseReceiver(rr.Receiver) }
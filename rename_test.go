@@ -0,0 +1,68 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenames(t *testing.T) {
+	type item struct {
+		Cust_Id int64
+		Name    string
+	}
+	type output struct {
+		Cust_Id int64
+		Items   []item
+	}
+	if _, err := CompileRenames(map[string]string{"a": "x", "b": "x"}); err == nil {
+		t.Error("colliding renames accepted")
+	}
+	renames, err := CompileRenames(map[string]string{"Cust_Id": "customerId", "Items": "items"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := JSONHandler{
+		Client: fakeClient{"Get": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return &receiver{parts: []interface{}{
+				&output{Cust_Id: 9007199254740993, Items: []item{{Cust_Id: 2, Name: "a"}}},
+				&output{Cust_Id: 9007199254740993, Items: []item{{Cust_Id: 3, Name: "b"}}},
+			}}, nil
+		}},
+		MergeStreams: true,
+		Renames:      map[string]*Renames{"Get": renames},
+	}
+	for query, wantBody := range map[string]string{
+		"":         `{"customerId":9007199254740993,"items":[{"Name":"a","customerId":2},{"Name":"b","customerId":3}]}`,
+		"?merge=0": `{"customerId":9007199254740993,"items":[{"Name":"a","customerId":2}]}`,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/Get"+query, strings.NewReader("{}")))
+		var got, want interface{}
+		// the first object
+		if err := numberJSON.NewDecoder(strings.NewReader(w.Body.String())).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		_ = numberJSON.UnmarshalFromString(wantBody, &want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got %s, wanted %v", query, w.Body.String(), want)
+		}
+	}
+}
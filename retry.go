@@ -0,0 +1,444 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// RetryPolicy configures WithRetry.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first (3 if zero).
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry (100ms if zero),
+	// multiplied by Multiplier (2 if zero) for each subsequent retry, up to MaxBackoff (5s if zero).
+	InitialBackoff, MaxBackoff time.Duration
+	Multiplier                 float64
+	// RetryableCodes are the retried status codes (Unavailable if empty;
+	// Unavailable and ResourceExhausted for DialConfig.Retry).
+	RetryableCodes []codes.Code
+	// Idempotent lists the methods to be retried, besides the ones
+	// with NO_SIDE_EFFECTS or IDEMPOTENT idempotency_level option.
+	Idempotent []string
+	// BudgetRatio limits the retries to this ratio of the successful calls (0.1 if zero),
+	// with an initial budget of 10 retries.
+	BudgetRatio float64
+	Log         func(...interface{}) error
+}
+
+type retryClient struct {
+	Client
+	policy     RetryPolicy
+	retryable  map[codes.Code]bool
+	idempotent sync.Map // name -> bool
+	budget     retryBudget
+}
+
+// WithRetry returns a Client which retries the calls of the idempotent methods
+// with exponential backoff - or the RetryInfo delay of the error, if that is longer.
+//
+// A call is retried only if Call or the first Recv returns a retryable error,
+// so no part is received twice.
+func WithRetry(c Client, policy RetryPolicy) Client {
+	policy = policy.withDefaults()
+	rc := &retryClient{
+		Client: c, policy: policy,
+		retryable: policy.retryable(),
+		budget:    retryBudget{tokens: 10, max: 10, ratio: policy.BudgetRatio},
+	}
+	for _, nm := range policy.Idempotent {
+		rc.idempotent.Store(nm, true)
+	}
+	return rc
+}
+
+func (policy RetryPolicy) withDefaults() RetryPolicy {
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 3
+	}
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = 100 * time.Millisecond
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = 5 * time.Second
+	}
+	if policy.Multiplier <= 0 {
+		policy.Multiplier = 2
+	}
+	if len(policy.RetryableCodes) == 0 {
+		policy.RetryableCodes = []codes.Code{codes.Unavailable}
+	}
+	if policy.BudgetRatio <= 0 {
+		policy.BudgetRatio = 0.1
+	}
+	if policy.Log == nil {
+		policy.Log = func(...interface{}) error { return nil }
+	}
+	return policy
+}
+
+func (policy RetryPolicy) retryable() map[codes.Code]bool {
+	m := make(map[codes.Code]bool, len(policy.RetryableCodes))
+	for _, code := range policy.RetryableCodes {
+		m[code] = true
+	}
+	return m
+}
+
+// wait before the next attempt: the jittered backoff, or the RetryInfo delay of err, if that is longer.
+//
+// Returns false if ctx is done (or would be, before the wait is over).
+// The backoff is increased for the next attempt.
+func (policy RetryPolicy) wait(ctx context.Context, backoff *time.Duration, err error) bool {
+	wait := jitter(*backoff)
+	if d, ok := retryDelay(err); ok && d > wait {
+		wait = d
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+		return false
+	}
+	timer := time.NewTimer(wait)
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return false
+	case <-timer.C:
+	}
+	if *backoff = time.Duration(float64(*backoff) * policy.Multiplier); *backoff > policy.MaxBackoff {
+		*backoff = policy.MaxBackoff
+	}
+	return true
+}
+
+// isIdempotent reports whether the method is configured or annotated as idempotent.
+func (rc *retryClient) isIdempotent(name string) bool {
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		name = name[i+1:]
+	}
+	if v, ok := rc.idempotent.Load(name); ok {
+		return v.(bool)
+	}
+	var idempotent bool
+	if pr, ok := rc.Client.Input(name).(protoReflecter); ok {
+		if md := findMethod(pr.ProtoReflect().Descriptor(), name); md != nil {
+			idempotent = idempotentMethod(md)
+		}
+	}
+	rc.idempotent.Store(name, idempotent)
+	return idempotent
+}
+
+// idempotentMethod reports whether the method has NO_SIDE_EFFECTS or IDEMPOTENT idempotency_level option.
+func idempotentMethod(md protoreflect.MethodDescriptor) bool {
+	if opts, ok := md.Options().(*descriptorpb.MethodOptions); ok {
+		switch opts.GetIdempotencyLevel() {
+		case descriptorpb.MethodOptions_NO_SIDE_EFFECTS, descriptorpb.MethodOptions_IDEMPOTENT:
+			return true
+		}
+	}
+	return false
+}
+
+// Call the named method, retrying it if it is idempotent.
+func (rc *retryClient) Call(name string, ctx context.Context, input interface{}, opts ...grpc.CallOption) (Receiver, error) {
+	if !rc.isIdempotent(name) {
+		return rc.Client.Call(name, ctx, input, opts...)
+	}
+	backoff := rc.policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		recv, err := rc.Client.Call(name, ctx, input, opts...)
+		var part interface{}
+		if err == nil {
+			if part, err = recv.Recv(); err == nil {
+				rc.budget.success()
+				return &prefixedReceiver{parts: []interface{}{part}, rest: recv}, nil
+			} else if err == io.EOF {
+				rc.budget.success()
+				return &sliceReceiver{}, nil
+			}
+		}
+		if attempt >= rc.policy.MaxAttempts || !rc.retryable[status.Code(err)] || !rc.budget.retry() {
+			return recv, err
+		}
+		if recv != nil {
+			CloseReceiver(recv)
+		}
+		rc.policy.Log("msg", "retry", "method", name, "attempt", attempt, "backoff", backoff, "error", err)
+		if !rc.policy.wait(ctx, &backoff, err) {
+			return nil, err
+		}
+	}
+}
+
+// jitter returns d randomized by ±20%.
+func jitter(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * (0.8 + 0.4*rand.Float64()))
+}
+
+// retryBudget is a token bucket: each retry costs a token, each success gives ratio tokens.
+type retryBudget struct {
+	mu                 sync.Mutex
+	tokens, max, ratio float64
+}
+
+func (b *retryBudget) success() {
+	b.mu.Lock()
+	if b.tokens += b.ratio; b.tokens > b.max {
+		b.tokens = b.max
+	}
+	b.mu.Unlock()
+}
+
+func (b *retryBudget) retry() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// retryDelay returns the RetryInfo delay of the ResourceExhausted or Unavailable error.
+func retryDelay(err error) (time.Duration, bool) {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.ResourceExhausted && st.Code() != codes.Unavailable {
+		return 0, false
+	}
+	for _, d := range st.Details() {
+		if ri, ok := d.(*errdetails.RetryInfo); ok && ri.GetRetryDelay() != nil {
+			return ri.GetRetryDelay().AsDuration(), true
+		}
+	}
+	return 0, false
+}
+
+// setRetryAfter sets the Retry-After header from the RetryInfo of err.
+func setRetryAfter(w http.ResponseWriter, err error) {
+	if d, ok := retryDelay(err); ok {
+		w.Header().Set("Retry-After", strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10))
+	}
+}
+
+// RetryAttempts overrides the maximum number of attempts of DialConfig.Retry for the call
+// (1 disables the retries), also for the methods not idempotent.
+func RetryAttempts(n int) grpc.CallOption { return retryAttempts{n: n} }
+
+type retryAttempts struct {
+	grpc.EmptyCallOption
+	n int
+}
+
+// retryInterceptor retries the calls failing with a retryable code, see DialConfig.Retry.
+type retryInterceptor struct {
+	policy     RetryPolicy
+	retryable  map[codes.Code]bool
+	budget     retryBudget
+	idempotent sync.Map // method -> bool
+}
+
+func newRetryInterceptor(policy RetryPolicy) *retryInterceptor {
+	if len(policy.RetryableCodes) == 0 {
+		policy.RetryableCodes = []codes.Code{codes.Unavailable, codes.ResourceExhausted}
+	}
+	policy = policy.withDefaults()
+	return &retryInterceptor{
+		policy:    policy,
+		retryable: policy.retryable(),
+		budget:    retryBudget{tokens: 10, max: 10, ratio: policy.BudgetRatio},
+	}
+}
+
+// isIdempotent reports whether the method ("/pkg.Service/Method") is configured
+// (by its full or short name) or annotated as idempotent in the registered files.
+func (ri *retryInterceptor) isIdempotent(method string) bool {
+	if v, ok := ri.idempotent.Load(method); ok {
+		return v.(bool)
+	}
+	full := strings.TrimPrefix(method, "/")
+	short := full[strings.LastIndexByte(full, '/')+1:]
+	var idempotent bool
+	for _, nm := range ri.policy.Idempotent {
+		if nm == short || nm == full || nm == method {
+			idempotent = true
+			break
+		}
+	}
+	if !idempotent {
+		if d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(strings.Replace(full, "/", ".", 1))); err == nil {
+			if md, ok := d.(protoreflect.MethodDescriptor); ok {
+				idempotent = idempotentMethod(md)
+			}
+		}
+	}
+	ri.idempotent.Store(method, idempotent)
+	return idempotent
+}
+
+// attempts returns the maximum number of attempts of the method (1 if not idempotent),
+// and opts without the RetryAttempts.
+func (ri *retryInterceptor) attempts(method string, opts []grpc.CallOption) (int, []grpc.CallOption) {
+	n := ri.policy.MaxAttempts
+	if !ri.isIdempotent(method) {
+		n = 1
+	}
+	filtered := opts[:0:0]
+	for _, o := range opts {
+		if ra, ok := o.(retryAttempts); ok {
+			n = ra.n
+			continue
+		}
+		filtered = append(filtered, o)
+	}
+	return n, filtered
+}
+
+// shouldRetry reports whether the failed attempt should be retried, and waits before it.
+func (ri *retryInterceptor) shouldRetry(ctx context.Context, method string, attempt, maxAttempts int, backoff *time.Duration, err error) bool {
+	if attempt >= maxAttempts || !ri.retryable[status.Code(err)] || !ri.budget.retry() {
+		return false
+	}
+	ri.policy.Log("msg", "retry", "method", method, "attempt", attempt, "backoff", *backoff, "error", err)
+	return ri.policy.wait(ctx, backoff, err)
+}
+
+func (ri *retryInterceptor) UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	maxAttempts, opts := ri.attempts(method, opts)
+	backoff := ri.policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if err == nil {
+			ri.budget.success()
+			return nil
+		}
+		if !ri.shouldRetry(ctx, method, attempt, maxAttempts, &backoff, err) {
+			return err
+		}
+	}
+}
+
+// StreamClientInterceptor retries the creation of the stream, and (for server streams)
+// the whole call if the first RecvMsg fails with a retryable code.
+func (ri *retryInterceptor) StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	maxAttempts, opts := ri.attempts(method, opts)
+	rs := &retryStream{
+		ri: ri, ctx: ctx, desc: desc, cc: cc, method: method, streamer: streamer, opts: opts,
+		maxAttempts: maxAttempts, backoff: ri.policy.InitialBackoff,
+	}
+	if err := rs.newStream(); err != nil {
+		return nil, err
+	}
+	if desc.ClientStreams {
+		return rs.ClientStream, nil
+	}
+	return rs, nil
+}
+
+// retryStream records the sent messages till the first received message,
+// to be able to replay them on a new stream.
+type retryStream struct {
+	grpc.ClientStream
+	ri       *retryInterceptor
+	ctx      context.Context
+	desc     *grpc.StreamDesc
+	cc       *grpc.ClientConn
+	method   string
+	streamer grpc.Streamer
+	opts     []grpc.CallOption
+
+	maxAttempts, attempt int
+	backoff              time.Duration
+	sent                 []interface{}
+	closeSent, received  bool
+}
+
+// newStream creates the stream, retrying on retryable errors.
+func (rs *retryStream) newStream() error {
+	for {
+		rs.attempt++
+		cs, err := rs.streamer(rs.ctx, rs.desc, rs.cc, rs.method, rs.opts...)
+		if err == nil {
+			rs.ClientStream = cs
+			return nil
+		}
+		if !rs.ri.shouldRetry(rs.ctx, rs.method, rs.attempt, rs.maxAttempts, &rs.backoff, err) {
+			return err
+		}
+	}
+}
+
+func (rs *retryStream) SendMsg(m interface{}) error {
+	if !rs.received {
+		rs.sent = append(rs.sent, m)
+	}
+	return rs.ClientStream.SendMsg(m)
+}
+
+func (rs *retryStream) CloseSend() error {
+	rs.closeSent = true
+	return rs.ClientStream.CloseSend()
+}
+
+func (rs *retryStream) RecvMsg(m interface{}) error {
+	if rs.received {
+		return rs.ClientStream.RecvMsg(m)
+	}
+	for {
+		err := rs.ClientStream.RecvMsg(m)
+		if err == nil || err == io.EOF {
+			rs.received, rs.sent = true, nil
+			rs.ri.budget.success()
+			return err
+		}
+		if !rs.ri.shouldRetry(rs.ctx, rs.method, rs.attempt, rs.maxAttempts, &rs.backoff, err) {
+			return err
+		}
+		if err := rs.replay(); err != nil {
+			return err
+		}
+	}
+}
+
+// replay the sent messages on a new stream.
+func (rs *retryStream) replay() error {
+	if err := rs.newStream(); err != nil {
+		return err
+	}
+	for _, m := range rs.sent {
+		if err := rs.ClientStream.SendMsg(m); err != nil {
+			return err
+		}
+	}
+	if rs.closeSent {
+		return rs.ClientStream.CloseSend()
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestRetry(t *testing.T) {
+	calls := make(map[string]int)
+	flaky := func(name string) func(ctx context.Context, input interface{}) (Receiver, error) {
+		return func(ctx context.Context, input interface{}) (Receiver, error) {
+			if calls[name]++; calls[name] < 3 {
+				return nil, status.Error(codes.Unavailable, "flaky")
+			}
+			return &receiver{parts: []interface{}{name}}, nil
+		}
+	}
+	c := WithRetry(fakeClient{"Get": flaky("Get"), "Put": flaky("Put")},
+		RetryPolicy{Idempotent: []string{"Get"}, InitialBackoff: time.Millisecond})
+	ctx := context.Background()
+
+	recv, err := c.Call("Get", ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if part, err := recv.Recv(); err != nil || part != "Get" {
+		t.Errorf("got %v (%+v)", part, err)
+	}
+	if calls["Get"] != 3 {
+		t.Errorf("Get called %d times, wanted 3", calls["Get"])
+	}
+
+	if _, err = c.Call("Put", ctx, nil); status.Code(err) != codes.Unavailable {
+		t.Errorf("wanted Unavailable, got %+v", err)
+	}
+	if calls["Put"] != 1 {
+		t.Errorf("non-idempotent Put called %d times", calls["Put"])
+	}
+
+	// the receivers failing at the first Recv are closed before the retry
+	var closed int
+	c = WithRetry(fakeClient{"List": func(ctx context.Context, input interface{}) (Receiver, error) {
+		if calls["List"]++; calls["List"] < 3 {
+			return &closeCountingReceiver{Receiver: &failingReceiver{err: status.Error(codes.Unavailable, "flaky")}, closed: &closed}, nil
+		}
+		return &receiver{parts: []interface{}{"List"}}, nil
+	}}, RetryPolicy{Idempotent: []string{"List"}, InitialBackoff: time.Millisecond})
+	if recv, err = c.Call("List", ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if part, err := recv.Recv(); err != nil || part != "List" || closed != 2 {
+		t.Errorf("got %v (%+v), %d receivers closed, wanted 2", part, err, closed)
+	}
+}
+
+type closeCountingReceiver struct {
+	Receiver
+	closed *int
+}
+
+func (r *closeCountingReceiver) Close() error { *r.closed++; return nil }
+
+func TestRetryInfo(t *testing.T) {
+	st, err := status.New(codes.ResourceExhausted, "slow down").WithDetails(
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(1500 * time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exhausted := st.Err()
+	if d, ok := retryDelay(exhausted); !ok || d != 1500*time.Millisecond {
+		t.Errorf("got %s, %t", d, ok)
+	}
+	w := httptest.NewRecorder()
+	setRetryAfter(w, exhausted)
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After: got %q, wanted 2", got)
+	}
+
+	var calls int
+	c := WithRetry(fakeClient{"Get": func(ctx context.Context, input interface{}) (Receiver, error) {
+		calls++
+		return nil, exhausted
+	}}, RetryPolicy{Idempotent: []string{"Get"}, RetryableCodes: []codes.Code{codes.ResourceExhausted}, InitialBackoff: time.Millisecond})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	if _, err = c.Call("Get", ctx, nil); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("got %+v", err)
+	}
+	if calls != 1 || time.Since(start) > 100*time.Millisecond {
+		t.Errorf("retried %d times in %s, despite the RetryInfo exceeding the deadline", calls, time.Since(start))
+	}
+}
+
+// replayStream fails the first RecvMsg with err, and records the sent messages.
+type replayStream struct {
+	grpc.ClientStream
+	err    error
+	sent   *[]interface{}
+	closed *int
+}
+
+func (rs replayStream) SendMsg(m interface{}) error { *rs.sent = append(*rs.sent, m); return nil }
+func (rs replayStream) CloseSend() error            { *rs.closed++; return nil }
+func (rs replayStream) RecvMsg(m interface{}) error { return rs.err }
+
+func TestRetryInterceptor(t *testing.T) {
+	ri := newRetryInterceptor(RetryPolicy{InitialBackoff: time.Millisecond, Idempotent: []string{"Get", "/pkg.Svc/List"}})
+	ctx := context.Background()
+
+	var calls int
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		for _, o := range opts {
+			if _, ok := o.(retryAttempts); ok {
+				t.Error("RetryAttempts passed to the invoker")
+			}
+		}
+		if calls++; calls < 3 {
+			return status.Error(codes.ResourceExhausted, "busy")
+		}
+		return nil
+	}
+	if err := ri.UnaryClientInterceptor(ctx, "/Get", nil, nil, nil, invoker); err != nil || calls != 3 {
+		t.Errorf("got %+v after %d calls, wanted success after 3", err, calls)
+	}
+	calls = 0
+	if err := ri.UnaryClientInterceptor(ctx, "/Get", nil, nil, nil, invoker, RetryAttempts(1)); status.Code(err) != codes.ResourceExhausted || calls != 1 {
+		t.Errorf("got %+v after %d calls, wanted ResourceExhausted after 1", err, calls)
+	}
+	calls = 0
+	if err := ri.UnaryClientInterceptor(ctx, "/pkg.Svc/Put", nil, nil, nil, invoker); status.Code(err) != codes.ResourceExhausted || calls != 1 {
+		t.Errorf("not idempotent: got %+v after %d calls, wanted ResourceExhausted after 1", err, calls)
+	}
+	calls = 0
+	if err := ri.UnaryClientInterceptor(ctx, "/pkg.Svc/Put", nil, nil, nil, invoker, RetryAttempts(3)); err != nil || calls != 3 {
+		t.Errorf("not idempotent with RetryAttempts: got %+v after %d calls, wanted success after 3", err, calls)
+	}
+
+	var sent []interface{}
+	var closed, streams int
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		streams++
+		if streams == 1 {
+			return nil, status.Error(codes.Unavailable, "down")
+		}
+		rs := replayStream{sent: &sent, closed: &closed}
+		if streams == 2 {
+			rs.err = status.Error(codes.Unavailable, "flaky")
+		}
+		return rs, nil
+	}
+	cs, err := ri.StreamClientInterceptor(ctx, &grpc.StreamDesc{ServerStreams: true}, nil, "/pkg.Svc/List", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cs.SendMsg("req"); err != nil {
+		t.Fatal(err)
+	}
+	if err = cs.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if err = cs.RecvMsg(nil); err != nil {
+		t.Errorf("RecvMsg: %+v", err)
+	}
+	if streams != 3 || len(sent) != 2 || closed != 2 {
+		t.Errorf("got %d streams, sent %v, closed %d; wanted 3 streams, the request sent and closed twice", streams, sent, closed)
+	}
+}
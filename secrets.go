@@ -0,0 +1,223 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"google.golang.org/grpc/credentials"
+)
+
+// ErrSecretNotFound is returned when the SecretSource has no such secret.
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretSource returns the named secrets (usernames, passwords, tokens).
+type SecretSource interface {
+	Secret(ctx context.Context, name string) (string, error)
+}
+
+// EnvSecrets reads the secrets from the environment variables, with the Prefix prepended to the name.
+type EnvSecrets struct {
+	Prefix string
+}
+
+// Secret returns the value of the environment variable.
+func (es EnvSecrets) Secret(ctx context.Context, name string) (string, error) {
+	if v, ok := os.LookupEnv(es.Prefix + name); ok {
+		return v, nil
+	}
+	return "", fmt.Errorf("%s%s: %w", es.Prefix, name, ErrSecretNotFound)
+}
+
+// FileSecrets reads the secrets from the files of Dir,
+// which must not be accessible by group or others (mode 0600 or stricter).
+type FileSecrets struct {
+	Dir string
+}
+
+// Secret returns the content of the file, without the trailing newline.
+func (fsec FileSecrets) Secret(ctx context.Context, name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", fmt.Errorf("%q: invalid secret name", name)
+	}
+	fn := filepath.Join(fsec.Dir, name)
+	fh, err := os.Open(fn)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("%s: %w", fn, ErrSecretNotFound)
+		}
+		return "", err
+	}
+	defer fh.Close()
+	fi, err := fh.Stat()
+	if err != nil {
+		return "", err
+	}
+	if mode := fi.Mode().Perm(); mode&0077 != 0 {
+		return "", fmt.Errorf("%s: mode %o is accessible by others, wanted 0600", fn, mode)
+	}
+	b, err := io.ReadAll(fh)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// VaultSecrets reads the secrets from a HashiCorp Vault KV version 2 secret,
+// through the HTTP API.
+type VaultSecrets struct {
+	// Addr is the Vault address, $VAULT_ADDR if empty.
+	Addr string
+	// Token is the Vault token, $VAULT_TOKEN if empty.
+	Token string
+	// Mount is the KV engine mount point, "secret" if empty.
+	Mount string
+	// Path of the secret, whose keys are the secret names.
+	Path   string
+	Client *http.Client
+}
+
+// Secret returns the named key of the Vault secret.
+func (vs VaultSecrets) Secret(ctx context.Context, name string) (string, error) {
+	addr, token, mount := vs.Addr, vs.Token, vs.Mount
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if mount == "" {
+		mount = "secret"
+	}
+	URL := strings.TrimSuffix(addr, "/") + "/v1/" + url.PathEscape(mount) + "/data/" + strings.TrimPrefix(vs.Path, "/")
+	req, err := http.NewRequestWithContext(ctx, "GET", URL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	cl := vs.Client
+	if cl == nil {
+		cl = http.DefaultClient
+	}
+	resp, err := cl.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", fmt.Errorf("%s: %w", URL, ErrSecretNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", URL, resp.Status)
+	}
+	var data struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err = jsoniter.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return "", fmt.Errorf("%s: %w", URL, err)
+	}
+	v, ok := data.Data.Data[name]
+	if !ok {
+		return "", fmt.Errorf("%s#%s: %w", URL, name, ErrSecretNotFound)
+	}
+	return fmt.Sprint(v), nil
+}
+
+// CachedSecrets caches the secrets of the Source for TTL, after which they are fetched again.
+// When the renewal fails, the expired value is used for at most another TTL.
+type CachedSecrets struct {
+	Source SecretSource
+	TTL    time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedSecret
+}
+
+type cachedSecret struct {
+	value   string
+	fetched time.Time
+}
+
+// Secret returns the cached secret, or fetches it from the Source.
+func (cs *CachedSecrets) Secret(ctx context.Context, name string) (string, error) {
+	cs.mu.Lock()
+	c, ok := cs.cache[name]
+	cs.mu.Unlock()
+	age := time.Since(c.fetched)
+	if ok && age < cs.TTL {
+		return c.value, nil
+	}
+	v, err := cs.Source.Secret(ctx, name)
+	if err != nil {
+		if ok && age < 2*cs.TTL {
+			return c.value, nil
+		}
+		return "", err
+	}
+	cs.mu.Lock()
+	if cs.cache == nil {
+		cs.cache = make(map[string]cachedSecret)
+	}
+	cs.cache[name] = cachedSecret{value: v, fetched: time.Now()}
+	cs.mu.Unlock()
+	return v, nil
+}
+
+var _ = credentials.PerRPCCredentials(secretBasicAuthCreds{})
+
+type secretBasicAuthCreds struct {
+	src                SecretSource
+	username, password string
+	insecure           bool
+}
+
+// NewSecretBasicAuth returns a PerRPCCredentials with the username and password
+// read by name from the SecretSource for each call.
+func NewSecretBasicAuth(src SecretSource, usernameSecret, passwordSecret string, insecure bool) credentials.PerRPCCredentials {
+	return secretBasicAuthCreds{src: src, username: usernameSecret, password: passwordSecret, insecure: insecure}
+}
+
+func (sa secretBasicAuthCreds) RequireTransportSecurity() bool { return !sa.insecure }
+
+// GetRequestMetadata returns the authorization of the context, or the secrets'.
+func (sa secretBasicAuthCreds) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	if up, _ := ctx.Value(BasicAuthKey).(string); up != "" {
+		return map[string]string{"authorization": up}, nil
+	}
+	u, err := sa.src.Secret(ctx, sa.username)
+	if err != nil {
+		return nil, fmt.Errorf("username: %w", err)
+	}
+	p, err := sa.src.Secret(ctx, sa.password)
+	if err != nil {
+		return nil, fmt.Errorf("password: %w", err)
+	}
+	return map[string]string{"authorization": u + ":" + p}, nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSecrets(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "password"), []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "open"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fsec := FileSecrets{Dir: dir}
+	if v, err := fsec.Secret(ctx, "password"); err != nil || v != "s3cret" {
+		t.Errorf("file: got %q (%+v)", v, err)
+	}
+	if _, err := fsec.Secret(ctx, "open"); err == nil {
+		t.Error("world-readable file accepted")
+	}
+	if _, err := fsec.Secret(ctx, "../password"); err == nil {
+		t.Error("path traversal accepted")
+	}
+
+	t.Setenv("GRPCER_TEST_USER", "user")
+	if v, err := (EnvSecrets{Prefix: "GRPCER_TEST_"}).Secret(ctx, "USER"); err != nil || v != "user" {
+		t.Errorf("env: got %q (%+v)", v, err)
+	}
+
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path != "/v1/secret/data/grpcer" || r.Header.Get("X-Vault-Token") != "tok" {
+			http.Error(w, r.URL.Path, http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"data":{"data":{"username":"vuser"},"metadata":{}}}`))
+	}))
+	defer srv.Close()
+	cs := &CachedSecrets{Source: VaultSecrets{Addr: srv.URL, Token: "tok", Path: "grpcer"}, TTL: time.Minute}
+	for i := 0; i < 2; i++ {
+		if v, err := cs.Secret(ctx, "username"); err != nil || v != "vuser" {
+			t.Errorf("vault: got %q (%+v)", v, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("vault called %d times", calls)
+	}
+	if _, err := cs.Secret(ctx, "missing"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("got %+v", err)
+	}
+
+	md, err := NewSecretBasicAuth(fsec, "password", "password", false).GetRequestMetadata(ctx)
+	if err != nil || md["authorization"] != "s3cret:s3cret" {
+		t.Errorf("got %v (%+v)", md, err)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"sync/atomic"
+
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/grpc"
+)
+
+type singleflightClient struct {
+	Client
+	keyFunc KeyFunc
+	group   singleflight.Group
+}
+
+type flightResult struct {
+	parts []interface{}
+	rest  Receiver
+	err   error
+	taken int32
+}
+
+// WithSingleflight returns a Client which collapses the concurrent calls with the same
+// key (by keyFunc, InputKey if nil) into one upstream call, and returns its parts to all the callers.
+//
+// The upstream call uses the first caller's context.
+// Streams longer than CacheMaxParts cannot be replayed: only one caller gets the
+// rest of that stream, the others issue their own calls.
+// The parts are shared between the callers, so they must not be modified!
+func WithSingleflight(c Client, keyFunc KeyFunc) Client {
+	if keyFunc == nil {
+		keyFunc = InputKey
+	}
+	return &singleflightClient{Client: c, keyFunc: keyFunc}
+}
+
+// Call the named method, sharing the result with the concurrent identical calls.
+func (sc *singleflightClient) Call(name string, ctx context.Context, input interface{}, opts ...grpc.CallOption) (Receiver, error) {
+	key := sc.keyFunc(name, input)
+	if key == "" {
+		return sc.Client.Call(name, ctx, input, opts...)
+	}
+	v, err, _ := sc.group.Do(key, func() (interface{}, error) {
+		recv, err := sc.Client.Call(name, ctx, input, opts...)
+		if err != nil {
+			return nil, err
+		}
+		var res flightResult
+		res.parts, res.rest, res.err = materialize(recv, CacheMaxParts)
+		return &res, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := v.(*flightResult)
+	if res.rest != nil {
+		if !atomic.CompareAndSwapInt32(&res.taken, 0, 1) {
+			return sc.Client.Call(name, ctx, input, opts...)
+		}
+		return &prefixedReceiver{parts: res.parts, rest: res.rest}, nil
+	}
+	return &prefixedReceiver{parts: res.parts, err: res.err}, nil
+}
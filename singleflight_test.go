@@ -0,0 +1,71 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSingleflight(t *testing.T) {
+	var n int32
+	start := make(chan struct{})
+	c := WithSingleflight(fakeClient{
+		"Slow": func(ctx context.Context, input interface{}) (Receiver, error) {
+			atomic.AddInt32(&n, 1)
+			<-start
+			return &receiver{parts: []interface{}{1, 2}}, nil
+		},
+	}, nil)
+
+	const callers = 8
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recv, err := c.Call("Slow", context.Background(), "same")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for _, want := range []int{1, 2} {
+				if part, err := recv.Recv(); err != nil || part != want {
+					t.Errorf("got %v (%+v), wanted %d", part, err, want)
+				}
+			}
+		}()
+	}
+	// the backend blocks till all the callers are in the flight
+	for inFlight() < callers {
+		runtime.Gosched()
+	}
+	close(start)
+	wg.Wait()
+	if n != 1 {
+		t.Errorf("got %d upstream calls for %d callers, wanted 1", n, callers)
+	}
+}
+
+// inFlight returns the number of goroutines in singleflight.Group.Do.
+func inFlight() int {
+	buf := make([]byte, 1<<20)
+	return strings.Count(string(buf[:runtime.Stack(buf, true)]), "singleflight.(*Group).Do(")
+}
@@ -0,0 +1,147 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"expvar"
+	"math/bits"
+	"sync"
+
+	"google.golang.org/grpc"
+)
+
+// SizeHistogram counts the message sizes in power-of-two buckets:
+// Buckets[i] counts the sizes below 1<<i (and above the previous bucket).
+type SizeHistogram struct {
+	Count   int64   `json:"count"`
+	Max     int64   `json:"max"`
+	Buckets []int64 `json:"buckets"`
+}
+
+func (h *SizeHistogram) add(size int64) {
+	h.Count++
+	if size > h.Max {
+		h.Max = size
+	}
+	i := bits.Len64(uint64(size))
+	for len(h.Buckets) <= i {
+		h.Buckets = append(h.Buckets, 0)
+	}
+	h.Buckets[i]++
+}
+
+// MessageSizeStats are the request and response sizes of a method.
+type MessageSizeStats struct {
+	Requests  SizeHistogram `json:"requests"`
+	Responses SizeHistogram `json:"responses"`
+}
+
+// MessageSizes is a pair of client interceptors, which observe the serialized sizes
+// of the proto messages by method, and log a warning for the ones above the threshold.
+type MessageSizes struct {
+	// Warn is the size above which a warning is logged, never if zero.
+	Warn int64
+	// WarnByMethod overrides Warn for the (fully qualified) methods.
+	WarnByMethod map[string]int64
+	Log          func(...interface{}) error
+
+	mu    sync.Mutex
+	stats map[string]*MessageSizeStats
+}
+
+func (ms *MessageSizes) observe(method string, size int64, response bool) {
+	ms.mu.Lock()
+	if ms.stats == nil {
+		ms.stats = make(map[string]*MessageSizeStats)
+	}
+	st := ms.stats[method]
+	if st == nil {
+		st = new(MessageSizeStats)
+		ms.stats[method] = st
+	}
+	if response {
+		st.Responses.add(size)
+	} else {
+		st.Requests.add(size)
+	}
+	ms.mu.Unlock()
+
+	warn, ok := ms.WarnByMethod[method]
+	if !ok {
+		warn = ms.Warn
+	}
+	if warn > 0 && size > warn && ms.Log != nil {
+		ms.Log("msg", "message size above threshold", "method", method, "size", size, "threshold", warn, "response", response)
+	}
+}
+
+// Stats returns a copy of the statistics by method.
+func (ms *MessageSizes) Stats() map[string]MessageSizeStats {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	m := make(map[string]MessageSizeStats, len(ms.stats))
+	for k, v := range ms.stats {
+		st := *v
+		st.Requests.Buckets = append([]int64(nil), v.Requests.Buckets...)
+		st.Responses.Buckets = append([]int64(nil), v.Responses.Buckets...)
+		m[k] = st
+	}
+	return m
+}
+
+// Publish the statistics as an expvar with the given name.
+func (ms *MessageSizes) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} { return ms.Stats() }))
+}
+
+// UnaryClientInterceptor observes the request and the response size.
+func (ms *MessageSizes) UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	ms.observe(method, protoSize(req), false)
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if err == nil {
+		ms.observe(method, protoSize(reply), true)
+	}
+	return err
+}
+
+// StreamClientInterceptor observes the sent and received message sizes.
+func (ms *MessageSizes) StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		return cs, err
+	}
+	return sizeStream{ClientStream: cs, ms: ms, method: method}, nil
+}
+
+type sizeStream struct {
+	grpc.ClientStream
+	ms     *MessageSizes
+	method string
+}
+
+func (s sizeStream) SendMsg(m interface{}) error {
+	s.ms.observe(s.method, protoSize(m), false)
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s sizeStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == nil {
+		s.ms.observe(s.method, protoSize(m), true)
+	}
+	return err
+}
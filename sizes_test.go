@@ -0,0 +1,50 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestMessageSizes(t *testing.T) {
+	var warnings []string
+	ms := &MessageSizes{Warn: 100, Log: func(keyvals ...interface{}) error {
+		warnings = append(warnings, keyvals[3].(string))
+		return nil
+	}}
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		reply.(*wrapperspb.StringValue).Value = strings.Repeat("x", 200)
+		return nil
+	}
+	for i := 0; i < 2; i++ {
+		if err := ms.UnaryClientInterceptor(context.Background(), "/test.Svc/Get",
+			wrapperspb.String("small"), new(wrapperspb.StringValue), nil, invoker); err != nil {
+			t.Fatal(err)
+		}
+	}
+	st := ms.Stats()["/test.Svc/Get"]
+	if st.Requests.Count != 2 || st.Requests.Max != 7 || st.Responses.Max < 200 || st.Responses.Buckets[8] != 2 {
+		t.Errorf("got %+v", st)
+	}
+	if len(warnings) != 2 || warnings[0] != "/test.Svc/Get" {
+		t.Errorf("warnings: %q", warnings)
+	}
+}
@@ -0,0 +1,347 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The SOAP envelope namespaces.
+const (
+	SOAP11Namespace = "http://schemas.xmlsoap.org/soap/envelope/"
+	SOAP12Namespace = "http://www.w3.org/2003/05/soap-envelope"
+)
+
+var errNoSOAPBody = errors.New("no SOAP Body")
+
+// SOAPHandler is a SOAP 1.1 and 1.2 bridge of the Client.
+//
+// The method is named by the SOAPAction (see Actions), or by the element of the Body
+// (see Operations, with an optional "Request" suffix),
+// which is unmarshaled into the method's input with encoding/xml.
+// The (merged) output is sent in a "{method}Response" element,
+// the errors as SOAP Faults, with the gRPC status code in the detail.
+//
+// The MTOM requests have their attachments unmarshaled into the []byte fields as is,
+// and are answered with MTOM, sending the top-level []byte fields of the output as attachments.
+// The MTOM parts are read one by one from the body, but each of them into memory,
+// as the attachments may follow the envelope referencing them.
+type SOAPHandler struct {
+	Client
+	Log     func(...interface{}) error
+	Timeout time.Duration
+	// ForwardHeaders maps the HTTP headers to be forwarded to the outgoing
+	// metadata keys (the lowercased header name if empty).
+	// The authorization, OnBehalfOfKey and x-client-cert-* keys are never forwarded.
+	ForwardHeaders map[string]string
+	// WSSecurity authenticates the requests with the UsernameToken of the SOAP header, if set.
+	// Otherwise the basic auth of the request is forwarded.
+	WSSecurity *WSSecurity
+	// Limit is the concurrency limit of the calls (shared by copies of the handler),
+	// a global slot is held from reading the request body, the method's slot from decoding it.
+	Limit *ConcurrencyLimit
+	// MaxBodySize is the limit of the decompressed request body, DefaultMaxBodySize if zero.
+	MaxBodySize int64
+	// Actions maps the SOAPAction (the header of SOAP 1.1, the action parameter
+	// of the Content-Type of SOAP 1.2) to the method name.
+	// If set, the requests with any other (non-empty) action are rejected with a Client fault.
+	Actions map[string]string
+	// Operations maps the element name of the Body to the method name,
+	// for the requests without a SOAPAction in Actions.
+	Operations map[string]string
+}
+
+func (h SOAPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	Log := h.Log
+	if Log == nil {
+		Log = func(...interface{}) error { return nil }
+	}
+	ns := SOAP11Namespace
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/soap+xml") {
+		ns = SOAP12Namespace
+	}
+	// a global slot is held while reading the body, the method's slot after it is named
+	release, err := h.Limit.acquireGlobal(r.Context())
+	if err != nil {
+		Log("msg", "limit", "error", err)
+		w.Header().Set("Retry-After", "1")
+		soapFault(w, ns, status.Error(codes.ResourceExhausted, err.Error()), limitErrorCode(err))
+		return
+	}
+	defer release()
+	if err := decodeBody(w, r, h.MaxBodySize); err != nil {
+		soapFault(w, ns, status.Error(codes.InvalidArgument, err.Error()), bodyErrorCode(err))
+		return
+	}
+	envelope, attachments, mtomNS, mtom, err := readMTOM(r.Body, r.Header.Get("Content-Type"))
+	if err != nil {
+		var code int
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			code = http.StatusRequestEntityTooLarge
+		}
+		soapFault(w, mtomNS, status.Error(codes.InvalidArgument, err.Error()), code)
+		return
+	}
+	body := envelope
+	if !mtom {
+		if body, err = io.ReadAll(r.Body); err != nil {
+			soapFault(w, ns, status.Error(codes.InvalidArgument, err.Error()), bodyErrorCode(err))
+			return
+		}
+	}
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	if mtom {
+		dec = xml.NewTokenDecoder(&xopReader{dec: dec, parts: attachments})
+	}
+	var name string
+	var inp interface{}
+	ns, start, err := findSOAPMethod(dec)
+	if err == nil {
+		if name, inp, err = h.method(soapAction(r), start.Name.Local); err == nil {
+			if err = dec.DecodeElement(inp, &start); err != nil {
+				err = status.Errorf(codes.InvalidArgument, "decode %s: %v", name, err)
+			}
+		}
+	}
+	Log("name", name, "inp", inp, "error", err)
+	if err != nil {
+		soapFault(w, ns, err, 0)
+		return
+	}
+
+	ctx := forwardHeaders(r.Context(), r, h.ForwardHeaders)
+	if h.WSSecurity != nil {
+		ut, err := ParseUsernameToken(bytes.NewReader(body))
+		if err == nil {
+			ctx, err = h.WSSecurity.Authenticate(ctx, ut)
+		}
+		if err != nil {
+			Log("msg", "WS-Security", "error", err)
+			soapFault(w, ns, status.Error(codes.Unauthenticated, err.Error()), 0)
+			return
+		}
+	} else if u, p, ok := r.BasicAuth(); ok {
+		ctx = WithBasicAuth(ctx, u, p)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		timeout := h.Timeout
+		if timeout == 0 {
+			timeout = DefaultTimeout
+		}
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+	}
+	releaseMethod, err := h.Limit.acquireMethod(ctx, name)
+	if err != nil {
+		Log("call", name, "error", err)
+		w.Header().Set("Retry-After", "1")
+		soapFault(w, ns, status.Error(codes.ResourceExhausted, err.Error()), limitErrorCode(err))
+		return
+	}
+	defer releaseMethod()
+	recv, err := h.Call(name, ctx, inp)
+	if err != nil {
+		Log("call", name, "error", err)
+		soapFault(w, ns, err, 0)
+		return
+	}
+	defer CloseReceiver(recv)
+	part, err := recv.Recv()
+	if err != nil {
+		Log("msg", "recv", "error", err)
+		soapFault(w, ns, err, 0)
+		return
+	}
+
+	enc := &xmlStreamEncoder{name: name + "Response"}
+	var mw *mtomWriter
+	if mtom {
+		mw = newMTOMWriter(w, w.Header(), ns)
+		enc.attach = mw.attach
+	} else {
+		w.Header().Set("Content-Type", soapContentType(ns))
+	}
+	w.WriteHeader(200)
+	out := io.Writer(w)
+	if mw != nil {
+		if out, err = mw.root(ns); err != nil {
+			Log("msg", "MTOM", "error", err)
+			return
+		}
+	}
+	fmt.Fprintf(out, "%s<soap:Envelope xmlns:soap=%q><soap:Body>", xml.Header, ns)
+	if err := mergeStreamsConfig(ctx, out, part, recv, mergeConfig{enc: enc, Log: Log}); err != nil {
+		Log("mergeStreams", "error", err)
+	}
+	io.WriteString(out, "</soap:Body></soap:Envelope>\n")
+	if mw != nil {
+		if err := mw.Close(); err != nil {
+			Log("msg", "MTOM", "error", err)
+		}
+	}
+}
+
+// method returns the name and the input of the method called by the action,
+// or else the element of the Body.
+func (h SOAPHandler) method(action, element string) (string, interface{}, error) {
+	if action != "" && h.Actions != nil {
+		name, ok := h.Actions[action]
+		if !ok {
+			return "", nil, status.Errorf(codes.NotFound, "unknown SOAPAction %q", action)
+		}
+		if inp := h.Input(name); inp != nil {
+			return name, inp, nil
+		}
+		return name, nil, status.Error(codes.NotFound, notFoundMessage(h.Client, name))
+	}
+	name := element
+	if nm, ok := h.Operations[element]; ok {
+		name = nm
+	}
+	if inp := h.Input(name); inp != nil {
+		return name, inp, nil
+	}
+	if nm := strings.TrimSuffix(name, "Request"); nm != name {
+		if inp := h.Input(nm); inp != nil {
+			return nm, inp, nil
+		}
+	}
+	return name, nil, status.Error(codes.NotFound, notFoundMessage(h.Client, name))
+}
+
+// soapAction returns the SOAPAction header, or the action parameter of the SOAP 1.2 Content-Type,
+// without the quotes.
+func soapAction(r *http.Request) string {
+	action := r.Header.Get("SOAPAction")
+	if action == "" {
+		if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil {
+			action = params["action"]
+		}
+	}
+	return strings.Trim(action, `"`)
+}
+
+// findSOAPMethod reads the Envelope up to the first element of the Body,
+// returning the envelope's namespace and the element.
+func findSOAPMethod(dec *xml.Decoder) (ns string, start xml.StartElement, err error) {
+	ns = SOAP11Namespace
+	var inBody bool
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if err == io.EOF {
+				err = errNoSOAPBody
+			}
+			return ns, start, status.Error(codes.InvalidArgument, err.Error())
+		}
+		switch x := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case inBody:
+				return ns, x.Copy(), nil
+			case x.Name.Local == "Envelope":
+				if x.Name.Space != SOAP11Namespace && x.Name.Space != SOAP12Namespace {
+					return ns, start, status.Errorf(codes.InvalidArgument, "unknown SOAP envelope namespace %q", x.Name.Space)
+				}
+				ns = x.Name.Space
+			case x.Name.Local == "Body" && x.Name.Space == ns:
+				inBody = true
+			}
+		case xml.EndElement:
+			if inBody {
+				return ns, start, status.Error(codes.InvalidArgument, "empty SOAP Body")
+			}
+		}
+	}
+}
+
+func soapContentType(ns string) string { return soapMediaType(ns) + "; charset=utf-8" }
+
+func soapMediaType(ns string) string {
+	if ns == SOAP12Namespace {
+		return "application/soap+xml"
+	}
+	return "text/xml"
+}
+
+// soapFault writes err as a SOAP Fault, with HTTP status code (by the SOAP version if zero).
+func soapFault(w http.ResponseWriter, ns string, err error, code int) {
+	st := status.Convert(err)
+	var sender bool
+	switch st.Code() {
+	case codes.InvalidArgument, codes.NotFound, codes.AlreadyExists, codes.PermissionDenied,
+		codes.Unauthenticated, codes.FailedPrecondition, codes.OutOfRange:
+		sender = true
+	}
+	if code == 0 {
+		code = http.StatusInternalServerError
+		if sender && ns == SOAP12Namespace {
+			code = http.StatusBadRequest
+		}
+	}
+	type detail struct {
+		Code string `xml:"code"`
+	}
+	var fault interface{}
+	if ns == SOAP12Namespace {
+		value := "soap:Receiver"
+		if sender {
+			value = "soap:Sender"
+		}
+		type text struct {
+			Lang  string `xml:"xml:lang,attr"`
+			Value string `xml:",chardata"`
+		}
+		fault = struct {
+			XMLName xml.Name `xml:"soap:Fault"`
+			Code    string   `xml:"soap:Code>soap:Value"`
+			Reason  text     `xml:"soap:Reason>soap:Text"`
+			Detail  detail   `xml:"soap:Detail"`
+		}{Code: value, Reason: text{Lang: "en", Value: st.Message()}, Detail: detail{Code: st.Code().String()}}
+	} else {
+		value := "soap:Server"
+		if sender {
+			value = "soap:Client"
+		}
+		fault = struct {
+			XMLName xml.Name `xml:"soap:Fault"`
+			Code    string   `xml:"faultcode"`
+			String  string   `xml:"faultstring"`
+			Detail  detail   `xml:"detail"`
+		}{Code: value, String: st.Message(), Detail: detail{Code: st.Code().String()}}
+	}
+	w.Header().Set("Content-Type", soapContentType(ns))
+	w.WriteHeader(code)
+	fmt.Fprintf(w, "%s<soap:Envelope xmlns:soap=%q><soap:Body>", xml.Header, ns)
+	xml.NewEncoder(w).Encode(fault)
+	io.WriteString(w, "</soap:Body></soap:Envelope>\n")
+}
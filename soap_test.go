@@ -0,0 +1,199 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type soapInput struct {
+	Name string
+	Data []byte
+}
+
+type soapOutput struct {
+	Greeting string   `xml:"greeting"`
+	Data     []byte   `xml:"data,omitempty"`
+	Items    []string `xml:"items>item"`
+}
+
+type soapClient struct{}
+
+func (soapClient) List() []string { return []string{"Hello"} }
+func (soapClient) Input(name string) interface{} {
+	if name == "Hello" {
+		return new(soapInput)
+	}
+	return nil
+}
+func (soapClient) Output(name string) interface{} { return new(soapOutput) }
+func (soapClient) Call(name string, ctx context.Context, input interface{}, opts ...grpc.CallOption) (Receiver, error) {
+	inp := input.(*soapInput)
+	if inp.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty name")
+	}
+	return &receiver{parts: []interface{}{
+		&soapOutput{Greeting: "Hello, " + inp.Name, Data: inp.Data, Items: []string{"a"}},
+		&soapOutput{Items: []string{"b"}},
+	}}, nil
+}
+
+func TestSOAPHandler(t *testing.T) {
+	h := SOAPHandler{Client: soapClient{}}
+	for _, tc := range []struct {
+		NS, ContentType, Body string
+		Code                  int
+		Want                  []string
+	}{
+		{NS: SOAP11Namespace, ContentType: "text/xml", Body: `<HelloRequest><Name>World</Name></HelloRequest>`,
+			Code: 200, Want: []string{"<HelloResponse><greeting>Hello, World</greeting><items><item>a</item><item>b</item></items></HelloResponse>"}},
+		{NS: SOAP11Namespace, ContentType: "text/xml", Body: `<Hello></Hello>`,
+			Code: 500, Want: []string{"<faultcode>soap:Client</faultcode>", "<code>InvalidArgument</code>"}},
+		{NS: SOAP12Namespace, ContentType: "application/soap+xml", Body: `<Hello></Hello>`,
+			Code: 400, Want: []string{"<soap:Value>soap:Sender</soap:Value>", `<soap:Text xml:lang="en">empty name</soap:Text>`}},
+		{NS: SOAP12Namespace, ContentType: "application/soap+xml", Body: `<Unknown/>`,
+			Code: 400, Want: []string{"<code>NotFound</code>"}},
+	} {
+		envelope := `<soap:Envelope xmlns:soap="` + tc.NS + `"><soap:Header/><soap:Body>` + tc.Body + `</soap:Body></soap:Envelope>`
+		req := httptest.NewRequest("POST", "/", strings.NewReader(envelope))
+		req.Header.Set("Content-Type", tc.ContentType)
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		got := rw.Body.String()
+		t.Log(got)
+		if rw.Code != tc.Code {
+			t.Errorf("%s: got %d, wanted %d", tc.Body, rw.Code, tc.Code)
+		}
+		if ct := rw.Header().Get("Content-Type"); !strings.HasPrefix(ct, tc.ContentType) {
+			t.Errorf("%s: got Content-Type %q, wanted %q", tc.Body, ct, tc.ContentType)
+		}
+		got = strings.ReplaceAll(got, "\n", "")
+		for _, want := range tc.Want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: %q not found in %q", tc.Body, want, got)
+			}
+		}
+	}
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("POST", "/", strings.NewReader("<foo/>")))
+	if rw.Code != http.StatusInternalServerError || !strings.Contains(rw.Body.String(), "soap:Client") {
+		t.Errorf("no envelope: got %d %q", rw.Code, rw.Body.String())
+	}
+}
+
+func TestSOAPHandlerRouting(t *testing.T) {
+	h := SOAPHandler{Client: soapClient{},
+		Actions:    map[string]string{"urn:greet": "Hello"},
+		Operations: map[string]string{"Greet": "Hello"}}
+	for name, tc := range map[string]struct {
+		Action, ContentType, Body string
+		Code                      int
+		Want                      string
+	}{
+		"header":       {Action: `"urn:greet"`, Body: `<Whatever><Name>World</Name></Whatever>`, Code: 200, Want: "<HelloResponse><greeting>Hello, World</greeting>"},
+		"content-type": {ContentType: `application/soap+xml; action="urn:greet"`, Body: `<Whatever><Name>World</Name></Whatever>`, Code: 200, Want: "<HelloResponse>"},
+		"operation":    {Action: `""`, Body: `<Greet><Name>World</Name></Greet>`, Code: 200, Want: "<HelloResponse>"},
+		"unknown":      {Action: "urn:nosuch", Body: `<Hello><Name>World</Name></Hello>`, Code: 500, Want: "<faultcode>soap:Client</faultcode>"},
+	} {
+		ns, ct := SOAP11Namespace, tc.ContentType
+		if ct == "" {
+			ct = "text/xml"
+		} else {
+			ns = SOAP12Namespace
+		}
+		envelope := `<soap:Envelope xmlns:soap="` + ns + `"><soap:Body>` + tc.Body + `</soap:Body></soap:Envelope>`
+		req := httptest.NewRequest("POST", "/", strings.NewReader(envelope))
+		req.Header.Set("Content-Type", ct)
+		if tc.Action != "" {
+			req.Header.Set("SOAPAction", tc.Action)
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		if got := rw.Body.String(); rw.Code != tc.Code || !strings.Contains(got, tc.Want) {
+			t.Errorf("%s: got %d %q, wanted %d with %q", name, rw.Code, got, tc.Code, tc.Want)
+		}
+	}
+}
+
+func TestSOAPHandlerMTOM(t *testing.T) {
+	data := []byte("\x00\x01<binary>")
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	root, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {`application/xop+xml; type="text/xml"`}, "Content-ID": {"<root>"}})
+	io.WriteString(root, `<soap:Envelope xmlns:soap="`+SOAP11Namespace+`"><soap:Body><Hello><Name>World</Name>`+
+		`<Data><xop:Include xmlns:xop="`+XOPNamespace+`" href="cid:data%40test"/></Data></Hello></soap:Body></soap:Envelope>`)
+	att, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/octet-stream"}, "Content-ID": {"<data@test>"}})
+	att.Write(data)
+	mw.Close()
+	body := buf.String()
+	contentType := `multipart/related; type="application/xop+xml"; start="<root>"; start-info="text/xml"; boundary=` + mw.Boundary()
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rw := httptest.NewRecorder()
+	SOAPHandler{Client: soapClient{}}.ServeHTTP(rw, req)
+	if rw.Code != 200 {
+		t.Fatalf("got %d %q", rw.Code, rw.Body.String())
+	}
+	_, params, err := mime.ParseMediaType(rw.Header().Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := make(map[string]string)
+	mr := multipart.NewReader(rw.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(p)
+		parts[p.Header.Get("Content-ID")] = string(b)
+	}
+	t.Log(parts)
+	envelope := parts["<root>"]
+	if !strings.Contains(envelope, "<greeting>Hello, World</greeting><data><xop:Include") {
+		t.Fatalf("no xop:Include in %q", envelope)
+	}
+	_, href, _ := strings.Cut(envelope, `href="cid:`)
+	href, _, _ = strings.Cut(href, `"`)
+	if got := parts["<"+href+">"]; got != string(data) {
+		t.Errorf("got attachment %q, wanted %q", got, data)
+	}
+
+	req = httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rw = httptest.NewRecorder()
+	SOAPHandler{Client: soapClient{}, MaxBodySize: 64}.ServeHTTP(rw, req)
+	if rw.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got %d %q, wanted 413", rw.Code, rw.Body.String())
+	}
+}
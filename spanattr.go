@@ -0,0 +1,161 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+	"google.golang.org/grpc"
+)
+
+// spanAttributer adds the configured input fields as attributes to the current span.
+type spanAttributer struct {
+	fields []string
+	redact map[string]bool
+}
+
+func newSpanAttributer(fields, redacted []string) *spanAttributer {
+	if len(fields) == 0 {
+		return nil
+	}
+	sa := spanAttributer{fields: fields}
+	if len(redacted) != 0 {
+		sa.redact = make(map[string]bool, len(redacted))
+		for _, f := range redacted {
+			sa.redact[f] = true
+		}
+	}
+	return &sa
+}
+
+func (sa *spanAttributer) annotate(ctx context.Context, input interface{}) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	kvs := make([]label.KeyValue, 0, len(sa.fields))
+	for _, f := range sa.fields {
+		v, ok := inputField(input, f)
+		if !ok {
+			continue
+		}
+		s := fmt.Sprint(v)
+		if sa.redact[f] {
+			s = redact(s)
+		}
+		kvs = append(kvs, label.String("input."+f, s))
+	}
+	if len(kvs) != 0 {
+		span.SetAttributes(kvs...)
+	}
+}
+
+// redactKey is the HMAC key of redact, random for each process,
+// so the low-entropy values (e.g. PINs) cannot be recovered by hashing all the candidates.
+var redactKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+// redact the value, keeping it correlatable within the process by its keyed hash.
+func redact(s string) string {
+	mac := hmac.New(sha256.New, redactKey)
+	io.WriteString(mac, s)
+	return "hmac:" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+func (sa *spanAttributer) UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	sa.annotate(ctx, req)
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func (sa *spanAttributer) StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		return cs, err
+	}
+	return &annotatingStream{ClientStream: cs, ctx: ctx, sa: sa}, nil
+}
+
+// annotatingStream annotates the span with the first sent message.
+type annotatingStream struct {
+	grpc.ClientStream
+	ctx  context.Context
+	sa   *spanAttributer
+	done bool
+}
+
+func (as *annotatingStream) SendMsg(m interface{}) error {
+	if !as.done {
+		as.done = true
+		as.sa.annotate(as.ctx, m)
+	}
+	return as.ClientStream.SendMsg(m)
+}
+
+// inputField returns the value of the dot-separated field path of input.
+//
+// The field names are matched case-insensitively, ignoring underscores,
+// so both CustomerID and customer_id works.
+func inputField(input interface{}, path string) (interface{}, bool) {
+	rv := reflect.ValueOf(input)
+	for _, nm := range strings.Split(path, ".") {
+		for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+			if rv.IsNil() {
+				return nil, false
+			}
+			rv = rv.Elem()
+		}
+		want := strings.ToLower(strings.Replace(nm, "_", "", -1))
+		switch rv.Kind() {
+		case reflect.Struct:
+			rv = rv.FieldByNameFunc(func(s string) bool {
+				return strings.ToLower(strings.Replace(s, "_", "", -1)) == want
+			})
+		case reflect.Map:
+			if rv.Type().Key().Kind() != reflect.String {
+				return nil, false
+			}
+			var found reflect.Value
+			for it := rv.MapRange(); it.Next(); {
+				if strings.ToLower(strings.Replace(it.Key().String(), "_", "", -1)) == want {
+					found = it.Value()
+					break
+				}
+			}
+			rv = found
+		default:
+			return nil, false
+		}
+		if !rv.IsValid() || !rv.CanInterface() {
+			return nil, false
+		}
+	}
+	return rv.Interface(), true
+}
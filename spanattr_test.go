@@ -0,0 +1,76 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+	"google.golang.org/grpc"
+)
+
+type recordingSpan struct {
+	trace.Span
+	attrs map[string]string
+}
+
+func (rs *recordingSpan) IsRecording() bool { return true }
+func (rs *recordingSpan) SetAttributes(kvs ...label.KeyValue) {
+	for _, kv := range kvs {
+		rs.attrs[string(kv.Key)] = kv.Value.AsString()
+	}
+}
+
+func TestSpanAttributes(t *testing.T) {
+	type order struct{ ID int }
+	input := struct {
+		CustomerID string
+		Password   string
+		Order      *order
+	}{CustomerID: "C1", Password: "secret", Order: &order{ID: 42}}
+
+	span := &recordingSpan{attrs: make(map[string]string)}
+	ctx := trace.ContextWithSpan(context.Background(), span)
+	sa := newSpanAttributer([]string{"customer_id", "Password", "Order.ID", "Missing"}, []string{"Password"})
+	if err := sa.UnaryClientInterceptor(ctx, "/a.B/C", &input, nil, nil,
+		func(context.Context, string, interface{}, interface{}, *grpc.ClientConn, ...grpc.CallOption) error {
+			return nil
+		},
+	); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"input.customer_id": "C1",
+		"input.Password":    redact("secret"),
+		"input.Order.ID":    "42",
+	}
+	if len(span.attrs) != len(want) {
+		t.Errorf("got %v, wanted %v", span.attrs, want)
+	}
+	for k, v := range want {
+		if got := span.attrs[k]; got != v {
+			t.Errorf("%s: got %q, wanted %q", k, got, v)
+		}
+	}
+	if plain := sha256.Sum256([]byte("secret")); strings.Contains(redact("secret"), hex.EncodeToString(plain[:8])) {
+		t.Error("redacted with the plain hash")
+	}
+}
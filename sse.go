@@ -0,0 +1,115 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// SSEKeepAlive is the interval of the keep-alive comments of the Server-Sent Events stream,
+// none if not positive.
+var SSEKeepAlive = 15 * time.Second
+
+// flush the writer, if it (or the ResponseWriter it wraps) is an http.Flusher.
+func flush(w io.Writer) {
+	if rw, ok := w.(http.ResponseWriter); ok {
+		_ = http.NewResponseController(rw).Flush()
+	} else if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// sseEncoder writes each part as a Server-Sent Event ("event: part", "data: {json}"),
+// the stream error as an "error" event, and the end of the stream as an "end" event.
+//
+// A keep-alive comment is sent when nothing was written for the keepAlive interval.
+type sseEncoder struct {
+	mu   sync.Mutex
+	w    io.Writer
+	api  jsoniter.API
+	last time.Time
+	done chan struct{}
+	once sync.Once
+}
+
+func newSSEEncoder(w io.Writer, api jsoniter.API, keepAlive time.Duration) *sseEncoder {
+	e := &sseEncoder{w: w, api: api, last: time.Now(), done: make(chan struct{})}
+	if keepAlive > 0 {
+		go e.keepAlive(keepAlive)
+	}
+	return e
+}
+
+func (e *sseEncoder) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.done:
+			return
+		case now := <-ticker.C:
+			e.mu.Lock()
+			select {
+			case <-e.done:
+			default:
+				if now.Sub(e.last) >= interval {
+					_ = e.writeLocked([]byte(": keep-alive\n\n"))
+				}
+			}
+			e.mu.Unlock()
+		}
+	}
+}
+
+func (e *sseEncoder) writeLocked(b []byte) error {
+	e.last = time.Now()
+	if _, err := e.w.Write(b); err != nil {
+		return err
+	}
+	flush(e.w)
+	return nil
+}
+
+func (e *sseEncoder) event(name string, v interface{}) error {
+	b, err := e.api.Marshal(v)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.writeLocked(append(append([]byte("event: "+name+"\ndata: "), b...), '\n', '\n'))
+}
+
+func (e *sseEncoder) Encode(part interface{}) error { return e.event("part", part) }
+
+// EncodeError sends the error event, and stops the keep-alives.
+func (e *sseEncoder) EncodeError(err error) error {
+	e.stop()
+	return e.event("error", truncatedMarker{Error: err.Error(), Truncated: true})
+}
+
+// Close sends the end event, and stops the keep-alives.
+func (e *sseEncoder) Close() error {
+	e.stop()
+	return e.event("end", struct{}{})
+}
+
+func (e *sseEncoder) stop() { e.once.Do(func() { close(e.done) }) }
@@ -0,0 +1,69 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+func TestSSE(t *testing.T) {
+	h := JSONHandler{MergeStreams: true, Client: fakeClient{
+		"Get": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return &receiver{parts: []interface{}{map[string]interface{}{"a": 1}, map[string]interface{}{"a": 2}}}, nil
+		},
+		"Fail": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return &failingReceiver{receiver: receiver{parts: []interface{}{map[string]interface{}{"a": 1}}}, err: errors.New("broken")}, nil
+		},
+	}}
+	for name, want := range map[string]string{
+		"Get":  "event: part\ndata: {\"a\":1}\n\nevent: part\ndata: {\"a\":2}\n\nevent: end\ndata: {}\n\n",
+		"Fail": "event: part\ndata: {\"a\":1}\n\nevent: error\ndata: {\"error\":\"broken\",\"truncated\":true}\n\n",
+	} {
+		r := httptest.NewRequest("POST", "/"+name, strings.NewReader("{}"))
+		r.Header.Set("Accept", "text/event-stream")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("%s: got Content-Type %q", name, ct)
+		}
+		if got := w.Body.String(); got != want {
+			t.Errorf("%s: got %q, wanted %q", name, got, want)
+		}
+		if !w.Flushed {
+			t.Errorf("%s: not flushed", name)
+		}
+	}
+}
+
+func TestSSEKeepAlive(t *testing.T) {
+	var buf bytes.Buffer
+	enc := newSSEEncoder(&buf, jsoniter.ConfigDefault, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); !strings.HasPrefix(got, ": keep-alive\n\n") || !strings.HasSuffix(got, "event: end\ndata: {}\n\n") {
+		t.Errorf("got %q", got)
+	}
+}
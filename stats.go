@@ -0,0 +1,214 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"expvar"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+var _ = stats.Handler(multiStatsHandler(nil))
+
+// multiStatsHandler calls all the handlers, as grpc.WithStatsHandler accepts only one.
+type multiStatsHandler []stats.Handler
+
+// TagRPC calls TagRPC of each handler, chaining the returned contexts.
+func (hs multiStatsHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	for _, h := range hs {
+		ctx = h.TagRPC(ctx, info)
+	}
+	return ctx
+}
+
+// HandleRPC calls HandleRPC of each handler.
+func (hs multiStatsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	for _, h := range hs {
+		h.HandleRPC(ctx, s)
+	}
+}
+
+// TagConn calls TagConn of each handler, chaining the returned contexts.
+func (hs multiStatsHandler) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	for _, h := range hs {
+		ctx = h.TagConn(ctx, info)
+	}
+	return ctx
+}
+
+// HandleConn calls HandleConn of each handler.
+func (hs multiStatsHandler) HandleConn(ctx context.Context, s stats.ConnStats) {
+	for _, h := range hs {
+		h.HandleConn(ctx, s)
+	}
+}
+
+// MethodStats are the statistics of a method.
+type MethodStats struct {
+	Calls int64 `json:"calls"`
+	// Errors by status code name.
+	Errors map[string]int64 `json:"errors,omitempty"`
+	// P50 and P99 latency percentiles of the last StatsWindow calls, from Call till the end of the stream.
+	P50 time.Duration `json:"p50"`
+	P99 time.Duration `json:"p99"`
+	// BytesSent and BytesReceived are the serialized sizes of the protobuf inputs and parts.
+	BytesSent     int64 `json:"bytesSent"`
+	BytesReceived int64 `json:"bytesReceived"`
+}
+
+// StatsWindow is the number of the last latencies kept for the percentiles.
+var StatsWindow = 1024
+
+type methodStats struct {
+	MethodStats
+	latencies []time.Duration
+	next      int
+}
+
+func (ms *methodStats) add(d time.Duration, err error) {
+	if err != nil && err != io.EOF {
+		if ms.Errors == nil {
+			ms.Errors = make(map[string]int64)
+		}
+		ms.Errors[status.Code(err).String()]++
+	}
+	if len(ms.latencies) < StatsWindow {
+		ms.latencies = append(ms.latencies, d)
+		return
+	}
+	ms.latencies[ms.next] = d
+	ms.next = (ms.next + 1) % len(ms.latencies)
+}
+
+func (ms *methodStats) snapshot() MethodStats {
+	res := ms.MethodStats
+	if len(ms.Errors) != 0 {
+		res.Errors = make(map[string]int64, len(ms.Errors))
+		for k, v := range ms.Errors {
+			res.Errors[k] = v
+		}
+	}
+	if n := len(ms.latencies); n != 0 {
+		lat := append(make([]time.Duration, 0, n), ms.latencies...)
+		sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+		res.P50, res.P99 = lat[(n-1)*50/100], lat[(n-1)*99/100]
+	}
+	return res
+}
+
+// StatsClient is a Client which maintains per-method statistics.
+type StatsClient struct {
+	Client
+	mu      sync.Mutex
+	methods map[string]*methodStats
+}
+
+// WithStats returns a StatsClient counting the calls of c.
+func WithStats(c Client) *StatsClient {
+	return &StatsClient{Client: c, methods: make(map[string]*methodStats)}
+}
+
+// Stats returns a snapshot of the per-method statistics.
+func (sc *StatsClient) Stats() map[string]MethodStats {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	res := make(map[string]MethodStats, len(sc.methods))
+	for nm, ms := range sc.methods {
+		res[nm] = ms.snapshot()
+	}
+	return res
+}
+
+// Publish the statistics as an expvar with the given name.
+func (sc *StatsClient) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} { return sc.Stats() }))
+}
+
+func (sc *StatsClient) update(name string, f func(*methodStats)) {
+	sc.mu.Lock()
+	ms := sc.methods[name]
+	if ms == nil {
+		ms = new(methodStats)
+		sc.methods[name] = ms
+	}
+	f(ms)
+	sc.mu.Unlock()
+}
+
+// Call the named method, counting it.
+func (sc *StatsClient) Call(name string, ctx context.Context, input interface{}, opts ...grpc.CallOption) (Receiver, error) {
+	start := time.Now()
+	sent := protoSize(input)
+	sc.update(name, func(ms *methodStats) { ms.Calls++; ms.BytesSent += sent })
+	recv, err := sc.Client.Call(name, ctx, input, opts...)
+	if err != nil {
+		sc.update(name, func(ms *methodStats) { ms.add(time.Since(start), err) })
+		return recv, err
+	}
+	return &statsReceiver{Receiver: recv, sc: sc, name: name, start: start}, nil
+}
+
+// errReceiverClosed is the result of the calls closed before their end.
+var errReceiverClosed = status.Error(codes.Canceled, "receiver closed")
+
+type statsReceiver struct {
+	Receiver
+	sc    *StatsClient
+	name  string
+	start time.Time
+	done  bool
+}
+
+func (sr *statsReceiver) Recv() (interface{}, error) {
+	part, err := sr.Receiver.Recv()
+	if sr.done {
+		return part, err
+	}
+	if err != nil {
+		sr.done = true
+		sr.sc.update(sr.name, func(ms *methodStats) { ms.add(time.Since(sr.start), err) })
+		return part, err
+	}
+	if n := protoSize(part); n != 0 {
+		sr.sc.update(sr.name, func(ms *methodStats) { ms.BytesReceived += n })
+	}
+	return part, err
+}
+
+func (sr *statsReceiver) Close() error {
+	if !sr.done {
+		sr.done = true
+		sr.sc.update(sr.name, func(ms *methodStats) { ms.add(time.Since(sr.start), errReceiverClosed) })
+	}
+	return CloseReceiver(sr.Receiver)
+}
+
+// protoSize returns the serialized size of v if it is a protobuf message, 0 otherwise.
+func protoSize(v interface{}) int64 {
+	if pr, ok := v.(protoReflecter); ok {
+		return int64(proto.Size(pr.ProtoReflect().Interface()))
+	}
+	return 0
+}
@@ -0,0 +1,64 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestStats(t *testing.T) {
+	sc := WithStats(fakeClient{
+		"Check": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return &receiver{parts: []interface{}{&grpc_health_v1.HealthCheckResponse{
+				Status: grpc_health_v1.HealthCheckResponse_SERVING,
+			}}}, nil
+		},
+		"Fail": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return nil, status.Error(codes.Unavailable, "down")
+		},
+	})
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		recv, err := sc.Call("Check", ctx, &grpc_health_v1.HealthCheckRequest{Service: "x"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for {
+			if _, err = recv.Recv(); err != nil {
+				break
+			}
+		}
+	}
+	sc.Call("Fail", ctx, nil)
+	if recv, err := sc.Call("Check", ctx, &grpc_health_v1.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	} else if err = CloseReceiver(recv); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := sc.Stats()
+	if st := stats["Check"]; st.Calls != 4 || len(st.Errors) != 1 || st.Errors["Canceled"] != 1 || st.BytesSent != 9 || st.BytesReceived != 6 {
+		t.Errorf("Check: %+v", st)
+	}
+	if st := stats["Fail"]; st.Calls != 1 || st.Errors["Unavailable"] != 1 {
+		t.Errorf("Fail: %+v", st)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StatusRule translates a legacy error of a backend into a proper status.
+type StatusRule struct {
+	// From is the code of the matching errors (typically codes.Unknown).
+	From codes.Code
+	// Prefix the message must start with, which is trimmed from the new message.
+	Prefix string
+	// Pattern the message must match, if not nil.
+	Pattern *regexp.Regexp
+	// To is the new code.
+	To codes.Code
+	// Reason and Domain are added as an ErrorInfo detail, if Reason is not empty.
+	Reason, Domain string
+}
+
+// apply the rule to st, reporting whether it matched.
+func (sr StatusRule) apply(st *status.Status) (*status.Status, bool) {
+	msg := st.Message()
+	if st.Code() != sr.From || !strings.HasPrefix(msg, sr.Prefix) ||
+		sr.Pattern != nil && !sr.Pattern.MatchString(msg) {
+		return st, false
+	}
+	p := st.Proto()
+	p.Code = int32(sr.To)
+	p.Message = strings.TrimSpace(strings.TrimPrefix(msg, sr.Prefix))
+	nst := status.FromProto(p)
+	if sr.Reason != "" {
+		if withInfo, err := nst.WithDetails(&errdetails.ErrorInfo{Reason: sr.Reason, Domain: sr.Domain}); err == nil {
+			nst = withInfo
+		}
+	}
+	return nst, true
+}
+
+// mapStatus applies the first matching rule to err.
+func mapStatus(rules []StatusRule, err error) error {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() == codes.OK {
+		return err
+	}
+	for _, r := range rules {
+		if nst, ok := r.apply(st); ok {
+			return nst.Err()
+		}
+	}
+	return err
+}
+
+type statusMapClient struct {
+	Client
+	rules []StatusRule
+}
+
+// WithStatusMapping returns a Client which translates the errors of the calls
+// (and of the streams' Recv) by the first matching rule.
+func WithStatusMapping(c Client, rules []StatusRule) Client {
+	return statusMapClient{Client: c, rules: rules}
+}
+
+func (sc statusMapClient) Call(name string, ctx context.Context, input interface{}, opts ...grpc.CallOption) (Receiver, error) {
+	recv, err := sc.Client.Call(name, ctx, input, opts...)
+	if err != nil {
+		return recv, mapStatus(sc.rules, err)
+	}
+	return statusMapReceiver{Receiver: recv, rules: sc.rules}, nil
+}
+
+type statusMapReceiver struct {
+	Receiver
+	rules []StatusRule
+}
+
+func (sr statusMapReceiver) Recv() (interface{}, error) {
+	part, err := sr.Receiver.Recv()
+	if err != nil {
+		err = mapStatus(sr.rules, err)
+	}
+	return part, err
+}
+
+func (sr statusMapReceiver) Close() error { return CloseReceiver(sr.Receiver) }
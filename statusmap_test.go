@@ -0,0 +1,81 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"io"
+	"regexp"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStatusMapping(t *testing.T) {
+	c := WithStatusMapping(fakeClient{
+		"Get": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return nil, status.Error(codes.Unknown, "ERR-404: customer 42 not found")
+		},
+		"Stream": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return &failingReceiver{receiver: receiver{parts: []interface{}{1}}, err: status.Error(codes.Unknown, "ORA-00054: resource busy")}, nil
+		},
+		"Other": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return nil, status.Error(codes.Unknown, "something else")
+		},
+	}, []StatusRule{
+		{From: codes.Unknown, Prefix: "ERR-404:", To: codes.NotFound, Reason: "NOT_FOUND", Domain: "legacy"},
+		{From: codes.Unknown, Pattern: regexp.MustCompile(`^ORA-000(54|60)`), To: codes.Unavailable},
+	})
+	ctx := context.Background()
+
+	_, err := c.Call("Get", ctx, nil)
+	st := status.Convert(err)
+	if st.Code() != codes.NotFound || st.Message() != "customer 42 not found" {
+		t.Errorf("got %+v", err)
+	}
+	if d := st.Details(); len(d) != 1 || d[0].(*errdetails.ErrorInfo).GetReason() != "NOT_FOUND" {
+		t.Errorf("details: %+v", d)
+	}
+
+	recv, err := c.Call("Stream", ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv.Recv()
+	if _, err = recv.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("stream: got %+v", err)
+	}
+
+	if _, err = c.Call("Other", ctx, nil); status.Code(err) != codes.Unknown {
+		t.Errorf("unmatched: got %+v", err)
+	}
+}
+
+// failingReceiver returns err after the parts.
+type failingReceiver struct {
+	receiver
+	err error
+}
+
+func (fr *failingReceiver) Recv() (interface{}, error) {
+	part, err := fr.receiver.Recv()
+	if err == io.EOF {
+		err = fr.err
+	}
+	return part, err
+}
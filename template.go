@@ -0,0 +1,121 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	jsoniter "github.com/json-iterator/go"
+	"google.golang.org/grpc"
+)
+
+type templateClient struct {
+	Client
+	templates map[string]interface{}
+}
+
+// WithInputTemplates returns a Client whose Call merges the given input over the method's JSON template.
+//
+// The set (non-zero) fields of the input are merged: nested messages and maps are merged,
+// slices and the other values are replaced.
+func WithInputTemplates(c Client, templates map[string][]byte) (Client, error) {
+	parsed := make(map[string]interface{}, len(templates))
+	for name, tmpl := range templates {
+		inp := c.Input(name)
+		if inp == nil {
+			return nil, fmt.Errorf("%s: unknown method", name)
+		}
+		if err := jsoniter.Unmarshal(tmpl, inp); err != nil {
+			return nil, fmt.Errorf("%s: template %s: %w", name, tmpl, err)
+		}
+		parsed[name] = inp
+	}
+	return templateClient{Client: c, templates: parsed}, nil
+}
+
+// Call the named method with the input merged over the template.
+func (tc templateClient) Call(name string, ctx context.Context, input interface{}, opts ...grpc.CallOption) (Receiver, error) {
+	tmpl, ok := tc.templates[name]
+	if !ok || input == nil || reflect.TypeOf(tmpl) != reflect.TypeOf(input) {
+		return tc.Client.Call(name, ctx, input, opts...)
+	}
+	merged := tc.Client.Input(name)
+	dst := reflect.ValueOf(merged)
+	mergeSetFields(dst, reflect.ValueOf(tmpl))
+	mergeSetFields(dst, reflect.ValueOf(input))
+	return tc.Client.Call(name, ctx, merged, opts...)
+}
+
+// mergeSetFields merges the set fields of src into dst, copying the slices and maps
+// so dst shares no memory with src (except the values in interfaces, such as oneofs).
+func mergeSetFields(dst, src reflect.Value) {
+	switch src.Kind() {
+	case reflect.Ptr:
+		if src.IsNil() {
+			return
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.New(src.Type().Elem()))
+		}
+		if src.Elem().Kind() == reflect.Struct {
+			mergeSetFields(dst.Elem(), src.Elem())
+		} else {
+			dst.Elem().Set(src.Elem())
+		}
+	case reflect.Struct:
+		if _, ok := dst.Addr().Interface().(protoReflecter); !ok && hasUnexportedFields(src.Type()) {
+			if !src.IsZero() { // an opaque value, such as time.Time
+				dst.Set(src)
+			}
+			return
+		}
+		for i := 0; i < src.NumField(); i++ {
+			if f := dst.Field(i); f.CanSet() {
+				mergeSetFields(f, src.Field(i))
+			}
+		}
+	case reflect.Map:
+		if src.IsNil() {
+			return
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMapWithSize(src.Type(), src.Len()))
+		}
+		for it := src.MapRange(); it.Next(); {
+			dst.SetMapIndex(it.Key(), it.Value())
+		}
+	case reflect.Slice:
+		if src.IsNil() {
+			return
+		}
+		dst.Set(reflect.AppendSlice(reflect.MakeSlice(src.Type(), 0, src.Len()), src))
+	default:
+		if !src.IsZero() {
+			dst.Set(src)
+		}
+	}
+}
+
+func hasUnexportedFields(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath != "" {
+			return true
+		}
+	}
+	return false
+}
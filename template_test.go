@@ -0,0 +1,73 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type tmplNested struct {
+	A, B int `json:",omitempty"`
+}
+type tmplInput struct {
+	Const  string      `json:",omitempty"`
+	Own    bool        `json:",omitempty"`
+	Nested *tmplNested `json:",omitempty"`
+	Tags   []string
+	Labels map[string]string
+	At     time.Time
+}
+
+type tmplClient struct{ fakeClient }
+
+func (tmplClient) Input(name string) interface{} { return new(tmplInput) }
+
+func TestInputTemplates(t *testing.T) {
+	var got *tmplInput
+	c, err := WithInputTemplates(tmplClient{fakeClient{"M": func(ctx context.Context, input interface{}) (Receiver, error) {
+		got = input.(*tmplInput)
+		return &receiver{}, nil
+	}}}, map[string][]byte{"M": []byte(`{"Const":"c","Nested":{"A":1,"B":2},"Tags":["t"],"Labels":{"a":"1"}}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tc := range []struct {
+		In, Want *tmplInput
+	}{
+		{In: &tmplInput{Own: true, Nested: &tmplNested{B: 3}},
+			Want: &tmplInput{Const: "c", Own: true, Nested: &tmplNested{A: 1, B: 3}, Tags: []string{"t"}, Labels: map[string]string{"a": "1"}}},
+		{In: &tmplInput{Const: "own", Tags: []string{"x", "y"}, Labels: map[string]string{"b": "2"}, At: time.Unix(1, 0)},
+			Want: &tmplInput{Const: "own", Nested: &tmplNested{A: 1, B: 2}, Tags: []string{"x", "y"}, Labels: map[string]string{"a": "1", "b": "2"}, At: time.Unix(1, 0)}},
+	} {
+		if _, err = c.Call("M", context.Background(), tc.In); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tc.Want) {
+			t.Errorf("%d. got %+v, wanted %+v", i, got, tc.Want)
+		}
+	}
+	// the template is not modified
+	if got.Nested.B = 9; c.(templateClient).templates["M"].(*tmplInput).Nested.B != 2 {
+		t.Error("the merged input shares memory with the template")
+	}
+
+	if _, err = WithInputTemplates(fakeClient{}, map[string][]byte{"X": []byte("{}")}); err == nil {
+		t.Error("wanted error for unknown method")
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"reflect"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/modern-go/reflect2"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// The special TimeFormat layouts, encoded as JSON numbers.
+const (
+	EpochSeconds = "epoch"
+	EpochMillis  = "epochmillis"
+)
+
+// TimeFormat is the JSON encoding of the times
+// (time.Time, structs embedding it and timestamppb.Timestamp).
+type TimeFormat struct {
+	// Layout is a time.Format layout (e.g. "20060102150405"), or EpochSeconds or EpochMillis.
+	Layout string
+	// Location is the time zone to convert to, unchanged if nil.
+	Location *time.Location
+}
+
+func (tf TimeFormat) encode(t time.Time, stream *jsoniter.Stream) {
+	switch tf.Layout {
+	case EpochSeconds:
+		stream.WriteInt64(t.Unix())
+		return
+	case EpochMillis:
+		stream.WriteInt64(t.UnixMilli())
+		return
+	}
+	if tf.Location != nil {
+		t = t.In(tf.Location)
+	}
+	stream.WriteString(t.Format(tf.Layout))
+}
+
+type timeFormats struct {
+	global  *TimeFormat
+	byField map[string]TimeFormat
+}
+
+var currentTimeFormats atomic.Pointer[timeFormats]
+
+// SetTimeFormats sets the global time format (the types' own encoding if nil),
+// and the formats of the fields by Go or JSON field name, overriding the global.
+func SetTimeFormats(global *TimeFormat, byField map[string]TimeFormat) {
+	currentTimeFormats.Store(&timeFormats{global: global, byField: byField})
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	timestampType = reflect.TypeOf(timestamppb.Timestamp{})
+)
+
+// timeOf returns the function returning the time at ptr, if typ is a time type
+// or a pointer to one (the zero time for nil).
+func timeOf(typ reflect.Type) func(unsafe.Pointer) time.Time {
+	switch {
+	case typ.Kind() == reflect.Ptr:
+		get := timeOf(typ.Elem())
+		if get == nil {
+			return nil
+		}
+		return func(ptr unsafe.Pointer) time.Time {
+			if p := *(*unsafe.Pointer)(ptr); p != nil {
+				return get(p)
+			}
+			return time.Time{}
+		}
+	case typ == timeType:
+		return func(ptr unsafe.Pointer) time.Time { return *(*time.Time)(ptr) }
+	case typ == timestampType:
+		return func(ptr unsafe.Pointer) time.Time {
+			return (*timestamppb.Timestamp)(ptr).AsTime()
+		}
+	case typ.Kind() == reflect.Struct && typ.NumField() != 0 &&
+		typ.Field(0).Anonymous && typ.Field(0).Type == timeType:
+		// e.g. custom.DateTime
+		return func(ptr unsafe.Pointer) time.Time { return *(*time.Time)(ptr) }
+	}
+	return nil
+}
+
+// timeFormatExtension encodes the times as set by SetTimeFormats.
+type timeFormatExtension struct {
+	jsoniter.DummyExtension
+}
+
+func (*timeFormatExtension) DecorateEncoder(typ reflect2.Type, encoder jsoniter.ValEncoder) jsoniter.ValEncoder {
+	if get := timeOf(typ.Type1()); get != nil {
+		return timeEncoder{ValEncoder: encoder, get: get}
+	}
+	return encoder
+}
+
+func (*timeFormatExtension) UpdateStructDescriptor(sd *jsoniter.StructDescriptor) {
+	for _, binding := range sd.Fields {
+		if timeOf(binding.Field.Type().Type1()) == nil {
+			continue
+		}
+		names := append([]string{binding.Field.Name()}, binding.ToNames...)
+		binding.Encoder = fieldTimeEncoder{ValEncoder: binding.Encoder, names: names}
+	}
+}
+
+// timeEncoder encodes with the global TimeFormat, if set.
+type timeEncoder struct {
+	jsoniter.ValEncoder
+	get func(unsafe.Pointer) time.Time
+}
+
+func (te timeEncoder) Encode(ptr unsafe.Pointer, stream *jsoniter.Stream) {
+	format, ok := stream.Attachment.(TimeFormat)
+	if !ok {
+		if tfs := currentTimeFormats.Load(); tfs != nil && tfs.global != nil {
+			format, ok = *tfs.global, true
+		}
+	}
+	if ok {
+		if t := te.get(ptr); !t.IsZero() {
+			format.encode(t, stream)
+			return
+		}
+	}
+	te.ValEncoder.Encode(ptr, stream)
+}
+
+// fieldTimeEncoder passes the field's TimeFormat to the timeEncoder in the stream's Attachment.
+type fieldTimeEncoder struct {
+	jsoniter.ValEncoder
+	names []string
+}
+
+func (fe fieldTimeEncoder) Encode(ptr unsafe.Pointer, stream *jsoniter.Stream) {
+	tfs := currentTimeFormats.Load()
+	if tfs == nil || len(tfs.byField) == 0 {
+		fe.ValEncoder.Encode(ptr, stream)
+		return
+	}
+	for _, nm := range fe.names {
+		if tf, ok := tfs.byField[nm]; ok {
+			prev := stream.Attachment
+			stream.Attachment = tf
+			fe.ValEncoder.Encode(ptr, stream)
+			stream.Attachment = prev
+			return
+		}
+	}
+	fe.ValEncoder.Encode(ptr, stream)
+}
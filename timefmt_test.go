@@ -0,0 +1,70 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestTimeFormats(t *testing.T) {
+	defer SetTimeFormats(nil, nil)
+	type embedded struct{ time.Time }
+	type S struct {
+		Created  time.Time
+		Modified *time.Time `json:"modified"`
+		TS       *timestamppb.Timestamp
+		Emb      embedded
+		Zero     time.Time
+	}
+	now := time.Date(2020, 3, 4, 5, 6, 7, 0, time.UTC)
+	v := S{Created: now, Modified: &now, TS: timestamppb.New(now), Emb: embedded{now}}
+
+	budapest, err := time.LoadLocation("Europe/Budapest")
+	if err != nil {
+		t.Skip(err)
+	}
+	SetTimeFormats(&TimeFormat{Layout: "20060102150405", Location: budapest},
+		map[string]TimeFormat{"modified": {Layout: EpochMillis}, "TS": {Layout: time.RFC3339}})
+	b, err := jsoniter.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `{"Created":"20200304060607","modified":1583298367000,"TS":"2020-03-04T05:06:07Z","Emb":"20200304060607","Zero":"0001-01-01T00:00:00Z"}`
+	if string(b) != want {
+		t.Errorf("got %s,\nwanted %s", b, want)
+	}
+
+	// the layout's literal text is escaped as JSON
+	SetTimeFormats(&TimeFormat{Layout: "2006\x01"}, nil)
+	if b, err = jsoniter.Marshal(struct{ T time.Time }{now}); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"T":"2020\u0001"}` {
+		t.Errorf("escaped: got %s", b)
+	}
+
+	SetTimeFormats(nil, nil)
+	if b, err = jsoniter.Marshal(v); err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); s[:35] != `{"Created":"2020-03-04T05:06:07Z","` {
+		t.Errorf("default: got %s", b)
+	}
+}
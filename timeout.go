@@ -0,0 +1,227 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type timeoutClient struct {
+	Client
+	byMethod       map[string]time.Duration
+	defaultTimeout time.Duration
+}
+
+// WithTimeouts returns a Client which applies the method's timeout from byMethod
+// (or defaultTimeout) to the calls whose context has no deadline.
+//
+// The context is canceled when the Receiver returns an error (io.EOF included).
+func WithTimeouts(c Client, byMethod map[string]time.Duration, defaultTimeout time.Duration) Client {
+	return timeoutClient{Client: c, byMethod: byMethod, defaultTimeout: defaultTimeout}
+}
+
+func (tc timeoutClient) timeout(name string) time.Duration {
+	if d, ok := tc.byMethod[name]; ok {
+		return d
+	}
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		if d, ok := tc.byMethod[name[i+1:]]; ok {
+			return d
+		}
+	}
+	return tc.defaultTimeout
+}
+
+// Call the named method with the method's timeout, if the context has no deadline.
+func (tc timeoutClient) Call(name string, ctx context.Context, input interface{}, opts ...grpc.CallOption) (Receiver, error) {
+	if _, ok := ctx.Deadline(); ok {
+		return tc.Client.Call(name, ctx, input, opts...)
+	}
+	timeout := tc.timeout(name)
+	if timeout <= 0 {
+		return tc.Client.Call(name, ctx, input, opts...)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	recv, err := tc.Client.Call(name, ctx, input, opts...)
+	if err != nil {
+		cancel()
+		return recv, err
+	}
+	return &cancelReceiver{Receiver: recv, cancel: cancel}, nil
+}
+
+// cancelReceiver calls cancel when Recv returns an error.
+type cancelReceiver struct {
+	Receiver
+	cancel context.CancelFunc
+}
+
+func (cr *cancelReceiver) Recv() (interface{}, error) {
+	part, err := cr.Receiver.Recv()
+	if err != nil {
+		cr.cancel()
+	}
+	return part, err
+}
+
+func (cr *cancelReceiver) Close() error {
+	err := CloseReceiver(cr.Receiver)
+	cr.cancel()
+	return err
+}
+
+// callTimeout is the interceptor applying the timeout to the calls without a deadline,
+// see DialConfig.DefaultCallTimeout.
+type callTimeout time.Duration
+
+func (ct callTimeout) UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(ct))
+		defer cancel()
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func (ct callTimeout) StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if _, ok := ctx.Deadline(); ok {
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(ct))
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		cancel()
+		return cs, err
+	}
+	return cancelStream{ClientStream: cs, cancel: cancel}, nil
+}
+
+// cancelStream calls cancel when RecvMsg returns an error (io.EOF included).
+type cancelStream struct {
+	grpc.ClientStream
+	cancel context.CancelFunc
+}
+
+func (cs cancelStream) RecvMsg(m interface{}) error {
+	err := cs.ClientStream.RecvMsg(m)
+	if err != nil {
+		cs.cancel()
+	}
+	return err
+}
+
+var errIdleTimeout = status.Error(codes.DeadlineExceeded, "idle timeout between stream parts")
+
+// idleTimer cancels its context if not reset in time.
+type idleTimer struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	d      time.Duration
+	mu     sync.Mutex
+	timer  *time.Timer
+	fired  bool
+}
+
+// newIdleTimer returns an idleTimer with a child context of ctx,
+// which is canceled if the timer is not reset in d.
+func newIdleTimer(ctx context.Context, d time.Duration) *idleTimer {
+	it := &idleTimer{d: d}
+	it.ctx, it.cancel = context.WithCancel(ctx)
+	it.timer = time.AfterFunc(d, func() {
+		it.mu.Lock()
+		it.fired = true
+		it.mu.Unlock()
+		it.cancel()
+	})
+	return it
+}
+
+// reset the timer, reporting whether it has already fired.
+func (it *idleTimer) reset() bool {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if !it.fired {
+		it.timer.Reset(it.d)
+	}
+	return it.fired
+}
+
+// Stop the timer and cancel the context.
+func (it *idleTimer) Stop() {
+	it.timer.Stop()
+	it.cancel()
+}
+
+// idleReceiver resets the idleTimer on each part,
+// and returns errIdleTimeout if it has fired.
+type idleReceiver struct {
+	Receiver
+	it *idleTimer
+}
+
+func (ir *idleReceiver) Recv() (interface{}, error) {
+	part, err := ir.Receiver.Recv()
+	if fired := ir.it.reset(); fired && err != nil {
+		return part, errIdleTimeout
+	}
+	return part, err
+}
+
+func (ir *idleReceiver) Close() error { return CloseReceiver(ir.Receiver) }
+
+// RequestTimeoutHeader is the HTTP header of the client's timeout,
+// in seconds (1.5) or as a Go duration (1500ms).
+const RequestTimeoutHeader = "X-Request-Timeout"
+
+// DefaultTimeoutMargin is subtracted from the incoming deadlines,
+// to have time for sending the response.
+var DefaultTimeoutMargin = 100 * time.Millisecond
+
+// incomingDeadline returns the earlier of the RequestTimeoutHeader and the
+// server's WriteTimeout deadline of r, minus margin.
+func incomingDeadline(r *http.Request, margin time.Duration) (time.Time, bool) {
+	now := time.Now()
+	var timeout time.Duration
+	if s := r.Header.Get(RequestTimeoutHeader); s != "" {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			timeout = time.Duration(f * float64(time.Second))
+		} else if d, err := time.ParseDuration(s); err == nil {
+			timeout = d
+		}
+	}
+	if srv, ok := r.Context().Value(http.ServerContextKey).(*http.Server); ok && srv.WriteTimeout > 0 {
+		if timeout <= 0 || srv.WriteTimeout < timeout {
+			timeout = srv.WriteTimeout
+		}
+	}
+	if timeout <= 0 {
+		return time.Time{}, false
+	}
+	if timeout -= margin; timeout <= 0 {
+		// leave at least a moment for the call to fail with DeadlineExceeded
+		timeout = time.Millisecond
+	}
+	return now.Add(timeout), true
+}
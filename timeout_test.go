@@ -0,0 +1,242 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// slowReceiver returns the parts after the delays.
+type slowReceiver struct {
+	ctx    context.Context
+	parts  []interface{}
+	delays []time.Duration
+}
+
+func (sr *slowReceiver) Recv() (interface{}, error) {
+	if len(sr.parts) == 0 {
+		return (&receiver{}).Recv()
+	}
+	select {
+	case <-time.After(sr.delays[0]):
+	case <-sr.ctx.Done():
+		return nil, sr.ctx.Err()
+	}
+	part := sr.parts[0]
+	sr.parts, sr.delays = sr.parts[1:], sr.delays[1:]
+	return part, nil
+}
+
+func TestJSONHandlerIdleTimeout(t *testing.T) {
+	var delays []time.Duration
+	h := JSONHandler{
+		Client: fakeClient{"Export": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return &slowReceiver{ctx: ctx, parts: []interface{}{1, 2}, delays: delays}, nil
+		}},
+		IdleTimeouts: map[string]time.Duration{"Export": 50 * time.Millisecond},
+	}
+	for tN, tC := range map[string]struct {
+		Delays []time.Duration
+		Code   int
+		Body   string
+	}{
+		"ok":        {Delays: []time.Duration{0, 10 * time.Millisecond}, Code: 200, Body: "1\n2\n"},
+		"first":     {Delays: []time.Duration{time.Second, 0}, Code: http.StatusGatewayTimeout},
+		"truncated": {Delays: []time.Duration{0, time.Second}, Code: 200, Body: "1\n" + `{"error":"rpc error: code = DeadlineExceeded desc = idle timeout between stream parts","truncated":true}` + "\n"},
+	} {
+		delays = tC.Delays
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/Export", strings.NewReader("{}")))
+		if w.Code != tC.Code {
+			t.Errorf("%s: got %d, wanted %d", tN, w.Code, tC.Code)
+		}
+		if tC.Body != "" && w.Body.String() != tC.Body {
+			t.Errorf("%s: got %q, wanted %q", tN, w.Body.String(), tC.Body)
+		}
+	}
+}
+
+func TestIncomingDeadline(t *testing.T) {
+	srv := &http.Server{WriteTimeout: 10 * time.Second}
+	for tN, tC := range map[string]struct {
+		Header string
+		Server *http.Server
+		Want   time.Duration
+	}{
+		"none":     {},
+		"seconds":  {Header: "1.5", Want: 1400 * time.Millisecond},
+		"duration": {Header: "500ms", Want: 400 * time.Millisecond},
+		"server":   {Server: srv, Want: 10*time.Second - 100*time.Millisecond},
+		"earlier":  {Header: "20", Server: srv, Want: 10*time.Second - 100*time.Millisecond},
+		"tiny":     {Header: "10ms", Want: time.Millisecond},
+	} {
+		r := httptest.NewRequest("POST", "/", nil)
+		if tC.Header != "" {
+			r.Header.Set(RequestTimeoutHeader, tC.Header)
+		}
+		if tC.Server != nil {
+			r = r.WithContext(context.WithValue(r.Context(), http.ServerContextKey, tC.Server))
+		}
+		start := time.Now()
+		deadline, ok := incomingDeadline(r, 100*time.Millisecond)
+		if ok != (tC.Want != 0) {
+			t.Errorf("%s: got %t", tN, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if got := deadline.Sub(start); got < tC.Want || got > tC.Want+50*time.Millisecond {
+			t.Errorf("%s: got %s, wanted %s", tN, got, tC.Want)
+		}
+	}
+}
+
+func TestJSONHandlerDeadline(t *testing.T) {
+	var deadline time.Time
+	h := JSONHandler{
+		Client: fakeClient{"Get": func(ctx context.Context, input interface{}) (Receiver, error) {
+			deadline, _ = ctx.Deadline()
+			return &receiver{parts: []interface{}{1}}, nil
+		}},
+		Timeout:  time.Minute,
+		Timeouts: map[string]time.Duration{"Get": 10 * time.Second},
+	}
+	for tN, tC := range map[string]struct {
+		Header string
+		Want   time.Duration
+	}{
+		"configured": {Want: 10 * time.Second},
+		"shorter":    {Header: "1.1", Want: time.Second},
+		"longer":     {Header: "3600", Want: 10 * time.Second},
+	} {
+		r := httptest.NewRequest("POST", "/Get", strings.NewReader("{}"))
+		if tC.Header != "" {
+			r.Header.Set(RequestTimeoutHeader, tC.Header)
+		}
+		start := time.Now()
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		if got := deadline.Sub(start); got < tC.Want || got > tC.Want+50*time.Millisecond {
+			t.Errorf("%s: got %s, wanted %s", tN, got, tC.Want)
+		}
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	ct := callTimeout(time.Minute)
+	var deadline time.Time
+	var ok bool
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		deadline, ok = ctx.Deadline()
+		return nil
+	}
+	_ = ct.UnaryClientInterceptor(context.Background(), "/Get", nil, nil, nil, invoker)
+	if d := time.Until(deadline); !ok || d <= 0 || d > time.Minute {
+		t.Errorf("got deadline %v (%t), wanted in a minute", deadline, ok)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	_ = ct.UnaryClientInterceptor(ctx, "/Get", nil, nil, nil, invoker)
+	if d := time.Until(deadline); d <= time.Minute {
+		t.Errorf("got deadline %v, wanted the caller's", deadline)
+	}
+
+	var streamCtx context.Context
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		streamCtx = ctx
+		return replayStream{err: io.EOF}, nil
+	}
+	cs, err := ct.StreamClientInterceptor(context.Background(), &grpc.StreamDesc{ServerStreams: true}, nil, "/List", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := streamCtx.Deadline(); !ok {
+		t.Error("stream has no deadline")
+	}
+	if err = cs.RecvMsg(nil); err != io.EOF {
+		t.Errorf("got %+v, wanted EOF", err)
+	}
+	if streamCtx.Err() == nil {
+		t.Error("stream context is not canceled at the end")
+	}
+}
+
+func TestWithTimeouts(t *testing.T) {
+	var got context.Context
+	capture := func(ctx context.Context, input interface{}) (Receiver, error) {
+		got = ctx
+		return &receiver{parts: []interface{}{1}}, nil
+	}
+	c := WithTimeouts(fakeClient{"Get": capture, "/pkg.Svc/Get": capture, "Other": capture},
+		map[string]time.Duration{"Get": time.Minute}, time.Hour)
+	noDefault := WithTimeouts(fakeClient{"Other": capture}, map[string]time.Duration{"Get": time.Minute}, 0)
+
+	withDeadline, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for name, tc := range map[string]struct {
+		Client Client
+		Ctx    context.Context
+		Method string
+		Want   time.Duration // 0: no deadline
+	}{
+		"method":          {Client: c, Ctx: context.Background(), Method: "Get", Want: time.Minute},
+		"qualified":       {Client: c, Ctx: context.Background(), Method: "/pkg.Svc/Get", Want: time.Minute},
+		"default":         {Client: c, Ctx: context.Background(), Method: "Other", Want: time.Hour},
+		"no default":      {Client: noDefault, Ctx: context.Background(), Method: "Other"},
+		"caller deadline": {Client: c, Ctx: withDeadline, Method: "Get", Want: 10 * time.Second},
+	} {
+		recv, err := tc.Client.Call(tc.Method, tc.Ctx, nil)
+		if err != nil {
+			t.Fatalf("%s: %+v", name, err)
+		}
+		deadline, ok := got.Deadline()
+		if tc.Want == 0 {
+			if ok {
+				t.Errorf("%s: got deadline %s, wanted none", name, time.Until(deadline))
+			}
+		} else if d := time.Until(deadline); !ok || d > tc.Want || d < tc.Want-time.Second {
+			t.Errorf("%s: got deadline in %s (%t), wanted %s", name, d, ok, tc.Want)
+		}
+
+		// the applied timeout is canceled on Close
+		if err = CloseReceiver(recv); err != nil {
+			t.Fatalf("%s: %+v", name, err)
+		}
+		if canceled := got.Err() == context.Canceled; canceled != (tc.Want != 0 && tc.Ctx == context.Background()) {
+			t.Errorf("%s: got canceled=%t after Close", name, canceled)
+		}
+	}
+
+	// and at the end of the stream
+	recv, err := c.Call("Get", context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for err == nil {
+		_, err = recv.Recv()
+	}
+	if got.Err() != context.Canceled {
+		t.Errorf("got %v at the end of the stream, wanted canceled", got.Err())
+	}
+}
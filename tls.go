@@ -0,0 +1,167 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// useTLS reports whether the config requires a TLS connection.
+func (conf DialConfig) useTLS() bool {
+	return conf.TLS || conf.CAFile != "" || len(conf.CAFiles) != 0 || len(conf.CAPEM) != 0 || conf.CASecret != "" ||
+		conf.CertFile != "" || conf.Certificate != nil
+}
+
+// tlsConfig returns the TLS client config: the CA pool, the ServerHostOverride
+// and the client certificate, if set.
+func tlsConfig(conf DialConfig) (*tls.Config, error) {
+	pool, err := certPool(conf)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{ServerName: conf.ServerHostOverride, RootCAs: pool}
+	switch {
+	case conf.Certificate != nil:
+		cfg.Certificates = []tls.Certificate{*conf.Certificate}
+	case conf.CertFile != "":
+		kp := &keyPair{certFile: conf.CertFile, keyFile: conf.KeyFile, reload: conf.ReloadKeyPair}
+		if err := kp.load(); err != nil {
+			return nil, err
+		}
+		cfg.GetClientCertificate = kp.GetClientCertificate
+	}
+	return cfg, nil
+}
+
+// certPool returns the pool of the CA certificates from CAFile, CAFiles, CAPEM and CASecret,
+// on top of the system roots if SystemRoots is set.
+//
+// Returns nil (the system roots) if no CA is given.
+func certPool(conf DialConfig) (*x509.CertPool, error) {
+	type source struct {
+		name string
+		pem  []byte
+	}
+	var sources []source
+	for _, fn := range append([]string{conf.CAFile}, conf.CAFiles...) {
+		if fn == "" {
+			continue
+		}
+		b, err := os.ReadFile(fn)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source{name: fn, pem: b})
+	}
+	if len(conf.CAPEM) != 0 {
+		sources = append(sources, source{name: "CAPEM", pem: conf.CAPEM})
+	}
+	if conf.CASecret != "" {
+		if conf.Secrets == nil {
+			return nil, fmt.Errorf("CASecret %q: %w", conf.CASecret, errNoSecrets)
+		}
+		s, err := conf.Secrets.Secret(context.Background(), conf.CASecret)
+		if err != nil {
+			return nil, fmt.Errorf("CASecret %q: %w", conf.CASecret, err)
+		}
+		sources = append(sources, source{name: conf.CASecret, pem: []byte(s)})
+	}
+	if len(sources) == 0 {
+		return nil, nil
+	}
+
+	pool := x509.NewCertPool()
+	if conf.SystemRoots {
+		var err error
+		if pool, err = x509.SystemCertPool(); err != nil {
+			return nil, fmt.Errorf("system cert pool: %w", err)
+		}
+	}
+	for _, src := range sources {
+		if !pool.AppendCertsFromPEM(src.pem) {
+			return nil, fmt.Errorf("%q: no certificates found", src.name)
+		}
+	}
+	return pool, nil
+}
+
+var errNoSecrets = errors.New("no Secrets set")
+
+// keyPair is the client certificate loaded from certFile and keyFile,
+// reloaded when the files change, if reload is set.
+type keyPair struct {
+	certFile, keyFile string
+	reload            bool
+
+	mu              sync.Mutex
+	cert            *tls.Certificate
+	certMod, keyMod time.Time
+}
+
+func (kp *keyPair) load() error {
+	certMod, keyMod, err := kp.modTimes()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(kp.certFile, kp.keyFile)
+	if err != nil {
+		return fmt.Errorf("load %q,%q: %w", kp.certFile, kp.keyFile, err)
+	}
+	kp.mu.Lock()
+	kp.cert, kp.certMod, kp.keyMod = &cert, certMod, keyMod
+	kp.mu.Unlock()
+	return nil
+}
+
+func (kp *keyPair) modTimes() (certMod, keyMod time.Time, err error) {
+	fi, err := os.Stat(kp.certFile)
+	if err != nil {
+		return certMod, keyMod, err
+	}
+	certMod = fi.ModTime()
+	if fi, err = os.Stat(kp.keyFile); err != nil {
+		return certMod, keyMod, err
+	}
+	return certMod, fi.ModTime(), nil
+}
+
+// GetClientCertificate returns the certificate, reloading it first if the files has changed.
+//
+// A failed reload keeps the previous certificate.
+func (kp *keyPair) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if kp.reload {
+		certMod, keyMod, err := kp.modTimes()
+		kp.mu.Lock()
+		changed := err == nil && !(certMod.Equal(kp.certMod) && keyMod.Equal(kp.keyMod))
+		kp.mu.Unlock()
+		if changed {
+			_ = kp.load()
+		}
+	}
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	if kp.cert == nil {
+		return nil, errors.New("no client certificate")
+	}
+	return kp.cert, nil
+}
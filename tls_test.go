@@ -0,0 +1,164 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCertificate returns a self-signed certificate and its key, in PEM.
+func testCertificate(t *testing.T, cn string) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestKeyPairReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	write := func(cn string, mod time.Time) {
+		certPEM, keyPEM := testCertificate(t, cn)
+		for fn, b := range map[string][]byte{certFile: certPEM, keyFile: keyPEM} {
+			if err := os.WriteFile(fn, b, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(fn, mod, mod); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	commonName := func(kp *keyPair) string {
+		t.Helper()
+		cert, err := kp.GetClientCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.Subject.CommonName
+	}
+
+	now := time.Now()
+	write("first", now.Add(-time.Minute))
+	cfg, err := tlsConfig(DialConfig{CertFile: certFile, KeyFile: keyFile, ReloadKeyPair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.GetClientCertificate == nil {
+		t.Fatal("no GetClientCertificate")
+	}
+	kp := &keyPair{certFile: certFile, keyFile: keyFile, reload: true}
+	if err := kp.load(); err != nil {
+		t.Fatal(err)
+	}
+	if got := commonName(kp); got != "first" {
+		t.Errorf("got %q, wanted first", got)
+	}
+	write("second", now)
+	if got := commonName(kp); got != "second" {
+		t.Errorf("got %q, wanted second", got)
+	}
+
+	// a broken key keeps the previous certificate
+	if err := os.WriteFile(keyFile, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(keyFile, now.Add(time.Minute), now.Add(time.Minute))
+	if got := commonName(kp); got != "second" {
+		t.Errorf("got %q, wanted second", got)
+	}
+
+	kp = &keyPair{certFile: certFile, keyFile: keyFile}
+	if err := kp.load(); err == nil {
+		t.Error("wanted error for the broken key")
+	}
+}
+
+func TestCertPool(t *testing.T) {
+	dir := t.TempDir()
+	fileCA, _ := testCertificate(t, "file")
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, fileCA, 0600); err != nil {
+		t.Fatal(err)
+	}
+	pemCA, _ := testCertificate(t, "pem")
+	envCA, _ := testCertificate(t, "env")
+	t.Setenv("TEST_CA", string(envCA))
+
+	pool, err := certPool(DialConfig{})
+	if err != nil || pool != nil {
+		t.Errorf("got %v, %+v for no CA, wanted nil", pool, err)
+	}
+
+	pool, err = certPool(DialConfig{CAFile: caFile, CAPEM: pemCA, Secrets: EnvSecrets{}, CASecret: "TEST_CA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range [][]byte{fileCA, pemCA, envCA} {
+		block, _ := pem.Decode(b)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cert.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+			t.Errorf("%s: %+v", cert.Subject.CommonName, err)
+		}
+	}
+
+	if _, err = certPool(DialConfig{CAPEM: []byte("garbage")}); err == nil {
+		t.Error("wanted error for garbage PEM")
+	}
+	if _, err = certPool(DialConfig{CASecret: "TEST_CA"}); err == nil {
+		t.Error("wanted error for missing Secrets")
+	}
+	if !(DialConfig{TLS: true}).useTLS() {
+		t.Error("TLS should use TLS")
+	}
+}
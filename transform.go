@@ -0,0 +1,534 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Transform is a compiled jq-like program, applied to the JSON form of each part.
+//
+// The supported subset:
+//
+//	.                    identity
+//	.a.b[0]              path
+//	{a: .x, b, "c": .y}  object construction (b is short for b: .b)
+//	del(.a, .b.c)        delete paths
+//	map(f)               apply f to each element of an array
+//	select(f)            keep the value if f is true (compare with == != < <= > >=)
+//	f | g                pipe
+//	"s", 1, true, null   literals
+//
+// select returning nothing drops the part (or the array element, in map).
+//
+// The transformed parts are not merged (see JSONHandler.MergeStreams), as their
+// shape is unknown, and cannot be encoded in the formats needing protobuf messages
+// (see Format.ProtoOnly).
+type Transform struct {
+	src string
+	f   filter
+}
+
+// filter returns the transformed value, or false when it is dropped.
+type filter func(v interface{}) (interface{}, bool, error)
+
+// CompileTransform compiles the program.
+func CompileTransform(src string) (*Transform, error) {
+	p := transformParser{src: src}
+	f, err := p.pipe()
+	if err == nil {
+		if p.skipSpace(); p.pos < len(p.src) {
+			err = p.errorf("unexpected %q", p.src[p.pos:])
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Transform{src: src, f: f}, nil
+}
+
+// MustCompileTransform is like CompileTransform, but panics on error.
+func MustCompileTransform(src string) *Transform {
+	t, err := CompileTransform(src)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func (t *Transform) String() string { return t.src }
+
+// Apply the transformation to part. Returns false if the part is dropped.
+func (t *Transform) Apply(part interface{}) (interface{}, bool, error) {
+	b, err := jsoniter.Marshal(part)
+	if err != nil {
+		return nil, false, fmt.Errorf("marshal %T: %w", part, err)
+	}
+	var v interface{}
+	if err = jsoniter.Unmarshal(b, &v); err != nil {
+		return nil, false, fmt.Errorf("unmarshal %s: %w", b, err)
+	}
+	res, ok, err := t.f(v)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", t.src, err)
+	}
+	return res, ok, nil
+}
+
+// transformReceiver applies the Transform to each part, skipping the dropped ones.
+type transformReceiver struct {
+	Receiver
+	t *Transform
+}
+
+func (tr transformReceiver) Recv() (interface{}, error) {
+	for {
+		part, err := tr.Receiver.Recv()
+		if err != nil {
+			return part, err
+		}
+		if part, ok, err := tr.t.Apply(part); err != nil || ok {
+			return part, err
+		}
+	}
+}
+
+func (tr transformReceiver) Close() error { return CloseReceiver(tr.Receiver) }
+
+type transformParser struct {
+	src string
+	pos int
+}
+
+func (p *transformParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("transform %q at %d: %s", p.src, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *transformParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *transformParser) peek() byte {
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+func (p *transformParser) accept(s string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.src[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *transformParser) expect(s string) error {
+	if !p.accept(s) {
+		return p.errorf("wanted %q", s)
+	}
+	return nil
+}
+
+func (p *transformParser) ident() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := rune(p.src[p.pos])
+		if !(c == '_' || unicode.IsLetter(c) || p.pos > start && unicode.IsDigit(c)) {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// pipe := cond ('|' cond)*
+func (p *transformParser) pipe() (filter, error) {
+	f, err := p.cond()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == '|' {
+		p.pos++
+		g, err := p.cond()
+		if err != nil {
+			return nil, err
+		}
+		f = pipeFilters(f, g)
+	}
+	return f, nil
+}
+
+func pipeFilters(f, g filter) filter {
+	return func(v interface{}) (interface{}, bool, error) {
+		v, ok, err := f(v)
+		if !ok || err != nil {
+			return v, ok, err
+		}
+		return g(v)
+	}
+}
+
+// cond := term (op term)?
+func (p *transformParser) cond() (filter, error) {
+	f, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if !p.accept(op) {
+			continue
+		}
+		g, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		return compareFilters(op, f, g), nil
+	}
+	return f, nil
+}
+
+func compareFilters(op string, f, g filter) filter {
+	return func(v interface{}) (interface{}, bool, error) {
+		a, ok, err := f(v)
+		if !ok || err != nil {
+			return a, ok, err
+		}
+		b, ok, err := g(v)
+		if !ok || err != nil {
+			return b, ok, err
+		}
+		switch op {
+		case "==":
+			return reflect.DeepEqual(a, b), true, nil
+		case "!=":
+			return !reflect.DeepEqual(a, b), true, nil
+		}
+		var c int
+		switch x := a.(type) {
+		case float64:
+			y, ok := b.(float64)
+			if !ok {
+				return nil, false, fmt.Errorf("cannot compare %v and %v", a, b)
+			}
+			if x < y {
+				c = -1
+			} else if x > y {
+				c = 1
+			}
+		case string:
+			y, ok := b.(string)
+			if !ok {
+				return nil, false, fmt.Errorf("cannot compare %v and %v", a, b)
+			}
+			c = strings.Compare(x, y)
+		default:
+			return nil, false, fmt.Errorf("cannot compare %v and %v", a, b)
+		}
+		switch op {
+		case "<":
+			return c < 0, true, nil
+		case "<=":
+			return c <= 0, true, nil
+		case ">":
+			return c > 0, true, nil
+		default:
+			return c >= 0, true, nil
+		}
+	}
+}
+
+func (p *transformParser) term() (filter, error) {
+	switch c := p.peek(); {
+	case c == '.':
+		path, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		return func(v interface{}) (interface{}, bool, error) { return getPath(v, path), true, nil }, nil
+	case c == '{':
+		return p.object()
+	case c == '(':
+		p.pos++
+		f, err := p.pipe()
+		if err != nil {
+			return nil, err
+		}
+		return f, p.expect(")")
+	case c == '"' || c == '-' || '0' <= c && c <= '9':
+		return p.literal()
+	case c == 0:
+		return nil, p.errorf("unexpected end")
+	}
+	start := p.pos
+	switch nm := p.ident(); nm {
+	case "true", "false", "null":
+		p.pos = start
+		return p.literal()
+	case "del":
+		return p.del()
+	case "map", "select":
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		f, err := p.pipe()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(")"); err != nil {
+			return nil, err
+		}
+		if nm == "select" {
+			return selectFilter(f), nil
+		}
+		return mapFilter(f), nil
+	default:
+		p.pos = start
+		return nil, p.errorf("unknown function %q", nm)
+	}
+}
+
+func (p *transformParser) literal() (filter, error) {
+	p.skipSpace()
+	var lit interface{}
+	start := p.pos
+	switch c := p.src[p.pos]; {
+	case c == '"':
+		for p.pos++; p.pos < len(p.src) && p.src[p.pos] != '"'; p.pos++ {
+			if p.src[p.pos] == '\\' {
+				p.pos++
+			}
+		}
+		p.pos++
+		if p.pos > len(p.src) {
+			return nil, p.errorf("unclosed string")
+		}
+		s, err := strconv.Unquote(p.src[start:p.pos])
+		if err != nil {
+			return nil, p.errorf("string: %v", err)
+		}
+		lit = s
+	case c == '-' || '0' <= c && c <= '9':
+		for p.pos++; p.pos < len(p.src) && strings.IndexByte("0123456789.eE+-", p.src[p.pos]) >= 0; p.pos++ {
+		}
+		f, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return nil, p.errorf("number: %v", err)
+		}
+		lit = f
+	default:
+		switch nm := p.ident(); nm {
+		case "true":
+			lit = true
+		case "false":
+			lit = false
+		case "null":
+		default:
+			return nil, p.errorf("unknown literal %q", nm)
+		}
+	}
+	return func(interface{}) (interface{}, bool, error) { return lit, true, nil }, nil
+}
+
+// path := '.' (ident | '[' int ']') ('.' ident | '[' int ']')*
+func (p *transformParser) path() ([]interface{}, error) {
+	if err := p.expect("."); err != nil {
+		return nil, err
+	}
+	var path []interface{}
+	if nm := p.ident(); nm != "" {
+		path = append(path, nm)
+	}
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '.':
+			p.pos++
+			nm := p.ident()
+			if nm == "" {
+				return nil, p.errorf("wanted field name")
+			}
+			path = append(path, nm)
+		case '[':
+			p.pos++
+			end := strings.IndexByte(p.src[p.pos:], ']')
+			if end < 0 {
+				return nil, p.errorf("unclosed [")
+			}
+			i, err := strconv.Atoi(strings.TrimSpace(p.src[p.pos : p.pos+end]))
+			if err != nil {
+				return nil, p.errorf("index: %v", err)
+			}
+			p.pos += end + 1
+			path = append(path, i)
+		default:
+			return path, nil
+		}
+	}
+	return path, nil
+}
+
+func getPath(v interface{}, path []interface{}) interface{} {
+	for _, k := range path {
+		switch k := k.(type) {
+		case string:
+			m, _ := v.(map[string]interface{})
+			v = m[k]
+		case int:
+			a, _ := v.([]interface{})
+			if k < 0 {
+				k += len(a)
+			}
+			if k < 0 || k >= len(a) {
+				return nil
+			}
+			v = a[k]
+		}
+	}
+	return v
+}
+
+// object := '{' (key (':' cond)?) (',' key (':' cond)?)* '}'
+func (p *transformParser) object() (filter, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var keys []string
+	var filters []filter
+	for !p.accept("}") {
+		if len(keys) != 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		var key string
+		if p.peek() == '"' {
+			lit, err := p.literal()
+			if err != nil {
+				return nil, err
+			}
+			v, _, _ := lit(nil)
+			var ok bool
+			if key, ok = v.(string); !ok {
+				return nil, p.errorf("wanted string key, got %v", v)
+			}
+		} else if key = p.ident(); key == "" {
+			return nil, p.errorf("wanted key")
+		}
+		var f filter
+		if p.accept(":") {
+			var err error
+			if f, err = p.cond(); err != nil {
+				return nil, err
+			}
+		} else {
+			path := []interface{}{key}
+			f = func(v interface{}) (interface{}, bool, error) { return getPath(v, path), true, nil }
+		}
+		keys, filters = append(keys, key), append(filters, f)
+	}
+	return func(v interface{}) (interface{}, bool, error) {
+		m := make(map[string]interface{}, len(keys))
+		for i, k := range keys {
+			x, ok, err := filters[i](v)
+			if !ok || err != nil {
+				return x, ok, err
+			}
+			m[k] = x
+		}
+		return m, true, nil
+	}, nil
+}
+
+// del := 'del(' path (',' path)* ')'
+func (p *transformParser) del() (filter, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var paths [][]interface{}
+	for !p.accept(")") {
+		if len(paths) != 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		path, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return nil, p.errorf("cannot delete .")
+		}
+		paths = append(paths, path)
+	}
+	return func(v interface{}) (interface{}, bool, error) {
+		for _, path := range paths {
+			parent := getPath(v, path[:len(path)-1])
+			switch k := path[len(path)-1].(type) {
+			case string:
+				if m, ok := parent.(map[string]interface{}); ok {
+					delete(m, k)
+				}
+			case int:
+				return nil, false, fmt.Errorf("cannot delete array element %d", k)
+			}
+		}
+		return v, true, nil
+	}, nil
+}
+
+func selectFilter(f filter) filter {
+	return func(v interface{}) (interface{}, bool, error) {
+		x, ok, err := f(v)
+		if !ok || err != nil {
+			return x, ok, err
+		}
+		if x == nil || x == false {
+			return nil, false, nil
+		}
+		return v, true, nil
+	}
+}
+
+func mapFilter(f filter) filter {
+	return func(v interface{}) (interface{}, bool, error) {
+		a, ok := v.([]interface{})
+		if !ok {
+			return nil, false, fmt.Errorf("cannot map over %T", v)
+		}
+		res := make([]interface{}, 0, len(a))
+		for _, x := range a {
+			y, ok, err := f(x)
+			if err != nil {
+				return nil, false, err
+			}
+			if ok {
+				res = append(res, y)
+			}
+		}
+		return res, true, nil
+	}
+}
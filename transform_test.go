@@ -0,0 +1,104 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+func TestTransform(t *testing.T) {
+	type item struct {
+		Name  string `json:"name"`
+		Price int    `json:"price"`
+	}
+	type order struct {
+		ID       int    `json:"id"`
+		Customer string `json:"customer"`
+		Secret   string `json:"secret"`
+		Items    []item `json:"items"`
+	}
+	part := &order{ID: 1, Customer: "C", Secret: "s", Items: []item{{"a", 10}, {"b", 200}}}
+	for tN, tC := range map[string]struct {
+		Src, Want string
+		Drop      bool
+	}{
+		"identity": {Src: ".", Want: `{"customer":"C","id":1,"items":[{"name":"a","price":10},{"name":"b","price":200}],"secret":"s"}`},
+		"path":     {Src: ".items[1].name", Want: `"b"`},
+		"object":   {Src: `{orderID: .id, customer, "first": .items[0].name}`, Want: `{"customer":"C","first":"a","orderID":1}`},
+		"del":      {Src: "del(.secret, .items)", Want: `{"customer":"C","id":1}`},
+		"map":      {Src: `.items | map(select(.price > 100) | .name)`, Want: `["b"]`},
+		"select":   {Src: `select(.customer == "X")`, Drop: true},
+		"pipe":     {Src: `del(.items) | {id, ok: .customer != null}`, Want: `{"id":1,"ok":true}`},
+	} {
+		tr, err := CompileTransform(tC.Src)
+		if err != nil {
+			t.Fatalf("%s: %+v", tN, err)
+		}
+		v, ok, err := tr.Apply(part)
+		if err != nil {
+			t.Fatalf("%s: %+v", tN, err)
+		}
+		if ok == tC.Drop {
+			t.Errorf("%s: got ok=%t", tN, ok)
+			continue
+		}
+		if tC.Drop {
+			continue
+		}
+		b, err := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tC.Want {
+			t.Errorf("%s: got %s, wanted %s", tN, b, tC.Want)
+		}
+	}
+
+	for _, src := range []string{"", ".a |", "{a: }", "del(.)", "nosuch(.)", `"unclosed`} {
+		if _, err := CompileTransform(src); err == nil {
+			t.Errorf("%q: wanted error", src)
+		}
+	}
+}
+
+func TestJSONHandlerTransformFormat(t *testing.T) {
+	tr, err := CompileTransform("{a: .a}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var called bool
+	h := JSONHandler{
+		Client: fakeClient{"Get": func(ctx context.Context, input interface{}) (Receiver, error) {
+			called = true
+			return &receiver{parts: []interface{}{map[string]interface{}{"a": 1, "b": 2}}}, nil
+		}},
+		Transforms: map[string]*Transform{"Get": tr},
+	}
+	for format, code := range map[string]int{"ndjson": 200, "protobuf": http.StatusNotAcceptable, "avro": http.StatusNotAcceptable} {
+		called = false
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/Get?format="+format, strings.NewReader("{}")))
+		if w.Code != code || called != (code == 200) {
+			t.Errorf("%s: got %d (called=%t), wanted %d", format, w.Code, called, code)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FieldViolation describes an invalid field of the input.
+type FieldViolation struct {
+	Field, Description string
+}
+
+// ValidateFunc validates the input of the named method.
+type ValidateFunc func(name string, input interface{}) []FieldViolation
+
+type validatingClient struct {
+	Client
+	validate ValidateFunc
+}
+
+// WithValidation returns a Client which validates the input with validate before calling the method,
+// and returns an InvalidArgument error with BadRequest field violations details for invalid input.
+//
+// If validate is nil, ValidateInput is used.
+func WithValidation(c Client, validate ValidateFunc) Client {
+	if validate == nil {
+		validate = ValidateInput
+	}
+	return validatingClient{Client: c, validate: validate}
+}
+
+// Call the named method if the input is valid.
+func (vc validatingClient) Call(name string, ctx context.Context, input interface{}, opts ...grpc.CallOption) (Receiver, error) {
+	if violations := vc.validate(name, input); len(violations) != 0 {
+		return nil, InvalidArgumentError(violations)
+	}
+	return vc.Client.Call(name, ctx, input, opts...)
+}
+
+// InvalidArgumentError returns an InvalidArgument status error with the violations as BadRequest details.
+func InvalidArgumentError(violations []FieldViolation) error {
+	msgs := make([]string, len(violations))
+	br := errdetails.BadRequest{FieldViolations: make([]*errdetails.BadRequest_FieldViolation, len(violations))}
+	for i, v := range violations {
+		msgs[i] = v.Field + ": " + v.Description
+		br.FieldViolations[i] = &errdetails.BadRequest_FieldViolation{Field: v.Field, Description: v.Description}
+	}
+	st := status.New(codes.InvalidArgument, "invalid input: "+strings.Join(msgs, "; "))
+	if det, err := st.WithDetails(&br); err == nil {
+		st = det
+	}
+	return st.Err()
+}
+
+// ValidateInput validates the input with its ValidateAll() or Validate() method,
+// as generated by protoc-gen-validate.
+func ValidateInput(name string, input interface{}) []FieldViolation {
+	var err error
+	if v, ok := input.(interface{ ValidateAll() error }); ok {
+		err = v.ValidateAll()
+	} else if v, ok := input.(interface{ Validate() error }); ok {
+		err = v.Validate()
+	}
+	if err == nil {
+		return nil
+	}
+	var errs []error
+	if me, ok := err.(interface{ AllErrors() []error }); ok {
+		errs = me.AllErrors()
+	} else {
+		errs = []error{err}
+	}
+	violations := make([]FieldViolation, 0, len(errs))
+	for _, err := range errs {
+		if fe, ok := err.(interface {
+			Field() string
+			Reason() string
+		}); ok {
+			violations = append(violations, FieldViolation{Field: fe.Field(), Description: fe.Reason()})
+		} else {
+			violations = append(violations, FieldViolation{Description: err.Error()})
+		}
+	}
+	return violations
+}
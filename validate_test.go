@@ -0,0 +1,73 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fieldError struct{ field, reason string }
+
+func (fe fieldError) Error() string  { return fe.field + ": " + fe.reason }
+func (fe fieldError) Field() string  { return fe.field }
+func (fe fieldError) Reason() string { return fe.reason }
+
+type validatedInput struct{ ID int }
+
+func (vi validatedInput) Validate() error {
+	if vi.ID <= 0 {
+		return fieldError{field: "ID", reason: "must be positive"}
+	}
+	return nil
+}
+
+func TestValidation(t *testing.T) {
+	var called int
+	c := WithValidation(fakeClient{"M": func(ctx context.Context, input interface{}) (Receiver, error) {
+		called++
+		return &receiver{}, nil
+	}}, nil)
+	ctx := context.Background()
+	if _, err := c.Call("M", ctx, validatedInput{ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.Call("M", ctx, validatedInput{})
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument {
+		t.Fatalf("wanted InvalidArgument, got %+v", err)
+	}
+	if called != 1 {
+		t.Errorf("called %d times, wanted 1", called)
+	}
+	if code := statusCodeFromError(err); code != http.StatusBadRequest {
+		t.Errorf("got HTTP status %d, wanted %d", code, http.StatusBadRequest)
+	}
+	var found bool
+	for _, d := range st.Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			found = len(br.FieldViolations) == 1 && br.FieldViolations[0].Field == "ID"
+		}
+	}
+	if !found {
+		t.Errorf("no ID field violation in %+v", st.Details())
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FillInputValues fills dst from the flat key paths of values,
+// such as "a.b.c=1&items.0.id=2" (or "items[0][id]=2").
+//
+// Numeric path elements are slice indexes, repeated keys are slices.
+// See FillInput for the conversion rules.
+func FillInputValues(dst interface{}, values url.Values) error {
+	m, err := unflatten(values)
+	if err != nil {
+		return err
+	}
+	return FillInput(dst, m)
+}
+
+var bracketReplacer = strings.NewReplacer("][", ".", "[", ".", "]", "")
+
+// unflatten the flat key paths into nested maps and slices.
+func unflatten(values url.Values) (map[string]interface{}, error) {
+	root := make(map[string]interface{}, len(values))
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		vv := values[k]
+		if len(vv) == 0 {
+			continue
+		}
+		var v interface{} = vv[0]
+		if len(vv) > 1 {
+			s := make([]interface{}, len(vv))
+			for i, x := range vv {
+				s[i] = x
+			}
+			v = s
+		}
+		path := strings.Split(bracketReplacer.Replace(k), ".")
+		m := root
+		for i, p := range path[:len(path)-1] {
+			sub, ok := m[p]
+			if !ok {
+				sub = make(map[string]interface{})
+				m[p] = sub
+			}
+			if m, ok = sub.(map[string]interface{}); !ok {
+				return nil, fmt.Errorf("%s: %q is both a value and a parent", k, strings.Join(path[:i+1], "."))
+			}
+		}
+		last := path[len(path)-1]
+		if _, ok := m[last]; ok {
+			return nil, fmt.Errorf("%s: %q is both a value and a parent", k, strings.Join(path, "."))
+		}
+		m[last] = v
+	}
+	v, err := indexedToSlice(root)
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[string]interface{}), nil
+}
+
+// indexedToSlice converts the maps with only numeric keys to slices, recursively.
+func indexedToSlice(v interface{}) (interface{}, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return v, nil
+	}
+	maxIdx, numeric := -1, len(m) != 0
+	for k, x := range m {
+		var err error
+		if m[k], err = indexedToSlice(x); err != nil {
+			return nil, err
+		}
+		if !numeric {
+			continue
+		}
+		i, err := strconv.Atoi(k)
+		if err != nil || i < 0 {
+			numeric = false
+			continue
+		}
+		if i > maxIdx {
+			maxIdx = i
+		}
+	}
+	if !numeric {
+		return m, nil
+	}
+	if maxIdx >= 2*len(m)+16 {
+		return nil, fmt.Errorf("index %d is too sparse for %d elements", maxIdx, len(m))
+	}
+	s := make([]interface{}, maxIdx+1)
+	for i := range s {
+		s[i] = map[string]interface{}{}
+	}
+	for k, x := range m {
+		i, _ := strconv.Atoi(k)
+		s[i] = x
+	}
+	return s, nil
+}
+
+// isFormRequest reports whether the input is in the URL query or in a posted form.
+func isFormRequest(r *http.Request) bool {
+	if r.Method == http.MethodGet {
+		return true
+	}
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return ct == "application/x-www-form-urlencoded"
+}
+
+// handlerParams are the query parameters of the JSONHandler itself, not part of the input.
+var handlerParams = map[string]bool{"format": true, "merge": true}
+
+// formValues returns the form values of the request, without the handler's own parameters.
+func formValues(r *http.Request) (url.Values, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	values := make(url.Values, len(r.Form))
+	for k, vv := range r.Form {
+		if !handlerParams[k] {
+			values[k] = vv
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,174 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/websocket"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// isWebSocket reports whether r is a WebSocket upgrade request.
+func isWebSocket(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// webSocketError is the last message of a failed WebSocket call.
+type webSocketError struct {
+	Error     string `json:"error"`
+	Code      string `json:"code"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// checkOrigin allows the requests without Origin (non-browser clients),
+// from the same host, or from one of the allowed origins.
+func checkOrigin(r *http.Request, allowed []string) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return fmt.Errorf("origin %q: %w", origin, err)
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return nil
+	}
+	for _, a := range allowed {
+		if a == "*" || strings.EqualFold(a, origin) {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %q is not allowed", origin)
+}
+
+// serveWebSocket serves the named method over WebSocket: the input is the first (JSON) message,
+// each part is sent as a text message, and the call is canceled by any further
+// message of the client (or by closing the connection).
+//
+// A failed call ends with a {"error":...,"code":...} message.
+func (h JSONHandler) serveWebSocket(w http.ResponseWriter, r *http.Request, name string, Log func(...interface{}) error) {
+	inp := h.Input(name)
+	if inp == nil || !allowedMethod(h.Methods, name) {
+		jsonError(w, notFoundMessage(h.Client, name), http.StatusNotFound)
+		return
+	}
+	if err := checkOrigin(r, h.WebSocketOrigins); err != nil {
+		jsonError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	websocket.Server{
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler:   func(ws *websocket.Conn) { h.webSocketCall(ws, r, name, inp, Log) },
+	}.ServeHTTP(w, r)
+}
+
+func (h JSONHandler) webSocketCall(ws *websocket.Conn, r *http.Request, name string, inp interface{}, Log func(...interface{}) error) {
+	defer ws.Close()
+	var sent bool
+	send := func(v interface{}) error {
+		b, err := jsonConfigOf(h.SpecialFloats).api.Marshal(v)
+		if err != nil {
+			return err
+		}
+		sent = true
+		return websocket.Message.Send(ws, string(b))
+	}
+	fail := func(err error) {
+		Log("call", name, "error", err)
+		st, ok := status.FromError(err)
+		if !ok {
+			switch {
+			case errors.Is(err, context.Canceled):
+				st = status.New(codes.Canceled, err.Error())
+			case errors.Is(err, context.DeadlineExceeded):
+				st = status.New(codes.DeadlineExceeded, err.Error())
+			default:
+				st = status.New(codes.Unknown, err.Error())
+			}
+		}
+		_ = send(webSocketError{Error: st.Message(), Code: st.Code().String(), Truncated: sent})
+	}
+
+	// the slot is held while receiving the input, too
+	release, err := h.Limit.Acquire(r.Context(), name)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer release()
+	var msg []byte
+	if err := websocket.Message.Receive(ws, &msg); err != nil {
+		Log("msg", "receive input", "error", err)
+		return
+	}
+	if len(bytes.TrimSpace(msg)) != 0 {
+		if err := decodeJSONBytes(inp, msg, h.StrictInput, h.SpecialFloats, Log); err != nil {
+			fail(err)
+			return
+		}
+	}
+	Log("inp", inp)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		var b []byte
+		if err := websocket.Message.Receive(ws, &b); err == nil {
+			Log("msg", "canceled by the client", "message", string(b))
+		}
+		cancel()
+	}()
+	ctx, idleTimer, cancelCall, err := h.callContext(ctx, r, name, inp, Log)
+	if err != nil {
+		fail(status.Error(codes.PermissionDenied, err.Error()))
+		return
+	}
+	defer cancelCall()
+	recv, err := h.Call(name, ctx, inp)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer CloseReceiver(recv)
+	if idleTimer != nil {
+		recv = &idleReceiver{Receiver: recv, it: idleTimer}
+	}
+	stop := context.AfterFunc(ctx, func() { CloseReceiver(recv) })
+	defer stop()
+	for {
+		part, err := recvContext(ctx, recv)
+		if err != nil {
+			if err != io.EOF {
+				fail(err)
+			}
+			return
+		}
+		if err = send(part); err != nil {
+			Log("send", part, "error", err)
+			return
+		}
+	}
+}
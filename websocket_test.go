@@ -0,0 +1,133 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestWebSocket(t *testing.T) {
+	hanging := &hangingReceiver{receiver: receiver{parts: []interface{}{map[string]interface{}{"a": 1}}}, closed: make(chan struct{})}
+	srv := httptest.NewServer(NewHTTPHandler(fakeClient{
+		"Get": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return &receiver{parts: []interface{}{map[string]interface{}{"a": 1}, map[string]interface{}{"a": 2}}}, nil
+		},
+		"Hang": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return hanging, nil
+		},
+	}, HandlerWebSocket()))
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	receiveAll := func(t *testing.T, ws *websocket.Conn) []string {
+		var msgs []string
+		for {
+			var msg string
+			if err := websocket.Message.Receive(ws, &msg); err != nil {
+				return msgs
+			}
+			msgs = append(msgs, msg)
+		}
+	}
+
+	ws, err := websocket.Dial(wsURL+"/Get", "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = websocket.Message.Send(ws, "{}"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(receiveAll(t, ws), " "), `{"a":1} {"a":2}`; got != want {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+	ws.Close()
+
+	if ws, err = websocket.Dial(wsURL+"/Hang", "", srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if err = websocket.Message.Send(ws, "{}"); err != nil {
+		t.Fatal(err)
+	}
+	var msg string
+	if err = websocket.Message.Receive(ws, &msg); err != nil || msg != `{"a":1}` {
+		t.Fatalf("got %q, %+v", msg, err)
+	}
+	if err = websocket.Message.Send(ws, "cancel"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(receiveAll(t, ws), " "), `{"error":"context canceled","code":"Canceled","truncated":true}`; got != want {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+	ws.Close()
+	select {
+	case <-hanging.closed:
+	case <-time.After(time.Second):
+		t.Error("receiver is not closed")
+	}
+
+	if _, err = websocket.Dial(wsURL+"/Get", "", "http://example.com"); err == nil {
+		t.Error("wanted error for foreign origin")
+	}
+}
+
+func TestWebSocketCallContext(t *testing.T) {
+	var gotMD metadata.MD
+	var gotInput map[string]interface{}
+	h := NewHTTPHandler(fakeClient{"Get": func(ctx context.Context, input interface{}) (Receiver, error) {
+		gotMD, _ = metadata.FromOutgoingContext(ctx)
+		gotInput = *(input.(*map[string]interface{}))
+		return &receiver{parts: []interface{}{1}}, nil
+	}}, HandlerWebSocket(), func(h *JSONHandler) { h.LocaleHeader, h.LocaleField = "Accept-Language", "lang" })
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	for _, onBehalfOf := range []string{"", "customer-1"} {
+		cfg, err := websocket.NewConfig("ws"+strings.TrimPrefix(srv.URL, "http")+"/Get", srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Header.Set("Accept-Language", "hu")
+		if onBehalfOf != "" {
+			cfg.Header.Set(OnBehalfOfHeader, onBehalfOf)
+		}
+		ws, err := websocket.DialConfig(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = websocket.Message.Send(ws, "{}"); err != nil {
+			t.Fatal(err)
+		}
+		var msg string
+		_ = websocket.Message.Receive(ws, &msg)
+		ws.Close()
+		if onBehalfOf != "" {
+			if !strings.Contains(msg, `"code":"PermissionDenied"`) {
+				t.Errorf("on behalf of: got %q", msg)
+			}
+			continue
+		}
+		if msg != "1" || gotInput["lang"] != "hu" || len(gotMD.Get(LocaleMetadataKey)) != 1 {
+			t.Errorf("got %q, input %v, metadata %v", msg, gotInput, gotMD)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// The WS-Security UsernameToken password types.
+const (
+	PasswordText   = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordText"
+	PasswordDigest = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest"
+)
+
+var (
+	// ErrNoUsernameToken is returned when the SOAP header has no UsernameToken.
+	ErrNoUsernameToken = errors.New("no WS-Security UsernameToken")
+	// ErrBadUsernameToken is returned when the UsernameToken does not verify.
+	ErrBadUsernameToken = errors.New("bad WS-Security UsernameToken")
+)
+
+// UsernameToken is a WS-Security UsernameToken.
+type UsernameToken struct {
+	Username     string
+	Password     string
+	PasswordType string // PasswordText if empty
+	Nonce        []byte
+	Created      string
+}
+
+// ParseUsernameToken reads the UsernameToken from the header of the SOAP envelope,
+// stopping at the Body.
+func ParseUsernameToken(r io.Reader) (*UsernameToken, error) {
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if err == io.EOF {
+				return nil, ErrNoUsernameToken
+			}
+			return nil, err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch se.Name.Local {
+		case "Body":
+			return nil, ErrNoUsernameToken
+		case "UsernameToken":
+			var x struct {
+				Username string `xml:"Username"`
+				Password struct {
+					Type  string `xml:"Type,attr"`
+					Value string `xml:",chardata"`
+				} `xml:"Password"`
+				Nonce   string `xml:"Nonce"`
+				Created string `xml:"Created"`
+			}
+			if err := dec.DecodeElement(&x, &se); err != nil {
+				return nil, fmt.Errorf("decode UsernameToken: %w", err)
+			}
+			ut := UsernameToken{Username: x.Username, Password: x.Password.Value, PasswordType: x.Password.Type, Created: x.Created}
+			if x.Nonce != "" {
+				if ut.Nonce, err = base64.StdEncoding.DecodeString(x.Nonce); err != nil {
+					return nil, fmt.Errorf("decode Nonce: %w", err)
+				}
+			}
+			return &ut, nil
+		}
+	}
+}
+
+// Verify the token against the user's password.
+//
+// A PasswordDigest token must be Created in maxAge (if positive).
+func (ut *UsernameToken) Verify(password string, maxAge time.Duration) error {
+	switch ut.PasswordType {
+	case "", PasswordText:
+		if subtle.ConstantTimeCompare([]byte(ut.Password), []byte(password)) != 1 {
+			return ErrBadUsernameToken
+		}
+		return nil
+	case PasswordDigest:
+		if maxAge > 0 {
+			created, err := time.Parse(time.RFC3339, ut.Created)
+			if err != nil {
+				return fmt.Errorf("%w: Created: %v", ErrBadUsernameToken, err)
+			}
+			if d := time.Since(created); d > maxAge || d < -maxAge {
+				return fmt.Errorf("%w: expired", ErrBadUsernameToken)
+			}
+		}
+		h := sha1.New()
+		h.Write(ut.Nonce)
+		io.WriteString(h, ut.Created)
+		io.WriteString(h, password)
+		want := base64.StdEncoding.EncodeToString(h.Sum(nil))
+		if subtle.ConstantTimeCompare([]byte(ut.Password), []byte(want)) != 1 {
+			return ErrBadUsernameToken
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown password type %q", ErrBadUsernameToken, ut.PasswordType)
+	}
+}
+
+// WSSecurity authenticates the calls with WS-Security UsernameTokens.
+type WSSecurity struct {
+	// Password returns the password of the user for verification.
+	// If nil, the PasswordText tokens are passed through unverified, and the digests are rejected.
+	Password func(username string) (string, error)
+	// Credentials maps the verified user to the backend's basic auth credentials.
+	// Requires Password. If nil, the token's username and password are used (PasswordText only).
+	Credentials func(username string) (user, password string, err error)
+	// MaxAge is the maximum age of the PasswordDigest tokens, required for accepting them.
+	MaxAge time.Duration
+
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	sweep  time.Time
+}
+
+// Authenticate verifies the token, and returns the context with the backend's basic auth.
+//
+// The nonce of an accepted PasswordDigest token is remembered for 2*MaxAge,
+// and a replayed token is rejected.
+func (ws *WSSecurity) Authenticate(ctx context.Context, ut *UsernameToken) (context.Context, error) {
+	isText := ut.PasswordType == "" || ut.PasswordType == PasswordText
+	if ws.Password != nil {
+		if !isText {
+			if ws.MaxAge <= 0 {
+				return ctx, fmt.Errorf("%w: digest without MaxAge", ErrBadUsernameToken)
+			}
+			if len(ut.Nonce) == 0 {
+				return ctx, fmt.Errorf("%w: digest without Nonce", ErrBadUsernameToken)
+			}
+		}
+		password, err := ws.Password(ut.Username)
+		if err != nil {
+			return ctx, fmt.Errorf("%w: %v", ErrBadUsernameToken, err)
+		}
+		if err = ut.Verify(password, ws.MaxAge); err != nil {
+			return ctx, err
+		}
+		if !isText && !ws.useNonce(ut.Nonce) {
+			return ctx, fmt.Errorf("%w: replayed Nonce", ErrBadUsernameToken)
+		}
+	} else if ws.Credentials != nil {
+		return ctx, fmt.Errorf("%w: Credentials without Password", ErrBadUsernameToken)
+	} else if !isText {
+		return ctx, fmt.Errorf("%w: cannot verify the digest", ErrBadUsernameToken)
+	}
+	if ws.Credentials != nil {
+		u, p, err := ws.Credentials(ut.Username)
+		if err != nil {
+			return ctx, err
+		}
+		return WithBasicAuth(ctx, u, p), nil
+	}
+	if !isText {
+		return ctx, fmt.Errorf("%w: cannot pass through the digest", ErrBadUsernameToken)
+	}
+	return WithBasicAuth(ctx, ut.Username, ut.Password), nil
+}
+
+// useNonce records the nonce, and reports whether it has not been seen in 2*MaxAge.
+// A token's Created may be MaxAge in the past or in the future, so this covers its validity.
+func (ws *WSSecurity) useNonce(nonce []byte) bool {
+	now := time.Now()
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.nonces == nil {
+		ws.nonces = make(map[string]time.Time)
+	}
+	if now.After(ws.sweep) {
+		for k, exp := range ws.nonces {
+			if now.After(exp) {
+				delete(ws.nonces, k)
+			}
+		}
+		ws.sweep = now.Add(ws.MaxAge)
+	}
+	k := string(nonce)
+	if exp, ok := ws.nonces[k]; ok && now.Before(exp) {
+		return false
+	}
+	ws.nonces[k] = now.Add(2 * ws.MaxAge)
+	return true
+}
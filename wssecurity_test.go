@@ -0,0 +1,88 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWSSecurity(t *testing.T) {
+	const envelope = `<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/">
+<soapenv:Header><wsse:Security xmlns:wsse="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd"
+ xmlns:wsu="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd">
+<wsse:UsernameToken><wsse:Username>partner</wsse:Username>
+<wsse:Password Type="%TYPE%">%PASSWORD%</wsse:Password>
+<wsse:Nonce>bm9uY2U=</wsse:Nonce><wsu:Created>%CREATED%</wsu:Created>
+</wsse:UsernameToken></wsse:Security></soapenv:Header>
+<soapenv:Body><Get/></soapenv:Body></soapenv:Envelope>`
+	created := time.Now().UTC().Format(time.RFC3339)
+	h := sha1.New()
+	h.Write([]byte("nonce" + created + "secret"))
+	digest := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	ws := WSSecurity{
+		Password:    func(string) (string, error) { return "secret", nil },
+		Credentials: func(user string) (string, string, error) { return "backend", "pw", nil },
+		MaxAge:      5 * time.Minute,
+	}
+	for i, tc := range []struct {
+		Type, Password string
+		Err            error
+	}{
+		{PasswordDigest, digest, nil},
+		{PasswordDigest, digest, ErrBadUsernameToken}, // replayed
+		{PasswordDigest, "bad", ErrBadUsernameToken},
+		{PasswordText, "secret", nil},
+		{PasswordText, "bad", ErrBadUsernameToken},
+	} {
+		ut, err := ParseUsernameToken(strings.NewReader(strings.NewReplacer(
+			"%TYPE%", tc.Type, "%PASSWORD%", tc.Password, "%CREATED%", created).Replace(envelope)))
+		if err != nil {
+			t.Fatalf("%d. %+v", i, err)
+		}
+		ctx, err := ws.Authenticate(context.Background(), ut)
+		if !errors.Is(err, tc.Err) {
+			t.Errorf("%d. got %+v, wanted %v", i, err, tc.Err)
+		}
+		if err == nil && ctx.Value(BasicAuthKey) != "basic backend:pw" {
+			t.Errorf("%d. got %v", i, ctx.Value(BasicAuthKey))
+		}
+	}
+
+	text := &UsernameToken{Username: "partner", Password: "secret"}
+	for name, bad := range map[string]*WSSecurity{
+		"credentials without password": {Credentials: ws.Credentials},
+		"digest without MaxAge":        {Password: ws.Password},
+	} {
+		ut := text
+		if bad.Credentials == nil {
+			ut = &UsernameToken{Username: "partner", Password: digest, PasswordType: PasswordDigest, Nonce: []byte("nonce"), Created: created}
+		}
+		if _, err := bad.Authenticate(context.Background(), ut); !errors.Is(err, ErrBadUsernameToken) {
+			t.Errorf("%s: got %+v, wanted %v", name, err, ErrBadUsernameToken)
+		}
+	}
+
+	if _, err := ParseUsernameToken(strings.NewReader(`<Envelope><Body/></Envelope>`)); err != ErrNoUsernameToken {
+		t.Errorf("got %+v", err)
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// xmlEncoder writes each part as an XML element, one per line - no merging of the parts.
+type xmlEncoder struct {
+	w io.Writer
+}
+
+func (e xmlEncoder) Encode(part interface{}) error {
+	if err := xml.NewEncoder(e.w).Encode(part); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, "\n")
+	return err
+}
+
+func (e xmlEncoder) Close() error { return nil }
+
+// truncatedXML is the XML form of truncatedMarker.
+type truncatedXML struct {
+	XMLName   xml.Name `xml:"error"`
+	Truncated bool     `xml:"truncated,attr"`
+	Error     string   `xml:",chardata"`
+}
+
+// xmlStreamEncoder is the XML streamEncoder.
+//
+// The root element is named after the first part (its XMLName or its type),
+// the slice fields' elements are repeated under it as they arrive.
+type xmlStreamEncoder struct {
+	// name overrides the name of the root element, if set.
+	name string
+	root string
+	// attach stores the content of a []byte field as an attachment, returning its Content-ID
+	// for the xop:Include element written instead of the content, if set.
+	attach func([]byte) string
+}
+
+// encodeXOP writes v as an xop:Include element of the attachment, if it is a []byte and e.attach is set.
+func (e *xmlStreamEncoder) encodeXOP(w io.Writer, name string, v interface{}) (bool, error) {
+	b, ok := v.([]byte)
+	if !ok || e.attach == nil {
+		return false, nil
+	}
+	_, err := fmt.Fprintf(w, `<%s><xop:Include xmlns:xop=%q href="cid:%s"/></%s>`, name, XOPNamespace, e.attach(b), name)
+	return true, err
+}
+
+// xmlField is the XML representation of a field.
+type xmlField struct {
+	Name                 string
+	Parents              []string
+	Attr, CharData, Skip bool
+	OmitEmpty            bool
+}
+
+func parseXMLField(f field) xmlField {
+	tag := f.Tag.Get("xml")
+	if tag == "-" || f.Name == "XMLName" || f.Name == "" || !('A' <= f.Name[0] && f.Name[0] <= 'Z') {
+		return xmlField{Skip: true}
+	}
+	var xf xmlField
+	name, opts, _ := strings.Cut(tag, ",")
+	for _, o := range strings.Split(opts, ",") {
+		switch o {
+		case "attr":
+			xf.Attr = true
+		case "chardata":
+			xf.CharData = true
+		case "omitempty":
+			xf.OmitEmpty = true
+		case "innerxml", "comment", "any":
+			xf.Skip = true
+		}
+	}
+	if i := strings.LastIndexByte(name, ' '); i >= 0 { // namespace
+		name = name[i+1:]
+	}
+	if name == "" {
+		name = f.Name
+	}
+	if xf.Attr {
+		xf.Name = name
+		return xf
+	}
+	parts := strings.Split(name, ">")
+	xf.Name, xf.Parents = parts[len(parts)-1], parts[:len(parts)-1]
+	return xf
+}
+
+// xmlRootName returns the element name of v: the name of its XMLName field, or its type name.
+func xmlRootName(v interface{}) string {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	t := rv.Type()
+	if f, ok := t.FieldByName("XMLName"); ok && f.Type == reflect.TypeOf(xml.Name{}) {
+		if name, _, _ := strings.Cut(f.Tag.Get("xml"), ","); name != "" {
+			if i := strings.LastIndexByte(name, ' '); i >= 0 {
+				name = name[i+1:]
+			}
+			return name
+		}
+		if n := rv.FieldByIndex(f.Index).Interface().(xml.Name); n.Local != "" {
+			return n.Local
+		}
+	}
+	return t.Name()
+}
+
+func (e *xmlStreamEncoder) Part(w io.Writer, part interface{}) error {
+	if e.name == "" {
+		return xmlEncoder{w: w}.Encode(part)
+	}
+	if err := xml.NewEncoder(w).EncodeElement(part, xml.StartElement{Name: xml.Name{Local: e.name}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func (e *xmlStreamEncoder) EndParts(w io.Writer, stopped error) error {
+	if stopped == nil {
+		return nil
+	}
+	return xmlEncoder{w: w}.Encode(truncatedXML{Error: stopped.Error(), Truncated: true})
+}
+
+func (e *xmlStreamEncoder) Begin(w io.Writer, first interface{}, notSlice []field) error {
+	if e.root = e.name; e.root == "" {
+		e.root = xmlRootName(first)
+	}
+	start := xml.StartElement{Name: xml.Name{Local: e.root}}
+	for _, f := range notSlice {
+		if xf := parseXMLField(f); !xf.Skip && xf.Attr && !(xf.OmitEmpty && reflect.ValueOf(f.Value).IsZero()) {
+			start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: xf.Name}, Value: fmt.Sprint(f.Value)})
+		}
+	}
+	enc := xml.NewEncoder(w)
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, f := range notSlice {
+		xf := parseXMLField(f)
+		if xf.Skip || xf.Attr || xf.OmitEmpty && reflect.ValueOf(f.Value).IsZero() {
+			continue
+		}
+		if xf.CharData {
+			if err := enc.EncodeToken(xml.CharData(fmt.Sprint(f.Value))); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := enc.Flush(); err != nil {
+			return err
+		}
+		writeXMLParents(w, xf.Parents, false)
+		if ok, err := e.encodeXOP(w, xf.Name, f.Value); err != nil {
+			return err
+		} else if !ok {
+			if err := enc.EncodeElement(f.Value, xml.StartElement{Name: xml.Name{Local: xf.Name}}); err != nil {
+				return fmt.Errorf("%s: %w", f.Name, err)
+			}
+		}
+		if err := enc.Flush(); err != nil {
+			return err
+		}
+		writeXMLParents(w, xf.Parents, true)
+	}
+	return enc.Flush()
+}
+
+// writeXMLParents writes the start (or the end) elements of the parents.
+func writeXMLParents(w io.Writer, parents []string, end bool) error {
+	if !end {
+		for _, p := range parents {
+			if _, err := fmt.Fprintf(w, "<%s>", p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i := len(parents) - 1; i >= 0; i-- {
+		if _, err := fmt.Fprintf(w, "</%s>", parents[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *xmlStreamEncoder) BeginSlice(w io.Writer, f field, i int) error {
+	if xf := parseXMLField(f); !xf.Skip {
+		return writeXMLParents(w, xf.Parents, false)
+	}
+	return nil
+}
+
+func (e *xmlStreamEncoder) Elements(w io.Writer, f field, more bool) error {
+	xf := parseXMLField(f)
+	if xf.Skip {
+		return nil
+	}
+	enc := xml.NewEncoder(w)
+	start := xml.StartElement{Name: xml.Name{Local: xf.Name}}
+	rv := reflect.ValueOf(f.Value)
+	for i, n := 0, rv.Len(); i < n; i++ {
+		if e.attach != nil {
+			if err := enc.Flush(); err != nil {
+				return err
+			}
+			if ok, err := e.encodeXOP(w, xf.Name, rv.Index(i).Interface()); err != nil {
+				return err
+			} else if ok {
+				continue
+			}
+		}
+		if err := enc.EncodeElement(rv.Index(i).Interface(), start); err != nil {
+			return fmt.Errorf("%s[%d]: %w", f.Name, i, err)
+		}
+	}
+	return enc.Flush()
+}
+
+func (e *xmlStreamEncoder) EndSlice(w io.Writer, f field) error {
+	if xf := parseXMLField(f); !xf.Skip {
+		return writeXMLParents(w, xf.Parents, true)
+	}
+	return nil
+}
+
+func (e *xmlStreamEncoder) End(w io.Writer, stopped error) error {
+	if stopped != nil {
+		if err := xml.NewEncoder(w).Encode(truncatedXML{Error: stopped.Error(), Truncated: true}); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "</%s>\n", e.root)
+	return err
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package grpcer
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type xmlItem struct {
+	ID   int    `xml:"id,attr"`
+	Name string `xml:"name"`
+}
+
+type xmlPart struct {
+	XMLName xml.Name  `xml:"Response"`
+	Status  string    `xml:"status,attr"`
+	Total   int       `xml:"total"`
+	Items   []xmlItem `xml:"items>item"`
+	Codes   []string  `xml:"code"`
+}
+
+func TestMergeStreamsXML(t *testing.T) {
+	recv := &receiver{parts: []interface{}{
+		&xmlPart{Items: []xmlItem{{ID: 2, Name: "b"}}, Codes: []string{"y"}},
+		&xmlPart{Items: []xmlItem{{ID: 3, Name: "c&d"}}},
+	}}
+	var buf bytes.Buffer
+	if err := MergeStreams(&buf, &xmlPart{Status: "ok", Total: 3, Items: []xmlItem{{ID: 1, Name: "a"}}, Codes: []string{"x"}}, recv,
+		MergeXML(), MergeTempDir(t.TempDir()),
+	); err != nil {
+		t.Fatal(err)
+	}
+	want := `<Response status="ok"><total>3</total><items>` +
+		`<item id="1"><name>a</name></item><item id="2"><name>b</name></item><item id="3"><name>c&amp;d</name></item>` +
+		`</items><code>x</code><code>y</code></Response>` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwanted\n%s", got, want)
+	}
+	var got xmlPart
+	if err := xml.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Items) != 3 || len(got.Codes) != 2 || got.Total != 3 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestMergeStreamsXMLContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var buf bytes.Buffer
+	err := MergeStreamsContext(ctx, &buf, &xmlPart{Codes: []string{"x"}}, &receiver{}, MergeXML())
+	if err != context.Canceled {
+		t.Errorf("got %+v, wanted Canceled", err)
+	}
+	want := `<Response status=""><total>0</total><code>x</code><error truncated="true">context canceled</error></Response>` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwanted\n%s", got, want)
+	}
+}
+
+func TestJSONHandlerXML(t *testing.T) {
+	h := JSONHandler{MergeStreams: true, Client: fakeClient{
+		"Get": func(ctx context.Context, input interface{}) (Receiver, error) {
+			return &receiver{parts: []interface{}{&xmlPart{Codes: []string{"x"}}, &xmlPart{Codes: []string{"y"}}}}, nil
+		},
+	}}
+	for tN, tC := range map[string]struct {
+		URL, Want string
+	}{
+		"merged":   {URL: "/Get", Want: `<Response status=""><total>0</total><code>x</code><code>y</code></Response>` + "\n"},
+		"unmerged": {URL: "/Get?merge=0", Want: `<Response status=""><total>0</total><items></items><code>x</code></Response>` + "\n" + `<Response status=""><total>0</total><items></items><code>y</code></Response>` + "\n"},
+	} {
+		r := httptest.NewRequest("POST", tC.URL, strings.NewReader("{}"))
+		r.Header.Set("Accept", "application/xml")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if ct := w.Header().Get("Content-Type"); ct != "application/xml" {
+			t.Errorf("%s: got Content-Type %q", tN, ct)
+		}
+		if got := w.Body.String(); got != tC.Want {
+			t.Errorf("%s: got\n%s\nwanted\n%s", tN, got, tC.Want)
+		}
+	}
+}
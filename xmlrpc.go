@@ -21,10 +21,7 @@ import (
 	"io"
 	"net/http"
 	"time"
-	"unicode"
-	"unicode/utf8"
 
-	"github.com/mitchellh/mapstructure"
 	"github.com/tgulacsi/go-xmlrpc"
 )
 
@@ -32,6 +29,15 @@ type XMLRPCHandler struct {
 	Client
 	Log     func(...interface{}) error
 	Timeout time.Duration
+	// ForwardHeaders maps the HTTP headers to be forwarded to the outgoing
+	// metadata keys (the lowercased header name if empty).
+	// The authorization, OnBehalfOfKey and x-client-cert-* keys are never forwarded.
+	ForwardHeaders map[string]string
+	// Limit is the concurrency limit of the calls (shared by copies of the handler),
+	// a global slot is held from reading the request body, the method's slot from decoding it.
+	Limit *ConcurrencyLimit
+	// MaxBodySize is the limit of the decompressed request body, DefaultMaxBodySize if zero.
+	MaxBodySize int64
 }
 
 func (h XMLRPCHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -39,15 +45,28 @@ func (h XMLRPCHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if Log == nil {
 		Log = func(...interface{}) error { return nil }
 	}
+	// a global slot is held while reading the body, the method's slot after it is named
+	release, err := h.Limit.acquireGlobal(r.Context())
+	if err != nil {
+		Log("msg", "limit", "error", err)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), limitErrorCode(err))
+		return
+	}
+	defer release()
+	if err := decodeBody(w, r, h.MaxBodySize); err != nil {
+		http.Error(w, err.Error(), bodyErrorCode(err))
+		return
+	}
 	name, params, err := xmlrpc.Unmarshal(r.Body)
 	Log("name", name, "params", params, "error", err)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("ERROR unmarshaling: %v", err), http.StatusBadRequest)
+		http.Error(w, fmt.Sprintf("ERROR unmarshaling: %v", err), bodyErrorCode(err))
 		return
 	}
 	inp := h.Input(name)
 	if inp == nil {
-		http.Error(w, fmt.Sprintf("No unmarshaler for %q.", name), http.StatusNotFound)
+		http.Error(w, notFoundMessage(h.Client, name), http.StatusNotFound)
 		return
 	}
 
@@ -61,24 +80,13 @@ func (h XMLRPCHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// mapstruct
-	for k, v := range m {
-		if s, ok := v.(string); ok && s == "" {
-			delete(m, k)
-			continue
-		}
-		f, _ := utf8.DecodeRune([]byte(k))
-		if unicode.IsLower(f) {
-			m[CamelCase(k)] = v
-		}
-	}
-	if err := mapstructure.WeakDecode(m, inp); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := fillInputLenient(inp, m, Log); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	Log("inp", inp)
 
-	ctx := r.Context()
+	ctx := forwardHeaders(r.Context(), r, h.ForwardHeaders)
 	if u, p, ok := r.BasicAuth(); ok {
 		ctx = WithBasicAuth(ctx, u, p)
 	}
@@ -93,6 +101,14 @@ func (h XMLRPCHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			defer cancel()
 		}
 	}
+	releaseMethod, err := h.Limit.acquireMethod(ctx, name)
+	if err != nil {
+		Log("call", name, "error", err)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), limitErrorCode(err))
+		return
+	}
+	defer releaseMethod()
 	recv, err := h.Call(name, ctx, inp)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)